@@ -0,0 +1,138 @@
+package batch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type published struct {
+	topic   string
+	qos     byte
+	payload []byte
+}
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	frames []published
+}
+
+func (p *recordingPublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frames = append(p.frames, published{topic, qos, payload})
+	return nil
+}
+
+func (p *recordingPublisher) snapshot() []published {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]published(nil), p.frames...)
+}
+
+func healthJSON(i int) []byte {
+	return []byte(fmt.Sprintf(`{"v":1,"type":"health","robot_id":"robot1","seq":%d,"payload":{"cpu_temperature":51.5,"motor_temperatures":[50,48.5]}}`, i))
+}
+
+func TestPackUnpack(t *testing.T) {
+	var messages [][]byte
+	raw := 0
+	for i := 1; i <= 20; i++ {
+		messages = append(messages, healthJSON(i))
+		raw += len(healthJSON(i))
+	}
+	frame, err := Pack(messages, DefaultConfig().Level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("20 health messages: %d bytes separately, %d bytes as a batch", raw, len(frame))
+	if len(frame)*3 > raw {
+		t.Errorf("Expected at least 3x compression, got %d -> %d", raw, len(frame))
+	}
+
+	got, err := Unpack(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 20 || string(got[0]) != string(messages[0]) || string(got[19]) != string(messages[19]) {
+		t.Errorf("Unexpected unpacked messages: %d", len(got))
+	}
+
+	// Incompressible batches are sent uncompressed
+	frame, _ = Pack([][]byte{{1}, {2}}, DefaultConfig().Level)
+	if frame[2] != compressionNone {
+		t.Errorf("Expected an uncompressed frame for tiny input")
+	}
+	if got, err := Unpack(frame); err != nil || len(got) != 2 || got[1][0] != 2 {
+		t.Errorf("Unexpected unpack of uncompressed frame: %v %v", got, err)
+	}
+
+	if got, err := Unpack([]byte(`{"v":1}`)); err != nil || len(got) != 1 {
+		t.Errorf("Non-batch payloads should pass through, got %v %v", got, err)
+	}
+	for _, bad := range [][]byte{{frameMagic}, {frameMagic, 9, 0}, {frameMagic, frameVersion, 7}, {frameMagic, frameVersion, compressionNone, 5, 1}} {
+		if _, err := Unpack(bad); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Expected ErrCorrupt for %v, got %v", bad, err)
+		}
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	pub := &recordingPublisher{}
+	cfg := DefaultConfig()
+	cfg.MaxMessages = 5
+	cfg.MaxDelay = 50 * time.Millisecond
+	b := NewBatcher(pub, cfg)
+
+	if !b.Bypasses("estop") || b.Bypasses("health") {
+		t.Error("Unexpected bypass list")
+	}
+
+	// Five health messages fill a batch and flush immediately
+	for i := 1; i <= 5; i++ {
+		b.Add("robots/r1/telemetry/health", 1, healthJSON(i))
+	}
+	// Navigation is batched separately and flushed by the timer
+	b.Add("robots/r1/telemetry/navigation", 0, []byte("nav-1"))
+	b.Add("robots/r1/telemetry/navigation", 1, []byte("nav-2"))
+
+	frames := pub.snapshot()
+	if len(frames) != 1 || frames[0].topic != "robots/r1/telemetry/health" || !IsBatch(frames[0].payload) {
+		t.Fatalf("Expected one health batch, got %+v", frames)
+	}
+
+	time.Sleep(3 * cfg.MaxDelay)
+	frames = pub.snapshot()
+	if len(frames) != 2 || frames[1].topic != "robots/r1/telemetry/navigation" || frames[1].qos != 1 {
+		t.Fatalf("Expected the navigation batch after the delay at QoS 1, got %+v", frames)
+	}
+
+	// Subscribers see the original messages in order
+	var delivered []string
+	handler := Handler(func(topic string, payload []byte) {
+		delivered = append(delivered, string(payload))
+	})
+	for _, f := range frames {
+		handler(f.topic, f.payload)
+	}
+	handler("robots/r1/telemetry/estop", []byte("stop"))
+	if len(delivered) != 8 || delivered[5] != "nav-1" || delivered[6] != "nav-2" || delivered[7] != "stop" {
+		t.Errorf("Unexpected delivery: %q", delivered)
+	}
+
+	// Close flushes what is pending, and a single message is sent without a frame
+	b.Add("robots/r1/telemetry/heartbeat", 1, []byte("hb"))
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	frames = pub.snapshot()
+	if last := frames[len(frames)-1]; string(last.payload) != "hb" {
+		t.Errorf("Expected the lone heartbeat unframed, got %q", last.payload)
+	}
+	b.Add("robots/r1/telemetry/heartbeat", 1, []byte("late"))
+	if frames = pub.snapshot(); string(frames[len(frames)-1].payload) != "late" {
+		t.Error("Messages after Close should be published immediately")
+	}
+}
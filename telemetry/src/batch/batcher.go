@@ -0,0 +1,164 @@
+package batch
+
+import (
+	"compress/flate"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// Publisher sends raw payloads, e.g. an MQTTTelemetryClient
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+}
+
+// Config controls when batches are flushed
+type Config struct {
+	MaxMessages int           // Flush a topic once it holds this many messages
+	MaxDelay    time.Duration // Flush a topic this long after its first message
+	Level       int           // flate compression level
+	// Bypass lists message types that are always published immediately
+	Bypass []string
+}
+
+// DefaultConfig batches up to 20 messages or 200ms, and never delays commands,
+// acknowledgements, alerts or emergency stops
+func DefaultConfig() Config {
+	return Config{
+		MaxMessages: 20,
+		MaxDelay:    200 * time.Millisecond,
+		Level:       flate.BestSpeed,
+		Bypass:      []string{"command", "ack", "alert", "estop"},
+	}
+}
+
+type pending struct {
+	qos      byte
+	messages [][]byte
+	timer    *time.Timer
+}
+
+// Batcher accumulates messages per topic and publishes each topic's messages as one
+// compressed frame
+type Batcher struct {
+	config    Config
+	publisher Publisher
+	log       *logger.Logger
+	mu        sync.Mutex
+	topics    map[string]*pending
+	bypass    map[string]bool
+	publishMu sync.Mutex // Keeps frames of a topic in order across flushes
+	closed    bool
+}
+
+// NewBatcher creates a batcher publishing through publisher
+func NewBatcher(publisher Publisher, config Config) *Batcher {
+	if config.MaxMessages <= 0 {
+		config.MaxMessages = 1
+	}
+	b := &Batcher{
+		config:    config,
+		publisher: publisher,
+		log:       logger.New(logger.INFO),
+		topics:    make(map[string]*pending),
+		bypass:    make(map[string]bool),
+	}
+	for _, t := range config.Bypass {
+		b.bypass[t] = true
+	}
+	return b
+}
+
+// Bypasses reports whether messageType skips batching
+func (b *Batcher) Bypasses(messageType string) bool {
+	return b.bypass[messageType]
+}
+
+// Add queues a message for topic. It is published when the topic's batch fills up or
+// MaxDelay after the batch started, whichever comes first. Messages added after Close
+// are published immediately.
+func (b *Batcher) Add(topic string, qos byte, payload []byte) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return b.publisher.Publish(topic, qos, false, payload)
+	}
+	p, ok := b.topics[topic]
+	if !ok {
+		p = &pending{qos: qos}
+		b.topics[topic] = p
+		if b.config.MaxDelay > 0 {
+			p.timer = time.AfterFunc(b.config.MaxDelay, func() {
+				if err := b.flushTopic(topic, p); err != nil {
+					b.log.Error("Failed to publish batch on %s: %v", topic, err)
+				}
+			})
+		}
+	}
+	// A batch is published with the strongest QoS of its messages
+	if qos > p.qos {
+		p.qos = qos
+	}
+	p.messages = append(p.messages, payload)
+	full := len(p.messages) >= b.config.MaxMessages
+	b.mu.Unlock()
+
+	if full {
+		return b.flushTopic(topic, p)
+	}
+	return nil
+}
+
+// Flush publishes every pending batch
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	batches := make(map[string]*pending, len(b.topics))
+	for topic, p := range b.topics {
+		batches[topic] = p
+	}
+	b.mu.Unlock()
+
+	var firstErr error
+	for topic, p := range batches {
+		if err := b.flushTopic(topic, p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close flushes pending batches; later messages are published unbatched
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	return b.Flush()
+}
+
+// flushTopic publishes p if it is still the current batch for topic
+func (b *Batcher) flushTopic(topic string, p *pending) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	b.mu.Lock()
+	if b.topics[topic] != p {
+		b.mu.Unlock()
+		return nil
+	}
+	delete(b.topics, topic)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	// A batch of one is cheaper sent as is
+	if len(p.messages) == 1 {
+		return b.publisher.Publish(topic, p.qos, false, p.messages[0])
+	}
+	frame, err := Pack(p.messages, b.config.Level)
+	if err != nil {
+		return err
+	}
+	return b.publisher.Publish(topic, p.qos, false, frame)
+}
@@ -0,0 +1,130 @@
+package batch
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// frameMagic starts a batch frame. Envelopes start with '{' or their own magic byte,
+// so batches can share topics with single messages.
+const frameMagic = 0xBA
+
+const frameVersion = 1
+
+// Compression methods
+const (
+	compressionNone    = 0
+	compressionDeflate = 1
+)
+
+// maxUnpackedSize bounds decompression so a hostile frame cannot exhaust memory
+const maxUnpackedSize = 16 << 20
+
+// ErrCorrupt is returned for frames that cannot be unpacked
+var ErrCorrupt = errors.New("corrupt batch frame")
+
+// IsBatch reports whether payload is a batch frame
+func IsBatch(payload []byte) bool {
+	return len(payload) > 0 && payload[0] == frameMagic
+}
+
+// Pack builds a batch frame from messages, deflating the body when that makes it
+// smaller
+//
+//	magic        0xBA
+//	version      uint8
+//	compression  uint8, 0 none or 1 deflate
+//	body         uvarint count, then uvarint length + bytes per message
+func Pack(messages [][]byte, level int) ([]byte, error) {
+	var body bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	body.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(messages)))])
+	for _, msg := range messages {
+		body.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(msg)))])
+		body.Write(msg)
+	}
+
+	frame := bytes.NewBuffer([]byte{frameMagic, frameVersion, compressionDeflate})
+	w, err := flate.NewWriter(frame, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if frame.Len()-3 < body.Len() {
+		return frame.Bytes(), nil
+	}
+	return append([]byte{frameMagic, frameVersion, compressionNone}, body.Bytes()...), nil
+}
+
+// Unpack returns the messages in a batch frame, or payload itself when it is not one
+func Unpack(payload []byte) ([][]byte, error) {
+	if !IsBatch(payload) {
+		return [][]byte{payload}, nil
+	}
+	if len(payload) < 3 {
+		return nil, fmt.Errorf("%w: truncated header", ErrCorrupt)
+	}
+	if payload[1] != frameVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrCorrupt, payload[1])
+	}
+
+	body := payload[3:]
+	switch payload[2] {
+	case compressionNone:
+	case compressionDeflate:
+		r := flate.NewReader(bytes.NewReader(body))
+		defer r.Close()
+		inflated, err := io.ReadAll(io.LimitReader(r, maxUnpackedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if len(inflated) > maxUnpackedSize {
+			return nil, fmt.Errorf("%w: exceeds %d bytes unpacked", ErrCorrupt, maxUnpackedSize)
+		}
+		body = inflated
+	default:
+		return nil, fmt.Errorf("%w: compression %d", ErrCorrupt, payload[2])
+	}
+
+	r := bytes.NewReader(body)
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: bad message count", ErrCorrupt)
+	}
+	messages := make([][]byte, 0, count)
+	for i := uint64(0); i < count; i++ {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: message %d truncated", ErrCorrupt, i)
+		}
+		start := len(body) - r.Len()
+		messages = append(messages, body[start:start+int(n)])
+		r.Seek(int64(n), io.SeekCurrent)
+	}
+	return messages, nil
+}
+
+// Handler wraps a subscription handler so batches are delivered as their individual
+// messages, in order
+func Handler(handler func(topic string, payload []byte)) func(topic string, payload []byte) {
+	return func(topic string, payload []byte) {
+		messages, err := Unpack(payload)
+		if err != nil {
+			// Hand the frame on as is so the consumer's dead-letter path sees it
+			handler(topic, payload)
+			return
+		}
+		for _, msg := range messages {
+			handler(topic, msg)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+	"telemetry/src/simulation"
+)
+
+// Battery error codes reported in BatteryData and HealthMessage.ErrorCodes
+const (
+	ErrCodeCellImbalance   = "BATTERY_CELL_IMBALANCE"
+	ErrCodeOverTemperature = "BATTERY_OVER_TEMPERATURE"
+	ErrCodeUnderVoltage    = "BATTERY_UNDER_VOLTAGE"
+)
+
+// BMSReading is a raw sample from a battery management system
+type BMSReading struct {
+	CellVoltages []float64 // Volts per series cell
+	PackCurrent  float64   // Amps, positive while discharging
+	Temperature  float64   // Degrees Celsius
+}
+
+// BMSReader reads samples from a battery management system
+type BMSReader interface {
+	ReadBMS(ctx context.Context) (BMSReading, error)
+}
+
+// BatteryData represents the estimated battery state
+type BatteryData struct {
+	StateOfCharge  float64  `json:"state_of_charge"` // Percent, 0-100
+	StateOfHealth  float64  `json:"state_of_health"` // Percent of nominal capacity
+	PackVoltage    float64  `json:"pack_voltage"`
+	Current        float64  `json:"current"`
+	Temperature    float64  `json:"temperature"`
+	CellMinVoltage float64  `json:"cell_min_voltage"`
+	CellMaxVoltage float64  `json:"cell_max_voltage"`
+	Cycles         float64  `json:"cycles"` // Equivalent full discharge cycles
+	ErrorCodes     []string `json:"error_codes,omitempty"`
+}
+
+// BatteryConfig describes the pack and alarm thresholds
+type BatteryConfig struct {
+	NominalCapacityAh float64
+	// RestCurrent and RestDuration define when the pack is at rest so the open circuit
+	// voltage can be trusted to recalibrate the coulomb counter
+	RestCurrent  float64
+	RestDuration time.Duration
+	// MinCalibrationSpan is the smallest SoC change between two rest points used to
+	// measure capacity for state of health
+	MinCalibrationSpan float64
+	MaxCellImbalance   float64 // Volts
+	MaxTemperature     float64 // Degrees Celsius
+	MinCellVoltage     float64 // Volts
+}
+
+// DefaultBatteryConfig returns settings for a small Li-ion robot pack
+func DefaultBatteryConfig(capacityAh float64) BatteryConfig {
+	return BatteryConfig{
+		NominalCapacityAh:  capacityAh,
+		RestCurrent:        0.05,
+		RestDuration:       30 * time.Second,
+		MinCalibrationSpan: 20,
+		MaxCellImbalance:   0.1,
+		MaxTemperature:     60,
+		MinCellVoltage:     3.0,
+	}
+}
+
+// liIonOCV maps resting cell voltage to state of charge for a typical NMC Li-ion cell
+var liIonOCV = []struct{ volts, soc float64 }{
+	{3.00, 0}, {3.45, 5}, {3.55, 10}, {3.62, 20}, {3.68, 30}, {3.73, 40},
+	{3.78, 50}, {3.84, 60}, {3.91, 70}, {3.98, 80}, {4.06, 90}, {4.20, 100},
+}
+
+// OCVStateOfCharge estimates state of charge in percent from a resting cell voltage
+func OCVStateOfCharge(cellVolts float64) float64 {
+	if cellVolts <= liIonOCV[0].volts {
+		return 0
+	}
+	for i := 1; i < len(liIonOCV); i++ {
+		lo, hi := liIonOCV[i-1], liIonOCV[i]
+		if cellVolts <= hi.volts {
+			return lo.soc + (cellVolts-lo.volts)/(hi.volts-lo.volts)*(hi.soc-lo.soc)
+		}
+	}
+	return 100
+}
+
+// BatterySensor estimates state of charge by coulomb counting, recalibrated against the
+// open circuit voltage curve whenever the pack rests, and tracks state of health from
+// the capacity measured between rest points.
+type BatterySensor struct {
+	id     string
+	bms    BMSReader
+	config BatteryConfig
+	log    *logger.Logger
+	now    func() time.Time
+	mu     sync.RWMutex
+
+	initialized  bool
+	soc          float64
+	soh          float64
+	lastSample   time.Time
+	restingSince time.Time
+	dischargedAh float64
+	netAh        float64 // Charge drawn since the last calibration point
+	anchorSoC    float64
+	latest       BatteryData
+}
+
+// NewBatterySensor creates a battery sensor reading from a BMS
+func NewBatterySensor(id string, bms BMSReader, config BatteryConfig) *BatterySensor {
+	return &BatterySensor{
+		id:     id,
+		bms:    bms,
+		config: config,
+		log:    logger.New(logger.INFO),
+		now:    time.Now,
+		soh:    100,
+	}
+}
+
+func (s *BatterySensor) ID() string {
+	return s.id
+}
+
+func (s *BatterySensor) Initialize() error {
+	if s.bms == nil {
+		return fmt.Errorf("battery sensor %s has no bms", s.id)
+	}
+	if s.config.NominalCapacityAh <= 0 {
+		return fmt.Errorf("battery sensor %s needs a nominal capacity", s.id)
+	}
+	return nil
+}
+
+func (s *BatterySensor) Shutdown() error {
+	return nil
+}
+
+func (s *BatterySensor) Read(ctx context.Context) (SensorData, error) {
+	reading, err := s.bms.ReadBMS(ctx)
+	if err != nil {
+		return SensorData{}, err
+	}
+	if len(reading.CellVoltages) == 0 {
+		return SensorData{}, fmt.Errorf("battery sensor %s: bms reported no cells", s.id)
+	}
+
+	now := s.now()
+	data := s.update(reading, now)
+	return SensorData{
+		Timestamp: now,
+		SensorID:  s.id,
+		DataType:  "battery",
+		Value:     data,
+	}, nil
+}
+
+func (s *BatterySensor) update(r BMSReading, now time.Time) BatteryData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pack, cellMin, cellMax := 0.0, math.Inf(1), math.Inf(-1)
+	for _, v := range r.CellVoltages {
+		pack += v
+		cellMin = math.Min(cellMin, v)
+		cellMax = math.Max(cellMax, v)
+	}
+	ocvSoC := OCVStateOfCharge(pack / float64(len(r.CellVoltages)))
+
+	if !s.initialized {
+		s.initialized = true
+		s.soc = ocvSoC
+		s.anchorSoC = ocvSoC
+	} else {
+		hours := now.Sub(s.lastSample).Hours()
+		ah := r.PackCurrent * hours
+		s.netAh += ah
+		if ah > 0 {
+			s.dischargedAh += ah
+		}
+		s.soc -= ah / s.config.NominalCapacityAh * 100
+	}
+	s.lastSample = now
+
+	if math.Abs(r.PackCurrent) <= s.config.RestCurrent {
+		if s.restingSince.IsZero() {
+			s.restingSince = now
+		}
+		if now.Sub(s.restingSince) >= s.config.RestDuration {
+			s.calibrate(ocvSoC)
+		}
+	} else {
+		s.restingSince = time.Time{}
+	}
+	s.soc = math.Max(0, math.Min(100, s.soc))
+
+	var codes []string
+	if cellMax-cellMin > s.config.MaxCellImbalance {
+		codes = append(codes, ErrCodeCellImbalance)
+	}
+	if r.Temperature > s.config.MaxTemperature {
+		codes = append(codes, ErrCodeOverTemperature)
+	}
+	if cellMin < s.config.MinCellVoltage {
+		codes = append(codes, ErrCodeUnderVoltage)
+	}
+	if len(codes) > 0 {
+		s.log.Warn("Battery %s alarms: %v", s.id, codes)
+	}
+
+	s.latest = BatteryData{
+		StateOfCharge:  s.soc,
+		StateOfHealth:  s.soh,
+		PackVoltage:    pack,
+		Current:        r.PackCurrent,
+		Temperature:    r.Temperature,
+		CellMinVoltage: cellMin,
+		CellMaxVoltage: cellMax,
+		Cycles:         s.dischargedAh / s.config.NominalCapacityAh,
+		ErrorCodes:     codes,
+	}
+	return s.latest
+}
+
+// calibrate resets the coulomb counter to the rest voltage estimate and, when the pack
+// has moved far enough since the last rest point, updates the capacity estimate
+func (s *BatterySensor) calibrate(ocvSoC float64) {
+	span := s.anchorSoC - ocvSoC
+	if math.Abs(span) >= s.config.MinCalibrationSpan && math.Abs(s.netAh) > 0 {
+		measured := s.netAh / (span / 100)
+		health := math.Max(0, math.Min(120, measured/s.config.NominalCapacityAh*100))
+		// Smooth across cycles so a single noisy calibration does not swing the estimate
+		s.soh = 0.8*s.soh + 0.2*health
+		s.log.Info("Battery %s measured capacity %.2f Ah, state of health %.1f%%", s.id, measured, s.soh)
+	}
+	if math.Abs(span) >= s.config.MinCalibrationSpan || s.netAh == 0 {
+		s.anchorSoC = ocvSoC
+		s.netAh = 0
+	}
+	s.soc = ocvSoC
+}
+
+// Latest returns the most recent battery estimate
+func (s *BatterySensor) Latest() BatteryData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.latest
+}
+
+// PowerStatus implements simulation.PowerSource so battery estimates feed heartbeat and
+// health messages; pass the sensor to TelemetryTestRunner.SetPowerSource to use it. It
+// fails until the sensor has been read; see PollPower.
+func (s *BatterySensor) PowerStatus() (simulation.PowerStatus, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.initialized {
+		return simulation.PowerStatus{}, fmt.Errorf("%w: %s", ErrInputUnavailable, s.id)
+	}
+	return simulation.PowerStatus{
+		BatteryPct:   s.latest.StateOfCharge,
+		VoltageLevel: s.latest.PackVoltage,
+		CurrentDraw:  s.latest.Current,
+		ErrorCodes:   s.latest.ErrorCodes,
+	}, nil
+}
+
+// PollPower reads the BMS so PowerStatus reports fresh state. It implements
+// simulation.PolledPowerSource; the test runner polls it every second.
+func (s *BatterySensor) PollPower(ctx context.Context) error {
+	_, err := s.Read(ctx)
+	return err
+}
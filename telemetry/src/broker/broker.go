@@ -0,0 +1,323 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"telemetry/include/logger"
+)
+
+// ErrClosed is returned when using a broker after Close
+var ErrClosed = errors.New("broker closed")
+
+// Message is an application message routed by the broker
+type Message struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+}
+
+// Config controls broker limits and authentication
+type Config struct {
+	// MaxQueued is how many QoS 1 messages an offline persistent session keeps; the
+	// oldest are dropped beyond it
+	MaxQueued int
+	// MaxPendingBytes bounds the unsent data buffered per connection; clients that fall
+	// further behind are disconnected
+	MaxPendingBytes int
+	// Authenticate accepts or rejects a connection; nil accepts every client
+	Authenticate func(clientID, username string, password []byte) bool
+}
+
+// DefaultConfig keeps 1000 messages per offline session and 8 MiB per connection
+func DefaultConfig() Config {
+	return Config{
+		MaxQueued:       1000,
+		MaxPendingBytes: 8 << 20,
+	}
+}
+
+// Broker is a minimal in-process MQTT 3.1.1 broker. It supports QoS 0 and 1 (QoS 2
+// publishes are accepted and delivered at QoS 1), + and # wildcards, retained
+// messages, last wills and persistent sessions. Sessions live in memory only.
+type Broker struct {
+	config   Config
+	log      *logger.Logger
+	mu       sync.Mutex
+	listener net.Listener
+	wsServer *http.Server
+	wsAddr   string
+	sessions map[string]*session
+	retained map[string]Message
+	conns    map[*conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// New creates a broker; call Start to accept connections
+func New(config Config) *Broker {
+	defaults := DefaultConfig()
+	if config.MaxQueued <= 0 {
+		config.MaxQueued = defaults.MaxQueued
+	}
+	if config.MaxPendingBytes <= 0 {
+		config.MaxPendingBytes = defaults.MaxPendingBytes
+	}
+	return &Broker{
+		config:   config,
+		log:      logger.New(logger.INFO),
+		sessions: make(map[string]*session),
+		retained: make(map[string]Message),
+		conns:    make(map[*conn]struct{}),
+	}
+}
+
+// Start listens on addr, e.g. ":1883", or "127.0.0.1:0" for a random port in tests
+func (b *Broker) Start(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if b.closed || b.listener != nil {
+		b.mu.Unlock()
+		ln.Close()
+		if b.closed {
+			return ErrClosed
+		}
+		return errors.New("broker already started")
+	}
+	b.listener = ln
+	b.mu.Unlock()
+
+	b.log.Info("MQTT broker listening on %s", ln.Addr())
+	b.wg.Add(1)
+	go b.accept(ln)
+	return nil
+}
+
+// Addr returns the address the broker listens on, or "" before Start
+func (b *Broker) Addr() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.listener == nil {
+		return ""
+	}
+	return b.listener.Addr().String()
+}
+
+// URL returns the broker URL for MQTT clients, e.g. tcp://127.0.0.1:41234
+func (b *Broker) URL() string {
+	return "tcp://" + b.Addr()
+}
+
+// Close stops accepting connections and disconnects every client
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	var err error
+	if b.listener != nil {
+		err = b.listener.Close()
+	}
+	if b.wsServer != nil {
+		b.wsServer.Close()
+	}
+	for c := range b.conns {
+		c.close()
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return err
+}
+
+// Publish routes a message from inside the process as if a client had published it
+func (b *Broker) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	if !validTopic(topic) {
+		return fmt.Errorf("%w: invalid topic %q", ErrProtocol, topic)
+	}
+	if qos > 1 {
+		qos = 1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	b.route(Message{Topic: topic, Payload: append([]byte(nil), payload...), QoS: qos, Retained: retained})
+	return nil
+}
+
+// Retained returns the retained message for topic
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg, ok := b.retained[topic]
+	return msg, ok
+}
+
+// DisconnectClient drops a client's connection without a DISCONNECT, so its last will
+// is published. It reports whether the client was connected.
+func (b *Broker) DisconnectClient(clientID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.sessions[clientID]
+	if !ok || s.conn == nil {
+		return false
+	}
+	b.drop(s.conn)
+	return true
+}
+
+// Stats is a snapshot of broker state
+type Stats struct {
+	Clients  int    // Connected clients
+	Sessions int    // Sessions, including offline persistent ones
+	Retained int    // Retained messages
+	Queued   int    // Messages queued for offline sessions
+	Dropped  uint64 // Messages dropped because a session's queue was full
+}
+
+// Stats returns a snapshot of broker state
+func (b *Broker) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := Stats{Sessions: len(b.sessions), Retained: len(b.retained)}
+	for _, s := range b.sessions {
+		if s.conn != nil {
+			st.Clients++
+		}
+		st.Queued += len(s.queue)
+		st.Dropped += s.dropped
+	}
+	return st
+}
+
+func (b *Broker) accept(ln net.Listener) {
+	defer b.wg.Done()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		b.serveConn(nc)
+	}
+}
+
+// serveConn handles a client connection in the background
+func (b *Broker) serveConn(nc net.Conn) {
+	c := newConn(b, nc)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		nc.Close()
+		return
+	}
+	b.conns[c] = struct{}{}
+	b.wg.Add(1)
+	go c.serve()
+}
+
+// route delivers msg to every matching subscription and updates the retained store;
+// callers hold mu
+func (b *Broker) route(msg Message) {
+	if msg.Retained {
+		if len(msg.Payload) == 0 {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	for _, s := range b.sessions {
+		qos, ok := s.match(msg.Topic)
+		if !ok {
+			continue
+		}
+		if msg.QoS < qos {
+			qos = msg.QoS
+		}
+		// Established subscriptions always see RETAIN cleared
+		s.deliver(Message{Topic: msg.Topic, Payload: msg.Payload, QoS: qos}, b.config.MaxQueued)
+	}
+}
+
+// sendRetained delivers the retained messages matching a new subscription; callers
+// hold mu
+func (b *Broker) sendRetained(s *session, filter string, qos byte) {
+	for topic, msg := range b.retained {
+		if !TopicMatches(filter, topic) {
+			continue
+		}
+		granted := qos
+		if msg.QoS < granted {
+			granted = msg.QoS
+		}
+		s.deliver(Message{Topic: topic, Payload: msg.Payload, QoS: granted, Retained: true}, b.config.MaxQueued)
+	}
+}
+
+// attach binds c to its session, resuming a persistent one when asked. It returns
+// whether an existing session was resumed. Callers hold mu.
+func (b *Broker) attach(c *conn, clientID string, clean bool) bool {
+	s, ok := b.sessions[clientID]
+	if ok && s.conn != nil {
+		b.log.Warn("Client %s connected again, dropping the previous connection", clientID)
+		b.drop(s.conn)
+		s.conn = nil
+	}
+	present := ok && !clean && !s.clean
+	if !present {
+		s = newSession(clientID)
+		b.sessions[clientID] = s
+	}
+	s.clean = clean
+	s.conn = c
+	c.session = s
+	return present
+}
+
+// drop closes c without a DISCONNECT and publishes its will straight away, so the
+// will is routed before anything from the client's next connection. Callers hold mu.
+func (b *Broker) drop(c *conn) {
+	c.close()
+	if c.will != nil {
+		b.route(*c.will)
+		c.will = nil
+	}
+}
+
+// detach unbinds c from its session, ending the session if it was clean. The will, if
+// still due, is routed before the session is released.
+func (b *Broker) detach(c *conn, publishWill bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if publishWill && c.will != nil && !b.closed {
+		b.route(*c.will)
+		c.will = nil
+	}
+	delete(b.conns, c)
+	s := c.session
+	if s == nil || s.conn != c {
+		return
+	}
+	s.conn = nil
+	if s.clean {
+		delete(b.sessions, s.clientID)
+	}
+}
+
+func randomClientID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return "auto-" + hex.EncodeToString(b[:])
+}
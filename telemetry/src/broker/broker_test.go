@@ -0,0 +1,343 @@
+package broker
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func startBroker(t *testing.T) *Broker {
+	t.Helper()
+	b := New(DefaultConfig())
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func connectClient(t *testing.T, b *Broker, clientID string, clean bool, configure func(*mqtt.ClientOptions)) mqtt.Client {
+	t.Helper()
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.URL()).
+		SetClientID(clientID).
+		SetCleanSession(clean).
+		SetAutoReconnect(false).
+		SetConnectTimeout(2 * time.Second)
+	if configure != nil {
+		configure(opts)
+	}
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		t.Fatalf("connect %s: %v", clientID, token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return client
+}
+
+func wait(t *testing.T, token mqtt.Token) {
+	t.Helper()
+	if !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		t.Fatalf("token: %v", token.Error())
+	}
+}
+
+type received struct {
+	topic    string
+	payload  string
+	retained bool
+}
+
+func collect(t *testing.T, client mqtt.Client, filter string, qos byte) chan received {
+	t.Helper()
+	ch := make(chan received, 100)
+	wait(t, client.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
+		ch <- received{msg.Topic(), string(msg.Payload()), msg.Retained()}
+	}))
+	return ch
+}
+
+func next(t *testing.T, ch chan received) received {
+	t.Helper()
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message")
+		return received{}
+	}
+}
+
+func expectNone(t *testing.T, ch chan received) {
+	t.Helper()
+	select {
+	case r := <-ch:
+		t.Fatalf("unexpected message %+v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"robots/r1/status", "robots/r1/status", true},
+		{"robots/+/status", "robots/r1/status", true},
+		{"robots/+/status", "robots/r1/telemetry/health", false},
+		{"robots/#", "robots", true},
+		{"robots/#", "robots/r1/telemetry/health", true},
+		{"robots/+/telemetry/+", "robots/r1/telemetry/health", true},
+		{"robots/+/telemetry/+", "robots/r1/telemetry", false},
+		{"+", "robots", true},
+		{"+/+", "/robots", true},
+		{"#", "$SYS/uptime", false},
+		{"$SYS/#", "$SYS/uptime", true},
+	}
+	for _, c := range cases {
+		if got := TopicMatches(c.filter, c.topic); got != c.want {
+			t.Errorf("TopicMatches(%q, %q) = %t, want %t", c.filter, c.topic, got, c.want)
+		}
+	}
+
+	for _, filter := range []string{"a/#/b", "a/b#", "a+/b", ""} {
+		if validFilter(filter) {
+			t.Errorf("filter %q accepted", filter)
+		}
+	}
+}
+
+func TestWildcardSubscriptions(t *testing.T) {
+	b := startBroker(t)
+	fleet := connectClient(t, b, "fleet", true, nil)
+	robot := connectClient(t, b, "robot1", true, nil)
+
+	all := collect(t, fleet, "robots/+/telemetry/+", 1)
+	robot1 := collect(t, fleet, "robots/robot1/#", 0)
+
+	wait(t, robot.Publish("robots/robot1/telemetry/health", 1, false, "h"))
+	wait(t, robot.Publish("robots/robot1/status", 1, false, "s"))
+
+	if r := next(t, all); r.topic != "robots/robot1/telemetry/health" || r.payload != "h" {
+		t.Errorf("telemetry subscription got %+v", r)
+	}
+	expectNone(t, all)
+	got := map[string]bool{}
+	got[next(t, robot1).topic] = true
+	got[next(t, robot1).topic] = true
+	if !got["robots/robot1/telemetry/health"] || !got["robots/robot1/status"] {
+		t.Errorf("robot subscription got %v", got)
+	}
+
+	wait(t, fleet.Unsubscribe("robots/robot1/#"))
+	wait(t, robot.Publish("robots/robot1/status", 0, false, "s"))
+	expectNone(t, robot1)
+}
+
+func TestRetainedMessages(t *testing.T) {
+	b := startBroker(t)
+	robot := connectClient(t, b, "robot1", true, nil)
+	wait(t, robot.Publish("robots/robot1/status", 1, true, "ONLINE"))
+
+	late := connectClient(t, b, "dashboard", true, nil)
+	status := collect(t, late, "robots/+/status", 1)
+	if r := next(t, status); r.payload != "ONLINE" || !r.retained {
+		t.Errorf("got %+v, want retained ONLINE", r)
+	}
+
+	// Live deliveries clear the retain flag
+	wait(t, robot.Publish("robots/robot1/status", 1, true, "OFFLINE"))
+	if r := next(t, status); r.payload != "OFFLINE" || r.retained {
+		t.Errorf("got %+v, want live OFFLINE", r)
+	}
+
+	// An empty retained payload clears the topic
+	wait(t, robot.Publish("robots/robot1/status", 1, true, ""))
+	next(t, status)
+	if _, ok := b.Retained("robots/robot1/status"); ok {
+		t.Error("retained message not cleared")
+	}
+}
+
+func TestLastWill(t *testing.T) {
+	b := startBroker(t)
+	observer := connectClient(t, b, "observer", true, nil)
+	status := collect(t, observer, "robots/robot1/status", 1)
+
+	robot := connectClient(t, b, "robot1", true, func(o *mqtt.ClientOptions) {
+		o.SetWill("robots/robot1/status", "LOST", 1, true)
+	})
+	wait(t, robot.Publish("robots/robot1/status", 1, true, "ONLINE"))
+	next(t, status)
+
+	if !b.DisconnectClient("robot1") {
+		t.Fatal("robot1 not connected")
+	}
+	if r := next(t, status); r.payload != "LOST" {
+		t.Errorf("got %+v, want will", r)
+	}
+	if msg, ok := b.Retained("robots/robot1/status"); !ok || string(msg.Payload) != "LOST" {
+		t.Errorf("retained will = %q, %t", msg.Payload, ok)
+	}
+
+	// A clean disconnect discards the will
+	other := connectClient(t, b, "robot2", true, func(o *mqtt.ClientOptions) {
+		o.SetWill("robots/robot1/status", "LOST2", 1, false)
+	})
+	other.Disconnect(100)
+	expectNone(t, status)
+}
+
+func TestPersistentSession(t *testing.T) {
+	b := startBroker(t)
+	robot := connectClient(t, b, "robot1", true, nil)
+
+	fleet := connectClient(t, b, "fleet", false, nil)
+	wait(t, fleet.Subscribe("robots/+/telemetry/+", 1, nil))
+	fleet.Disconnect(100)
+	waitForClients(t, b, 1)
+
+	// QoS 0 messages are not queued for offline sessions
+	wait(t, robot.Publish("robots/robot1/telemetry/health", 0, false, "qos0"))
+	for _, p := range []string{"1", "2", "3"} {
+		wait(t, robot.Publish("robots/robot1/telemetry/health", 1, false, p))
+	}
+	if st := b.Stats(); st.Queued != 3 || st.Sessions != 2 || st.Clients != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	ch := make(chan received, 10)
+	resumed := connectClient(t, b, "fleet", false, func(o *mqtt.ClientOptions) {
+		o.SetDefaultPublishHandler(func(_ mqtt.Client, msg mqtt.Message) {
+			ch <- received{msg.Topic(), string(msg.Payload()), msg.Retained()}
+		})
+	})
+	for _, want := range []string{"1", "2", "3"} {
+		if r := next(t, ch); r.payload != want {
+			t.Errorf("got %q, want %q", r.payload, want)
+		}
+	}
+	expectNone(t, ch)
+
+	// The subscription survived the reconnect
+	wait(t, robot.Publish("robots/robot1/telemetry/health", 1, false, "4"))
+	if r := next(t, ch); r.payload != "4" {
+		t.Errorf("got %q after resume", r.payload)
+	}
+	resumed.Disconnect(100)
+	waitForClients(t, b, 1)
+
+	// A clean session discards the stored state
+	connectClient(t, b, "fleet", true, nil).Disconnect(100)
+	waitForClients(t, b, 1)
+	wait(t, robot.Publish("robots/robot1/telemetry/health", 1, false, "5"))
+	if st := b.Stats(); st.Queued != 0 {
+		t.Errorf("queued %d after clean session", st.Queued)
+	}
+}
+
+// waitForClients waits for the broker to notice disconnects
+func waitForClients(t *testing.T, b *Broker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Stats().Clients != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("clients = %d, want %d", b.Stats().Clients, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUnacknowledgedResentOnResume(t *testing.T) {
+	b := startBroker(t)
+
+	// A raw client subscribes on a persistent session and never acknowledges
+	nc, err := net.Dial("tcp", b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(nc)
+	rawConnect(t, nc, r, "raw", false)
+	subscribe := appendString([]byte{0, 1}, "robots/#")
+	nc.Write(encodePacket(packetSubscribe, 0x02, append(subscribe, 1)))
+	if p := readRaw(t, r); p.kind != packetSuback {
+		t.Fatalf("got packet %d, want SUBACK", p.kind)
+	}
+
+	b.Publish("robots/robot1/commands", 1, false, []byte("stop"))
+	first := readRaw(t, r)
+	msg, id, err := parsePublish(first)
+	if err != nil || msg.QoS != 1 || string(msg.Payload) != "stop" {
+		t.Fatalf("got %+v, %v", msg, err)
+	}
+	nc.Close()
+	waitForClients(t, b, 0)
+
+	nc, err = net.Dial("tcp", b.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	r = bufio.NewReader(nc)
+	if present := rawConnect(t, nc, r, "raw", false); !present {
+		t.Error("session not resumed")
+	}
+	again := readRaw(t, r)
+	msg, id2, err := parsePublish(again)
+	if err != nil || id2 != id || again.flags&0x08 == 0 || string(msg.Payload) != "stop" {
+		t.Errorf("resent %+v id %d flags %#x, want dup of id %d", msg, id2, again.flags, id)
+	}
+	nc.Write(encodeAck(packetPuback, id))
+}
+
+func rawConnect(t *testing.T, nc net.Conn, r *bufio.Reader, clientID string, clean bool) bool {
+	t.Helper()
+	body := appendString(nil, "MQTT")
+	flags := byte(0)
+	if clean {
+		flags = 0x02
+	}
+	body = append(body, 4, flags, 0, 0)
+	body = appendString(body, clientID)
+	nc.Write(encodePacket(packetConnect, 0, body))
+	p := readRaw(t, r)
+	if p.kind != packetConnack || len(p.body) != 2 || p.body[1] != connackAccepted {
+		t.Fatalf("got packet %d %v, want CONNACK", p.kind, p.body)
+	}
+	return p.body[0]&0x01 != 0
+}
+
+func readRaw(t *testing.T, r *bufio.Reader) packet {
+	t.Helper()
+	p, err := readPacket(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return p
+}
+
+func TestRejectsBadConnect(t *testing.T) {
+	b := New(Config{Authenticate: func(clientID, username string, password []byte) bool {
+		return username == "robot" && string(password) == "secret"
+	}})
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	opts := mqtt.NewClientOptions().AddBroker(b.URL()).SetClientID("r1").
+		SetUsername("robot").SetPassword("wrong").SetAutoReconnect(false)
+	if token := mqtt.NewClient(opts).Connect(); token.WaitTimeout(2*time.Second) && token.Error() == nil {
+		t.Error("bad credentials accepted")
+	}
+
+	opts.SetPassword("secret")
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); !token.WaitTimeout(2*time.Second) || token.Error() != nil {
+		t.Fatalf("good credentials refused: %v", token.Error())
+	}
+	client.Disconnect(0)
+}
@@ -0,0 +1,263 @@
+package broker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetPubrec      = 5
+	packetPubrel      = 6
+	packetPubcomp     = 7
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+// CONNACK return codes
+const (
+	connackAccepted           = 0
+	connackBadProtocol        = 1
+	connackIdentifierRejected = 2
+	connackBadCredentials     = 4
+	connackNotAuthorized      = 5
+)
+
+// maxPacketSize bounds the remaining length the broker accepts
+const maxPacketSize = 1 << 20
+
+// ErrProtocol is returned for packets that violate MQTT 3.1.1
+var ErrProtocol = errors.New("mqtt protocol violation")
+
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	length, shift := 0, 0
+	for i := 0; ; i++ {
+		if i == 4 {
+			return packet{}, fmt.Errorf("%w: remaining length too long", ErrProtocol)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		length |= int(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxPacketSize {
+		return packet{}, fmt.Errorf("%w: packet of %d bytes", ErrProtocol, length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: header >> 4, flags: header & 0x0f, body: body}, nil
+}
+
+func encodePacket(kind, flags byte, body []byte) []byte {
+	out := []byte{kind<<4 | flags}
+	n := len(body)
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+// reader walks the variable header and payload of a packet
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) byte() byte {
+	if r.err != nil || len(r.data) < 1 {
+		r.fail()
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *reader) uint16() uint16 {
+	if r.err != nil || len(r.data) < 2 {
+		r.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data)
+	r.data = r.data[2:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := int(r.uint16())
+	if r.err != nil || len(r.data) < n {
+		r.fail()
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) fail() {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: truncated packet", ErrProtocol)
+	}
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+type connectPacket struct {
+	protocol     string
+	level        byte
+	cleanSession bool
+	keepAlive    uint16
+	clientID     string
+	will         *Message
+	username     string
+	password     []byte
+	hasUsername  bool
+}
+
+func parseConnect(body []byte) (connectPacket, error) {
+	r := &reader{data: body}
+	c := connectPacket{protocol: r.string(), level: r.byte()}
+	flags := r.byte()
+	c.keepAlive = r.uint16()
+	if r.err != nil {
+		return c, r.err
+	}
+	if flags&0x01 != 0 {
+		return c, fmt.Errorf("%w: reserved connect flag set", ErrProtocol)
+	}
+	c.cleanSession = flags&0x02 != 0
+	c.clientID = r.string()
+	if flags&0x04 != 0 {
+		c.will = &Message{
+			Topic:    r.string(),
+			QoS:      (flags >> 3) & 0x03,
+			Retained: flags&0x20 != 0,
+		}
+		c.will.Payload = append([]byte(nil), r.bytes()...)
+	}
+	if flags&0x80 != 0 {
+		c.hasUsername = true
+		c.username = r.string()
+	}
+	if flags&0x40 != 0 {
+		c.password = append([]byte(nil), r.bytes()...)
+	}
+	return c, r.err
+}
+
+func parsePublish(p packet) (Message, uint16, error) {
+	r := &reader{data: p.body}
+	msg := Message{
+		Topic:    r.string(),
+		QoS:      (p.flags >> 1) & 0x03,
+		Retained: p.flags&0x01 != 0,
+	}
+	var id uint16
+	if msg.QoS > 0 {
+		id = r.uint16()
+	}
+	if r.err != nil {
+		return msg, 0, r.err
+	}
+	if msg.QoS > 2 {
+		return msg, 0, fmt.Errorf("%w: qos 3", ErrProtocol)
+	}
+	msg.Payload = append([]byte(nil), r.data...)
+	return msg, id, nil
+}
+
+func encodePublish(msg Message, id uint16, dup bool) []byte {
+	flags := msg.QoS << 1
+	if msg.Retained {
+		flags |= 0x01
+	}
+	if dup {
+		flags |= 0x08
+	}
+	body := appendString(nil, msg.Topic)
+	if msg.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, msg.Payload...)
+	return encodePacket(packetPublish, flags, body)
+}
+
+func encodeAck(kind byte, id uint16) []byte {
+	flags := byte(0)
+	if kind == packetPubrel {
+		flags = 0x02
+	}
+	return encodePacket(kind, flags, binary.BigEndian.AppendUint16(nil, id))
+}
+
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+func parseSubscribe(body []byte) (uint16, []subscription, error) {
+	r := &reader{data: body}
+	id := r.uint16()
+	var subs []subscription
+	for r.err == nil && len(r.data) > 0 {
+		subs = append(subs, subscription{filter: r.string(), qos: r.byte()})
+	}
+	if r.err == nil && len(subs) == 0 {
+		return id, nil, fmt.Errorf("%w: subscribe without topics", ErrProtocol)
+	}
+	return id, subs, r.err
+}
+
+func parseUnsubscribe(body []byte) (uint16, []string, error) {
+	r := &reader{data: body}
+	id := r.uint16()
+	var filters []string
+	for r.err == nil && len(r.data) > 0 {
+		filters = append(filters, r.string())
+	}
+	return id, filters, r.err
+}
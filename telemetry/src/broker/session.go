@@ -0,0 +1,424 @@
+package broker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// connectTimeout is how long a new connection has to send CONNECT
+const connectTimeout = 10 * time.Second
+
+// writeTimeout bounds a single write to a client
+const writeTimeout = 10 * time.Second
+
+type inflight struct {
+	id  uint16
+	msg Message
+}
+
+// session is the state of one client ID: subscriptions, QoS 1 messages awaiting
+// PUBACK and, while offline, queued messages. All fields are guarded by Broker.mu.
+type session struct {
+	clientID string
+	clean    bool
+	subs     map[string]byte
+	conn     *conn
+	inflight []inflight
+	queue    []Message
+	nextID   uint16
+	dropped  uint64
+}
+
+func newSession(clientID string) *session {
+	return &session{clientID: clientID, subs: make(map[string]byte)}
+}
+
+// match returns the highest QoS of the session's filters matching topic
+func (s *session) match(topic string) (byte, bool) {
+	var qos byte
+	found := false
+	for filter, q := range s.subs {
+		if TopicMatches(filter, topic) {
+			found = true
+			if q > qos {
+				qos = q
+			}
+		}
+	}
+	return qos, found
+}
+
+// deliver sends msg to the client, or queues it while a persistent session is offline
+func (s *session) deliver(msg Message, maxQueued int) {
+	if s.conn == nil {
+		if msg.QoS == 0 {
+			return
+		}
+		s.queue = append(s.queue, msg)
+		if len(s.queue) > maxQueued {
+			s.queue = s.queue[len(s.queue)-maxQueued:]
+			s.dropped++
+		}
+		return
+	}
+	if msg.QoS == 0 {
+		s.conn.send(encodePublish(msg, 0, false))
+		return
+	}
+	id, ok := s.packetID()
+	if !ok {
+		s.dropped++
+		return
+	}
+	s.inflight = append(s.inflight, inflight{id: id, msg: msg})
+	s.conn.send(encodePublish(msg, id, false))
+}
+
+// resume resends unacknowledged messages and flushes the offline queue after a
+// persistent session reconnects
+func (s *session) resume(maxQueued int) {
+	for _, f := range s.inflight {
+		s.conn.send(encodePublish(f.msg, f.id, true))
+	}
+	queued := s.queue
+	s.queue = nil
+	for _, msg := range queued {
+		s.deliver(msg, maxQueued)
+	}
+}
+
+func (s *session) acknowledge(id uint16) {
+	for i, f := range s.inflight {
+		if f.id == id {
+			s.inflight = append(s.inflight[:i], s.inflight[i+1:]...)
+			return
+		}
+	}
+}
+
+// packetID returns a packet identifier not used by an in-flight message
+func (s *session) packetID() (uint16, bool) {
+	if len(s.inflight) >= 0xffff {
+		return 0, false
+	}
+	for {
+		s.nextID++
+		if s.nextID == 0 {
+			continue
+		}
+		used := false
+		for _, f := range s.inflight {
+			if f.id == s.nextID {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return s.nextID, true
+		}
+	}
+}
+
+// conn is one client network connection
+type conn struct {
+	broker    *Broker
+	net       net.Conn
+	session   *session
+	will      *Message
+	clientID  string
+	mu        sync.Mutex
+	pending   [][]byte
+	pendingN  int
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newConn(b *Broker, nc net.Conn) *conn {
+	return &conn{
+		broker: b,
+		net:    nc,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// send queues a packet for the writer without blocking; a client that falls too far
+// behind is disconnected
+func (c *conn) send(pkt []byte) {
+	c.mu.Lock()
+	if c.pendingN+len(pkt) > c.broker.config.MaxPendingBytes {
+		c.mu.Unlock()
+		c.broker.log.Warn("Client %s is not keeping up, disconnecting", c.clientID)
+		c.close()
+		return
+	}
+	c.pending = append(c.pending, pkt)
+	c.pendingN += len(pkt)
+	c.mu.Unlock()
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *conn) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.net.Close()
+	})
+}
+
+func (c *conn) writer() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.wake:
+		}
+		c.mu.Lock()
+		batch := c.pending
+		c.pending = nil
+		c.pendingN = 0
+		c.mu.Unlock()
+		for _, pkt := range batch {
+			c.net.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if _, err := c.net.Write(pkt); err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// writeNow writes pkt directly; used before the writer starts
+func (c *conn) writeNow(pkt []byte) {
+	c.net.SetWriteDeadline(time.Now().Add(writeTimeout))
+	c.net.Write(pkt)
+}
+
+func (c *conn) serve() {
+	defer c.broker.wg.Done()
+	defer c.close()
+
+	graceful, err := c.run()
+	c.broker.detach(c, !graceful)
+	if err != nil && !errors.Is(err, net.ErrClosed) && c.clientID != "" {
+		c.broker.log.Info("Client %s disconnected: %v", c.clientID, err)
+	}
+}
+
+// run handles CONNECT and then every packet until the client disconnects. It reports
+// whether the client sent DISCONNECT.
+func (c *conn) run() (bool, error) {
+	r := bufio.NewReader(c.net)
+	c.net.SetReadDeadline(time.Now().Add(connectTimeout))
+	first, err := readPacket(r)
+	if err != nil {
+		return false, err
+	}
+	if first.kind != packetConnect {
+		return false, fmt.Errorf("%w: expected CONNECT, got packet type %d", ErrProtocol, first.kind)
+	}
+	keepAlive, err := c.connect(first)
+	if err != nil {
+		return false, err
+	}
+	go c.writer()
+
+	qos2 := make(map[uint16]bool)
+	for {
+		if keepAlive > 0 {
+			c.net.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		} else {
+			c.net.SetReadDeadline(time.Time{})
+		}
+		p, err := readPacket(r)
+		if err != nil {
+			return false, err
+		}
+		switch p.kind {
+		case packetPublish:
+			if err := c.publish(p, qos2); err != nil {
+				return false, err
+			}
+		case packetPuback:
+			id, err := packetIdentifier(p)
+			if err != nil {
+				return false, err
+			}
+			c.broker.mu.Lock()
+			c.session.acknowledge(id)
+			c.broker.mu.Unlock()
+		case packetPubrel:
+			id, err := packetIdentifier(p)
+			if err != nil {
+				return false, err
+			}
+			delete(qos2, id)
+			c.send(encodeAck(packetPubcomp, id))
+		case packetSubscribe:
+			if err := c.subscribe(p); err != nil {
+				return false, err
+			}
+		case packetUnsubscribe:
+			if err := c.unsubscribe(p); err != nil {
+				return false, err
+			}
+		case packetPingreq:
+			c.send(encodePacket(packetPingresp, 0, nil))
+		case packetDisconnect:
+			return true, nil
+		default:
+			return false, fmt.Errorf("%w: unexpected packet type %d", ErrProtocol, p.kind)
+		}
+	}
+}
+
+// connect validates CONNECT, attaches the session and answers with CONNACK
+func (c *conn) connect(p packet) (time.Duration, error) {
+	req, err := parseConnect(p.body)
+	if err != nil {
+		return 0, err
+	}
+	refuse := func(code byte, reason string) (time.Duration, error) {
+		c.writeNow(encodePacket(packetConnack, 0, []byte{0, code}))
+		return 0, fmt.Errorf("connection refused: %s", reason)
+	}
+	if !(req.protocol == "MQTT" && req.level == 4) && !(req.protocol == "MQIsdp" && req.level == 3) {
+		return refuse(connackBadProtocol, fmt.Sprintf("protocol %s level %d", req.protocol, req.level))
+	}
+	if req.will != nil && (req.will.QoS > 2 || !validTopic(req.will.Topic)) {
+		return 0, fmt.Errorf("%w: invalid will", ErrProtocol)
+	}
+	if req.clientID == "" {
+		if !req.cleanSession {
+			return refuse(connackIdentifierRejected, "empty client ID for a persistent session")
+		}
+		req.clientID = randomClientID()
+	}
+	if auth := c.broker.config.Authenticate; auth != nil && !auth(req.clientID, req.username, req.password) {
+		return refuse(connackBadCredentials, "authentication failed for "+req.clientID)
+	}
+	c.clientID = req.clientID
+	if req.will != nil {
+		if req.will.QoS > 1 {
+			req.will.QoS = 1
+		}
+		c.will = req.will
+	}
+
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, ErrClosed
+	}
+	present := b.attach(c, req.clientID, req.cleanSession)
+	flags := byte(0)
+	if present {
+		flags = 1
+	}
+	c.send(encodePacket(packetConnack, 0, []byte{flags, connackAccepted}))
+	if present {
+		c.session.resume(b.config.MaxQueued)
+	}
+	b.log.Info("Client %s connected (clean session %t, resumed %t)", req.clientID, req.cleanSession, present)
+	return time.Duration(req.keepAlive) * time.Second, nil
+}
+
+func (c *conn) publish(p packet, qos2 map[uint16]bool) error {
+	msg, id, err := parsePublish(p)
+	if err != nil {
+		return err
+	}
+	if !validTopic(msg.Topic) {
+		return fmt.Errorf("%w: publish to %q", ErrProtocol, msg.Topic)
+	}
+
+	route := true
+	if msg.QoS == 2 {
+		// Deliver once per packet identifier until the client releases it
+		route = !qos2[id]
+		qos2[id] = true
+		msg.QoS = 1
+	}
+	if route {
+		c.broker.mu.Lock()
+		c.broker.route(msg)
+		c.broker.mu.Unlock()
+	}
+
+	switch p.flags >> 1 & 0x03 {
+	case 1:
+		c.send(encodeAck(packetPuback, id))
+	case 2:
+		c.send(encodeAck(packetPubrec, id))
+	}
+	return nil
+}
+
+func (c *conn) subscribe(p packet) error {
+	if p.flags != 0x02 {
+		return fmt.Errorf("%w: SUBSCRIBE flags %#x", ErrProtocol, p.flags)
+	}
+	id, subs, err := parseSubscribe(p.body)
+	if err != nil {
+		return err
+	}
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	granted := make([]byte, 0, len(subs)+2)
+	granted = append(granted, byte(id>>8), byte(id))
+	for _, sub := range subs {
+		if sub.qos > 2 {
+			return fmt.Errorf("%w: requested qos %d", ErrProtocol, sub.qos)
+		}
+		if !validFilter(sub.filter) {
+			granted = append(granted, 0x80)
+			continue
+		}
+		qos := sub.qos
+		if qos > 1 {
+			qos = 1
+		}
+		c.session.subs[sub.filter] = qos
+		granted = append(granted, qos)
+	}
+	c.send(encodePacket(packetSuback, 0, granted))
+	for i, sub := range subs {
+		if granted[i+2] != 0x80 {
+			b.sendRetained(c.session, sub.filter, granted[i+2])
+		}
+	}
+	return nil
+}
+
+func (c *conn) unsubscribe(p packet) error {
+	if p.flags != 0x02 {
+		return fmt.Errorf("%w: UNSUBSCRIBE flags %#x", ErrProtocol, p.flags)
+	}
+	id, filters, err := parseUnsubscribe(p.body)
+	if err != nil {
+		return err
+	}
+	c.broker.mu.Lock()
+	for _, filter := range filters {
+		delete(c.session.subs, filter)
+	}
+	c.broker.mu.Unlock()
+	c.send(encodeAck(packetUnsuback, id))
+	return nil
+}
+
+func packetIdentifier(p packet) (uint16, error) {
+	r := &reader{data: p.body}
+	id := r.uint16()
+	return id, r.err
+}
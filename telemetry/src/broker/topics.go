@@ -0,0 +1,51 @@
+package broker
+
+import "strings"
+
+// validTopic reports whether name can be published to: non-empty and free of wildcards
+func validTopic(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "+#\x00")
+}
+
+// validFilter reports whether filter is a well-formed subscription: + must fill a
+// whole level and # must be the last level
+func validFilter(filter string) bool {
+	if filter == "" || strings.ContainsRune(filter, 0) {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		switch {
+		case level == "#":
+			if i != len(levels)-1 {
+				return false
+			}
+		case level == "+":
+		case strings.ContainsAny(level, "+#"):
+			return false
+		}
+	}
+	return true
+}
+
+// TopicMatches reports whether topic matches the subscription filter. Topics starting
+// with $ are not matched by filters starting with a wildcard.
+func TopicMatches(filter, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	for i, level := range f {
+		if level == "#" {
+			return true
+		}
+		if i >= len(t) {
+			return false
+		}
+		if level != "+" && level != t[i] {
+			return false
+		}
+	}
+	return len(f) == len(t)
+}
@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketPath is where the broker accepts MQTT over WebSockets
+const WebSocketPath = "/mqtt"
+
+// StartWebSocket also accepts MQTT over WebSockets on addr, at WebSocketPath
+func (b *Broker) StartWebSocket(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"mqtt"},
+		// Robots are not browsers; there is no origin to check
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(WebSocketPath, func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		b.serveConn(&wsConn{ws: ws})
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: connectTimeout}
+
+	b.mu.Lock()
+	if b.closed || b.wsServer != nil {
+		b.mu.Unlock()
+		ln.Close()
+		if b.closed {
+			return ErrClosed
+		}
+		return errors.New("broker WebSocket listener already started")
+	}
+	b.wsServer = server
+	b.wsAddr = ln.Addr().String()
+	b.mu.Unlock()
+
+	b.log.Info("MQTT broker accepting WebSockets on %s%s", ln.Addr(), WebSocketPath)
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		server.Serve(ln)
+	}()
+	return nil
+}
+
+// WebSocketURL returns the URL for MQTT clients over WebSockets, or "" before
+// StartWebSocket
+func (b *Broker) WebSocketURL() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wsServer == nil {
+		return ""
+	}
+	return "ws://" + b.wsAddr + WebSocketPath
+}
+
+// wsConn carries the MQTT byte stream in binary WebSocket messages
+type wsConn struct {
+	ws     *websocket.Conn
+	reader io.Reader
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			kind, r, err := c.ws.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			if kind != websocket.BinaryMessage {
+				continue
+			}
+			c.reader = r
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,68 @@
+package telemetry
+
+import "errors"
+
+// ErrCOBSDecode is returned for byte sequences that are not valid COBS encodings
+var ErrCOBSDecode = errors.New("invalid cobs encoding")
+
+// cobsEncode applies Consistent Overhead Byte Stuffing so the result contains no zero
+// bytes and can be delimited by 0x00 on the wire. The delimiter is not appended.
+func cobsEncode(src []byte) []byte {
+	dst := make([]byte, 1, len(src)+len(src)/254+2)
+	codeIdx := 0
+	code := byte(1)
+
+	for _, b := range src {
+		if b != 0 {
+			dst = append(dst, b)
+			code++
+		}
+		if b == 0 || code == 0xFF {
+			dst[codeIdx] = code
+			codeIdx = len(dst)
+			dst = append(dst, 0)
+			code = 1
+		}
+	}
+	dst[codeIdx] = code
+	return dst
+}
+
+// cobsDecode reverses cobsEncode; src must not include the 0x00 delimiter
+func cobsDecode(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src))
+	for i := 0; i < len(src); {
+		code := src[i]
+		if code == 0 || i+int(code) > len(src) {
+			return nil, ErrCOBSDecode
+		}
+		i++
+		for j := 1; j < int(code); j++ {
+			if src[i] == 0 {
+				return nil, ErrCOBSDecode
+			}
+			dst = append(dst, src[i])
+			i++
+		}
+		if code < 0xFF && i < len(src) {
+			dst = append(dst, 0)
+		}
+	}
+	return dst, nil
+}
+
+// crc16CCITT computes CRC-16/CCITT-FALSE (poly 0x1021, init 0xFFFF)
+func crc16CCITT(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Content types of the built-in codecs
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeCBOR    = "application/cbor"
+	ContentTypeCompact = "application/vnd.robo.compact"
+)
+
+// Errors returned by codecs
+var (
+	ErrUnknownContentType = errors.New("unknown content type")
+	ErrUnsupportedType    = errors.New("type not supported by codec")
+	ErrCorrupt            = errors.New("corrupt encoding")
+)
+
+// Codec encodes message payloads. The content type travels with each message so the
+// receiver can pick the matching codec.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs
+var (
+	JSON    Codec = jsonCodec{}
+	CBOR    Codec = cborCodec{}
+	Compact Codec = compactCodec{}
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		ContentTypeJSON:    JSON,
+		ContentTypeCBOR:    CBOR,
+		ContentTypeCompact: Compact,
+	}
+)
+
+// Register makes a codec available to Lookup, replacing any with the same content type
+func Register(c Codec) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[c.ContentType()] = c
+}
+
+// Lookup returns the codec for a content type; an empty content type means JSON
+func Lookup(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	c, ok := registry[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownContentType, contentType)
+	}
+	return c, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,184 @@
+package codec
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+func navigationSample() simulation.NavigationMessage {
+	return simulation.NavigationMessage{
+		RobotID:   "WAREHOUSE_ROBOT_017",
+		Timestamp: time.Date(2024, 3, 1, 10, 30, 0, 123456789, time.UTC),
+		Position:  simulation.Position{X: 12.5, Y: -3.25, Z: 0},
+		Heading:   87.5,
+		Velocity:  1.25,
+		Obstacles: []simulation.Obstacle{
+			{Position: simulation.Position{X: 13.5, Y: -3}, Size: 0.5, Type: "static", Severity: "HIGH"},
+			{Position: simulation.Position{X: 15, Y: -2.75}, Size: 0.25, Type: "dynamic", Severity: "LOW"},
+		},
+		PathStatus: "CLEAR",
+	}
+}
+
+func TestCodecRoundTrips(t *testing.T) {
+	nav := navigationSample()
+	health := simulation.HealthMessage{
+		RobotID:     "r1",
+		Timestamp:   time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC),
+		CPUTemp:     51.5,
+		MotorTemps:  []float64{40.5, 41},
+		ErrorCodes:  []string{"BATTERY_OVER_TEMPERATURE"},
+		Throttled:   true,
+		OutboxDepth: 12,
+	}
+
+	for _, c := range []Codec{JSON, CBOR, Compact} {
+		data, err := c.Marshal(nav)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %v", c.ContentType(), err)
+		}
+		var gotNav simulation.NavigationMessage
+		if err := c.Unmarshal(data, &gotNav); err != nil {
+			t.Fatalf("%s: unmarshal failed: %v", c.ContentType(), err)
+		}
+		if !reflect.DeepEqual(gotNav, nav) {
+			t.Errorf("%s: navigation round trip mismatch:\n got %+v\nwant %+v", c.ContentType(), gotNav, nav)
+		}
+
+		data, err = c.Marshal(&health)
+		if err != nil {
+			t.Fatalf("%s: marshal failed: %v", c.ContentType(), err)
+		}
+		var gotHealth simulation.HealthMessage
+		if err := c.Unmarshal(data, &gotHealth); err != nil {
+			t.Fatalf("%s: unmarshal failed: %v", c.ContentType(), err)
+		}
+		if !reflect.DeepEqual(gotHealth, health) {
+			t.Errorf("%s: health round trip mismatch:\n got %+v\nwant %+v", c.ContentType(), gotHealth, health)
+		}
+	}
+}
+
+// TestCodecSizes documents what each encoding costs for a navigation message
+func TestCodecSizes(t *testing.T) {
+	nav := navigationSample()
+	sizes := make(map[string]int)
+	for _, c := range []Codec{JSON, CBOR, Compact} {
+		data, err := c.Marshal(nav)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[c.ContentType()] = len(data)
+	}
+	t.Logf("navigation message: json=%d cbor=%d compact=%d bytes",
+		sizes[ContentTypeJSON], sizes[ContentTypeCBOR], sizes[ContentTypeCompact])
+
+	if sizes[ContentTypeCBOR] >= sizes[ContentTypeJSON] {
+		t.Errorf("CBOR (%d) should be smaller than JSON (%d)", sizes[ContentTypeCBOR], sizes[ContentTypeJSON])
+	}
+	if sizes[ContentTypeCompact]*3 > sizes[ContentTypeJSON] {
+		t.Errorf("Compact (%d) should be under a third of JSON (%d)", sizes[ContentTypeCompact], sizes[ContentTypeJSON])
+	}
+}
+
+func TestCompactPrecisionAndCompatibility(t *testing.T) {
+	nav := navigationSample()
+	nav.Position.X = 1234.56789
+	data, err := Compact.Marshal(nav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got simulation.NavigationMessage
+	if err := Compact.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(got.Position.X-nav.Position.X) > 1e-3 {
+		t.Errorf("Float32 precision lost too much: %v vs %v", got.Position.X, nav.Position.X)
+	}
+
+	// A sender with fewer fields still decodes; the missing ones are zero
+	short := data[:len(data)-len("CLEAR")-1]
+	got = simulation.NavigationMessage{PathStatus: "stale"}
+	if err := Compact.Unmarshal(short, &got); err != nil {
+		t.Fatalf("Older message failed to decode: %v", err)
+	}
+	if got.PathStatus != "" || len(got.Obstacles) != 2 {
+		t.Errorf("Unexpected decode of older message: %+v", got)
+	}
+
+	if err := Compact.Unmarshal(data[:10], &got); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for a cut field, got %v", err)
+	}
+	var health simulation.HealthMessage
+	if err := Compact.Unmarshal(data, &health); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected schema mismatch, got %v", err)
+	}
+	if _, err := Compact.Marshal(map[string]int{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Expected ErrUnsupportedType, got %v", err)
+	}
+}
+
+// TestCompactDecodesOlderRobots decodes frames recorded from earlier releases, which
+// catches fields inserted rather than appended
+func TestCompactDecodesOlderRobots(t *testing.T) {
+	// A health message from before the bandwidth governor's shed counts
+	frame, _ := hex.DecodeString("0206726f626f74318080faf1dee9cdb82f000036420200004842000042420000" +
+		"00000000000000000000000000000000000000000000000000000000000000000004000300004841")
+	var got simulation.HealthMessage
+	if err := Compact.Unmarshal(frame, &got); err != nil {
+		t.Fatalf("Older health message failed to decode: %v", err)
+	}
+	want := simulation.HealthMessage{
+		RobotID:         "robot1",
+		Timestamp:       time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+		CPUTemp:         45.5,
+		MotorTemps:      []float64{50, 48.5},
+		OutboxDepth:     2,
+		Reconnects:      3,
+		DowntimeSeconds: 12.5,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unexpected decode of older health message:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestCBORDecodesForeignEncodings(t *testing.T) {
+	// {"a": 1.5 as float16, "b": [-2, h'01'], "c": 1(1700000000)}
+	data := []byte{0xa3,
+		0x61, 'a', 0xf9, 0x3e, 0x00,
+		0x61, 'b', 0x82, 0x21, 0x41, 0x01,
+		0x61, 'c', 0xc1, 0x1a, 0x65, 0x53, 0xf1, 0x00,
+	}
+	var v struct {
+		A float64       `json:"a"`
+		B []interface{} `json:"b"`
+		C int64         `json:"c"`
+	}
+	if err := CBOR.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.A != 1.5 || v.B[0] != float64(-2) || v.C != 1700000000 {
+		t.Errorf("Unexpected decode: %+v", v)
+	}
+	if err := CBOR.Unmarshal(data[:len(data)-1], &v); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt for truncated input, got %v", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	if c, err := Lookup(""); err != nil || c != JSON {
+		t.Errorf("Empty content type should be JSON, got %v %v", c, err)
+	}
+	if c, err := Lookup(ContentTypeCompact); err != nil || c != Compact {
+		t.Errorf("Lookup compact failed: %v %v", c, err)
+	}
+	if _, err := Lookup("application/xml"); !errors.Is(err, ErrUnknownContentType) {
+		t.Errorf("Expected ErrUnknownContentType, got %v", err)
+	}
+}
@@ -0,0 +1,240 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// compactCodec is a schema-driven binary encoding for the simulation message types.
+// The schema is the Go struct itself: exported fields are written in declaration
+// order without names, so fields may only ever be appended. A decoder that reaches
+// the end of the data leaves the remaining fields zero, and ignores trailing fields it
+// does not know, so old and new robots interoperate.
+//
+// Layout per value:
+//
+//	struct     fields in order
+//	string     uvarint length, bytes
+//	float      float32 little endian (telemetry does not need more precision)
+//	int        zigzag varint
+//	uint       uvarint
+//	bool       one byte
+//	slice      uvarint length, elements
+//	time.Time  varint Unix nanoseconds, 0 for the zero time; decoded as UTC
+//
+// Each message starts with a one byte schema ID identifying its type.
+type compactCodec struct{}
+
+// compactSchemas assigns IDs to the supported types; IDs must never be reused
+var compactSchemas = map[byte]reflect.Type{
+	1: reflect.TypeOf(simulation.HeartbeatMessage{}),
+	2: reflect.TypeOf(simulation.HealthMessage{}),
+	3: reflect.TypeOf(simulation.NavigationMessage{}),
+	4: reflect.TypeOf(simulation.StatusMessage{}),
+}
+
+var (
+	compactIDsOnce sync.Once
+	compactIDs     map[reflect.Type]byte
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func compactID(t reflect.Type) (byte, bool) {
+	compactIDsOnce.Do(func() {
+		compactIDs = make(map[reflect.Type]byte, len(compactSchemas))
+		for id, st := range compactSchemas {
+			compactIDs[st] = id
+		}
+	})
+	id, ok := compactIDs[t]
+	return id, ok
+}
+
+func (compactCodec) ContentType() string {
+	return ContentTypeCompact
+}
+
+func (compactCodec) Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	id, ok := compactID(rv.Type())
+	if !ok {
+		return nil, fmt.Errorf("%w: compact %T", ErrUnsupportedType, v)
+	}
+	buf := bytes.NewBuffer([]byte{id})
+	if err := compactEncode(buf, rv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compactCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("%w: compact needs a non-nil pointer, got %T", ErrUnsupportedType, v)
+	}
+	rv = rv.Elem()
+	id, ok := compactID(rv.Type())
+	if !ok {
+		return fmt.Errorf("%w: compact %s", ErrUnsupportedType, rv.Type())
+	}
+	if len(data) == 0 || data[0] != id {
+		return fmt.Errorf("%w: compact schema mismatch for %s", ErrCorrupt, rv.Type())
+	}
+	r := bytes.NewReader(data[1:])
+	rv.Set(reflect.Zero(rv.Type()))
+	return compactDecodeStruct(r, rv, true)
+}
+
+func compactEncode(buf *bytes.Buffer, v reflect.Value) error {
+	var scratch [binary.MaxVarintLen64]byte
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			t := v.Interface().(time.Time)
+			nanos := int64(0)
+			if !t.IsZero() {
+				nanos = t.UnixNano()
+			}
+			buf.Write(scratch[:binary.PutVarint(scratch[:], nanos)])
+			return nil
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := compactEncode(buf, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(v.Len()))])
+		buf.WriteString(v.String())
+	case reflect.Float32, reflect.Float64:
+		binary.Write(buf, binary.LittleEndian, math.Float32bits(float32(v.Float())))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.Write(scratch[:binary.PutVarint(scratch[:], v.Int())])
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], v.Uint())])
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case reflect.Slice:
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(v.Len()))])
+		for i := 0; i < v.Len(); i++ {
+			if err := compactEncode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: compact field kind %s", ErrUnsupportedType, v.Kind())
+	}
+	return nil
+}
+
+// compactDecodeStruct decodes fields in order. At the top level running out of data
+// between fields is not an error, so messages from older senders still decode.
+func compactDecodeStruct(r *bytes.Reader, v reflect.Value, topLevel bool) error {
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+		if topLevel && r.Len() == 0 {
+			return nil
+		}
+		if err := compactDecode(r, v.Field(i)); err != nil {
+			return fmt.Errorf("%s.%s: %w", v.Type().Name(), v.Type().Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+func compactDecode(r *bytes.Reader, v reflect.Value) error {
+	corrupt := func(err error) error {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			nanos, err := binary.ReadVarint(r)
+			if err != nil {
+				return corrupt(err)
+			}
+			if nanos != 0 {
+				v.Set(reflect.ValueOf(time.Unix(0, nanos).UTC()))
+			}
+			return nil
+		}
+		return compactDecodeStruct(r, v, false)
+	case reflect.String:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		if n > uint64(r.Len()) {
+			return corrupt(fmt.Errorf("string of %d bytes with %d left", n, r.Len()))
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		v.SetString(string(b))
+	case reflect.Float32, reflect.Float64:
+		var bits uint32
+		if err := binary.Read(r, binary.LittleEndian, &bits); err != nil {
+			return corrupt(err)
+		}
+		v.SetFloat(float64(math.Float32frombits(bits)))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		v.SetUint(n)
+	case reflect.Bool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return corrupt(err)
+		}
+		v.SetBool(b != 0)
+	case reflect.Slice:
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return corrupt(err)
+		}
+		// Every element takes at least one byte
+		if n > uint64(r.Len()) {
+			return corrupt(fmt.Errorf("slice of %d elements with %d bytes left", n, r.Len()))
+		}
+		if n == 0 {
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), int(n), int(n))
+		for i := 0; i < int(n); i++ {
+			if err := compactDecode(r, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	default:
+		return fmt.Errorf("%w: compact field kind %s", ErrUnsupportedType, v.Kind())
+	}
+	return nil
+}
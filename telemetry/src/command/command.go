@@ -0,0 +1,267 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Version is the command envelope version this package speaks
+const Version = 1
+
+// Errors returned while decoding and validating commands
+var (
+	ErrMalformed   = errors.New("malformed command")
+	ErrVersion     = errors.New("unsupported command version")
+	ErrExpired     = errors.New("command expired")
+	ErrUnsupported = errors.New("unsupported command type")
+	ErrInvalidArgs = errors.New("invalid command arguments")
+)
+
+// Type identifies what a command asks the robot to do
+type Type string
+
+const (
+	TypeStop      Type = "stop"
+	TypeMoveTo    Type = "move_to"
+	TypeSetMode   Type = "set_mode"
+	TypeSetRate   Type = "set_rate"
+	TypeCalibrate Type = "calibrate"
+	TypePing      Type = "ping"
+)
+
+// Command is the versioned envelope received on robots/<id>/commands
+type Command struct {
+	Version   int             `json:"version"`
+	ID        string          `json:"id"`
+	Type      Type            `json:"type"`
+	Args      json.RawMessage `json:"args,omitempty"`
+	IssuedAt  time.Time       `json:"issued_at"`
+	ExpiresAt time.Time       `json:"expires_at"` // Zero means the command never expires
+	Issuer    string          `json:"issuer"`
+	// Nonce and Signature are set by Sign; robots with a Verifier reject commands
+	// without them
+	Nonce     string     `json:"nonce,omitempty"`
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// New creates a command with encoded args, issued now
+func New(id string, t Type, args interface{}, issuer string) (Command, error) {
+	cmd := Command{Version: Version, ID: id, Type: t, IssuedAt: time.Now(), Issuer: issuer}
+	if args != nil {
+		raw, err := json.Marshal(args)
+		if err != nil {
+			return Command{}, err
+		}
+		cmd.Args = raw
+	}
+	return cmd, nil
+}
+
+// Decode parses a command envelope and checks its version and required fields
+func Decode(data []byte) (Command, error) {
+	var cmd Command
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return Command{}, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if cmd.ID == "" {
+		return cmd, fmt.Errorf("%w: missing id", ErrMalformed)
+	}
+	if cmd.Version != Version {
+		return cmd, fmt.Errorf("%w: %d", ErrVersion, cmd.Version)
+	}
+	if cmd.Type == "" {
+		return cmd, fmt.Errorf("%w: missing type", ErrMalformed)
+	}
+	if cmd.IssuedAt.IsZero() {
+		return cmd, fmt.Errorf("%w: missing issued_at", ErrMalformed)
+	}
+	if err := checkFields(cmd); err != nil {
+		return cmd, err
+	}
+	return cmd, nil
+}
+
+// checkFields rejects control characters in the fields signed as lines of the
+// signing payload, where a newline could move text from one field into the next
+func checkFields(cmd Command) error {
+	fields := []struct{ name, value string }{
+		{"id", cmd.ID},
+		{"type", string(cmd.Type)},
+		{"issuer", cmd.Issuer},
+		{"nonce", cmd.Nonce},
+	}
+	if cmd.Signature != nil {
+		fields = append(fields,
+			struct{ name, value string }{"signature alg", cmd.Signature.Alg},
+			struct{ name, value string }{"signature key_id", cmd.Signature.KeyID})
+	}
+	for _, f := range fields {
+		for _, r := range f.value {
+			if r < 0x20 || r == 0x7f {
+				return fmt.Errorf("%w: control character in %s", ErrMalformed, f.name)
+			}
+		}
+	}
+	return nil
+}
+
+// Expired reports whether the command is past its expiry at now
+func (c Command) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
+}
+
+// Validator is implemented by argument types that check their own values
+type Validator interface {
+	Validate() error
+}
+
+// DecodeArgs decodes the command args into v, rejecting unknown fields, and validates
+// them when v implements Validator
+func (c Command) DecodeArgs(v interface{}) error {
+	if len(c.Args) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(c.Args))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+	}
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArgs, err)
+		}
+	}
+	return nil
+}
+
+// StopArgs are the arguments of a stop command
+type StopArgs struct {
+	Emergency bool   `json:"emergency,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// MoveToArgs are the arguments of a move_to command. Speed is in m/s; zero uses the
+// robot's default.
+type MoveToArgs struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Z     float64 `json:"z"`
+	Speed float64 `json:"speed,omitempty"`
+}
+
+func (a *MoveToArgs) Validate() error {
+	for _, v := range []float64{a.X, a.Y, a.Z, a.Speed} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("coordinates and speed must be finite")
+		}
+	}
+	if a.Speed < 0 {
+		return fmt.Errorf("speed must not be negative")
+	}
+	return nil
+}
+
+// Robot operating modes accepted by set_mode
+const (
+	ModeAutonomous = "autonomous"
+	ModeManual     = "manual"
+	ModeIdle       = "idle"
+	ModeCharging   = "charging"
+)
+
+// SetModeArgs are the arguments of a set_mode command
+type SetModeArgs struct {
+	Mode string `json:"mode"`
+}
+
+func (a *SetModeArgs) Validate() error {
+	switch a.Mode {
+	case ModeAutonomous, ModeManual, ModeIdle, ModeCharging:
+		return nil
+	case "":
+		return fmt.Errorf("mode is required")
+	}
+	return fmt.Errorf("unknown mode %q", a.Mode)
+}
+
+// SetRateArgs are the arguments of a set_rate command, setting how often a telemetry
+// stream such as "health" is published
+type SetRateArgs struct {
+	Stream string  `json:"stream"`
+	Hz     float64 `json:"hz"`
+}
+
+// MaxRateHz bounds set_rate so a typo cannot flood the broker
+const MaxRateHz = 50
+
+func (a *SetRateArgs) Validate() error {
+	if a.Stream == "" {
+		return fmt.Errorf("stream is required")
+	}
+	if math.IsNaN(a.Hz) || a.Hz <= 0 || a.Hz > MaxRateHz {
+		return fmt.Errorf("rate %v Hz outside (0, %d]", a.Hz, MaxRateHz)
+	}
+	return nil
+}
+
+// CalibrateArgs are the arguments of a calibrate command
+type CalibrateArgs struct {
+	Sensor string `json:"sensor"`
+}
+
+func (a *CalibrateArgs) Validate() error {
+	if a.Sensor == "" {
+		return fmt.Errorf("sensor is required")
+	}
+	return nil
+}
+
+// PingArgs are the arguments of a ping command; the payload is echoed back
+type PingArgs struct {
+	Payload string `json:"payload,omitempty"`
+}
+
+// builtinArgs returns a fresh argument value for the built-in command types, so the
+// dispatcher can validate args before accepting a command
+func builtinArgs(t Type) (interface{}, bool) {
+	switch t {
+	case TypeStop:
+		return &StopArgs{}, true
+	case TypeMoveTo:
+		return &MoveToArgs{}, true
+	case TypeSetMode:
+		return &SetModeArgs{}, true
+	case TypeSetRate:
+		return &SetRateArgs{}, true
+	case TypeCalibrate:
+		return &CalibrateArgs{}, true
+	case TypePing:
+		return &PingArgs{}, true
+	}
+	return nil, false
+}
+
+// AckState is the progress of a command reported in acks
+type AckState string
+
+const (
+	AckAccepted  AckState = "accepted"
+	AckRejected  AckState = "rejected"
+	AckCompleted AckState = "completed"
+	AckFailed    AckState = "failed"
+)
+
+// Ack reports the state of a command on robots/<id>/commands/ack
+type Ack struct {
+	Version   int         `json:"version"`
+	CommandID string      `json:"command_id"`
+	Type      Type        `json:"type,omitempty"`
+	RobotID   string      `json:"robot_id"`
+	State     AckState    `json:"state"`
+	Reason    string      `json:"reason,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+	Timestamp time.Time   `json:"timestamp"`
+}
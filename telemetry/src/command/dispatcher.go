@@ -0,0 +1,192 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// ackQoS is at least once, matching the command subscription
+const ackQoS = 1
+
+// Publisher sends raw payloads, e.g. an MQTTTelemetryClient
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+}
+
+// Handler executes an accepted command. The returned result is sent in the completed
+// ack; an error is sent as a failed ack.
+type Handler func(ctx context.Context, cmd Command) (interface{}, error)
+
+// Dispatcher decodes commands, validates them, routes them to the handler registered
+// for their type and reports progress as acks
+type Dispatcher struct {
+	robotID   string
+	ackTopic  string
+	publisher Publisher
+	log       *logger.Logger
+	now       func() time.Time
+	mu        sync.RWMutex
+	handlers  map[Type]Handler
+	wg        sync.WaitGroup
+	verifier  *Verifier
+	audit     func(AuditEvent)
+}
+
+// AuditEvent records what a dispatcher decided about one command
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	RobotID   string    `json:"robot_id"`
+	CommandID string    `json:"command_id,omitempty"`
+	Type      Type      `json:"type,omitempty"`
+	Issuer    string    `json:"issuer,omitempty"`
+	KeyID     string    `json:"key_id,omitempty"`
+	State     AckState  `json:"state"` // Accepted or rejected
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// NewDispatcher creates a dispatcher that publishes acks for robotID on ackTopic
+func NewDispatcher(robotID, ackTopic string, publisher Publisher) *Dispatcher {
+	return &Dispatcher{
+		robotID:   robotID,
+		ackTopic:  ackTopic,
+		publisher: publisher,
+		log:       logger.New(logger.INFO),
+		now:       time.Now,
+		handlers:  make(map[Type]Handler),
+	}
+}
+
+// SetVerifier makes the dispatcher reject commands that are unsigned, signed by an
+// unknown key, outside the verifier's timestamp window or replayed. It must be called
+// before the first Dispatch.
+func (d *Dispatcher) SetVerifier(v *Verifier) {
+	d.verifier = v
+}
+
+// OnAudit registers fn to be called with the decision on every dispatched command. It
+// must be called before the first Dispatch.
+func (d *Dispatcher) OnAudit(fn func(AuditEvent)) {
+	d.audit = fn
+}
+
+// Register sets the handler for a command type, replacing any previous one. Args of
+// the built-in types are validated before the handler is called.
+func (d *Dispatcher) Register(t Type, h Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[t] = h
+}
+
+// Dispatch handles one raw command payload. Rejected commands are acked and their
+// reason returned; accepted commands run in the background until ctx is cancelled.
+func (d *Dispatcher) Dispatch(ctx context.Context, payload []byte) error {
+	cmd, err := d.accept(payload)
+	d.record(cmd, err)
+	if err != nil {
+		if cmd.ID != "" {
+			d.ack(cmd, AckRejected, err.Error(), nil)
+		}
+		d.log.Warn("Rejected command %q: %v", cmd.ID, err)
+		return err
+	}
+
+	d.mu.RLock()
+	handler := d.handlers[cmd.Type]
+	d.mu.RUnlock()
+
+	d.ack(cmd, AckAccepted, "", nil)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		result, err := handler(ctx, cmd)
+		if err != nil {
+			d.log.Error("Command %s (%s) failed: %v", cmd.ID, cmd.Type, err)
+			d.ack(cmd, AckFailed, err.Error(), nil)
+			return
+		}
+		d.ack(cmd, AckCompleted, "", result)
+	}()
+	return nil
+}
+
+// Wait blocks until all running handlers have returned
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) accept(payload []byte) (Command, error) {
+	cmd, err := Decode(payload)
+	if err != nil {
+		return cmd, err
+	}
+	if d.verifier != nil {
+		if err := d.verifier.Verify(cmd, d.robotID, d.now()); err != nil {
+			return cmd, err
+		}
+	}
+	if cmd.Expired(d.now()) {
+		return cmd, fmt.Errorf("%w at %s", ErrExpired, cmd.ExpiresAt.Format(time.RFC3339))
+	}
+
+	d.mu.RLock()
+	_, ok := d.handlers[cmd.Type]
+	d.mu.RUnlock()
+	if !ok {
+		return cmd, fmt.Errorf("%w: %s", ErrUnsupported, cmd.Type)
+	}
+
+	if args, ok := builtinArgs(cmd.Type); ok {
+		if err := cmd.DecodeArgs(args); err != nil {
+			return cmd, err
+		}
+	}
+	return cmd, nil
+}
+
+// record emits the audit event for a dispatch decision
+func (d *Dispatcher) record(cmd Command, err error) {
+	if d.audit == nil {
+		return
+	}
+	ev := AuditEvent{
+		Time:      d.now(),
+		RobotID:   d.robotID,
+		CommandID: cmd.ID,
+		Type:      cmd.Type,
+		Issuer:    cmd.Issuer,
+		State:     AckAccepted,
+	}
+	if cmd.Signature != nil {
+		ev.KeyID = cmd.Signature.KeyID
+	}
+	if err != nil {
+		ev.State, ev.Reason = AckRejected, err.Error()
+	}
+	d.audit(ev)
+}
+
+func (d *Dispatcher) ack(cmd Command, state AckState, reason string, result interface{}) {
+	ack := Ack{
+		Version:   Version,
+		CommandID: cmd.ID,
+		Type:      cmd.Type,
+		RobotID:   d.robotID,
+		State:     state,
+		Reason:    reason,
+		Result:    result,
+		Timestamp: d.now(),
+	}
+	payload, err := json.Marshal(ack)
+	if err != nil {
+		d.log.Error("Failed to marshal ack for command %s: %v", cmd.ID, err)
+		return
+	}
+	if err := d.publisher.Publish(d.ackTopic, ackQoS, false, payload); err != nil {
+		d.log.Error("Failed to publish %s ack for command %s: %v", state, cmd.ID, err)
+	}
+}
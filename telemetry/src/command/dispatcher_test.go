@@ -0,0 +1,124 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher collects published acks
+type recordingPublisher struct {
+	mu   sync.Mutex
+	acks []Ack
+}
+
+func (p *recordingPublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	var ack Ack
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acks = append(p.acks, ack)
+	return nil
+}
+
+func (p *recordingPublisher) states(id string) []AckState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var states []AckState
+	for _, ack := range p.acks {
+		if ack.CommandID == id {
+			states = append(states, ack.State)
+		}
+	}
+	return states
+}
+
+func encode(t *testing.T, id string, typ Type, args interface{}, modify func(*Command)) []byte {
+	cmd, err := New(id, typ, args, "fleet-ui")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modify != nil {
+		modify(&cmd)
+	}
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDispatcher(t *testing.T) {
+	pub := &recordingPublisher{}
+	d := NewDispatcher("robot1", "robots/robot1/commands/ack", pub)
+
+	var moved MoveToArgs
+	d.Register(TypeMoveTo, func(ctx context.Context, cmd Command) (interface{}, error) {
+		if err := cmd.DecodeArgs(&moved); err != nil {
+			return nil, err
+		}
+		return "arrived", nil
+	})
+	d.Register(TypeCalibrate, func(ctx context.Context, cmd Command) (interface{}, error) {
+		return nil, errors.New("imu busy")
+	})
+	d.Register(TypeSetRate, func(ctx context.Context, cmd Command) (interface{}, error) {
+		t.Error("Handler called for invalid set_rate")
+		return nil, nil
+	})
+	ctx := context.Background()
+
+	if err := d.Dispatch(ctx, encode(t, "c1", TypeMoveTo, MoveToArgs{X: 1, Y: 2, Speed: 0.5}, nil)); err != nil {
+		t.Fatalf("move_to rejected: %v", err)
+	}
+	if err := d.Dispatch(ctx, encode(t, "c2", TypeCalibrate, CalibrateArgs{Sensor: "imu"}, nil)); err != nil {
+		t.Fatalf("calibrate rejected: %v", err)
+	}
+	d.Wait()
+
+	if states := pub.states("c1"); len(states) != 2 || states[0] != AckAccepted || states[1] != AckCompleted {
+		t.Errorf("Unexpected move_to acks: %v", states)
+	}
+	if moved.X != 1 || moved.Y != 2 {
+		t.Errorf("Handler got wrong args: %+v", moved)
+	}
+	if states := pub.states("c2"); len(states) != 2 || states[1] != AckFailed {
+		t.Errorf("Unexpected calibrate acks: %v", states)
+	}
+
+	rejections := []struct {
+		id      string
+		payload []byte
+		want    error
+	}{
+		{"r1", encode(t, "r1", TypePing, nil, nil), ErrUnsupported},
+		{"r2", encode(t, "r2", TypeSetRate, SetRateArgs{Stream: "health", Hz: 500}, nil), ErrInvalidArgs},
+		{"r3", encode(t, "r3", TypeMoveTo, map[string]interface{}{"x": 1, "heading": 90}, nil), ErrInvalidArgs},
+		{"r4", encode(t, "r4", TypeMoveTo, MoveToArgs{}, func(c *Command) {
+			c.ExpiresAt = time.Now().Add(-time.Second)
+		}), ErrExpired},
+		{"r5", encode(t, "r5", TypeMoveTo, MoveToArgs{}, func(c *Command) { c.Version = 2 }), ErrVersion},
+		{"r6", encode(t, "r6", TypeMoveTo, MoveToArgs{}, func(c *Command) { c.IssuedAt = time.Time{} }), ErrMalformed},
+	}
+	for _, r := range rejections {
+		if err := d.Dispatch(ctx, r.payload); !errors.Is(err, r.want) {
+			t.Errorf("%s: expected %v, got %v", r.id, r.want, err)
+		}
+		if states := pub.states(r.id); len(states) != 1 || states[0] != AckRejected {
+			t.Errorf("%s: expected a single rejected ack, got %v", r.id, states)
+		}
+	}
+
+	before := len(pub.acks)
+	if err := d.Dispatch(ctx, []byte("not json")); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed, got %v", err)
+	}
+	if len(pub.acks) != before {
+		t.Error("Commands without an ID cannot be acked")
+	}
+}
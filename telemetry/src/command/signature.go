@@ -0,0 +1,309 @@
+package command
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned when verifying signed commands
+var (
+	ErrUnsigned     = errors.New("command not signed")
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrBadSignature = errors.New("invalid command signature")
+	ErrStale        = errors.New("command outside the timestamp window")
+	ErrReplay       = errors.New("command replayed")
+)
+
+// Signature algorithms
+const (
+	AlgHMACSHA256 = "hmac-sha256"
+	AlgEd25519    = "ed25519"
+)
+
+// Signature authenticates a command. It covers the target robot, every envelope field
+// and the args; see SigningPayload.
+type Signature struct {
+	Alg   string `json:"alg"`
+	KeyID string `json:"key_id"`
+	Value []byte `json:"value"` // Base64 in JSON
+}
+
+// SigningPayload returns the bytes a signature covers: a version line, then the robot
+// ID, the envelope fields, the key and the compacted args, one per line. Times are
+// RFC 3339 in UTC with nanoseconds, and a zero expiry is empty. Fields holding control
+// characters are rejected with ErrMalformed, so no two commands share a payload.
+func SigningPayload(cmd Command, robotID string) ([]byte, error) {
+	if err := checkFields(cmd); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(robotID, "\r\n") {
+		return nil, fmt.Errorf("%w: control character in robot id", ErrMalformed)
+	}
+	var args bytes.Buffer
+	if len(cmd.Args) > 0 {
+		if err := json.Compact(&args, cmd.Args); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+	}
+	var expires, alg, keyID string
+	if !cmd.ExpiresAt.IsZero() {
+		expires = cmd.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	if cmd.Signature != nil {
+		alg, keyID = cmd.Signature.Alg, cmd.Signature.KeyID
+	}
+	var b bytes.Buffer
+	for _, line := range []string{
+		"robo-command-v1",
+		robotID,
+		fmt.Sprint(cmd.Version),
+		cmd.ID,
+		string(cmd.Type),
+		cmd.Issuer,
+		cmd.IssuedAt.UTC().Format(time.RFC3339Nano),
+		expires,
+		cmd.Nonce,
+		alg,
+		keyID,
+	} {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.Write(args.Bytes())
+	return b.Bytes(), nil
+}
+
+// Signer signs commands with one key
+type Signer interface {
+	Alg() string
+	KeyID() string
+	Sign(payload []byte) []byte
+}
+
+type hmacSigner struct {
+	keyID  string
+	secret []byte
+}
+
+// NewHMACSigner signs with HMAC-SHA256 and a secret shared with the robots
+func NewHMACSigner(keyID string, secret []byte) Signer {
+	return hmacSigner{keyID: keyID, secret: secret}
+}
+
+func (s hmacSigner) Alg() string   { return AlgHMACSHA256 }
+func (s hmacSigner) KeyID() string { return s.keyID }
+func (s hmacSigner) Sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+type ed25519Signer struct {
+	keyID string
+	key   ed25519.PrivateKey
+}
+
+// NewEd25519Signer signs with an Ed25519 private key; robots only need the public key
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) Signer {
+	return ed25519Signer{keyID: keyID, key: key}
+}
+
+func (s ed25519Signer) Alg() string   { return AlgEd25519 }
+func (s ed25519Signer) KeyID() string { return s.keyID }
+func (s ed25519Signer) Sign(payload []byte) []byte {
+	return ed25519.Sign(s.key, payload)
+}
+
+// Sign signs cmd for robotID, giving it a random nonce unless it has one
+func Sign(cmd *Command, robotID string, signer Signer) error {
+	if cmd.Nonce == "" {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		cmd.Nonce = hex.EncodeToString(nonce)
+	}
+	cmd.Signature = &Signature{Alg: signer.Alg(), KeyID: signer.KeyID()}
+	payload, err := SigningPayload(*cmd, robotID)
+	if err != nil {
+		return err
+	}
+	cmd.Signature.Value = signer.Sign(payload)
+	return nil
+}
+
+// verifyKey is a key a Verifier accepts
+type verifyKey struct {
+	issuer string
+	alg    string
+	secret []byte            // HMAC
+	public ed25519.PublicKey // Ed25519
+}
+
+// Verifier checks command signatures against the keys of known issuers, rejects
+// commands issued outside a window around the robot's clock and remembers nonces for
+// that window to reject replays. It is safe for concurrent use.
+type Verifier struct {
+	window time.Duration
+	mu     sync.Mutex
+	keys   map[string]verifyKey
+	nonces map[string]time.Time // Issuer and nonce to when it can be forgotten
+}
+
+// NewVerifier creates a verifier without keys accepting commands issued within window
+// of now, in either direction to allow for clock skew
+func NewVerifier(window time.Duration) *Verifier {
+	return &Verifier{
+		window: window,
+		keys:   make(map[string]verifyKey),
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// AddHMACKey accepts HMAC-SHA256 signatures by keyID from issuer
+func (v *Verifier) AddHMACKey(issuer, keyID string, secret []byte) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[keyID] = verifyKey{issuer: issuer, alg: AlgHMACSHA256, secret: secret}
+}
+
+// AddEd25519Key accepts Ed25519 signatures by keyID from issuer
+func (v *Verifier) AddEd25519Key(issuer, keyID string, public ed25519.PublicKey) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[keyID] = verifyKey{issuer: issuer, alg: AlgEd25519, public: public}
+}
+
+// Verify checks that cmd was signed for robotID by a key of its issuer, within the
+// window of now, and has not been seen before
+func (v *Verifier) Verify(cmd Command, robotID string, now time.Time) error {
+	sig := cmd.Signature
+	if sig == nil || len(sig.Value) == 0 {
+		return ErrUnsigned
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for nonce, until := range v.nonces {
+		if now.After(until) {
+			delete(v.nonces, nonce)
+		}
+	}
+	key, ok := v.keys[sig.KeyID]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, sig.KeyID)
+	}
+	if key.issuer != cmd.Issuer {
+		return fmt.Errorf("%w: %q does not belong to issuer %q", ErrUnknownKey, sig.KeyID, cmd.Issuer)
+	}
+	if skew := now.Sub(cmd.IssuedAt); skew > v.window || skew < -v.window {
+		return fmt.Errorf("%w: issued %s, robot time %s", ErrStale,
+			cmd.IssuedAt.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	}
+	if cmd.Nonce == "" {
+		return fmt.Errorf("%w: missing nonce", ErrMalformed)
+	}
+	payload, err := SigningPayload(cmd, robotID)
+	if err != nil {
+		return err
+	}
+	if sig.Alg != key.alg || !key.verify(payload, sig.Value) {
+		return ErrBadSignature
+	}
+
+	// Only verified nonces are remembered, so forged commands cannot fill the cache
+	nonce := cmd.Issuer + "/" + cmd.Nonce
+	if _, seen := v.nonces[nonce]; seen {
+		return fmt.Errorf("%w: nonce %s", ErrReplay, cmd.Nonce)
+	}
+	// Beyond this the timestamp check rejects the command anyway
+	v.nonces[nonce] = cmd.IssuedAt.Add(v.window)
+	return nil
+}
+
+func (k verifyKey) verify(payload, signature []byte) bool {
+	switch k.alg {
+	case AlgHMACSHA256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(payload)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case AlgEd25519:
+		return ed25519.Verify(k.public, payload, signature)
+	}
+	return false
+}
+
+// keyFile is the JSON format of LoadVerifier
+type keyFile struct {
+	Window string `json:"window"` // A Go duration; 30s when empty
+	Keys   []struct {
+		Issuer    string `json:"issuer"`
+		KeyID     string `json:"key_id"`
+		Alg       string `json:"alg"`
+		Secret    []byte `json:"secret,omitempty"`     // Base64, for hmac-sha256
+		PublicKey []byte `json:"public_key,omitempty"` // Base64, for ed25519
+	} `json:"keys"`
+}
+
+// DefaultWindow is how far a command's issue time may be from the robot's clock
+const DefaultWindow = 30 * time.Second
+
+// LoadVerifier reads issuer keys from a JSON file, for example:
+//
+//	{
+//	  "window": "30s",
+//	  "keys": [
+//	    {"issuer": "fleet-ui", "key_id": "ui-2024", "alg": "ed25519", "public_key": "..."},
+//	    {"issuer": "scheduler", "key_id": "sched-1", "alg": "hmac-sha256", "secret": "..."}
+//	  ]
+//	}
+func LoadVerifier(path string) (*Verifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	window := DefaultWindow
+	if f.Window != "" {
+		if window, err = time.ParseDuration(f.Window); err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window must be positive")
+		}
+	}
+	v := NewVerifier(window)
+	for _, k := range f.Keys {
+		if k.Issuer == "" || k.KeyID == "" {
+			return nil, fmt.Errorf("key without issuer or key_id")
+		}
+		switch k.Alg {
+		case AlgHMACSHA256:
+			if len(k.Secret) == 0 {
+				return nil, fmt.Errorf("key %s: missing secret", k.KeyID)
+			}
+			v.AddHMACKey(k.Issuer, k.KeyID, k.Secret)
+		case AlgEd25519:
+			if len(k.PublicKey) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("key %s: public key must be %d bytes", k.KeyID, ed25519.PublicKeySize)
+			}
+			v.AddEd25519Key(k.Issuer, k.KeyID, ed25519.PublicKey(k.PublicKey))
+		default:
+			return nil, fmt.Errorf("key %s: unknown algorithm %q", k.KeyID, k.Alg)
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,194 @@
+package command
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignedCommands(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(30 * time.Second)
+	v.AddEd25519Key("fleet-ui", "ui-1", public)
+	v.AddHMACKey("scheduler", "sched-1", []byte("shared secret"))
+	ui := NewEd25519Signer("ui-1", private)
+	scheduler := NewHMACSigner("sched-1", []byte("shared secret"))
+
+	pub := &recordingPublisher{}
+	d := NewDispatcher("robot1", "robots/robot1/commands/ack", pub)
+	d.now = func() time.Time { return now }
+	d.SetVerifier(v)
+	var mu sync.Mutex
+	var audit []AuditEvent
+	d.OnAudit(func(ev AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		audit = append(audit, ev)
+	})
+	d.Register(TypePing, func(ctx context.Context, cmd Command) (interface{}, error) { return nil, nil })
+
+	sign := func(id, issuer string, signer Signer, robotID string, modify func(*Command)) []byte {
+		t.Helper()
+		cmd, err := New(id, TypePing, PingArgs{Payload: "hi"}, issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.IssuedAt = now.Add(-time.Second)
+		if signer != nil {
+			if err := Sign(&cmd, robotID, signer); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if modify != nil {
+			modify(&cmd)
+		}
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	signed := sign("cmd-1", "fleet-ui", ui, "robot1", nil)
+	if err := d.Dispatch(context.Background(), signed); err != nil {
+		t.Fatalf("Ed25519 signed command rejected: %v", err)
+	}
+	if err := d.Dispatch(context.Background(), sign("cmd-2", "scheduler", scheduler, "robot1", nil)); err != nil {
+		t.Fatalf("HMAC signed command rejected: %v", err)
+	}
+	d.Wait()
+
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"replay", signed, ErrReplay},
+		{"unsigned", sign("cmd-3", "fleet-ui", nil, "", nil), ErrUnsigned},
+		{"other robot", sign("cmd-4", "fleet-ui", ui, "robot2", nil), ErrBadSignature},
+		{"tampered", sign("cmd-5", "fleet-ui", ui, "robot1", func(c *Command) { c.Args = json.RawMessage(`{"payload":"bye"}`) }), ErrBadSignature},
+		{"other issuer", sign("cmd-6", "fleet-ui", scheduler, "robot1", nil), ErrUnknownKey},
+		{"stale", sign("cmd-7", "fleet-ui", ui, "robot1", func(c *Command) {
+			c.IssuedAt = now.Add(-time.Minute)
+			Sign(c, "robot1", ui)
+		}), ErrStale},
+		{"from the future", sign("cmd-8", "fleet-ui", ui, "robot1", func(c *Command) {
+			c.IssuedAt = now.Add(time.Minute)
+			Sign(c, "robot1", ui)
+		}), ErrStale},
+	} {
+		if err := d.Dispatch(context.Background(), tc.payload); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+	for _, id := range []string{"cmd-3", "cmd-4", "cmd-7"} {
+		if states := pub.states(id); len(states) != 1 || states[0] != AckRejected {
+			t.Errorf("%s: expected a rejection ack, got %v", id, states)
+		}
+	}
+
+	mu.Lock()
+	if len(audit) != 9 {
+		t.Fatalf("Expected an audit event per command, got %d", len(audit))
+	}
+	if ev := audit[0]; ev.State != AckAccepted || ev.Issuer != "fleet-ui" || ev.KeyID != "ui-1" {
+		t.Errorf("Unexpected audit event %+v", ev)
+	}
+	if ev := audit[2]; ev.State != AckRejected || ev.CommandID != "cmd-1" || ev.Reason == "" {
+		t.Errorf("Unexpected audit event for the replay %+v", ev)
+	}
+	mu.Unlock()
+
+	// Nonces are forgotten once the window has passed, when the timestamp check
+	// rejects the command instead
+	now = now.Add(time.Minute)
+	if err := d.Dispatch(context.Background(), signed); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected an old replay to be stale, got %v", err)
+	}
+	if len(v.nonces) != 0 {
+		t.Errorf("Expected expired nonces to be dropped, got %d", len(v.nonces))
+	}
+}
+
+func TestLoadVerifier(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(path, []byte(`{
+		"window": "10s",
+		"keys": [
+			{"issuer": "fleet-ui", "key_id": "ui-1", "alg": "ed25519", "public_key": "`+base64.StdEncoding.EncodeToString(public)+`"},
+			{"issuer": "scheduler", "key_id": "sched-1", "alg": "hmac-sha256", "secret": "`+base64.StdEncoding.EncodeToString([]byte("s3cret"))+`"}
+		]
+	}`), 0o644)
+	v, err := LoadVerifier(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, signer := range []struct {
+		issuer string
+		signer Signer
+	}{{"fleet-ui", NewEd25519Signer("ui-1", private)}, {"scheduler", NewHMACSigner("sched-1", []byte("s3cret"))}} {
+		cmd, _ := New("cmd-1", TypePing, nil, signer.issuer)
+		Sign(&cmd, "robot1", signer.signer)
+		if err := v.Verify(cmd, "robot1", now); err != nil {
+			t.Errorf("%s: %v", signer.issuer, err)
+		}
+		if err := v.Verify(cmd, "robot1", now.Add(11*time.Second)); !errors.Is(err, ErrStale) {
+			t.Errorf("%s: expected the 10s window, got %v", signer.issuer, err)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"keys": [{"issuer": "x", "key_id": "k", "alg": "rsa"}]}`), 0o644)
+	if _, err := LoadVerifier(path); err == nil {
+		t.Error("Expected an unknown algorithm to be rejected")
+	}
+}
+
+func TestControlCharactersRejected(t *testing.T) {
+	signer := NewHMACSigner("sched-1", []byte("shared secret"))
+	cmd, err := New("cmd-1", TypePing, nil, "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A newline in the ID would let the text after it pass for the type line
+	shifted := cmd
+	shifted.ID, shifted.Type = "cmd-1\nping", "stop"
+	if err := Sign(&shifted, "robot1", signer); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected signing a multi-line ID to fail, got %v", err)
+	}
+	if _, err := SigningPayload(cmd, "robot1\nrobot2"); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected a multi-line robot ID to be rejected, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*Command)
+	}{
+		{"id", func(c *Command) { c.ID = "cmd-1\nstop" }},
+		{"issuer", func(c *Command) { c.Issuer = "scheduler\r" }},
+		{"nonce", func(c *Command) { c.Nonce = "abc\x00" }},
+	} {
+		c := cmd
+		tc.modify(&c)
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decode(data); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%s: expected a control character to be rejected, got %v", tc.name, err)
+		}
+	}
+}
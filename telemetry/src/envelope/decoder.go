@@ -0,0 +1,161 @@
+package envelope
+
+import (
+	"fmt"
+	"sync"
+
+	"telemetry/src/codec"
+	"telemetry/src/simulation"
+)
+
+// Delivery classifies a message against the ones seen before on its stream
+type Delivery int
+
+const (
+	// InOrder is the next expected sequence number, or the first message seen
+	InOrder Delivery = iota
+	// Gap means Missing messages were skipped before this one
+	Gap
+	// Late fills part of an earlier gap
+	Late
+	// Duplicate was already delivered, or is too old to tell
+	Duplicate
+	// Restarted means the robot rebooted and its sequence started over
+	Restarted
+)
+
+func (d Delivery) String() string {
+	switch d {
+	case InOrder:
+		return "in_order"
+	case Gap:
+		return "gap"
+	case Late:
+		return "late"
+	case Duplicate:
+		return "duplicate"
+	case Restarted:
+		return "restarted"
+	}
+	return fmt.Sprintf("delivery(%d)", int(d))
+}
+
+// Message is a decoded envelope with its typed payload
+type Message struct {
+	Envelope
+	Value    interface{} // Typed payload, or the encoded payload for unregistered types
+	Delivery Delivery
+	Missing  uint64 // Messages skipped, set for Gap
+}
+
+// windowSize is how far behind the newest message late arrivals are still told apart
+// from duplicates
+const windowSize = 64
+
+type stream struct {
+	bootID string
+	last   uint64
+	seen   uint64 // Bit i set when last-i was received
+}
+
+// Decoder validates envelopes, tracks sequence numbers per robot and type, and decodes
+// payloads into registered types
+type Decoder struct {
+	mu      sync.Mutex
+	types   map[string]func() interface{}
+	streams map[string]*stream
+}
+
+// NewDecoder creates a decoder that knows the simulation message types
+func NewDecoder() *Decoder {
+	d := &Decoder{
+		types:   make(map[string]func() interface{}),
+		streams: make(map[string]*stream),
+	}
+	d.Register("heartbeat", func() interface{} { return &simulation.HeartbeatMessage{} })
+	d.Register("health", func() interface{} { return &simulation.HealthMessage{} })
+	d.Register("navigation", func() interface{} { return &simulation.NavigationMessage{} })
+	return d
+}
+
+// Register sets the payload type for messageType; newValue returns a pointer to decode into
+func (d *Decoder) Register(messageType string, newValue func() interface{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.types[messageType] = newValue
+}
+
+// Decode parses and validates an envelope and classifies its sequence number.
+// Duplicates are returned with their payload so callers can choose to drop them.
+func (d *Decoder) Decode(data []byte) (Message, error) {
+	env, err := Parse(data)
+	if err != nil {
+		return Message{}, err
+	}
+	return d.DecodeEnvelope(env)
+}
+
+// DecodeEnvelope validates and classifies an already parsed envelope
+func (d *Decoder) DecodeEnvelope(env Envelope) (Message, error) {
+	if err := env.Validate(); err != nil {
+		return Message{}, err
+	}
+	c, err := codec.Lookup(env.ContentType)
+	if err != nil {
+		return Message{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	d.mu.Lock()
+	newValue := d.types[env.Type]
+	d.mu.Unlock()
+
+	msg := Message{Envelope: env, Value: env.Payload}
+	if newValue != nil {
+		value := newValue()
+		if err := c.Unmarshal(env.Payload, value); err != nil {
+			// Not tracked, so the message counts as missing once the stream moves on
+			return msg, fmt.Errorf("%w: %s payload: %v", ErrInvalid, env.Type, err)
+		}
+		msg.Value = value
+	}
+
+	d.mu.Lock()
+	msg.Delivery, msg.Missing = d.track(env)
+	d.mu.Unlock()
+	return msg, nil
+}
+
+func (d *Decoder) track(env Envelope) (Delivery, uint64) {
+	key := env.RobotID + "/" + env.Type
+	s, ok := d.streams[key]
+	if !ok {
+		d.streams[key] = &stream{bootID: env.BootID, last: env.Seq, seen: 1}
+		return InOrder, 0
+	}
+	if s.bootID != env.BootID {
+		*s = stream{bootID: env.BootID, last: env.Seq, seen: 1}
+		return Restarted, 0
+	}
+
+	if env.Seq > s.last {
+		shift := env.Seq - s.last
+		if shift >= windowSize {
+			s.seen = 0
+		} else {
+			s.seen <<= shift
+		}
+		s.seen |= 1
+		s.last = env.Seq
+		if shift > 1 {
+			return Gap, shift - 1
+		}
+		return InOrder, 0
+	}
+
+	behind := s.last - env.Seq
+	if behind >= windowSize || s.seen&(1<<behind) != 0 {
+		return Duplicate, 0
+	}
+	s.seen |= 1 << behind
+	return Late, 0
+}
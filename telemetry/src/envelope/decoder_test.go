@@ -0,0 +1,170 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"telemetry/src/codec"
+	"telemetry/src/simulation"
+)
+
+func TestSequencerAndDecoder(t *testing.T) {
+	seq := NewSequencer("robot1", "boot-a")
+	d := NewDecoder()
+
+	var wire [][]byte
+	for i := 0; i < 5; i++ {
+		data, err := seq.Marshal("health", nil, simulation.HealthMessage{RobotID: "robot1", CPUTemp: float64(40 + i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		wire = append(wire, data)
+	}
+	nav, _ := seq.Marshal("navigation", nil, simulation.NavigationMessage{RobotID: "robot1", PathStatus: "CLEAR"})
+
+	check := func(data []byte, want Delivery, missing uint64) Message {
+		t.Helper()
+		msg, err := d.Decode(data)
+		if err != nil {
+			t.Fatalf("Decode failed: %v", err)
+		}
+		if msg.Delivery != want || msg.Missing != missing {
+			t.Errorf("Seq %d: expected %v (missing %d), got %v (missing %d)", msg.Seq, want, missing, msg.Delivery, msg.Missing)
+		}
+		return msg
+	}
+
+	first := check(wire[0], InOrder, 0)
+	if health, ok := first.Value.(*simulation.HealthMessage); !ok || health.CPUTemp != 40 {
+		t.Errorf("Expected a typed health payload, got %#v", first.Value)
+	}
+	if first.Version != SchemaVersion || first.RobotID != "robot1" || first.BootID != "boot-a" || first.SentAt.IsZero() {
+		t.Errorf("Unexpected envelope: %+v", first.Envelope)
+	}
+
+	// Sequences are per type, so navigation starts at 1 independently
+	if msg := check(nav, InOrder, 0); msg.Seq != 1 {
+		t.Errorf("Expected navigation seq 1, got %d", msg.Seq)
+	}
+
+	check(wire[1], InOrder, 0)
+	check(wire[4], Gap, 2)
+	check(wire[2], Late, 0)
+	check(wire[2], Duplicate, 0)
+	check(wire[4], Duplicate, 0)
+	check(wire[3], Late, 0)
+
+	// A reboot restarts the sequence without reporting a gap or duplicates
+	rebooted := NewSequencer("robot1", "boot-b")
+	data, _ := rebooted.Marshal("health", nil, simulation.HealthMessage{})
+	check(data, Restarted, 0)
+	data, _ = rebooted.Marshal("health", nil, simulation.HealthMessage{})
+	check(data, InOrder, 0)
+
+	// A payload that cannot be decoded is reported as missing by the next message
+	bad, _ := rebooted.Marshal("health", nil, simulation.HealthMessage{})
+	env, _ := Parse(bad)
+	env.Payload = json.RawMessage(`[1]`)
+	if _, err := d.DecodeEnvelope(env); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for an undecodable payload, got %v", err)
+	}
+	data, _ = rebooted.Marshal("health", nil, simulation.HealthMessage{})
+	check(data, Gap, 1)
+
+	// Unregistered types are passed through raw
+	data, _ = seq.Marshal("lidar", nil, map[string]int{"points": 360})
+	if msg := check(data, InOrder, 0); string(msg.Value.(json.RawMessage)) != `{"points":360}` {
+		t.Errorf("Unexpected raw payload: %v", msg.Value)
+	}
+}
+
+func TestDecoderValidation(t *testing.T) {
+	valid := Envelope{
+		Version: SchemaVersion,
+		Type:    "health",
+		RobotID: "robot1",
+		Seq:     1,
+		BootID:  "boot",
+		SentAt:  time.Now(),
+		Payload: json.RawMessage(`{}`),
+	}
+	tests := []struct {
+		name   string
+		modify func(*Envelope)
+		want   error
+	}{
+		{"future version", func(e *Envelope) { e.Version = SchemaVersion + 1 }, ErrVersion},
+		{"no version", func(e *Envelope) { e.Version = 0 }, ErrInvalid},
+		{"no type", func(e *Envelope) { e.Type = "" }, ErrInvalid},
+		{"no robot", func(e *Envelope) { e.RobotID = "" }, ErrInvalid},
+		{"zero seq", func(e *Envelope) { e.Seq = 0 }, ErrInvalid},
+		{"no boot", func(e *Envelope) { e.BootID = "" }, ErrInvalid},
+		{"no timestamp", func(e *Envelope) { e.SentAt = time.Time{} }, ErrInvalid},
+		{"bad payload", func(e *Envelope) { e.Payload = json.RawMessage(`[1]`) }, ErrInvalid},
+	}
+	for _, tt := range tests {
+		env := valid
+		tt.modify(&env)
+		data, _ := json.Marshal(env)
+		if _, err := NewDecoder().Decode(data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+	if _, err := NewDecoder().Decode([]byte("garbage")); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for garbage, got %v", err)
+	}
+}
+
+func TestContentTypeNegotiation(t *testing.T) {
+	nav := simulation.NavigationMessage{
+		RobotID:    "robot1",
+		Timestamp:  time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC),
+		Position:   simulation.Position{X: 12.5, Y: -3.25},
+		Heading:    87.5,
+		Velocity:   1.25,
+		Obstacles:  []simulation.Obstacle{{Position: simulation.Position{X: 13.5, Y: -3}, Size: 0.5, Type: "static", Severity: "HIGH"}},
+		PathStatus: "CLEAR",
+	}
+	d := NewDecoder()
+	sizes := make(map[string]int)
+	for i, c := range []codec.Codec{codec.JSON, codec.CBOR, codec.Compact} {
+		seq := NewSequencer("robot1", "boot")
+		data, err := seq.Marshal("navigation", c, nav)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[c.ContentType()] = len(data)
+		if (data[0] == '{') != (i == 0) {
+			t.Errorf("%s: unexpected frame start %q", c.ContentType(), data[0])
+		}
+
+		msg, err := d.Decode(data)
+		if err != nil {
+			t.Fatalf("%s: decode failed: %v", c.ContentType(), err)
+		}
+		got, ok := msg.Value.(*simulation.NavigationMessage)
+		if !ok || got.Position != nav.Position || got.PathStatus != "CLEAR" || len(got.Obstacles) != 1 {
+			t.Errorf("%s: unexpected payload %+v", c.ContentType(), msg.Value)
+		}
+		if msg.Seq != 1 || msg.BootID != "boot" || msg.RobotID != "robot1" {
+			t.Errorf("%s: unexpected envelope %+v", c.ContentType(), msg.Envelope)
+		}
+	}
+	t.Logf("navigation envelope: json=%d cbor=%d compact=%d bytes",
+		sizes[codec.ContentTypeJSON], sizes[codec.ContentTypeCBOR], sizes[codec.ContentTypeCompact])
+	if sizes[codec.ContentTypeCompact] >= sizes[codec.ContentTypeCBOR] || sizes[codec.ContentTypeCBOR] >= sizes[codec.ContentTypeJSON] {
+		t.Errorf("Expected compact < cbor < json, got %v", sizes)
+	}
+
+	unknown := Envelope{Version: 1, Type: "navigation", ContentType: "application/xml", RobotID: "r", Seq: 1,
+		BootID: "b", SentAt: time.Now(), Payload: []byte("<nav/>")}
+	data, _ := Encode(unknown)
+	if _, err := d.Decode(data); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for unknown content type, got %v", err)
+	}
+	if _, err := d.Decode(data[:4]); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected ErrInvalid for truncated frame, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"telemetry/src/codec"
+)
+
+// SchemaVersion is the envelope version written by this package. Decoders accept
+// this and older versions.
+const SchemaVersion = 1
+
+// Errors returned by the decoder
+var (
+	ErrInvalid = errors.New("invalid envelope")
+	ErrVersion = errors.New("unsupported envelope version")
+)
+
+// Envelope wraps every telemetry payload. JSON payloads are sent as a JSON object with
+// the payload inline; payloads in any other content type are sent in a binary frame
+// (see Encode) so they are not inflated by base64.
+type Envelope struct {
+	Version     int             `json:"v"`
+	Type        string          `json:"type"`
+	ContentType string          `json:"ct,omitempty"` // Empty means application/json
+	RobotID     string          `json:"robot_id"`
+	Seq         uint64          `json:"seq"`     // Per type, starting at 1 for each boot
+	BootID      string          `json:"boot_id"` // Changes whenever the sequence restarts
+	SentAt      time.Time       `json:"sent_at"`
+	Payload     json.RawMessage `json:"payload"` // Encoded with the content type's codec
+}
+
+// frameMagic starts a binary envelope; a JSON envelope always starts with '{'
+const frameMagic = 0xE5
+
+// NewBootID returns a random ID for this process lifetime
+func NewBootID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sequencer wraps payloads in envelopes with per-type sequence numbers
+type Sequencer struct {
+	robotID string
+	bootID  string
+	mu      sync.Mutex
+	seqs    map[string]uint64
+}
+
+// NewSequencer creates a sequencer for robotID; sequences restart with every bootID
+func NewSequencer(robotID, bootID string) *Sequencer {
+	return &Sequencer{robotID: robotID, bootID: bootID, seqs: make(map[string]uint64)}
+}
+
+// BootID returns the boot ID stamped on every envelope
+func (s *Sequencer) BootID() string {
+	return s.bootID
+}
+
+// Wrap encodes payload with c, JSON when nil, and builds the next envelope for
+// messageType
+func (s *Sequencer) Wrap(messageType string, c codec.Codec, payload interface{}) (Envelope, error) {
+	env, err := s.Prepare(messageType, c, payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+	return s.Sequence(env), nil
+}
+
+// Prepare builds the envelope for messageType without a sequence number, so a message
+// that may still be dropped does not use one up; see Sequence
+func (s *Sequencer) Prepare(messageType string, c codec.Codec, payload interface{}) (Envelope, error) {
+	if c == nil {
+		c = codec.JSON
+	}
+	raw, err := c.Marshal(payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	env := Envelope{
+		Version: SchemaVersion,
+		Type:    messageType,
+		RobotID: s.robotID,
+		BootID:  s.bootID,
+		SentAt:  time.Now(),
+		Payload: raw,
+	}
+	if c.ContentType() != codec.ContentTypeJSON {
+		env.ContentType = c.ContentType()
+	}
+	return env, nil
+}
+
+// Sequence gives a prepared envelope the next sequence number of its type
+func (s *Sequencer) Sequence(env Envelope) Envelope {
+	s.mu.Lock()
+	s.seqs[env.Type]++
+	env.Seq = s.seqs[env.Type]
+	s.mu.Unlock()
+	return env
+}
+
+// Marshal wraps and encodes a payload
+func (s *Sequencer) Marshal(messageType string, c codec.Codec, payload interface{}) ([]byte, error) {
+	env, err := s.Wrap(messageType, c, payload)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(env)
+}
+
+// Encode serializes an envelope: as JSON for JSON payloads, otherwise as a binary frame
+//
+//	magic      0xE5
+//	version    uint8
+//	ct, type, robot_id, boot_id   uvarint length + bytes each
+//	seq        uvarint
+//	sent_at    varint Unix nanoseconds
+//	payload    remaining bytes
+func Encode(env Envelope) ([]byte, error) {
+	if env.ContentType == "" || env.ContentType == codec.ContentTypeJSON {
+		env.ContentType = ""
+		return json.Marshal(env)
+	}
+
+	var buf bytes.Buffer
+	var scratch [binary.MaxVarintLen64]byte
+	buf.WriteByte(frameMagic)
+	buf.WriteByte(byte(env.Version))
+	for _, s := range []string{env.ContentType, env.Type, env.RobotID, env.BootID} {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(s)))])
+		buf.WriteString(s)
+	}
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], env.Seq)])
+	buf.Write(scratch[:binary.PutVarint(scratch[:], env.SentAt.UnixNano())])
+	buf.Write(env.Payload)
+	return buf.Bytes(), nil
+}
+
+// Parse reads a JSON or binary envelope without validating it
+func Parse(data []byte) (Envelope, error) {
+	if len(data) == 0 {
+		return Envelope{}, fmt.Errorf("%w: empty message", ErrInvalid)
+	}
+	if data[0] != frameMagic {
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			return Envelope{}, fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+		return env, nil
+	}
+
+	r := bytes.NewReader(data[1:])
+	truncated := fmt.Errorf("%w: truncated frame", ErrInvalid)
+	version, err := r.ReadByte()
+	if err != nil {
+		return Envelope{}, truncated
+	}
+	env := Envelope{Version: int(version)}
+	for _, field := range []*string{&env.ContentType, &env.Type, &env.RobotID, &env.BootID} {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return Envelope{}, truncated
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		*field = string(b)
+	}
+	if env.Seq, err = binary.ReadUvarint(r); err != nil {
+		return Envelope{}, truncated
+	}
+	nanos, err := binary.ReadVarint(r)
+	if err != nil {
+		return Envelope{}, truncated
+	}
+	env.SentAt = time.Unix(0, nanos).UTC()
+	env.Payload = data[len(data)-r.Len():]
+	return env, nil
+}
+
+// Validate checks the envelope version and required fields
+func (e Envelope) Validate() error {
+	switch {
+	case e.Version <= 0:
+		return fmt.Errorf("%w: missing version", ErrInvalid)
+	case e.Version > SchemaVersion:
+		return fmt.Errorf("%w: %d (newest known is %d)", ErrVersion, e.Version, SchemaVersion)
+	case e.Type == "":
+		return fmt.Errorf("%w: missing type", ErrInvalid)
+	case e.RobotID == "":
+		return fmt.Errorf("%w: missing robot_id", ErrInvalid)
+	case e.BootID == "":
+		return fmt.Errorf("%w: missing boot_id", ErrInvalid)
+	case e.Seq == 0:
+		return fmt.Errorf("%w: sequence numbers start at 1", ErrInvalid)
+	case e.SentAt.IsZero():
+		return fmt.Errorf("%w: missing sent_at", ErrInvalid)
+	case len(e.Payload) == 0:
+		return fmt.Errorf("%w: missing payload", ErrInvalid)
+	}
+	return nil
+}
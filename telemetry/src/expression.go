@@ -0,0 +1,424 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expression is a compiled arithmetic expression over sensor readings.
+//
+// Operands are numbers, the constant "pi" and references to sensors written as
+// "sensor_id" (the reading value itself) or "sensor_id.field" (a field of the reading
+// by its JSON name). IDs with other characters, such as '-', are quoted:
+// "imu-front".accel_x. Supported operators are + - * / ^ and parentheses, and the
+// functions abs, sqrt, pow, min, max, sin, cos, tan, atan, atan2, hypot, deg and rad.
+type Expression struct {
+	source string
+	root   exprNode
+	inputs []string
+}
+
+// Resolver returns the value of a sensor reference in an expression
+type Resolver func(sensorID, field string) (float64, error)
+
+type exprNode interface {
+	eval(resolve Resolver) (float64, error)
+}
+
+type numberNode float64
+
+type refNode struct {
+	sensorID string
+	field    string
+}
+
+type unaryNode struct {
+	operand exprNode
+}
+
+type binaryNode struct {
+	op          byte
+	left, right exprNode
+}
+
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+var exprFuncs = map[string]struct {
+	arity int
+	fn    func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"tan":   {1, func(a []float64) float64 { return math.Tan(a[0]) }},
+	"atan":  {1, func(a []float64) float64 { return math.Atan(a[0]) }},
+	"atan2": {2, func(a []float64) float64 { return math.Atan2(a[0], a[1]) }},
+	"hypot": {2, func(a []float64) float64 { return math.Hypot(a[0], a[1]) }},
+	"deg":   {1, func(a []float64) float64 { return a[0] * 180 / math.Pi }},
+	"rad":   {1, func(a []float64) float64 { return a[0] * math.Pi / 180 }},
+}
+
+// CompileExpression parses an expression so it can be evaluated repeatedly
+func CompileExpression(source string) (*Expression, error) {
+	p := &exprParser{src: source}
+	p.next()
+
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at %d", source, p.tok.text, p.tok.pos)
+	}
+
+	return &Expression{
+		source: source,
+		root:   root,
+		inputs: p.inputs,
+	}, nil
+}
+
+// Inputs returns the sensor IDs referenced by the expression
+func (e *Expression) Inputs() []string {
+	return e.inputs
+}
+
+// String returns the expression source
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression, resolving sensor references with resolve
+func (e *Expression) Eval(resolve Resolver) (float64, error) {
+	v, err := e.root.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("expression %q evaluated to %v", e.source, v)
+	}
+	return v, nil
+}
+
+func (n numberNode) eval(Resolver) (float64, error) {
+	return float64(n), nil
+}
+
+func (n refNode) eval(resolve Resolver) (float64, error) {
+	return resolve(n.sensorID, n.field)
+}
+
+func (n unaryNode) eval(resolve Resolver) (float64, error) {
+	v, err := n.operand.eval(resolve)
+	return -v, err
+}
+
+func (n binaryNode) eval(resolve Resolver) (float64, error) {
+	l, err := n.left.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+	r, err := n.right.eval(resolve)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/':
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return l / r, nil
+	case '^':
+		return math.Pow(l, r), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", n.op)
+}
+
+func (n callNode) eval(resolve Resolver) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(resolve)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return exprFuncs[n.name].fn(args), nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	tok    token
+	inputs []string
+}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		// Exponent, e.g. 1e-3
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && isDigit(p.src[p.pos]) {
+				p.pos++
+			}
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case isIdentStart(c):
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	case c == '"':
+		// Quoted sensor ID, optionally followed by a field
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			p.pos++
+		}
+		if p.pos < len(p.src) {
+			p.pos++
+		}
+		if p.pos < len(p.src) && p.src[p.pos] == '.' {
+			p.pos++
+			for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+				p.pos++
+			}
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: string(c), pos: start}
+	}
+}
+
+func (p *exprParser) isOp(ops string) bool {
+	return p.tok.kind == tokOp && strings.Contains(ops, p.tok.text)
+}
+
+// parseSum handles + and -
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+-") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseProduct handles * and /
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*/") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseUnary handles negation, which binds looser than ^ so -2^2 is -4
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.isOp("-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryNode{operand: operand}, nil
+	}
+	if p.isOp("+") {
+		p.next()
+		return p.parseUnary()
+	}
+	return p.parsePower()
+}
+
+// parsePower handles the right-associative ^ operator
+func (p *exprParser) parsePower() (exprNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("^") {
+		p.next()
+		exp, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryNode{op: '^', left: base, right: exp}, nil
+	}
+	return base, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", tok.text, tok.pos)
+		}
+		p.next()
+		return numberNode(v), nil
+
+	case tokIdent:
+		p.next()
+		if p.isOp("(") {
+			return p.parseCall(tok)
+		}
+		if tok.text == "pi" {
+			return numberNode(math.Pi), nil
+		}
+		return p.reference(tok)
+
+	case tokOp:
+		if tok.text == "(" {
+			p.next()
+			inner, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			if !p.isOp(")") {
+				return nil, fmt.Errorf("missing ) at %d", p.tok.pos)
+			}
+			p.next()
+			return inner, nil
+		}
+	}
+
+	if tok.kind == tokEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+}
+
+func (p *exprParser) parseCall(name token) (exprNode, error) {
+	f, ok := exprFuncs[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []exprNode
+	if !p.isOp(")") {
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+	}
+	if !p.isOp(")") {
+		return nil, fmt.Errorf("missing ) at %d", p.tok.pos)
+	}
+	p.next()
+
+	if len(args) != f.arity {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name.text, f.arity, len(args))
+	}
+	return callNode{name: name.text, args: args}, nil
+}
+
+func (p *exprParser) reference(tok token) (exprNode, error) {
+	sensorID, field, _ := strings.Cut(tok.text, ".")
+	if strings.HasPrefix(tok.text, `"`) {
+		var rest string
+		var closed bool
+		sensorID, rest, closed = strings.Cut(tok.text[1:], `"`)
+		if !closed || (rest != "" && !strings.HasPrefix(rest, ".")) {
+			return nil, fmt.Errorf("bad sensor reference %q at %d", tok.text, tok.pos)
+		}
+		field = strings.TrimPrefix(rest, ".")
+	}
+	if sensorID == "" || strings.HasSuffix(tok.text, ".") || strings.Contains(field, ".") {
+		return nil, fmt.Errorf("bad sensor reference %q at %d", tok.text, tok.pos)
+	}
+
+	seen := false
+	for _, id := range p.inputs {
+		if id == sensorID {
+			seen = true
+			break
+		}
+	}
+	if !seen {
+		p.inputs = append(p.inputs, sensorID)
+	}
+	return refNode{sensorID: sensorID, field: field}, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,203 @@
+package failover
+
+import (
+	"encoding/json"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// announceService marks discovery datagrams, so other traffic on the port is ignored
+const announceService = "mqtt-broker"
+
+// maxDatagram bounds an announcement
+const maxDatagram = 2048
+
+// Announcement is the datagram a broker sends to advertise itself
+type Announcement struct {
+	Service string `json:"service"`
+	Broker
+	// TTL is how long listeners keep the broker after the last announcement
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+// Announcer periodically advertises a broker by UDP broadcast, multicast or unicast
+type Announcer struct {
+	conn     *net.UDPConn
+	payload  []byte
+	interval time.Duration
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Announce advertises broker to target, e.g. "255.255.255.255:18830" for the local
+// subnet or "239.255.18.83:18830" for a multicast group, every interval. A broker URL
+// without a host, or with an unspecified one such as tcp://0.0.0.0:1883, is completed
+// by listeners with the announcement's source address.
+func Announce(target string, broker Broker, interval time.Duration) (*Announcer, error) {
+	addr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(Announcement{
+		Service:    announceService,
+		Broker:     broker,
+		TTLSeconds: (3 * interval).Seconds(),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	a := &Announcer{conn: conn, payload: payload, interval: interval, stop: make(chan struct{})}
+	a.wg.Add(1)
+	go a.run()
+	return a, nil
+}
+
+func (a *Announcer) run() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		// Send errors are transient, e.g. the interface is down; the next tick retries
+		a.conn.Write(a.payload)
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops announcing
+func (a *Announcer) Close() error {
+	close(a.stop)
+	a.wg.Wait()
+	return a.conn.Close()
+}
+
+type discovered struct {
+	broker  Broker
+	expires time.Time
+}
+
+// Listener collects broker announcements
+type Listener struct {
+	conn    *net.UDPConn
+	log     *logger.Logger
+	mu      sync.Mutex
+	brokers map[string]discovered
+	now     func() time.Time
+	done    chan struct{}
+}
+
+// Listen receives announcements on addr, e.g. ":18830" for broadcasts, or a multicast
+// group such as "239.255.18.83:18830", which is joined on the default interface
+func Listen(addr string) (*Listener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var conn *net.UDPConn
+	if udpAddr.IP != nil && udpAddr.IP.IsMulticast() {
+		conn, err = net.ListenMulticastUDP("udp", nil, udpAddr)
+	} else {
+		conn, err = net.ListenUDP("udp", udpAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		conn:    conn,
+		log:     logger.New(logger.INFO),
+		brokers: make(map[string]discovered),
+		now:     time.Now,
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// Addr returns the address the listener receives on
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *Listener) run() {
+	defer close(l.done)
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		var ann Announcement
+		if json.Unmarshal(buf[:n], &ann) != nil || ann.Service != announceService || ann.URL == "" {
+			continue
+		}
+		b, ok := resolveAnnounced(ann.Broker, from)
+		if !ok {
+			continue
+		}
+		l.add(b, time.Duration(ann.TTLSeconds*float64(time.Second)))
+	}
+}
+
+// resolveAnnounced fills in the sender's address when the URL names no usable host
+func resolveAnnounced(b Broker, from *net.UDPAddr) (Broker, bool) {
+	u, err := url.Parse(b.URL)
+	if err != nil || u.Scheme == "" {
+		return b, false
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		u.Host = net.JoinHostPort(from.IP.String(), u.Port())
+		b.URL = u.String()
+	}
+	return b, true
+}
+
+func (l *Listener) add(b Broker, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if d, ok := l.brokers[b.URL]; !ok || d.expires.Before(now) {
+		l.log.Info("Discovered broker %s (priority %d)", b.URL, b.Priority)
+	}
+	l.brokers[b.URL] = discovered{broker: b, expires: now.Add(ttl)}
+}
+
+// Brokers returns the brokers announced within their TTL, ordered by URL
+func (l *Listener) Brokers() []Broker {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	brokers := make([]Broker, 0, len(l.brokers))
+	for u, d := range l.brokers {
+		if d.expires.Before(now) {
+			delete(l.brokers, u)
+			continue
+		}
+		brokers = append(brokers, d.broker)
+	}
+	sort.Slice(brokers, func(i, j int) bool { return brokers[i].URL < brokers[j].URL })
+	return brokers
+}
+
+// Close stops listening
+func (l *Listener) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	return err
+}
@@ -0,0 +1,308 @@
+// Package failover spreads a robot's MQTT connection over several brokers: an
+// ordered or weighted broker list, health probing, failover when the connection drops,
+// fail-back to the preferred brokers and optional discovery of brokers on the subnet
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoBroker is returned when no configured or discovered broker accepts a connection
+var ErrNoBroker = errors.New("no broker available")
+
+// Broker is one broker the client may connect to
+type Broker struct {
+	URL string `json:"url"`
+	// Priority orders the brokers, lowest first. Brokers sharing a priority are chosen
+	// between at random in proportion to their weight.
+	Priority int `json:"priority,omitempty"`
+	// Weight is the broker's share within its priority, 1 when unset
+	Weight int `json:"weight,omitempty"`
+}
+
+// Ordered returns the brokers in the given order of preference: priority 0, 1, ...
+func Ordered(urls ...string) []Broker {
+	brokers := make([]Broker, len(urls))
+	for i, u := range urls {
+		brokers[i] = Broker{URL: u, Priority: i}
+	}
+	return brokers
+}
+
+// Config controls broker selection
+type Config struct {
+	Brokers []Broker
+	// ProbeInterval is how often every broker's health is checked
+	ProbeInterval time.Duration
+	// ProbeTimeout bounds a single health check
+	ProbeTimeout time.Duration
+	// FailbackDelay is how long a preferred broker must stay healthy before the client
+	// moves back to it
+	FailbackDelay time.Duration
+	// Sticky keeps the client on the broker it is connected to for as long as the
+	// connection lasts, and tries it first when reconnecting; there is no fail-back
+	Sticky bool
+	// RetryInterval is the pause after every broker failed; it doubles up to
+	// MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+	// Probe checks a broker's health; DialProbe when nil
+	Probe func(ctx context.Context, brokerURL string) error
+	// Discovery adds the brokers announced on the local network
+	Discovery *Listener
+}
+
+// DefaultConfig probes every 10 seconds and fails back after 30 seconds of health
+func DefaultConfig(brokers ...Broker) Config {
+	return Config{
+		Brokers:          brokers,
+		ProbeInterval:    10 * time.Second,
+		ProbeTimeout:     2 * time.Second,
+		FailbackDelay:    30 * time.Second,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: time.Minute,
+	}
+}
+
+// withDefaults fills unset durations from DefaultConfig
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = d.ProbeInterval
+	}
+	if c.ProbeTimeout <= 0 {
+		c.ProbeTimeout = d.ProbeTimeout
+	}
+	if c.FailbackDelay <= 0 {
+		c.FailbackDelay = d.FailbackDelay
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = d.RetryInterval
+	}
+	if c.MaxRetryInterval < c.RetryInterval {
+		c.MaxRetryInterval = c.RetryInterval
+	}
+	if c.Probe == nil {
+		c.Probe = DialProbe
+	}
+	return c
+}
+
+var defaultPorts = map[string]string{
+	"tcp": "1883", "mqtt": "1883",
+	"ssl": "8883", "tls": "8883", "mqtts": "8883", "tcps": "8883",
+	"ws": "80", "wss": "443",
+}
+
+// DialProbe reports a broker healthy when its port accepts a TCP connection
+func DialProbe(ctx context.Context, brokerURL string) error {
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port, ok := defaultPorts[u.Scheme]
+		if !ok {
+			return fmt.Errorf("no default port for %s", brokerURL)
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// BrokerStatus is a broker's last known health
+type BrokerStatus struct {
+	Broker
+	Healthy    bool
+	Checked    bool      // False until the broker was probed or connected to
+	Since      time.Time // When the broker last became healthy or unhealthy
+	LastError  string
+	Active     bool // The client is connected to this broker
+	Discovered bool
+}
+
+type health struct {
+	healthy bool
+	since   time.Time
+	err     error
+}
+
+// selector tracks broker health and orders the brokers to try
+type selector struct {
+	config Config
+	mu     sync.Mutex
+	health map[string]*health
+	rand   *rand.Rand
+	now    func() time.Time
+}
+
+func newSelector(config Config) *selector {
+	return &selector{
+		config: config,
+		health: make(map[string]*health),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:    time.Now,
+	}
+}
+
+// brokers returns the configured brokers followed by the discovered ones not already
+// configured
+func (s *selector) brokers() []Broker {
+	brokers := append([]Broker(nil), s.config.Brokers...)
+	if s.config.Discovery == nil {
+		return brokers
+	}
+	known := make(map[string]bool, len(brokers))
+	for _, b := range brokers {
+		known[b.URL] = true
+	}
+	for _, b := range s.config.Discovery.Brokers() {
+		if !known[b.URL] {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+// record updates a broker's health from a probe or connection attempt; it reports
+// whether the health changed
+func (s *selector) record(brokerURL string, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.health[brokerURL]
+	healthy := err == nil
+	changed := !ok || h.healthy != healthy
+	if !ok {
+		h = &health{}
+		s.health[brokerURL] = h
+	}
+	if changed {
+		h.since = s.now()
+	}
+	h.healthy = healthy
+	h.err = err
+	return changed
+}
+
+// healthySince returns when the broker became healthy; ok is false for unhealthy and
+// unprobed brokers
+func (s *selector) healthySince(brokerURL string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.health[brokerURL]
+	if !ok || !h.healthy {
+		return time.Time{}, false
+	}
+	return h.since, true
+}
+
+// candidates orders the brokers to try: healthy and unprobed brokers before unhealthy
+// ones, each by priority, shuffled by weight within a priority. A sticky selector
+// tries last first.
+func (s *selector) candidates(last string) []Broker {
+	brokers := s.brokers()
+	s.mu.Lock()
+	keys := make(map[string]float64, len(brokers))
+	down := make(map[string]bool, len(brokers))
+	for _, b := range brokers {
+		// Weighted random order: sort by u^(1/w), largest first
+		w := b.Weight
+		if w <= 0 {
+			w = 1
+		}
+		keys[b.URL] = -math.Pow(s.rand.Float64(), 1/float64(w))
+		if h, ok := s.health[b.URL]; ok && !h.healthy {
+			down[b.URL] = true
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(brokers, func(i, j int) bool {
+		a, b := brokers[i], brokers[j]
+		if down[a.URL] != down[b.URL] {
+			return !down[a.URL]
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return keys[a.URL] < keys[b.URL]
+	})
+	if s.config.Sticky && last != "" && !down[last] {
+		for i, b := range brokers {
+			if b.URL == last {
+				copy(brokers[1:i+1], brokers[:i])
+				brokers[0] = b
+				break
+			}
+		}
+	}
+	return brokers
+}
+
+// failback returns the broker to move to from active, if a broker of a better
+// priority has been healthy for the fail-back delay
+func (s *selector) failback(active string) (Broker, bool) {
+	if s.config.Sticky {
+		return Broker{}, false
+	}
+	brokers := s.brokers()
+	current, found := Broker{}, false
+	for _, b := range brokers {
+		if b.URL == active {
+			current, found = b, true
+		}
+	}
+	if !found {
+		// A discovered broker that stopped announcing; anything healthy is better
+		current.Priority = math.MaxInt
+	}
+	for _, b := range s.candidates("") {
+		if b.Priority >= current.Priority {
+			break
+		}
+		if since, ok := s.healthySince(b.URL); ok && s.now().Sub(since) >= s.config.FailbackDelay {
+			return b, true
+		}
+	}
+	return Broker{}, false
+}
+
+// status lists every broker with its health
+func (s *selector) status(active string) []BrokerStatus {
+	configured := make(map[string]bool, len(s.config.Brokers))
+	for _, b := range s.config.Brokers {
+		configured[b.URL] = true
+	}
+	brokers := s.brokers()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]BrokerStatus, len(brokers))
+	for i, b := range brokers {
+		st := BrokerStatus{Broker: b, Active: b.URL == active, Discovered: !configured[b.URL]}
+		if h, ok := s.health[b.URL]; ok {
+			st.Checked = true
+			st.Healthy = h.healthy
+			st.Since = h.since
+			if h.err != nil {
+				st.LastError = h.err.Error()
+			}
+		}
+		status[i] = st
+	}
+	return status
+}
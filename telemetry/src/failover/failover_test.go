@@ -0,0 +1,319 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"telemetry/src/transport"
+)
+
+// site is a set of in-memory brokers that can be taken down and brought back
+type site struct {
+	mu    sync.Mutex
+	buses map[string]*transport.Bus
+	down  map[string]bool
+	conns map[string]*transport.Memory
+}
+
+var errDown = errors.New("broker down")
+
+func newSite(urls ...string) *site {
+	s := &site{buses: make(map[string]*transport.Bus), down: make(map[string]bool), conns: make(map[string]*transport.Memory)}
+	for _, u := range urls {
+		s.buses[u] = transport.NewBus()
+	}
+	return s
+}
+
+func (s *site) factory(brokerURL string) (transport.Transport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[brokerURL] {
+		return nil, errDown
+	}
+	m := transport.NewMemory(s.buses[brokerURL])
+	s.conns[brokerURL] = m
+	return m, nil
+}
+
+func (s *site) probe(ctx context.Context, brokerURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[brokerURL] {
+		return errDown
+	}
+	return nil
+}
+
+// fail takes a broker down, dropping its client connection
+func (s *site) fail(brokerURL string) {
+	s.mu.Lock()
+	s.down[brokerURL] = true
+	conn := s.conns[brokerURL]
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Drop()
+	}
+}
+
+// conn returns the last client connection made to brokerURL
+func (s *site) conn(brokerURL string) *transport.Memory {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[brokerURL]
+}
+
+func (s *site) recover(brokerURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down[brokerURL] = false
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	s := newSelector(DefaultConfig(
+		Broker{URL: "tcp://a:1883", Priority: 1},
+		Broker{URL: "tcp://b:1883", Priority: 0},
+		Broker{URL: "tcp://c:1883", Priority: 2},
+	))
+	order := func(last string) []string {
+		var urls []string
+		for _, b := range s.candidates(last) {
+			urls = append(urls, b.URL)
+		}
+		return urls
+	}
+	check := func(got, want []string) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("Got %v, want %v", got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Got %v, want %v", got, want)
+				return
+			}
+		}
+	}
+	check(order(""), []string{"tcp://b:1883", "tcp://a:1883", "tcp://c:1883"})
+
+	// Unhealthy brokers are tried last
+	s.record("tcp://b:1883", errDown)
+	check(order(""), []string{"tcp://a:1883", "tcp://c:1883", "tcp://b:1883"})
+
+	// A sticky selector keeps to the last broker while it is healthy
+	s.config.Sticky = true
+	check(order("tcp://c:1883"), []string{"tcp://c:1883", "tcp://a:1883", "tcp://b:1883"})
+	check(order("tcp://b:1883"), []string{"tcp://a:1883", "tcp://c:1883", "tcp://b:1883"})
+}
+
+func TestWeightedCandidates(t *testing.T) {
+	s := newSelector(DefaultConfig(
+		Broker{URL: "tcp://heavy:1883", Weight: 3},
+		Broker{URL: "tcp://light:1883", Weight: 1},
+		Broker{URL: "tcp://backup:1883", Priority: 1, Weight: 100},
+	))
+	s.rand = rand.New(rand.NewSource(1))
+	first := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		candidates := s.candidates("")
+		first[candidates[0].URL]++
+		if candidates[2].URL != "tcp://backup:1883" {
+			t.Fatalf("Weight must not override priority: %v", candidates)
+		}
+	}
+	if share := float64(first["tcp://heavy:1883"]) / 4000; share < 0.7 || share > 0.8 {
+		t.Errorf("Heavy broker chosen first %.2f of the time, want about 0.75", share)
+	}
+}
+
+func TestFailoverAndFailback(t *testing.T) {
+	const primary, backup = "mem://primary", "mem://backup"
+	s := newSite(primary, backup)
+	config := DefaultConfig(Ordered(primary, backup)...)
+	config.Probe = s.probe
+	config.ProbeInterval = 10 * time.Millisecond
+	config.FailbackDelay = 50 * time.Millisecond
+	config.RetryInterval = 10 * time.Millisecond
+	f, err := New(config, s.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var events []transport.Event
+	f.OnEvent(func(ev transport.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	})
+	var commands []string
+	f.SetWill(transport.Will{Topic: "robots/r1/status", Payload: []byte("lost"), QoS: 1, Retained: true})
+	if err := f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Disconnect()
+	if f.Active() != primary {
+		t.Fatalf("Connected to %q, want the primary", f.Active())
+	}
+	if err := f.Subscribe("robots/r1/commands", 1, func(_ string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		commands = append(commands, string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary fails: its will is published there and the client moves to the
+	// backup with its subscription
+	s.fail(primary)
+	waitFor(t, "failover", func() bool { return f.Active() == backup })
+	if will, ok := s.buses[primary].Retained("robots/r1/status"); !ok || string(will) != "lost" {
+		t.Errorf("Primary has will %q, %t", will, ok)
+	}
+	observer := transport.NewMemory(s.buses[backup])
+	observer.Connect()
+	observer.Publish("robots/r1/commands", 1, false, []byte("on-backup"))
+	if err := f.Publish("robots/r1/telemetry/health", 0, false, []byte("ok")); err != nil {
+		t.Errorf("Publish after failover: %v", err)
+	}
+
+	// Once the primary has been healthy for the fail-back delay, the client returns
+	s.recover(primary)
+	waitFor(t, "fail-back", func() bool { return f.Active() == primary })
+	if s.conn(backup).IsConnected() {
+		t.Error("Backup connection should be closed after fail-back")
+	}
+	// A clean disconnect does not fire the will, so it is published before leaving
+	if will, ok := s.buses[backup].Retained("robots/r1/status"); !ok || string(will) != "lost" {
+		t.Errorf("Backup has will %q, %t after fail-back", will, ok)
+	}
+	observer = transport.NewMemory(s.buses[primary])
+	observer.Connect()
+	observer.Publish("robots/r1/commands", 1, false, []byte("on-primary"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 2 || commands[0] != "on-backup" || commands[1] != "on-primary" {
+		t.Errorf("Got commands %v", commands)
+	}
+	var connects []string
+	for _, ev := range events {
+		if ev.Type == transport.EventConnected {
+			connects = append(connects, ev.Broker)
+		}
+	}
+	if len(connects) != 3 || connects[0] != primary || connects[1] != backup || connects[2] != primary {
+		t.Errorf("Connected to %v, want primary, backup, primary", connects)
+	}
+}
+
+func TestStickyDoesNotFailBack(t *testing.T) {
+	const primary, backup = "mem://primary", "mem://backup"
+	s := newSite(primary, backup)
+	config := DefaultConfig(Ordered(primary, backup)...)
+	config.Probe = s.probe
+	config.ProbeInterval = 5 * time.Millisecond
+	config.FailbackDelay = 5 * time.Millisecond
+	config.RetryInterval = 5 * time.Millisecond
+	config.Sticky = true
+	f, err := New(config, s.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Disconnect()
+	s.fail(primary)
+	waitFor(t, "failover", func() bool { return f.Active() == backup })
+	s.recover(primary)
+	waitFor(t, "primary probed healthy", func() bool {
+		for _, st := range f.Brokers() {
+			if st.URL == primary && st.Healthy {
+				return true
+			}
+		}
+		return false
+	})
+	time.Sleep(50 * time.Millisecond)
+	if f.Active() != backup {
+		t.Errorf("Sticky client moved to %s", f.Active())
+	}
+}
+
+func TestAllBrokersDown(t *testing.T) {
+	s := newSite("mem://a", "mem://b")
+	s.fail("mem://a")
+	s.fail("mem://b")
+	f, err := New(DefaultConfig(Ordered("mem://a", "mem://b")...), s.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Connect(); !errors.Is(err, ErrNoBroker) {
+		t.Errorf("Expected ErrNoBroker, got %v", err)
+	}
+	if err := f.Publish("x", 0, false, nil); !errors.Is(err, transport.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+	s.recover("mem://b")
+	if err := f.Connect(); err != nil || f.Active() != "mem://b" {
+		t.Errorf("Connect after recovery: %v, active %q", err, f.Active())
+	}
+	f.Disconnect()
+}
+
+func TestDiscoveryOnLoopback(t *testing.T) {
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a, err := Announce(l.Addr().String(), Broker{URL: "tcp://0.0.0.0:1883", Priority: 2}, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "announcement", func() bool { return len(l.Brokers()) == 1 })
+	if b := l.Brokers()[0]; b.URL != "tcp://127.0.0.1:1883" || b.Priority != 2 {
+		t.Errorf("Discovered %+v", b)
+	}
+
+	// A client with no configured brokers uses the discovered one
+	s := newSite("tcp://127.0.0.1:1883")
+	config := DefaultConfig()
+	config.Discovery = l
+	f, err := New(config, s.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Disconnect()
+	if st := f.Brokers(); len(st) != 1 || !st[0].Discovered || !st[0].Active {
+		t.Errorf("Unexpected broker status %+v", st)
+	}
+
+	// Announcements expire once the broker stops sending them
+	a.Close()
+	l.mu.Lock()
+	l.now = func() time.Time { return time.Now().Add(time.Second) }
+	l.mu.Unlock()
+	if brokers := l.Brokers(); len(brokers) != 0 {
+		t.Errorf("Expected the announcement to expire, got %v", brokers)
+	}
+}
@@ -0,0 +1,366 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+	"telemetry/src/transport"
+)
+
+// errStopped ends a connection attempt that Disconnect overtook
+var errStopped = errors.New("failover stopped")
+
+// Factory builds the transport for one broker. The transport must not reconnect by
+// itself; Transport moves to another broker when the connection drops.
+type Factory func(brokerURL string) (transport.Transport, error)
+
+type subscription struct {
+	qos     byte
+	handler transport.Handler
+}
+
+// Transport is a transport.Transport over whichever broker is currently best. It
+// connects to the first candidate that accepts, moves to the next when the connection
+// drops, fails back to a preferred broker once it has been healthy for a while, and
+// restores the will and every subscription on each broker it joins. A broker it leaves
+// while still connected gets the will published explicitly.
+type Transport struct {
+	config   Config
+	factory  Factory
+	selector *selector
+	log      *logger.Logger
+	events   transport.Events
+
+	mu           sync.RWMutex
+	current      transport.Transport
+	active       string
+	last         string
+	will         *transport.Will
+	stop         chan struct{} // Closed by Disconnect; nil while disconnected
+	reconnecting bool
+
+	// switchMu serialises joining brokers, so a fail-back and a reconnect cannot race
+	switchMu sync.Mutex
+	subsMu   sync.Mutex
+	subs     map[string]subscription
+}
+
+// New creates a failover transport over config.Brokers and any discovered brokers
+func New(config Config, factory Factory) (*Transport, error) {
+	if len(config.Brokers) == 0 && config.Discovery == nil {
+		return nil, fmt.Errorf("%w: no brokers configured", ErrNoBroker)
+	}
+	config = config.withDefaults()
+	return &Transport{
+		config:   config,
+		factory:  factory,
+		selector: newSelector(config),
+		log:      logger.New(logger.INFO),
+		subs:     make(map[string]subscription),
+	}, nil
+}
+
+// Connect joins the first broker that accepts and starts probing broker health. It
+// returns ErrNoBroker, wrapping the last failure, when every broker refused.
+func (f *Transport) Connect() error {
+	f.events.Emit(transport.Event{Type: transport.EventConnecting})
+	stop := make(chan struct{})
+	f.mu.Lock()
+	if f.stop != nil {
+		f.mu.Unlock()
+		return errors.New("already connected")
+	}
+	f.stop = stop
+	f.mu.Unlock()
+
+	if err := f.connectAny(stop); err != nil {
+		f.mu.Lock()
+		f.stop = nil
+		f.mu.Unlock()
+		return err
+	}
+	go f.monitor(stop)
+	return nil
+}
+
+// connectAny tries every candidate in order
+func (f *Transport) connectAny(stop chan struct{}) error {
+	f.mu.RLock()
+	last := f.last
+	f.mu.RUnlock()
+	candidates := f.selector.candidates(last)
+	if len(candidates) == 0 {
+		return fmt.Errorf("%w: none configured or discovered", ErrNoBroker)
+	}
+	var lastErr error
+	for _, b := range candidates {
+		err := f.join(b, stop)
+		if err == nil || errors.Is(err, errStopped) {
+			return err
+		}
+		f.log.Warn("Broker %s unavailable: %v", b.URL, err)
+		lastErr = err
+	}
+	return fmt.Errorf("%w: %v", ErrNoBroker, lastErr)
+}
+
+// join connects to b, restores the will and subscriptions there and makes it the
+// current broker. A previous broker, on fail-back, is left gracefully afterwards.
+func (f *Transport) join(b Broker, stop chan struct{}) error {
+	f.switchMu.Lock()
+	defer f.switchMu.Unlock()
+
+	inner, err := f.factory(b.URL)
+	if err != nil {
+		return err
+	}
+	f.mu.RLock()
+	will := f.will
+	f.mu.RUnlock()
+	if will != nil {
+		inner.SetWill(*will)
+	}
+	inner.OnEvent(func(ev transport.Event) {
+		if ev.Type == transport.EventConnectionLost {
+			f.lost(inner, b.URL, ev.Err)
+		}
+	})
+	err = inner.Connect()
+	f.selector.record(b.URL, err)
+	if err != nil {
+		return err
+	}
+
+	f.subsMu.Lock()
+	var firstErr error
+	for filter, sub := range f.subs {
+		if err := inner.Subscribe(filter, sub.qos, sub.handler); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("resubscribe %s on %s: %w", filter, b.URL, err)
+		}
+	}
+	restored := len(f.subs)
+	f.mu.Lock()
+	if f.stop != stop {
+		f.mu.Unlock()
+		f.subsMu.Unlock()
+		inner.Disconnect()
+		return errStopped
+	}
+	previous := f.current
+	f.current, f.active, f.last = inner, b.URL, b.URL
+	f.mu.Unlock()
+	f.subsMu.Unlock()
+
+	if previous != nil {
+		f.leave(previous)
+	}
+	f.log.Info("Connected to broker %s", b.URL)
+	f.events.Emit(transport.Event{Type: transport.EventConnected, Broker: b.URL})
+	if restored > 0 {
+		f.events.Emit(transport.Event{Type: transport.EventResubscribed, Err: firstErr, Broker: b.URL})
+	}
+	return nil
+}
+
+// leave disconnects from a broker that is still reachable, e.g. on fail-back. A clean
+// disconnect does not trigger the will, so it is published first; otherwise the broker
+// would keep announcing the robot as connected.
+func (f *Transport) leave(previous transport.Transport) {
+	f.mu.RLock()
+	will := f.will
+	f.mu.RUnlock()
+	if will != nil {
+		if err := previous.Publish(will.Topic, will.QoS, will.Retained, will.Payload); err != nil {
+			f.log.Warn("Failed to publish the will before leaving a broker: %v", err)
+		}
+	}
+	previous.Disconnect()
+}
+
+// lost handles a dropped connection to the current broker by moving to another
+func (f *Transport) lost(inner transport.Transport, brokerURL string, err error) {
+	f.mu.Lock()
+	if f.current != inner {
+		f.mu.Unlock()
+		return
+	}
+	f.current, f.active = nil, ""
+	stop := f.stop
+	start := !f.reconnecting && stop != nil
+	if start {
+		f.reconnecting = true
+	}
+	f.mu.Unlock()
+
+	if err == nil {
+		err = errors.New("connection lost")
+	}
+	f.selector.record(brokerURL, err)
+	f.events.Emit(transport.Event{Type: transport.EventConnectionLost, Err: err, Broker: brokerURL})
+	if start {
+		go f.reconnect(stop)
+	}
+}
+
+// reconnect tries every broker in turn, backing off between rounds, until one accepts
+// or Disconnect is called
+func (f *Transport) reconnect(stop chan struct{}) {
+	defer func() {
+		f.mu.Lock()
+		f.reconnecting = false
+		f.mu.Unlock()
+	}()
+	delay := f.config.RetryInterval
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		f.events.Emit(transport.Event{Type: transport.EventReconnecting, Attempt: attempt})
+		err := f.connectAny(stop)
+		if err == nil || errors.Is(err, errStopped) {
+			return
+		}
+		f.log.Warn("Reconnect attempt %d failed: %v", attempt, err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > f.config.MaxRetryInterval {
+			delay = f.config.MaxRetryInterval
+		}
+	}
+}
+
+// monitor probes every broker and fails back to a preferred one while connected
+func (f *Transport) monitor(stop chan struct{}) {
+	ticker := time.NewTicker(f.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		f.probe(stop)
+
+		f.mu.RLock()
+		active := f.active
+		f.mu.RUnlock()
+		if active == "" {
+			continue
+		}
+		if b, ok := f.selector.failback(active); ok {
+			f.log.Info("Failing back from %s to %s", active, b.URL)
+			if err := f.join(b, stop); err != nil && !errors.Is(err, errStopped) {
+				f.log.Warn("Fail-back to %s failed: %v", b.URL, err)
+			}
+		}
+	}
+}
+
+// probe checks every broker once; the broker in use counts as healthy
+func (f *Transport) probe(stop chan struct{}) {
+	f.mu.RLock()
+	active := f.active
+	f.mu.RUnlock()
+	for _, b := range f.selector.brokers() {
+		if b.URL == active {
+			f.selector.record(b.URL, nil)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), f.config.ProbeTimeout)
+		err := f.config.Probe(ctx, b.URL)
+		cancel()
+		if f.selector.record(b.URL, err) {
+			if err != nil {
+				f.log.Warn("Broker %s is unhealthy: %v", b.URL, err)
+			} else {
+				f.log.Info("Broker %s is healthy", b.URL)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// Disconnect leaves the current broker gracefully and stops probing and reconnecting
+func (f *Transport) Disconnect() {
+	f.mu.Lock()
+	inner, stop := f.current, f.stop
+	f.current, f.active, f.stop = nil, "", nil
+	f.mu.Unlock()
+	if stop != nil {
+		close(stop)
+	}
+	if inner != nil {
+		inner.Disconnect()
+	}
+	f.events.Emit(transport.Event{Type: transport.EventDisconnected})
+}
+
+func (f *Transport) IsConnected() bool {
+	inner := f.inner()
+	return inner != nil && inner.IsConnected()
+}
+
+func (f *Transport) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	inner := f.inner()
+	if inner == nil {
+		return transport.ErrNotConnected
+	}
+	return inner.Publish(topic, qos, retained, payload)
+}
+
+// Subscribe subscribes on the current broker and on every broker joined later
+func (f *Transport) Subscribe(topic string, qos byte, handler transport.Handler) error {
+	f.subsMu.Lock()
+	defer f.subsMu.Unlock()
+	inner := f.inner()
+	if inner == nil {
+		return transport.ErrNotConnected
+	}
+	if err := inner.Subscribe(topic, qos, handler); err != nil {
+		return err
+	}
+	f.subs[topic] = subscription{qos: qos, handler: handler}
+	return nil
+}
+
+// SetWill sets the will registered with every broker joined from now on
+func (f *Transport) SetWill(will transport.Will) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.will = &will
+}
+
+func (f *Transport) OnEvent(fn func(transport.Event)) {
+	f.events.Add(fn)
+}
+
+// Active returns the URL of the broker in use, or "" while disconnected
+func (f *Transport) Active() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.active
+}
+
+// Brokers returns every configured and discovered broker with its last known health
+func (f *Transport) Brokers() []BrokerStatus {
+	return f.selector.status(f.Active())
+}
+
+func (f *Transport) inner() transport.Transport {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current
+}
@@ -0,0 +1,113 @@
+package telemetry
+
+import (
+	"math"
+
+	"telemetry/src/simulation"
+)
+
+// WGS84 ellipsoid parameters
+const (
+	wgs84A  = 6378137.0
+	wgs84F  = 1 / 298.257223563
+	wgs84B  = wgs84A * (1 - wgs84F)
+	wgs84E2 = wgs84F * (2 - wgs84F)
+)
+
+// GeoPoint is a WGS84 geodetic coordinate; altitude is height above the ellipsoid in meters
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// GeodeticToECEF converts a geodetic coordinate into earth-centered, earth-fixed meters
+func GeodeticToECEF(p GeoPoint) (x, y, z float64) {
+	lat := p.Latitude * math.Pi / 180
+	lon := p.Longitude * math.Pi / 180
+	sinLat, cosLat := math.Sincos(lat)
+	sinLon, cosLon := math.Sincos(lon)
+
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinLat*sinLat)
+	x = (n + p.Altitude) * cosLat * cosLon
+	y = (n + p.Altitude) * cosLat * sinLon
+	z = (n*(1-wgs84E2) + p.Altitude) * sinLat
+	return x, y, z
+}
+
+// ECEFToGeodetic converts earth-centered, earth-fixed meters into a geodetic coordinate
+// using Bowring's method, accurate to well under a millimeter near the earth's surface.
+func ECEFToGeodetic(x, y, z float64) GeoPoint {
+	ep2 := (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	p := math.Hypot(x, y)
+	theta := math.Atan2(z*wgs84A, p*wgs84B)
+	sinT, cosT := math.Sincos(theta)
+
+	lat := math.Atan2(z+ep2*wgs84B*sinT*sinT*sinT, p-wgs84E2*wgs84A*cosT*cosT*cosT)
+	lon := math.Atan2(y, x)
+	sinLat := math.Sin(lat)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinLat*sinLat)
+
+	var alt float64
+	if cosLat := math.Cos(lat); math.Abs(cosLat) > 1e-10 {
+		alt = p/cosLat - n
+	} else {
+		alt = math.Abs(z) - wgs84B
+	}
+
+	return GeoPoint{
+		Latitude:  lat * 180 / math.Pi,
+		Longitude: lon * 180 / math.Pi,
+		Altitude:  alt,
+	}
+}
+
+// LocalFrame is an east-north-up tangent plane anchored at a site origin. Positions in
+// the frame are meters east (X), north (Y) and up (Z) of the origin.
+type LocalFrame struct {
+	origin     GeoPoint
+	ox, oy, oz float64
+	sinLat     float64
+	cosLat     float64
+	sinLon     float64
+	cosLon     float64
+}
+
+// NewLocalFrame creates an ENU frame anchored at origin
+func NewLocalFrame(origin GeoPoint) *LocalFrame {
+	f := &LocalFrame{origin: origin}
+	f.ox, f.oy, f.oz = GeodeticToECEF(origin)
+	f.sinLat, f.cosLat = math.Sincos(origin.Latitude * math.Pi / 180)
+	f.sinLon, f.cosLon = math.Sincos(origin.Longitude * math.Pi / 180)
+	return f
+}
+
+// Origin returns the site origin of the frame
+func (f *LocalFrame) Origin() GeoPoint {
+	return f.origin
+}
+
+// ToENU converts a geodetic coordinate into east, north and up meters from the origin
+func (f *LocalFrame) ToENU(p GeoPoint) (east, north, up float64) {
+	x, y, z := GeodeticToECEF(p)
+	dx, dy, dz := x-f.ox, y-f.oy, z-f.oz
+
+	east = -f.sinLon*dx + f.cosLon*dy
+	north = -f.sinLat*f.cosLon*dx - f.sinLat*f.sinLon*dy + f.cosLat*dz
+	up = f.cosLat*f.cosLon*dx + f.cosLat*f.sinLon*dy + f.sinLat*dz
+	return east, north, up
+}
+
+// FromENU converts east, north and up meters from the origin into a geodetic coordinate
+func (f *LocalFrame) FromENU(east, north, up float64) GeoPoint {
+	dx := -f.sinLon*east - f.sinLat*f.cosLon*north + f.cosLat*f.cosLon*up
+	dy := f.cosLon*east - f.sinLat*f.sinLon*north + f.cosLat*f.sinLon*up
+	dz := f.cosLat*north + f.sinLat*up
+	return ECEFToGeodetic(f.ox+dx, f.oy+dy, f.oz+dz)
+}
+
+// Position converts a GPS fix into a local position for NavigationMessage.Position
+func (f *LocalFrame) Position(fix GPSData) simulation.Position {
+	east, north, up := f.ToENU(fix.Point())
+	return simulation.Position{X: east, Y: north, Z: up}
+}
@@ -0,0 +1,210 @@
+// Package governor keeps a robot's telemetry within an airtime budget. A token bucket
+// refills at the allowed byte rate; each priority below the protected ones must leave
+// a larger reserve in the bucket, so as the budget runs short raw sensor data is
+// downsampled first, then navigation, health and status, while alerts and e-stops
+// always go out.
+package governor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"telemetry/src/outbox"
+)
+
+// ErrShed is returned for a message dropped to stay within the budget
+var ErrShed = errors.New("shed by bandwidth governor")
+
+// ErrInvalidConfig is wrapped by every config validation error
+var ErrInvalidConfig = errors.New("invalid bandwidth governor config")
+
+// Config sets the budget
+type Config struct {
+	BytesPerSecond float64
+	Burst          float64 // Bucket size in bytes
+	// Reserve is the share of the burst held back from each priority level below
+	// Protected: status must leave Reserve of the bucket, health twice that, and so on
+	Reserve float64
+	// Protected is the lowest priority that is never shed. Protected messages may
+	// overdraw the bucket by up to a burst, delaying everything else.
+	Protected outbox.Priority
+}
+
+// DefaultConfig allows bytesPerSecond with a two-second burst, holding back a tenth of
+// the bucket per priority level and never shedding alerts or e-stops
+func DefaultConfig(bytesPerSecond float64) Config {
+	return Config{
+		BytesPerSecond: bytesPerSecond,
+		Burst:          2 * bytesPerSecond,
+		Reserve:        0.1,
+		Protected:      outbox.PriorityAlert,
+	}
+}
+
+// Validate checks that the budget is positive and leaves room for every priority
+func (c Config) Validate() error {
+	if c.BytesPerSecond <= 0 || c.Burst <= 0 {
+		return fmt.Errorf("%w: rate and burst must be positive", ErrInvalidConfig)
+	}
+	if c.Reserve < 0 || c.Reserve*float64(c.Protected-outbox.PriorityRawSensor) >= 1 {
+		return fmt.Errorf("%w: reserve %v leaves no room for raw sensor data", ErrInvalidConfig, c.Reserve)
+	}
+	return nil
+}
+
+// StreamStats counts what a governor did with one stream's messages
+type StreamStats struct {
+	Priority  outbox.Priority
+	Sent      uint64
+	SentBytes uint64
+	Shed      uint64
+	ShedBytes uint64
+}
+
+// Stats describes a governor for metrics
+type Stats struct {
+	Tokens  float64 // Bytes available now; negative after protected messages overdrew
+	Streams map[string]StreamStats
+}
+
+// Governor decides which messages fit the budget. It is safe for concurrent use.
+type Governor struct {
+	config  Config
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	now     func() time.Time
+	streams map[string]*StreamStats
+}
+
+// New creates a governor with a full bucket
+func New(config Config) (*Governor, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Governor{
+		config:  config,
+		tokens:  config.Burst,
+		now:     time.Now,
+		streams: make(map[string]*StreamStats),
+	}
+	g.last = g.now()
+	return g, nil
+}
+
+// Allow reports whether a message of size bytes on stream may be sent now, charging
+// it to the budget if so
+func (g *Governor) Allow(stream string, priority outbox.Priority, size int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refill()
+	st := g.stream(stream, priority)
+	if priority < g.config.Protected && g.tokens-float64(size) < g.reserve(priority) {
+		st.Shed++
+		st.ShedBytes += uint64(size)
+		return false
+	}
+	g.charge(st, priority, size)
+	return true
+}
+
+// Wait charges a message of size bytes on stream to the budget once it fits, blocking
+// until then or until ctx is done. Unlike Allow it never sheds, for messages that have
+// to go out eventually, such as an outbox replaying its backlog. A message too large
+// to ever fit waits for a full bucket.
+func (g *Governor) Wait(ctx context.Context, stream string, priority outbox.Priority, size int) error {
+	for {
+		delay := g.take(stream, priority, size)
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take charges the message if it fits now, or returns how long until it will
+func (g *Governor) take(stream string, priority outbox.Priority, size int) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refill()
+	st := g.stream(stream, priority)
+	if priority < g.config.Protected {
+		need := math.Min(g.reserve(priority)+float64(size), g.config.Burst)
+		if g.tokens < need {
+			delay := time.Duration((need - g.tokens) / g.config.BytesPerSecond * float64(time.Second))
+			return max(delay, time.Millisecond)
+		}
+	}
+	g.charge(st, priority, size)
+	return 0
+}
+
+// charge takes a sent message from the bucket. Protected messages overdraw it by at
+// most a burst.
+func (g *Governor) charge(st *StreamStats, priority outbox.Priority, size int) {
+	cost := float64(size)
+	if priority >= g.config.Protected && g.tokens-cost < -g.config.Burst {
+		cost = g.tokens + g.config.Burst
+	}
+	g.tokens -= cost
+	st.Sent++
+	st.SentBytes += uint64(size)
+}
+
+func (g *Governor) stream(name string, priority outbox.Priority) *StreamStats {
+	st, ok := g.streams[name]
+	if !ok {
+		st = &StreamStats{}
+		g.streams[name] = st
+	}
+	st.Priority = priority
+	return st
+}
+
+// reserve is how many bytes a message of priority p must leave in the bucket
+func (g *Governor) reserve(p outbox.Priority) float64 {
+	return g.config.Reserve * g.config.Burst * float64(g.config.Protected-p)
+}
+
+func (g *Governor) refill() {
+	now := g.now()
+	g.tokens += now.Sub(g.last).Seconds() * g.config.BytesPerSecond
+	if g.tokens > g.config.Burst {
+		g.tokens = g.config.Burst
+	}
+	g.last = now
+}
+
+// Stats returns the bucket level and per-stream counters
+func (g *Governor) Stats() Stats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.refill()
+	stats := Stats{Tokens: g.tokens, Streams: make(map[string]StreamStats, len(g.streams))}
+	for name, st := range g.streams {
+		stats.Streams[name] = *st
+	}
+	return stats
+}
+
+// Shed returns how many messages of each stream were shed, omitting streams that lost
+// nothing
+func (s Stats) Shed() map[string]uint64 {
+	shed := make(map[string]uint64)
+	for name, st := range s.Streams {
+		if st.Shed > 0 {
+			shed[name] = st.Shed
+		}
+	}
+	return shed
+}
@@ -0,0 +1,144 @@
+package governor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"telemetry/src/outbox"
+)
+
+// clock is a fake time source advanced by the test
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestGovernor(t *testing.T, config Config) (*Governor, *clock) {
+	t.Helper()
+	g, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clock{t: time.Unix(1700000000, 0)}
+	g.now = c.now
+	g.last = c.t
+	return g, c
+}
+
+func TestReservesPerPriority(t *testing.T) {
+	// A 2000 byte bucket: raw sensor data must leave 800 bytes, navigation 600, health
+	// 400 and status 200
+	g, c := newTestGovernor(t, DefaultConfig(1000))
+	for i, tc := range []struct {
+		stream   string
+		priority outbox.Priority
+		size     int
+		want     bool
+	}{
+		{"lidar", outbox.PriorityRawSensor, 1000, true},
+		{"lidar", outbox.PriorityRawSensor, 300, false},
+		{"navigation", outbox.PriorityNavigation, 300, true},
+		{"health", outbox.PriorityHealth, 250, true},
+		{"status", outbox.PriorityStatus, 200, true},
+		{"health", outbox.PriorityHealth, 100, false},
+		// Protected messages always go out, overdrawing by up to a burst
+		{"estop", outbox.PriorityEStop, 1000, true},
+		{"alert", outbox.PriorityAlert, 2000, true},
+		{"status", outbox.PriorityStatus, 1, false},
+	} {
+		if got := g.Allow(tc.stream, tc.priority, tc.size); got != tc.want {
+			t.Errorf("%d: %s %d bytes allowed %t, want %t", i, tc.stream, tc.size, got, tc.want)
+		}
+	}
+	if tokens := g.Stats().Tokens; tokens != -2000 {
+		t.Errorf("Bucket at %v, want the overdraft capped at -2000", tokens)
+	}
+	c.advance(3 * time.Second)
+	if !g.Allow("lidar", outbox.PriorityRawSensor, 100) {
+		t.Error("Raw data should flow again once the bucket refills")
+	}
+
+	stats := g.Stats()
+	if st := stats.Streams["lidar"]; st.Sent != 2 || st.Shed != 1 || st.ShedBytes != 300 {
+		t.Errorf("Unexpected lidar stats %+v", st)
+	}
+	shed := stats.Shed()
+	if len(shed) != 3 || shed["lidar"] != 1 || shed["health"] != 1 || shed["status"] != 1 {
+		t.Errorf("Unexpected shed report %v", shed)
+	}
+}
+
+func TestDownsamplesLowPriorityFirst(t *testing.T) {
+	g, c := newTestGovernor(t, DefaultConfig(1000))
+	// Ten seconds of 2 kB/s raw data with health and navigation on top, against a
+	// 1 kB/s budget
+	for tick := 0; tick < 200; tick++ {
+		c.advance(50 * time.Millisecond)
+		g.Allow("lidar", outbox.PriorityRawSensor, 100)
+		if tick%10 == 0 {
+			g.Allow("navigation", outbox.PriorityNavigation, 150)
+		}
+		if tick%20 == 0 {
+			g.Allow("health", outbox.PriorityHealth, 200)
+		}
+	}
+	stats := g.Stats()
+	if shed := stats.Streams["health"].Shed + stats.Streams["navigation"].Shed; shed != 0 {
+		t.Errorf("Shed %d health and navigation messages while raw data was available to shed", shed)
+	}
+	lidar := stats.Streams["lidar"]
+	if lidar.Sent == 0 || lidar.Shed == 0 {
+		t.Errorf("Raw data should be downsampled, not stopped or passed: %+v", lidar)
+	}
+	sent := lidar.SentBytes + stats.Streams["navigation"].SentBytes + stats.Streams["health"].SentBytes
+	if sent > 10*1000+2000 {
+		t.Errorf("Sent %d bytes, over the 10 s budget plus a burst", sent)
+	}
+}
+
+func TestWaitPacesInsteadOfShedding(t *testing.T) {
+	g, c := newTestGovernor(t, DefaultConfig(1000))
+	if delay := g.take("replay", outbox.PriorityRawSensor, 1000); delay != 0 {
+		t.Fatalf("Expected the first message to fit, got a %v delay", delay)
+	}
+	// 1000 bytes left against an 800 byte reserve: 800 ms until another 1000 fit
+	delay := g.take("replay", outbox.PriorityRawSensor, 1000)
+	if delay != 800*time.Millisecond {
+		t.Errorf("Expected an 800ms delay, got %v", delay)
+	}
+	c.advance(delay)
+	if delay := g.take("replay", outbox.PriorityRawSensor, 1000); delay != 0 {
+		t.Errorf("Expected the message to fit after waiting, got a %v delay", delay)
+	}
+	// A message larger than the bucket goes out once the bucket is full
+	if delay := g.take("replay", outbox.PriorityRawSensor, 5000); delay != 1200*time.Millisecond {
+		t.Errorf("Expected to wait for a full bucket, got %v", delay)
+	}
+	c.advance(1200 * time.Millisecond)
+	if delay := g.take("replay", outbox.PriorityRawSensor, 5000); delay != 0 {
+		t.Errorf("Expected the large message to go out, got a %v delay", delay)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := g.Wait(ctx, "replay", outbox.PriorityRawSensor, 100); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Wait to give up with its context, got %v", err)
+	}
+	if st := g.Stats().Streams["replay"]; st.Sent != 3 || st.Shed != 0 || st.SentBytes != 7000 {
+		t.Errorf("Unexpected replay stats %+v", st)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, config := range map[string]Config{
+		"no rate":     {Burst: 100},
+		"no burst":    {BytesPerSecond: 100},
+		"all reserve": {BytesPerSecond: 100, Burst: 100, Reserve: 0.25, Protected: outbox.PriorityAlert},
+	} {
+		if _, err := New(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected ErrInvalidConfig, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// ErrNoGPSFix is returned by a GPS sensor that has not yet received a valid fix
+var ErrNoGPSFix = errors.New("no gps fix")
+
+// GPSData represents a GPS fix assembled from NMEA sentences
+type GPSData struct {
+	Latitude   float64    `json:"latitude"`
+	Longitude  float64    `json:"longitude"`
+	Altitude   float64    `json:"altitude"`  // Meters above mean sea level
+	GeoidSep   float64    `json:"geoid_sep"` // Geoid height above the WGS84 ellipsoid
+	FixQuality FixQuality `json:"fix_quality"`
+	FixType    int        `json:"fix_type"` // 1 no fix, 2 2D, 3 3D
+	Satellites int        `json:"satellites"`
+	HDOP       float64    `json:"hdop"`
+	PDOP       float64    `json:"pdop"`
+	VDOP       float64    `json:"vdop"`
+	SpeedMPS   float64    `json:"speed_mps"`
+	CourseDeg  float64    `json:"course_deg"`
+	FixTime    time.Time  `json:"fix_time"`
+}
+
+// Point returns the fix as a geodetic coordinate with ellipsoidal altitude
+func (d GPSData) Point() GeoPoint {
+	return GeoPoint{
+		Latitude:  d.Latitude,
+		Longitude: d.Longitude,
+		Altitude:  d.Altitude + d.GeoidSep,
+	}
+}
+
+// HasFix reports whether the data describes a usable position
+func (d GPSData) HasFix() bool {
+	return d.FixQuality != FixInvalid
+}
+
+// GPSSensor reads NMEA 0183 sentences from a receiver, typically a serial port
+type GPSSensor struct {
+	id       string
+	reader   io.Reader
+	log      *logger.Logger
+	mu       sync.RWMutex
+	fix      GPSData
+	updated  time.Time // When a sentence last carried the position
+	seenGGA  bool      // GGA reports the fix quality; RMC stands in until one arrives
+	parseErr uint64
+	done     chan struct{}
+}
+
+// NewGPSSensor creates a GPS sensor reading sentences from r
+func NewGPSSensor(id string, r io.Reader) *GPSSensor {
+	return &GPSSensor{
+		id:     id,
+		reader: r,
+		log:    logger.New(logger.INFO),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *GPSSensor) ID() string {
+	return s.id
+}
+
+// Initialize starts consuming sentences in the background
+func (s *GPSSensor) Initialize() error {
+	if s.reader == nil {
+		return fmt.Errorf("gps sensor %s has no reader", s.id)
+	}
+	go s.readLoop()
+	return nil
+}
+
+func (s *GPSSensor) Read(ctx context.Context) (SensorData, error) {
+	s.mu.RLock()
+	fix, updated := s.fix, s.updated
+	s.mu.RUnlock()
+
+	if updated.IsZero() || !fix.HasFix() {
+		return SensorData{}, fmt.Errorf("%w: %s", ErrNoGPSFix, s.id)
+	}
+	return SensorData{
+		Timestamp: updated,
+		SensorID:  s.id,
+		DataType:  "gps",
+		Value:     fix,
+	}, nil
+}
+
+// Shutdown closes the reader when it supports closing
+func (s *GPSSensor) Shutdown() error {
+	if closer, ok := s.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Done is closed when the sentence stream ends
+func (s *GPSSensor) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *GPSSensor) readLoop() {
+	defer close(s.done)
+	scanner := bufio.NewScanner(s.reader)
+
+	for scanner.Scan() {
+		sentence, err := ParseNMEA(scanner.Text())
+		if err != nil {
+			if !errors.Is(err, ErrNMEAUnsupported) {
+				s.mu.Lock()
+				s.parseErr++
+				s.mu.Unlock()
+				s.log.Debug("GPS %s: %v", s.id, err)
+			}
+			continue
+		}
+		s.apply(sentence)
+	}
+	if err := scanner.Err(); err != nil {
+		s.log.Warn("GPS %s stopped reading: %v", s.id, err)
+	}
+}
+
+// apply merges a parsed sentence into the current fix
+func (s *GPSSensor) apply(sentence interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m := sentence.(type) {
+	case GGASentence:
+		s.fix.Latitude = m.Latitude
+		s.fix.Longitude = m.Longitude
+		s.fix.Altitude = m.Altitude
+		s.fix.GeoidSep = m.GeoidSep
+		s.fix.FixQuality = m.Quality
+		s.fix.Satellites = m.Satellites
+		s.fix.HDOP = m.HDOP
+		s.seenGGA = true
+		s.updated = time.Now()
+	case RMCSentence:
+		if !m.Valid {
+			s.fix.FixQuality = FixInvalid
+		} else {
+			s.fix.Latitude = m.Latitude
+			s.fix.Longitude = m.Longitude
+			if !s.seenGGA {
+				s.fix.FixQuality = FixGPS
+			}
+			s.updated = time.Now()
+		}
+		s.fix.SpeedMPS = m.SpeedMPS
+		s.fix.CourseDeg = m.CourseDeg
+		if !m.Time.IsZero() {
+			s.fix.FixTime = m.Time
+		}
+	case VTGSentence:
+		s.fix.SpeedMPS = m.SpeedMPS
+		s.fix.CourseDeg = m.CourseDeg
+	case GSASentence:
+		s.fix.FixType = m.FixType
+		s.fix.PDOP = m.PDOP
+		s.fix.HDOP = m.HDOP
+		s.fix.VDOP = m.VDOP
+	}
+}
+
+// ParseErrors returns the number of sentences rejected for checksum or format errors
+func (s *GPSSensor) ParseErrors() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.parseErr
+}
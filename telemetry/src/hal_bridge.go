@@ -0,0 +1,453 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// ErrHALNoReading is returned by HAL sensors before the HAL has reported a value
+var ErrHALNoReading = errors.New("no reading received from hal")
+
+// HALBridge connects the telemetry process to robot_hal over a Unix domain socket.
+// Readings pushed by the HAL are cached and exposed as Sensors, and motor commands
+// are sent back as framed requests that the HAL acknowledges with a result frame.
+type HALBridge struct {
+	conn     io.ReadWriteCloser
+	log      *logger.Logger
+	seq      uint32
+	writeMu  sync.Mutex
+	mu       sync.RWMutex
+	motors   map[string]HALMotorStateMessage
+	readings map[string]HALSensorReadingMessage
+	faults   map[string]*HardwareError
+	lastErr  *HardwareError
+	pending  map[uint32]chan HALCommandResultMessage
+	peer     HALHelloMessage
+	started  atomic.Bool // Set once the read loop runs, which then closes done
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// DialHAL connects to the HAL socket and performs the protocol handshake
+func DialHAL(ctx context.Context, socketPath string) (*HALBridge, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to hal at %s: %w", socketPath, err)
+	}
+
+	b := NewHALBridge(conn)
+	if err := b.Start(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewHALBridge creates a bridge over an established connection; call Start before use
+func NewHALBridge(conn io.ReadWriteCloser) *HALBridge {
+	return &HALBridge{
+		conn:     conn,
+		log:      logger.New(logger.INFO),
+		motors:   make(map[string]HALMotorStateMessage),
+		readings: make(map[string]HALSensorReadingMessage),
+		faults:   make(map[string]*HardwareError),
+		pending:  make(map[uint32]chan HALCommandResultMessage),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start exchanges hello frames with the HAL and begins processing incoming frames
+func (b *HALBridge) Start(ctx context.Context) error {
+	hello := HALHelloMessage{
+		Version:      HALProtocolVersion,
+		Role:         "telemetry",
+		Capabilities: []string{"motor_command"},
+	}
+	if err := b.write(HALHello, b.nextSeq(), hello); err != nil {
+		return fmt.Errorf("sending hal hello: %w", err)
+	}
+
+	type result struct {
+		frame HALFrame
+		err   error
+	}
+	reply := make(chan result, 1)
+	go func() {
+		frame, err := ReadHALFrame(b.conn)
+		reply <- result{frame, err}
+	}()
+
+	var r result
+	select {
+	case <-ctx.Done():
+		b.conn.Close()
+		return ctx.Err()
+	case r = <-reply:
+	}
+	if r.err != nil {
+		return fmt.Errorf("reading hal hello: %w", r.err)
+	}
+	if r.frame.Type != HALHello {
+		return fmt.Errorf("expected hal hello, got message type 0x%02x", r.frame.Type)
+	}
+	if err := r.frame.Decode(&b.peer); err != nil {
+		return fmt.Errorf("decoding hal hello: %w", err)
+	}
+	if b.peer.Version != HALProtocolVersion {
+		return fmt.Errorf("%w: peer speaks %d", ErrHALVersion, b.peer.Version)
+	}
+
+	b.log.Info("Connected to hal (protocol v%d, capabilities %v)", b.peer.Version, b.peer.Capabilities)
+	b.started.Store(true)
+	go b.readLoop()
+	return nil
+}
+
+// Peer returns the hello message sent by the HAL
+func (b *HALBridge) Peer() HALHelloMessage {
+	return b.peer
+}
+
+// Done is closed when the bridge connection ends
+func (b *HALBridge) Done() <-chan struct{} {
+	return b.done
+}
+
+// Close shuts down the connection to the HAL. It may be called without a successful
+// Start.
+func (b *HALBridge) Close() error {
+	err := b.conn.Close()
+	if b.started.Load() {
+		<-b.done
+	} else {
+		b.finish()
+	}
+	return err
+}
+
+// finish marks the bridge disconnected
+func (b *HALBridge) finish() {
+	b.doneOnce.Do(func() { close(b.done) })
+}
+
+// LastError returns the most recent HardwareError reported by the HAL
+func (b *HALBridge) LastError() *HardwareError {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastErr
+}
+
+func (b *HALBridge) nextSeq() uint32 {
+	return atomic.AddUint32(&b.seq, 1)
+}
+
+func (b *HALBridge) write(msgType HALMessageType, seq uint32, payload interface{}) error {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	return WriteHALFrame(b.conn, msgType, seq, payload)
+}
+
+func (b *HALBridge) readLoop() {
+	defer func() {
+		b.mu.Lock()
+		for seq, ch := range b.pending {
+			close(ch)
+			delete(b.pending, seq)
+		}
+		b.mu.Unlock()
+		b.finish()
+	}()
+
+	for {
+		frame, err := ReadHALFrame(b.conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				b.log.Error("HAL bridge read failed: %v", err)
+			}
+			return
+		}
+		if err := b.handleFrame(frame); err != nil {
+			b.log.Warn("Dropping malformed hal frame 0x%02x: %v", frame.Type, err)
+		}
+	}
+}
+
+func (b *HALBridge) handleFrame(frame HALFrame) error {
+	switch frame.Type {
+	case HALMotorState:
+		var msg HALMotorStateMessage
+		if err := frame.Decode(&msg); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.motors[msg.MotorID] = msg
+		b.mu.Unlock()
+
+	case HALSensorReading:
+		var msg HALSensorReadingMessage
+		if err := frame.Decode(&msg); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		b.readings[msg.SensorID] = msg
+		b.mu.Unlock()
+
+	case HALErrorReport:
+		var msg HALErrorMessage
+		if err := frame.Decode(&msg); err != nil {
+			return err
+		}
+		hwErr := msg.toError()
+		b.log.Error("HAL reported %v (component %q)", hwErr, msg.Component)
+		b.mu.Lock()
+		b.lastErr = hwErr
+		if msg.Component != "" {
+			b.faults[msg.Component] = hwErr
+		}
+		b.mu.Unlock()
+
+	case HALCommandResult:
+		var msg HALCommandResultMessage
+		if err := frame.Decode(&msg); err != nil {
+			return err
+		}
+		b.mu.Lock()
+		ch, ok := b.pending[msg.Seq]
+		delete(b.pending, msg.Seq)
+		b.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+
+	case HALHello:
+		// A repeated hello carries nothing new once the session is established
+
+	default:
+		return fmt.Errorf("unknown message type")
+	}
+	return nil
+}
+
+// takeFault returns and clears the pending fault for a component
+func (b *HALBridge) takeFault(component string) *HardwareError {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fault, ok := b.faults[component]
+	if ok {
+		delete(b.faults, component)
+	}
+	return fault
+}
+
+func (b *HALBridge) isClosed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetMotorSpeed sets a motor's speed between 0.0 and 1.0
+func (b *HALBridge) SetMotorSpeed(ctx context.Context, motorID string, speed float64) error {
+	if speed < 0.0 || speed > 1.0 {
+		return fmt.Errorf("speed must be between 0.0 and 1.0, got %v", speed)
+	}
+	return b.sendCommand(ctx, HALMotorCommandMessage{MotorID: motorID, Command: "set_speed", Speed: speed})
+}
+
+// SetMotorDirection sets a motor's direction: 1 forward, -1 backward, 0 stopped
+func (b *HALBridge) SetMotorDirection(ctx context.Context, motorID string, direction int) error {
+	dir, err := halDirection(direction)
+	if err != nil {
+		return err
+	}
+	return b.sendCommand(ctx, HALMotorCommandMessage{MotorID: motorID, Command: "set_direction", Direction: dir})
+}
+
+// StopMotor stops a motor
+func (b *HALBridge) StopMotor(ctx context.Context, motorID string) error {
+	return b.sendCommand(ctx, HALMotorCommandMessage{MotorID: motorID, Command: "stop"})
+}
+
+func (b *HALBridge) sendCommand(ctx context.Context, cmd HALMotorCommandMessage) error {
+	if b.isClosed() {
+		return ErrHALDisconnect
+	}
+
+	seq := b.nextSeq()
+	result := make(chan HALCommandResultMessage, 1)
+	b.mu.Lock()
+	b.pending[seq] = result
+	b.mu.Unlock()
+
+	if err := b.write(HALMotorCommand, seq, cmd); err != nil {
+		b.mu.Lock()
+		delete(b.pending, seq)
+		b.mu.Unlock()
+		return fmt.Errorf("sending %s to motor %s: %w", cmd.Command, cmd.MotorID, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		b.mu.Lock()
+		delete(b.pending, seq)
+		b.mu.Unlock()
+		return ctx.Err()
+	case <-b.done:
+		return ErrHALDisconnect
+	case res, ok := <-result:
+		if !ok {
+			return ErrHALDisconnect
+		}
+		if !res.OK {
+			if res.Error != nil {
+				return res.Error.toError()
+			}
+			return fmt.Errorf("hal rejected %s for motor %s", cmd.Command, cmd.MotorID)
+		}
+		return nil
+	}
+}
+
+func halDirection(direction int) (string, error) {
+	switch direction {
+	case 1:
+		return "forward", nil
+	case -1:
+		return "backward", nil
+	case 0:
+		return "stop", nil
+	}
+	return "", fmt.Errorf("invalid motor direction %d", direction)
+}
+
+func motorDirection(direction string) int {
+	switch direction {
+	case "forward":
+		return 1
+	case "backward":
+		return -1
+	}
+	return 0
+}
+
+// MotorSensor returns a Sensor reporting the latest MotorData of a HAL motor
+func (b *HALBridge) MotorSensor(motorID string) Sensor {
+	return &HALMotorSensor{bridge: b, motorID: motorID}
+}
+
+// Sensor returns a Sensor reporting the latest readings of a HAL sensor
+func (b *HALBridge) Sensor(sensorID string) Sensor {
+	return &HALSensor{bridge: b, sensorID: sensorID}
+}
+
+// HALMotorSensor exposes a HAL motor's reported state as MotorData
+type HALMotorSensor struct {
+	bridge  *HALBridge
+	motorID string
+}
+
+func (s *HALMotorSensor) ID() string {
+	return s.motorID
+}
+
+func (s *HALMotorSensor) Read(ctx context.Context) (SensorData, error) {
+	// Cached state goes stale once the HAL is gone
+	if s.bridge.isClosed() {
+		return SensorData{}, ErrHALDisconnect
+	}
+	if fault := s.bridge.takeFault(s.motorID); fault != nil {
+		return SensorData{}, fault
+	}
+
+	s.bridge.mu.RLock()
+	state, ok := s.bridge.motors[s.motorID]
+	s.bridge.mu.RUnlock()
+	if !ok {
+		return SensorData{}, fmt.Errorf("%w: motor %s", ErrHALNoReading, s.motorID)
+	}
+
+	return SensorData{
+		Timestamp: halTimestamp(state.Timestamp),
+		SensorID:  s.motorID,
+		DataType:  "motor",
+		Value: MotorData{
+			Speed:     state.Speed,
+			Direction: motorDirection(state.Direction),
+			Current:   state.Current,
+		},
+	}, nil
+}
+
+func (s *HALMotorSensor) Initialize() error {
+	if s.bridge.isClosed() {
+		return ErrHALDisconnect
+	}
+	return nil
+}
+
+func (s *HALMotorSensor) Shutdown() error {
+	return nil
+}
+
+// HALSensor exposes readings of a HAL sensor
+type HALSensor struct {
+	bridge   *HALBridge
+	sensorID string
+}
+
+func (s *HALSensor) ID() string {
+	return s.sensorID
+}
+
+func (s *HALSensor) Read(ctx context.Context) (SensorData, error) {
+	if s.bridge.isClosed() {
+		return SensorData{}, ErrHALDisconnect
+	}
+	if fault := s.bridge.takeFault(s.sensorID); fault != nil {
+		return SensorData{}, fault
+	}
+
+	s.bridge.mu.RLock()
+	reading, ok := s.bridge.readings[s.sensorID]
+	s.bridge.mu.RUnlock()
+	if !ok {
+		return SensorData{}, fmt.Errorf("%w: sensor %s", ErrHALNoReading, s.sensorID)
+	}
+
+	return SensorData{
+		Timestamp: halTimestamp(reading.Timestamp),
+		SensorID:  s.sensorID,
+		DataType:  reading.DataType,
+		Value:     reading.Value,
+	}, nil
+}
+
+func (s *HALSensor) Initialize() error {
+	if s.bridge.isClosed() {
+		return ErrHALDisconnect
+	}
+	return nil
+}
+
+func (s *HALSensor) Shutdown() error {
+	return nil
+}
+
+// halTimestamp falls back to the local clock when the HAL omits a timestamp
+func halTimestamp(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
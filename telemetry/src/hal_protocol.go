@@ -0,0 +1,180 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// HAL bridge wire protocol. Every frame is a fixed 12 byte big-endian header followed by
+// a JSON payload:
+//
+//	magic   [2]byte  "RH"
+//	version uint8    HALProtocolVersion
+//	type    uint8    HALMessageType
+//	seq     uint32   sender sequence number, echoed in command results
+//	length  uint32   payload length in bytes
+const (
+	HALProtocolVersion = 1
+	halHeaderSize      = 12
+	halMaxPayload      = 64 * 1024
+)
+
+var halMagic = [2]byte{'R', 'H'}
+
+var (
+	ErrHALBadMagic   = errors.New("hal frame has bad magic")
+	ErrHALVersion    = errors.New("unsupported hal protocol version")
+	ErrHALFrameSize  = errors.New("hal frame payload too large")
+	ErrHALDisconnect = errors.New("hal bridge disconnected")
+)
+
+// HALMessageType identifies the payload carried by a frame
+type HALMessageType uint8
+
+const (
+	HALHello         HALMessageType = 0x01
+	HALMotorState    HALMessageType = 0x10
+	HALSensorReading HALMessageType = 0x11
+	HALErrorReport   HALMessageType = 0x12
+	HALMotorCommand  HALMessageType = 0x20
+	HALCommandResult HALMessageType = 0x21
+)
+
+// HALFrame is a single decoded protocol frame
+type HALFrame struct {
+	Version uint8
+	Type    HALMessageType
+	Seq     uint32
+	Payload []byte
+}
+
+// HALHelloMessage is exchanged by both peers when the connection opens
+type HALHelloMessage struct {
+	Version      int      `json:"version"`
+	Role         string   `json:"role"` // "telemetry" or "hal"
+	Capabilities []string `json:"capabilities,omitempty"`
+}
+
+// HALMotorStateMessage reports the state of a motor, mirroring robot_hal's Motor trait
+type HALMotorStateMessage struct {
+	MotorID   string    `json:"motor_id"`
+	Speed     float64   `json:"speed"`     // 0.0 to 1.0
+	Direction string    `json:"direction"` // "forward", "backward" or "stop"
+	Current   float64   `json:"current"`   // Current draw in amps
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HALSensorReadingMessage reports a reading from a robot_hal Sensor
+type HALSensorReadingMessage struct {
+	SensorID  string    `json:"sensor_id"`
+	DataType  string    `json:"data_type"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HALErrorMessage carries a robot_hal HardwareError
+type HALErrorMessage struct {
+	Kind      string    `json:"kind"` // "gpio", "sensor" or "motor"
+	Component string    `json:"component,omitempty"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// HALMotorCommandMessage asks the HAL to change a motor's state
+type HALMotorCommandMessage struct {
+	MotorID   string  `json:"motor_id"`
+	Command   string  `json:"command"` // "set_speed", "set_direction" or "stop"
+	Speed     float64 `json:"speed,omitempty"`
+	Direction string  `json:"direction,omitempty"`
+}
+
+// HALCommandResultMessage answers a motor command; Seq is the command frame's sequence number
+type HALCommandResultMessage struct {
+	Seq   uint32           `json:"seq"`
+	OK    bool             `json:"ok"`
+	Error *HALErrorMessage `json:"error,omitempty"`
+}
+
+// HardwareError is the Go side representation of robot_hal's HardwareError
+type HardwareError struct {
+	Kind      string
+	Component string
+	Message   string
+}
+
+func (e *HardwareError) Error() string {
+	switch e.Kind {
+	case "gpio":
+		return "GPIO Error: " + e.Message
+	case "sensor":
+		return "Sensor Error: " + e.Message
+	case "motor":
+		return "Motor Error: " + e.Message
+	}
+	return e.Kind + " Error: " + e.Message
+}
+
+func (m *HALErrorMessage) toError() *HardwareError {
+	return &HardwareError{Kind: m.Kind, Component: m.Component, Message: m.Message}
+}
+
+// WriteHALFrame encodes payload as JSON and writes it as a single frame
+func WriteHALFrame(w io.Writer, msgType HALMessageType, seq uint32, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if len(body) > halMaxPayload {
+		return ErrHALFrameSize
+	}
+
+	buf := make([]byte, halHeaderSize+len(body))
+	copy(buf[0:2], halMagic[:])
+	buf[2] = HALProtocolVersion
+	buf[3] = byte(msgType)
+	binary.BigEndian.PutUint32(buf[4:8], seq)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(body)))
+	copy(buf[halHeaderSize:], body)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadHALFrame reads and validates the next frame
+func ReadHALFrame(r io.Reader) (HALFrame, error) {
+	var header [halHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return HALFrame{}, err
+	}
+	if header[0] != halMagic[0] || header[1] != halMagic[1] {
+		return HALFrame{}, ErrHALBadMagic
+	}
+	if header[2] != HALProtocolVersion {
+		return HALFrame{}, fmt.Errorf("%w: %d", ErrHALVersion, header[2])
+	}
+
+	length := binary.BigEndian.Uint32(header[8:12])
+	if length > halMaxPayload {
+		return HALFrame{}, ErrHALFrameSize
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return HALFrame{}, err
+	}
+
+	return HALFrame{
+		Version: header[2],
+		Type:    HALMessageType(header[3]),
+		Seq:     binary.BigEndian.Uint32(header[4:8]),
+		Payload: payload,
+	}, nil
+}
+
+// Decode unmarshals the frame payload into v
+func (f HALFrame) Decode(v interface{}) error {
+	return json.Unmarshal(f.Payload, v)
+}
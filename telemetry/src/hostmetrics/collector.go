@@ -0,0 +1,326 @@
+package hostmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"telemetry/include/logger"
+	"telemetry/src/simulation"
+)
+
+// Raspberry Pi firmware throttling flags reported by get_throttled
+const (
+	ThrottleUnderVoltage  = 1 << 0
+	ThrottleFreqCapped    = 1 << 1
+	ThrottleActive        = 1 << 2
+	ThrottleSoftTempLimit = 1 << 3
+)
+
+// Metrics is a snapshot of host health
+type Metrics struct {
+	Timestamp       time.Time
+	CPUTemp         float64            // Hottest thermal zone in degrees Celsius
+	ThermalZones    map[string]float64 // Zone type to degrees Celsius
+	CPUUsage        float64            // Percent busy since the previous collection
+	MemoryUsage     float64            // Percent of memory not available
+	DiskUsage       float64            // Percent of DiskPath used
+	Throttled       bool
+	ThrottleFlags   uint32
+	Uptime          time.Duration
+	WiFiInterface   string
+	WiFiLinkQuality float64
+	WiFiSignalDBm   float64
+}
+
+type cpuSample struct {
+	idle  uint64
+	total uint64
+}
+
+// Collector reads host health from /proc and /sys. Root is prepended to every path so
+// tests can point the collector at a fixture tree.
+type Collector struct {
+	Root     string
+	DiskPath string
+	log      *logger.Logger
+	statfs   func(path string) (total, free uint64, err error)
+	mu       sync.Mutex
+	prevCPU  *cpuSample
+}
+
+// NewCollector creates a collector reading below root, "/" for the live system
+func NewCollector(root string) *Collector {
+	return &Collector{
+		Root:     root,
+		DiskPath: "/",
+		log:      logger.New(logger.INFO),
+		statfs:   statfs,
+	}
+}
+
+func (c *Collector) path(parts ...string) string {
+	return filepath.Join(append([]string{c.Root}, parts...)...)
+}
+
+// Collect reads all metrics. Sources missing on this host are left zero; an error is
+// only returned when no source could be read at all.
+func (c *Collector) Collect() (Metrics, error) {
+	m := Metrics{Timestamp: time.Now()}
+	var errs []string
+	note := func(what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", what, err))
+		}
+	}
+
+	note("thermal", c.readThermal(&m))
+	note("cpu", c.readCPU(&m))
+	note("memory", c.readMemory(&m))
+	note("disk", c.readDisk(&m))
+	note("throttling", c.readThrottling(&m))
+	note("uptime", c.readUptime(&m))
+	note("wireless", c.readWireless(&m))
+
+	if len(errs) == 7 {
+		return m, fmt.Errorf("no host metrics available: %s", strings.Join(errs, "; "))
+	}
+	for _, e := range errs {
+		c.log.Debug("Host metric unavailable: %s", e)
+	}
+	return m, nil
+}
+
+// FillHealth implements simulation.HealthSource
+func (c *Collector) FillHealth(msg *simulation.HealthMessage) error {
+	m, err := c.Collect()
+	if err != nil {
+		return err
+	}
+	m.Apply(msg)
+	return nil
+}
+
+// Apply copies the metrics into a health message
+func (m Metrics) Apply(msg *simulation.HealthMessage) {
+	msg.CPUTemp = m.CPUTemp
+	msg.CPUUsage = m.CPUUsage
+	msg.MemoryUsage = m.MemoryUsage
+	msg.DiskUsage = m.DiskUsage
+	msg.Throttled = m.Throttled
+	msg.ThrottleFlags = m.ThrottleFlags
+	msg.UptimeSeconds = m.Uptime.Seconds()
+	msg.WiFiLinkQuality = m.WiFiLinkQuality
+	msg.WiFiSignalDBm = m.WiFiSignalDBm
+}
+
+func (c *Collector) readThermal(m *Metrics) error {
+	zones, err := filepath.Glob(c.path("sys/class/thermal/thermal_zone*"))
+	if err != nil {
+		return err
+	}
+	if len(zones) == 0 {
+		return os.ErrNotExist
+	}
+	sort.Strings(zones)
+
+	m.ThermalZones = make(map[string]float64)
+	for _, zone := range zones {
+		raw, err := os.ReadFile(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+		milli, err := strconv.ParseFloat(strings.TrimSpace(string(raw)), 64)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(zone)
+		if kind, err := os.ReadFile(filepath.Join(zone, "type")); err == nil {
+			name = strings.TrimSpace(string(kind))
+		}
+		celsius := milli / 1000
+		m.ThermalZones[name] = celsius
+		if len(m.ThermalZones) == 1 || celsius > m.CPUTemp {
+			m.CPUTemp = celsius
+		}
+	}
+	if len(m.ThermalZones) == 0 {
+		return fmt.Errorf("no readable thermal zones")
+	}
+	return nil
+}
+
+func (c *Collector) readCPU(m *Metrics) error {
+	f, err := os.Open(c.path("proc/stat"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return fmt.Errorf("empty /proc/stat")
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return fmt.Errorf("unexpected /proc/stat line %q", scanner.Text())
+	}
+
+	var sample cpuSample
+	for i, field := range fields[1:] {
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad /proc/stat field %q", field)
+		}
+		sample.total += v
+		// idle and iowait
+		if i == 3 || i == 4 {
+			sample.idle += v
+		}
+	}
+
+	c.mu.Lock()
+	prev := c.prevCPU
+	c.prevCPU = &sample
+	c.mu.Unlock()
+
+	// Utilization is a rate, so the first collection reports the average since boot
+	if prev == nil || sample.total <= prev.total {
+		prev = &cpuSample{}
+	}
+	total := sample.total - prev.total
+	if total > 0 {
+		m.CPUUsage = 100 * float64(total-(sample.idle-prev.idle)) / float64(total)
+	}
+	return nil
+}
+
+func (c *Collector) readMemory(m *Metrics) error {
+	f, err := os.Open(c.path("proc/meminfo"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if v, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			values[key] = v
+		}
+	}
+
+	total := values["MemTotal"]
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 lack MemAvailable
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	if total <= 0 {
+		return fmt.Errorf("MemTotal missing from /proc/meminfo")
+	}
+	m.MemoryUsage = 100 * (total - available) / total
+	return nil
+}
+
+func (c *Collector) readDisk(m *Metrics) error {
+	total, free, err := c.statfs(c.path(c.DiskPath))
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return fmt.Errorf("filesystem at %s reports no blocks", c.DiskPath)
+	}
+	m.DiskUsage = 100 * float64(total-free) / float64(total)
+	return nil
+}
+
+func (c *Collector) readThrottling(m *Metrics) error {
+	raw, err := os.ReadFile(c.path("sys/devices/platform/soc/soc:firmware/get_throttled"))
+	if err != nil {
+		return err
+	}
+	flags, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(raw)), "0x"), 16, 32)
+	if err != nil {
+		return fmt.Errorf("bad get_throttled value %q", raw)
+	}
+	m.ThrottleFlags = uint32(flags)
+	// The low bits are current conditions; the high bits record past occurrences
+	m.Throttled = flags&(ThrottleUnderVoltage|ThrottleFreqCapped|ThrottleActive|ThrottleSoftTempLimit) != 0
+	return nil
+}
+
+func (c *Collector) readUptime(m *Metrics) error {
+	raw, err := os.ReadFile(c.path("proc/uptime"))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(raw))
+	if len(fields) == 0 {
+		return fmt.Errorf("empty /proc/uptime")
+	}
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return fmt.Errorf("bad /proc/uptime value %q", fields[0])
+	}
+	m.Uptime = time.Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// readWireless reports the first interface listed in /proc/net/wireless
+func (c *Collector) readWireless(m *Metrics) error {
+	f, err := os.Open(c.path("proc/net/wireless"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		// Two header lines precede the interfaces
+		if line < 2 {
+			continue
+		}
+		iface, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 3 {
+			continue
+		}
+		link, err1 := strconv.ParseFloat(strings.TrimSuffix(fields[1], "."), 64)
+		level, err2 := strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err1 != nil || err2 != nil {
+			return fmt.Errorf("bad /proc/net/wireless line %q", scanner.Text())
+		}
+		m.WiFiInterface = strings.TrimSpace(iface)
+		m.WiFiLinkQuality = link
+		m.WiFiSignalDBm = level
+		return nil
+	}
+	return fmt.Errorf("no wireless interfaces")
+}
+
+func statfs(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
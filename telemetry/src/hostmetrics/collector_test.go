@@ -0,0 +1,104 @@
+package hostmetrics
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"telemetry/src/simulation"
+)
+
+func writeFixture(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollector(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{
+		"proc/stat":    "cpu  100 0 100 700 100 0 0 0 0 0\ncpu0 100 0 100 700 100 0 0 0 0 0\n",
+		"proc/meminfo": "MemTotal:        1000000 kB\nMemFree:          100000 kB\nMemAvailable:     250000 kB\n",
+		"proc/uptime":  "3600.50 7000.00\n",
+		"proc/net/wireless": "Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE\n" +
+			" face | tus | link level noise |  nwid  crypt   frag  retry   misc | beacon | 22\n" +
+			" wlan0: 0000   54.  -56.  -256        0      0      0      0      0        0\n",
+		"sys/class/thermal/thermal_zone0/type":                "cpu-thermal\n",
+		"sys/class/thermal/thermal_zone0/temp":                "51540\n",
+		"sys/class/thermal/thermal_zone1/type":                "gpu-thermal\n",
+		"sys/class/thermal/thermal_zone1/temp":                "48000\n",
+		"sys/devices/platform/soc/soc:firmware/get_throttled": "0x50005\n",
+	})
+
+	c := NewCollector(root)
+	c.statfs = func(path string) (uint64, uint64, error) {
+		if path != filepath.Join(root, "/") {
+			t.Errorf("Unexpected statfs path %s", path)
+		}
+		return 1000, 400, nil
+	}
+
+	m, err := c.Collect()
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+	if m.CPUTemp != 51.54 || m.ThermalZones["gpu-thermal"] != 48.0 {
+		t.Errorf("Unexpected thermal readings: %v %v", m.CPUTemp, m.ThermalZones)
+	}
+	if m.CPUUsage != 20 {
+		t.Errorf("Expected 20%% CPU since boot, got %v", m.CPUUsage)
+	}
+	if m.MemoryUsage != 75 || m.DiskUsage != 60 {
+		t.Errorf("Unexpected memory/disk usage: %v %v", m.MemoryUsage, m.DiskUsage)
+	}
+	if !m.Throttled || m.ThrottleFlags&ThrottleUnderVoltage == 0 || m.ThrottleFlags&ThrottleFreqCapped != 0 {
+		t.Errorf("Unexpected throttling: %v 0x%x", m.Throttled, m.ThrottleFlags)
+	}
+	if m.Uptime.Seconds() != 3600.5 {
+		t.Errorf("Unexpected uptime: %v", m.Uptime)
+	}
+	if m.WiFiInterface != "wlan0" || m.WiFiLinkQuality != 54 || m.WiFiSignalDBm != -56 {
+		t.Errorf("Unexpected wireless: %s %v %v", m.WiFiInterface, m.WiFiLinkQuality, m.WiFiSignalDBm)
+	}
+
+	// 100 more busy ticks and 300 idle ones since the last collection
+	writeFixture(t, root, map[string]string{
+		"proc/stat": "cpu  150 0 150 1000 100 0 0 0 0 0\n",
+	})
+	var msg simulation.HealthMessage
+	if err := c.FillHealth(&msg); err != nil {
+		t.Fatalf("FillHealth failed: %v", err)
+	}
+	if math.Abs(msg.CPUUsage-25) > 1e-9 || msg.CPUTemp != 51.54 || msg.UptimeSeconds != 3600.5 {
+		t.Errorf("Unexpected health message: %+v", msg)
+	}
+}
+
+func TestCollectorMissingSources(t *testing.T) {
+	root := t.TempDir()
+	writeFixture(t, root, map[string]string{"proc/uptime": "12.0 10.0\n"})
+
+	c := NewCollector(root)
+	c.statfs = func(string) (uint64, uint64, error) { return 0, 0, os.ErrNotExist }
+
+	m, err := c.Collect()
+	if err != nil {
+		t.Fatalf("Expected partial metrics, got %v", err)
+	}
+	if m.Uptime.Seconds() != 12 || m.CPUTemp != 0 || m.WiFiInterface != "" {
+		t.Errorf("Unexpected partial metrics: %+v", m)
+	}
+
+	empty := NewCollector(t.TempDir())
+	empty.statfs = c.statfs
+	if _, err := empty.Collect(); err == nil {
+		t.Error("Expected error when no sources exist")
+	}
+}
@@ -0,0 +1,272 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+	"telemetry/src/batch"
+	"telemetry/src/envelope"
+	"telemetry/src/simulation"
+)
+
+// ingestQoS matches the QoS robots publish telemetry with
+const ingestQoS = 1
+
+// ErrTopic is the dead-letter reason for messages on unexpected topics
+var ErrTopic = errors.New("unexpected telemetry topic")
+
+// Subscriber is the messaging the ingestor needs: any transport.Transport, or an
+// MQTTTelemetryClient
+type Subscriber interface {
+	Subscribe(topic string, qos byte, handler func(topic string, payload []byte)) error
+}
+
+// Record is a decoded telemetry message handed to sinks
+type Record struct {
+	RobotID    string
+	Type       string
+	Topic      string
+	Value      interface{} // *simulation.HeartbeatMessage etc., raw payload for other types
+	Envelope   *envelope.Message
+	ReceivedAt time.Time
+}
+
+// Sink consumes decoded records. Sinks are called concurrently and must be safe for
+// concurrent use.
+type Sink interface {
+	Name() string
+	Handle(rec Record) error
+}
+
+// DeadLetter is a message that could not be decoded
+type DeadLetter struct {
+	Topic      string
+	Payload    []byte
+	Reason     string
+	ReceivedAt time.Time
+}
+
+// DeadLetterSink receives messages the ingestor could not decode
+type DeadLetterSink interface {
+	DeadLetter(dl DeadLetter)
+}
+
+// RobotMetrics counts what the ingestor has seen from one robot
+type RobotMetrics struct {
+	Received     uint64
+	ByType       map[string]uint64
+	DeadLettered uint64
+	Missing      uint64 // Messages lost in sequence gaps
+	Duplicates   uint64
+	Restarts     uint64
+	SinkErrors   uint64
+	LastSeen     time.Time
+}
+
+// Ingestor subscribes to <prefix>/+/telemetry/+, decodes each message by its topic
+// suffix and routes it to the registered sinks
+type Ingestor struct {
+	prefix     string
+	subscriber Subscriber
+	decoder    *envelope.Decoder
+	log        *logger.Logger
+	now        func() time.Time
+	mu         sync.RWMutex
+	sinks      []Sink
+	deadLetter DeadLetterSink
+	metrics    map[string]*RobotMetrics
+	legacy     map[string]func() interface{}
+}
+
+// NewIngestor creates an ingestor for telemetry below prefix, "robots" by default
+func NewIngestor(subscriber Subscriber, prefix string) *Ingestor {
+	if prefix == "" {
+		prefix = "robots"
+	}
+	return &Ingestor{
+		prefix:     prefix,
+		subscriber: subscriber,
+		decoder:    envelope.NewDecoder(),
+		log:        logger.New(logger.INFO),
+		now:        time.Now,
+		deadLetter: NewDeadLetterQueue(1000),
+		metrics:    make(map[string]*RobotMetrics),
+		legacy: map[string]func() interface{}{
+			"heartbeat":  func() interface{} { return &simulation.HeartbeatMessage{} },
+			"health":     func() interface{} { return &simulation.HealthMessage{} },
+			"navigation": func() interface{} { return &simulation.NavigationMessage{} },
+		},
+	}
+}
+
+// AddSink registers a sink; every decoded record goes to every sink
+func (in *Ingestor) AddSink(sink Sink) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.sinks = append(in.sinks, sink)
+}
+
+// SetDeadLetterSink replaces the default in-memory dead-letter queue
+func (in *Ingestor) SetDeadLetterSink(sink DeadLetterSink) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.deadLetter = sink
+}
+
+// DeadLetters returns the dead-letter sink, a *DeadLetterQueue unless replaced
+func (in *Ingestor) DeadLetters() DeadLetterSink {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	return in.deadLetter
+}
+
+// Start subscribes to robot telemetry. Batch frames are unpacked transparently.
+func (in *Ingestor) Start() error {
+	return in.subscriber.Subscribe(in.prefix+"/+/telemetry/+", ingestQoS, batch.Handler(in.Handle))
+}
+
+// Handle decodes one message and routes it; it is exported so other transports can
+// feed the ingestor directly
+func (in *Ingestor) Handle(topic string, payload []byte) {
+	now := in.now()
+	robotID, messageType, ok := in.parseTopic(topic)
+	if !ok {
+		in.reject(topic, payload, robotID, ErrTopic, now)
+		return
+	}
+
+	rec, err := in.decode(topic, robotID, messageType, payload, now)
+	if err != nil {
+		in.reject(topic, payload, robotID, err, now)
+		return
+	}
+
+	in.mu.Lock()
+	m := in.robotMetrics(robotID)
+	m.Received++
+	m.ByType[messageType]++
+	m.LastSeen = now
+	duplicate := false
+	if rec.Envelope != nil {
+		switch rec.Envelope.Delivery {
+		case envelope.Gap:
+			m.Missing += rec.Envelope.Missing
+			in.log.Warn("Robot %s %s stream skipped %d messages", robotID, messageType, rec.Envelope.Missing)
+		case envelope.Duplicate:
+			m.Duplicates++
+			duplicate = true
+		case envelope.Restarted:
+			m.Restarts++
+		}
+	}
+	sinks := in.sinks
+	in.mu.Unlock()
+
+	if duplicate {
+		return
+	}
+	for _, sink := range sinks {
+		if err := sink.Handle(rec); err != nil {
+			in.log.Error("Sink %s failed for %s: %v", sink.Name(), topic, err)
+			in.mu.Lock()
+			in.robotMetrics(robotID).SinkErrors++
+			in.mu.Unlock()
+		}
+	}
+}
+
+func (in *Ingestor) parseTopic(topic string) (robotID, messageType string, ok bool) {
+	rest, found := strings.CutPrefix(topic, in.prefix+"/")
+	if !found {
+		return "", "", false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 || parts[1] != "telemetry" || parts[0] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// decode reads an envelope, or for robots that predate envelopes, a bare JSON message
+// of the type named by the topic suffix
+func (in *Ingestor) decode(topic, robotID, messageType string, payload []byte, now time.Time) (Record, error) {
+	rec := Record{RobotID: robotID, Type: messageType, Topic: topic, ReceivedAt: now}
+
+	env, err := envelope.Parse(payload)
+	if err == nil && env.Version == 0 && env.Type == "" {
+		newValue, ok := in.legacy[messageType]
+		if !ok {
+			return rec, fmt.Errorf("%w: %s message without an envelope", envelope.ErrInvalid, messageType)
+		}
+		value := newValue()
+		if err := json.Unmarshal(payload, value); err != nil {
+			return rec, fmt.Errorf("%w: %v", envelope.ErrInvalid, err)
+		}
+		rec.Value = value
+		return rec, nil
+	}
+	if err != nil {
+		return rec, err
+	}
+
+	if env.Type != messageType || env.RobotID != robotID {
+		return rec, fmt.Errorf("%w: envelope for %s/%s on %s", ErrTopic, env.RobotID, env.Type, topic)
+	}
+	msg, err := in.decoder.DecodeEnvelope(env)
+	if err != nil {
+		return rec, err
+	}
+	rec.Value = msg.Value
+	rec.Envelope = &msg
+	return rec, nil
+}
+
+func (in *Ingestor) reject(topic string, payload []byte, robotID string, reason error, now time.Time) {
+	in.mu.Lock()
+	if robotID != "" {
+		in.robotMetrics(robotID).DeadLettered++
+	}
+	deadLetter := in.deadLetter
+	in.mu.Unlock()
+
+	in.log.Warn("Dead-lettering message on %s: %v", topic, reason)
+	if deadLetter != nil {
+		deadLetter.DeadLetter(DeadLetter{
+			Topic:      topic,
+			Payload:    append([]byte(nil), payload...),
+			Reason:     reason.Error(),
+			ReceivedAt: now,
+		})
+	}
+}
+
+// robotMetrics returns the metrics for robotID; callers hold mu
+func (in *Ingestor) robotMetrics(robotID string) *RobotMetrics {
+	m, ok := in.metrics[robotID]
+	if !ok {
+		m = &RobotMetrics{ByType: make(map[string]uint64)}
+		in.metrics[robotID] = m
+	}
+	return m
+}
+
+// Metrics returns a copy of the per-robot metrics
+func (in *Ingestor) Metrics() map[string]RobotMetrics {
+	in.mu.RLock()
+	defer in.mu.RUnlock()
+	out := make(map[string]RobotMetrics, len(in.metrics))
+	for id, m := range in.metrics {
+		c := *m
+		c.ByType = make(map[string]uint64, len(m.ByType))
+		for t, n := range m.ByType {
+			c.ByType[t] = n
+		}
+		out[id] = c
+	}
+	return out
+}
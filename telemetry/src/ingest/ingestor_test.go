@@ -0,0 +1,158 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"telemetry/src/batch"
+	"telemetry/src/codec"
+	"telemetry/src/envelope"
+	"telemetry/src/simulation"
+	"telemetry/src/transport"
+)
+
+// fakeSubscriber records the subscription so the test can deliver messages
+type fakeSubscriber struct {
+	filter  string
+	handler func(topic string, payload []byte)
+}
+
+func (s *fakeSubscriber) Subscribe(topic string, qos byte, handler func(string, []byte)) error {
+	s.filter = topic
+	s.handler = handler
+	return nil
+}
+
+func TestIngestor(t *testing.T) {
+	sub := &fakeSubscriber{}
+	in := NewIngestor(sub, "")
+	fleet := NewFleetState()
+	var storage bytes.Buffer
+	var alerts []Alert
+	var alertsMu sync.Mutex
+	in.AddSink(fleet)
+	in.AddSink(NewJSONLinesSink(&storage))
+	in.AddSink(NewAlertSink(func(a Alert) {
+		alertsMu.Lock()
+		defer alertsMu.Unlock()
+		alerts = append(alerts, a)
+	}))
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if sub.filter != "robots/+/telemetry/+" {
+		t.Errorf("Unexpected subscription %q", sub.filter)
+	}
+
+	r1 := envelope.NewSequencer("r1", "boot")
+	send := func(topic, messageType string, c codec.Codec, v interface{}) {
+		data, err := r1.Marshal(messageType, c, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub.handler(topic, data)
+	}
+
+	send("robots/r1/telemetry/heartbeat", "heartbeat", nil, simulation.HeartbeatMessage{RobotID: "r1", Status: simulation.StatusWarning, BatteryPct: 10})
+	send("robots/r1/telemetry/navigation", "navigation", codec.Compact, simulation.NavigationMessage{RobotID: "r1", PathStatus: "BLOCKED"})
+
+	// Health arrives batched with a gap: seq 2 is never sent
+	h1, _ := r1.Marshal("health", nil, simulation.HealthMessage{RobotID: "r1", CPUTemp: 50})
+	r1.Marshal("health", nil, simulation.HealthMessage{})
+	h3, _ := r1.Marshal("health", nil, simulation.HealthMessage{RobotID: "r1", CPUTemp: 55, ErrorCodes: []string{"MOTOR_STALL"}})
+	frame, _ := batch.Pack([][]byte{h1, h3, h3}, 1)
+	sub.handler("robots/r1/telemetry/health", frame)
+
+	// A robot that predates envelopes
+	legacy, _ := json.Marshal(simulation.HeartbeatMessage{RobotID: "r2", Status: simulation.StatusOperational})
+	sub.handler("robots/r2/telemetry/heartbeat", legacy)
+
+	// Malformed payloads and envelopes on the wrong topic are dead-lettered; topics
+	// outside the telemetry layout are not attributed to a robot
+	sub.handler("robots/r2/telemetry/health", []byte("{not json"))
+	sub.handler("robots/r2/telemetry/health", h1)
+	sub.handler("robots/r2/status", []byte("{}"))
+
+	state, ok := fleet.Robot("r1")
+	if !ok || state.Heartbeat == nil || state.Navigation == nil || state.Health == nil {
+		t.Fatalf("Incomplete fleet state: %+v", state)
+	}
+	if state.Navigation.PathStatus != "BLOCKED" || state.Health.CPUTemp != 55 {
+		t.Errorf("Unexpected fleet state: %+v %+v", state.Navigation, state.Health)
+	}
+	if r2, ok := fleet.Robot("r2"); !ok || r2.Heartbeat.Status != simulation.StatusOperational {
+		t.Errorf("Legacy heartbeat not ingested: %+v", r2)
+	}
+
+	metrics := in.Metrics()
+	m1 := metrics["r1"]
+	if m1.Received != 5 || m1.ByType["health"] != 3 || m1.Missing != 1 || m1.Duplicates != 1 {
+		t.Errorf("Unexpected r1 metrics: %+v", m1)
+	}
+	if m2 := metrics["r2"]; m2.Received != 1 || m2.DeadLettered != 2 {
+		t.Errorf("Unexpected r2 metrics: %+v", m2)
+	}
+
+	letters, total := in.DeadLetters().(*DeadLetterQueue).Letters()
+	if total != 3 || !strings.Contains(letters[1].Reason, "envelope for r1/health") {
+		t.Errorf("Unexpected dead letters: %d %+v", total, letters)
+	}
+
+	if lines := strings.Count(storage.String(), "\n"); lines != 5 {
+		t.Errorf("Expected 5 stored records (duplicate dropped), got %d", lines)
+	}
+	alertsMu.Lock()
+	defer alertsMu.Unlock()
+	kinds := make(map[string]bool)
+	for _, a := range alerts {
+		kinds[a.Kind] = true
+	}
+	if len(alerts) != 3 || !kinds["status"] || !kinds["battery"] || !kinds["error_code"] {
+		t.Errorf("Unexpected alerts: %+v", alerts)
+	}
+}
+
+// TestSimulatedFleetOverMemoryTransport runs the fleet simulator and the ingestor on
+// an in-memory bus, without a broker
+func TestSimulatedFleetOverMemoryTransport(t *testing.T) {
+	bus := transport.NewBus()
+	robots, fleetSide := transport.NewMemory(bus), transport.NewMemory(bus)
+	robots.Connect()
+	fleetSide.Connect()
+
+	in := NewIngestor(fleetSide, "robots")
+	state := NewFleetState()
+	in.AddSink(state)
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	sim := simulation.NewFleetManager()
+	sim.AddRobot("sim1", simulation.Position{})
+	sim.AddRobot("sim2", simulation.Position{X: 5})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sim.StartAll(ctx)
+	go sim.PublishTo(ctx, robots, "robots")
+
+	for {
+		s1, ok1 := state.Robot("sim1")
+		s2, ok2 := state.Robot("sim2")
+		if ok1 && ok2 && s1.Navigation != nil && s2.Navigation != nil && s1.Heartbeat != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("Fleet state incomplete: %v", state.Robots())
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if m := in.Metrics()["sim2"]; m.DeadLettered != 0 || m.ByType["navigation"] == 0 {
+		t.Errorf("Unexpected metrics for sim2: %+v", m)
+	}
+}
@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc struct {
+	SinkName string
+	Fn       func(rec Record) error
+}
+
+func (s SinkFunc) Name() string {
+	return s.SinkName
+}
+
+func (s SinkFunc) Handle(rec Record) error {
+	return s.Fn(rec)
+}
+
+// RobotState is the latest known state of one robot
+type RobotState struct {
+	RobotID    string
+	Heartbeat  *simulation.HeartbeatMessage
+	Health     *simulation.HealthMessage
+	Navigation *simulation.NavigationMessage
+	UpdatedAt  time.Time
+}
+
+// FleetState keeps the latest heartbeat, health and navigation message per robot
+type FleetState struct {
+	mu     sync.RWMutex
+	robots map[string]*RobotState
+}
+
+// NewFleetState creates an empty fleet state sink
+func NewFleetState() *FleetState {
+	return &FleetState{robots: make(map[string]*RobotState)}
+}
+
+func (f *FleetState) Name() string {
+	return "fleet_state"
+}
+
+func (f *FleetState) Handle(rec Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.robots[rec.RobotID]
+	if !ok {
+		state = &RobotState{RobotID: rec.RobotID}
+		f.robots[rec.RobotID] = state
+	}
+	switch v := rec.Value.(type) {
+	case *simulation.HeartbeatMessage:
+		state.Heartbeat = v
+	case *simulation.HealthMessage:
+		state.Health = v
+	case *simulation.NavigationMessage:
+		state.Navigation = v
+	default:
+		return nil
+	}
+	state.UpdatedAt = rec.ReceivedAt
+	return nil
+}
+
+// Robot returns a copy of the state of one robot
+func (f *FleetState) Robot(robotID string) (RobotState, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	state, ok := f.robots[robotID]
+	if !ok {
+		return RobotState{}, false
+	}
+	return *state, true
+}
+
+// Robots returns the IDs of every robot seen
+func (f *FleetState) Robots() []string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	ids := make([]string, 0, len(f.robots))
+	for id := range f.robots {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Alert is raised by AlertSink
+type Alert struct {
+	RobotID string
+	Kind    string
+	Message string
+	At      time.Time
+}
+
+// AlertSink raises alerts for robots reporting a warning or error status, hardware
+// error codes or a low battery
+type AlertSink struct {
+	LowBatteryPct float64
+	notify        func(Alert)
+}
+
+// NewAlertSink creates an alerting sink calling notify for each alert
+func NewAlertSink(notify func(Alert)) *AlertSink {
+	return &AlertSink{LowBatteryPct: 15, notify: notify}
+}
+
+func (a *AlertSink) Name() string {
+	return "alerting"
+}
+
+func (a *AlertSink) Handle(rec Record) error {
+	raise := func(kind, message string) {
+		a.notify(Alert{RobotID: rec.RobotID, Kind: kind, Message: message, At: rec.ReceivedAt})
+	}
+	switch v := rec.Value.(type) {
+	case *simulation.HeartbeatMessage:
+		if v.Status == simulation.StatusWarning || v.Status == simulation.StatusError {
+			raise("status", string(v.Status))
+		}
+		if v.BatteryPct > 0 && v.BatteryPct < a.LowBatteryPct {
+			raise("battery", "battery low")
+		}
+	case *simulation.HealthMessage:
+		for _, code := range v.ErrorCodes {
+			raise("error_code", code)
+		}
+	}
+	return nil
+}
+
+// JSONLinesSink stores records as one JSON object per line, e.g. to a log file for
+// later loading into a database
+type JSONLinesSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLinesSink creates a storage sink writing to w
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONLinesSink) Name() string {
+	return "storage"
+}
+
+func (s *JSONLinesSink) Handle(rec Record) error {
+	line := struct {
+		RobotID    string      `json:"robot_id"`
+		Type       string      `json:"type"`
+		Seq        uint64      `json:"seq,omitempty"`
+		ReceivedAt time.Time   `json:"received_at"`
+		Value      interface{} `json:"value"`
+	}{RobotID: rec.RobotID, Type: rec.Type, ReceivedAt: rec.ReceivedAt, Value: rec.Value}
+	if rec.Envelope != nil {
+		line.Seq = rec.Envelope.Seq
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(line)
+}
+
+// DeadLetterQueue keeps the most recent dead letters in memory
+type DeadLetterQueue struct {
+	mu      sync.Mutex
+	limit   int
+	letters []DeadLetter
+	total   uint64
+}
+
+// NewDeadLetterQueue creates a queue keeping up to limit dead letters
+func NewDeadLetterQueue(limit int) *DeadLetterQueue {
+	return &DeadLetterQueue{limit: limit}
+}
+
+func (q *DeadLetterQueue) DeadLetter(dl DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.total++
+	q.letters = append(q.letters, dl)
+	if len(q.letters) > q.limit {
+		q.letters = q.letters[len(q.letters)-q.limit:]
+	}
+}
+
+// Letters returns the retained dead letters, oldest first, and the total ever received
+func (q *DeadLetterQueue) Letters() ([]DeadLetter, uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]DeadLetter(nil), q.letters...), q.total
+}
@@ -0,0 +1,290 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"telemetry/src/simulation"
+)
+
+// LaserScan represents a single 2D LiDAR sweep. Angles are in radians measured
+// counterclockwise from the sensor's forward axis; ranges are in meters and values
+// outside [RangeMin, RangeMax] mark invalid returns.
+type LaserScan struct {
+	AngleMin       float64   `json:"angle_min"`
+	AngleMax       float64   `json:"angle_max"`
+	AngleIncrement float64   `json:"angle_increment"`
+	RangeMin       float64   `json:"range_min"`
+	RangeMax       float64   `json:"range_max"`
+	Ranges         []float64 `json:"ranges"`
+	Intensities    []float64 `json:"intensities,omitempty"`
+}
+
+var ErrLaserScanEncoding = errors.New("invalid laser scan encoding")
+
+// Compact laser scan encoding, little-endian:
+//
+//	magic      [2]byte "LS"
+//	version    uint8
+//	flags      uint8   bit 0: intensities present
+//	angle_min  float32
+//	angle_inc  float32
+//	range_min  float32
+//	range_max  float32
+//	count      uint16
+//	ranges     [count]uint16 millimeters, 0 for invalid returns
+//	intensity  [count]uint8 when flagged
+const (
+	laserScanVersion     = 1
+	laserScanHeaderSize  = 22
+	laserFlagIntensities = 0x01
+)
+
+// Valid reports whether range i is a usable return
+func (s LaserScan) Valid(i int) bool {
+	r := s.Ranges[i]
+	return !math.IsNaN(r) && !math.IsInf(r, 0) && r >= s.RangeMin && r <= s.RangeMax && r > 0
+}
+
+// Angle returns the bearing of range i
+func (s LaserScan) Angle(i int) float64 {
+	return s.AngleMin + float64(i)*s.AngleIncrement
+}
+
+// MarshalBinary encodes the scan with millimeter range resolution, roughly a quarter of
+// the size of its JSON form, for publishing over MQTT
+func (s LaserScan) MarshalBinary() ([]byte, error) {
+	if len(s.Ranges) > math.MaxUint16 {
+		return nil, fmt.Errorf("laser scan has too many ranges: %d", len(s.Ranges))
+	}
+	withIntensities := len(s.Intensities) == len(s.Ranges) && len(s.Ranges) > 0
+
+	size := laserScanHeaderSize + 2*len(s.Ranges)
+	if withIntensities {
+		size += len(s.Ranges)
+	}
+	buf := make([]byte, 0, size)
+	buf = append(buf, 'L', 'S', laserScanVersion, 0)
+	if withIntensities {
+		buf[3] |= laserFlagIntensities
+	}
+	for _, v := range []float64{s.AngleMin, s.AngleIncrement, s.RangeMin, s.RangeMax} {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+	}
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(s.Ranges)))
+
+	for i, r := range s.Ranges {
+		mm := uint16(0)
+		if s.Valid(i) {
+			mm = uint16(math.Min(math.Round(r*1000), math.MaxUint16))
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, mm)
+	}
+	if withIntensities {
+		for _, v := range s.Intensities {
+			buf = append(buf, uint8(math.Max(0, math.Min(255, math.Round(v)))))
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a scan produced by MarshalBinary; invalid returns decode as +Inf
+func (s *LaserScan) UnmarshalBinary(data []byte) error {
+	if len(data) < laserScanHeaderSize || data[0] != 'L' || data[1] != 'S' {
+		return ErrLaserScanEncoding
+	}
+	if data[2] != laserScanVersion {
+		return fmt.Errorf("%w: version %d", ErrLaserScanEncoding, data[2])
+	}
+	flags := data[3]
+	readF := func(off int) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off:])))
+	}
+	s.AngleMin = readF(4)
+	s.AngleIncrement = readF(8)
+	s.RangeMin = readF(12)
+	s.RangeMax = readF(16)
+	count := int(binary.LittleEndian.Uint16(data[20:]))
+
+	want := laserScanHeaderSize + 2*count
+	if flags&laserFlagIntensities != 0 {
+		want += count
+	}
+	if len(data) != want {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrLaserScanEncoding, want, len(data))
+	}
+
+	s.Ranges = make([]float64, count)
+	for i := range s.Ranges {
+		mm := binary.LittleEndian.Uint16(data[laserScanHeaderSize+2*i:])
+		if mm == 0 {
+			s.Ranges[i] = math.Inf(1)
+		} else {
+			s.Ranges[i] = float64(mm) / 1000
+		}
+	}
+	s.Intensities = nil
+	if flags&laserFlagIntensities != 0 {
+		off := laserScanHeaderSize + 2*count
+		s.Intensities = make([]float64, count)
+		for i := range s.Intensities {
+			s.Intensities[i] = float64(data[off+i])
+		}
+	}
+	s.AngleMax = s.AngleMin
+	if count > 0 {
+		s.AngleMax = s.Angle(count - 1)
+	}
+	return nil
+}
+
+// ClusterConfig controls how scans are segmented into obstacles
+type ClusterConfig struct {
+	// MaxGap is the largest distance in meters between neighboring returns of one cluster
+	MaxGap float64
+	// MinPoints discards clusters with fewer returns, filtering out noise
+	MinPoints int
+	// HighSeverityRange and MediumSeverityRange grade obstacles by their nearest return
+	HighSeverityRange   float64
+	MediumSeverityRange float64
+}
+
+// DefaultClusterConfig returns settings suited to indoor RPLidar-class sensors
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		MaxGap:              0.15,
+		MinPoints:           3,
+		HighSeverityRange:   0.5,
+		MediumSeverityRange: 1.5,
+	}
+}
+
+// ScanCluster is a group of neighboring returns in the sensor frame
+type ScanCluster struct {
+	Points  [][2]float64
+	Nearest float64
+}
+
+// ClusterScan segments a scan into clusters of neighboring returns
+func ClusterScan(scan LaserScan, cfg ClusterConfig) []ScanCluster {
+	var clusters []ScanCluster
+	var current *ScanCluster
+	var prev [2]float64
+
+	for i := range scan.Ranges {
+		if !scan.Valid(i) {
+			current = nil
+			continue
+		}
+		r, a := scan.Ranges[i], scan.Angle(i)
+		p := [2]float64{r * math.Cos(a), r * math.Sin(a)}
+
+		if current == nil || math.Hypot(p[0]-prev[0], p[1]-prev[1]) > cfg.MaxGap {
+			clusters = append(clusters, ScanCluster{Nearest: r})
+			current = &clusters[len(clusters)-1]
+		}
+		current.Points = append(current.Points, p)
+		current.Nearest = math.Min(current.Nearest, r)
+		prev = p
+	}
+
+	// A full sweep wraps around, so the last and first clusters may be one object
+	fullSweep := len(scan.Ranges) > 1 &&
+		math.Abs(float64(len(scan.Ranges))*scan.AngleIncrement-2*math.Pi) < scan.AngleIncrement
+	if fullSweep && len(clusters) > 1 && scan.Valid(0) && scan.Valid(len(scan.Ranges)-1) {
+		first, last := clusters[0], clusters[len(clusters)-1]
+		a, b := first.Points[0], last.Points[len(last.Points)-1]
+		if math.Hypot(a[0]-b[0], a[1]-b[1]) <= cfg.MaxGap {
+			last.Points = append(last.Points, first.Points...)
+			last.Nearest = math.Min(last.Nearest, first.Nearest)
+			clusters = append(clusters[1:len(clusters)-1], last)
+		}
+	}
+
+	kept := clusters[:0]
+	for _, c := range clusters {
+		if len(c.Points) >= cfg.MinPoints {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// ExtractObstacles clusters a scan and converts the clusters into world frame obstacles
+// for a robot at pose heading headingDeg degrees counterclockwise from the world X axis
+func ExtractObstacles(scan LaserScan, pose simulation.Position, headingDeg float64, cfg ClusterConfig) []simulation.Obstacle {
+	sinH, cosH := math.Sincos(headingDeg * math.Pi / 180)
+	clusters := ClusterScan(scan, cfg)
+	obstacles := make([]simulation.Obstacle, 0, len(clusters))
+
+	for _, c := range clusters {
+		var cx, cy float64
+		for _, p := range c.Points {
+			cx += p[0]
+			cy += p[1]
+		}
+		cx /= float64(len(c.Points))
+		cy /= float64(len(c.Points))
+
+		radius := 0.0
+		for _, p := range c.Points {
+			radius = math.Max(radius, math.Hypot(p[0]-cx, p[1]-cy))
+		}
+
+		severity := "LOW"
+		if c.Nearest <= cfg.HighSeverityRange {
+			severity = "HIGH"
+		} else if c.Nearest <= cfg.MediumSeverityRange {
+			severity = "MEDIUM"
+		}
+
+		obstacles = append(obstacles, simulation.Obstacle{
+			Position: simulation.Position{
+				X: pose.X + cx*cosH - cy*sinH,
+				Y: pose.Y + cx*sinH + cy*cosH,
+				Z: pose.Z,
+			},
+			Size:     2 * radius,
+			Type:     "STATIC",
+			Severity: severity,
+		})
+	}
+	return obstacles
+}
+
+// LaserObstacleSource feeds obstacles extracted from the latest scan of a LiDAR sensor
+// to a simulated or real robot's navigation messages
+type LaserObstacleSource struct {
+	readings *ReadingCache
+	sensorID string
+	Config   ClusterConfig
+}
+
+// NewLaserObstacleSource creates a source reading scans of sensorID from the cache
+func NewLaserObstacleSource(readings *ReadingCache, sensorID string) *LaserObstacleSource {
+	return &LaserObstacleSource{
+		readings: readings,
+		sensorID: sensorID,
+		Config:   DefaultClusterConfig(),
+	}
+}
+
+// DetectObstacles implements simulation.ObstacleSource
+func (s *LaserObstacleSource) DetectObstacles(pose simulation.Position, headingDeg float64) ([]simulation.Obstacle, error) {
+	data, ok := s.readings.Latest(s.sensorID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInputUnavailable, s.sensorID)
+	}
+	var scan LaserScan
+	switch v := data.Value.(type) {
+	case LaserScan:
+		scan = v
+	case *LaserScan:
+		scan = *v
+	default:
+		return nil, fmt.Errorf("sensor %s reported %T, not a laser scan", s.sensorID, data.Value)
+	}
+	return ExtractObstacles(scan, pose, headingDeg, s.Config), nil
+}
@@ -1,17 +1,99 @@
 package main
 
 import (
+	"flag"
+	"strings"
+	"time"
+
 	"telemetry/include/logger"
+	"telemetry/src/broker"
+	"telemetry/src/failover"
+	"telemetry/src/governor"
+	"telemetry/src/mqtt"
+	"telemetry/src/obstacles"
 	"telemetry/src/testing"
 )
 
 func main() {
 	log := logger.New(logger.DEBUG)
 
-	runner := testing.NewTelemetryTestRunner(
-		"TEST_ROBOT_001",
-		"tcp://localhost:1883",
-	)
+	brokerURL := flag.String("broker", "tcp://localhost:1883", "MQTT broker URL")
+	backups := flag.String("failover", "", "comma-separated backup broker URLs, in order of preference")
+	discover := flag.String("discover", "", "listen for broker announcements on this UDP address, e.g. :18830 or 239.255.18.83:18830")
+	embedded := flag.String("embedded-broker", "", "run an embedded MQTT broker on this address, e.g. :1883, for sites without one")
+	announce := flag.String("announce", "", "announce the embedded broker to this UDP address, e.g. 255.255.255.255:18830")
+	policyFile := flag.String("policy", "", "JSON file with per-message-type QoS, retain, priority and TTL, e.g. config/policy.json")
+	commandKeys := flag.String("command-keys", "", "JSON file with the issuer keys commands must be signed with; required unless -insecure-commands is set")
+	insecureCommands := flag.Bool("insecure-commands", false, "accept unsigned commands from any client on the broker, for test benches only")
+	obstacleService := flag.Bool("obstacle-service", false, "merge the fleet's obstacle reports into the shared map and publish it, for sites without a map service")
+	bandwidth := flag.Float64("bandwidth", 0, "telemetry airtime budget in bytes per second, shedding low-priority messages first; 0 is unlimited")
+	flag.Parse()
+
+	if *embedded != "" {
+		b := broker.New(broker.DefaultConfig())
+		if err := b.Start(*embedded); err != nil {
+			log.Fatal("Failed to start embedded broker: %v", err)
+		}
+		defer b.Close()
+		*brokerURL = b.URL()
+		if *announce != "" {
+			a, err := failover.Announce(*announce, failover.Broker{URL: b.URL()}, 5*time.Second)
+			if err != nil {
+				log.Fatal("Failed to announce embedded broker: %v", err)
+			}
+			defer a.Close()
+		}
+	}
+
+	opts := mqtt.DefaultOptions("TEST_ROBOT_001", *brokerURL)
+	if *backups != "" {
+		opts.Failover = failover.DefaultConfig()
+		for i, u := range strings.Split(*backups, ",") {
+			opts.Failover.Brokers = append(opts.Failover.Brokers, failover.Broker{URL: strings.TrimSpace(u), Priority: i + 1})
+		}
+	}
+	if *discover != "" {
+		l, err := failover.Listen(*discover)
+		if err != nil {
+			log.Fatal("Failed to listen for broker announcements: %v", err)
+		}
+		defer l.Close()
+		if *backups == "" {
+			opts.Failover = failover.DefaultConfig()
+		}
+		opts.Failover.Discovery = l
+	}
+
+	runner, err := testing.NewTelemetryTestRunnerWithOptions(opts)
+	if err != nil {
+		log.Fatal("Invalid options: %v", err)
+	}
+	if *policyFile != "" {
+		if err := runner.LoadPolicy(*policyFile); err != nil {
+			log.Fatal("Failed to load delivery policy: %v", err)
+		}
+	}
+
+	switch {
+	case *commandKeys != "":
+		if err := runner.RequireSignedCommands(*commandKeys); err != nil {
+			log.Fatal("Failed to load command keys: %v", err)
+		}
+	case *insecureCommands:
+		log.Warn("-insecure-commands: commands are NOT authenticated and any client on the broker can command this robot")
+	default:
+		log.Fatal("-command-keys is required so unsigned and stale commands are rejected; pass -insecure-commands to run without it")
+	}
+	if *bandwidth > 0 {
+		if err := runner.LimitBandwidth(governor.DefaultConfig(*bandwidth)); err != nil {
+			log.Fatal("Invalid bandwidth budget: %v", err)
+		}
+	}
+	if *obstacleService {
+		if err := runner.RunObstacleService(obstacles.DefaultConfig()); err != nil {
+			log.Fatal("Invalid obstacle map config: %v", err)
+		}
+	}
 
 	if err := runner.Run(); err != nil {
 		log.Fatal("Test runner failed: %v", err)
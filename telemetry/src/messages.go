@@ -62,6 +62,7 @@ type TelemetryManager struct {
 	sensors  []Sensor
 	storage  Storage
 	interval time.Duration
+	readings *ReadingCache
 	log      *logger.Logger
 }
 
@@ -71,10 +72,16 @@ func NewTelemetryManager(storage Storage, interval time.Duration) *TelemetryMana
 		sensors:  make([]Sensor, 0),
 		storage:  storage,
 		interval: interval,
+		readings: NewReadingCache(),
 		log:      logger.New(logger.INFO),
 	}
 }
 
+// Readings returns the cache of latest readings used by virtual sensors
+func (tm *TelemetryManager) Readings() *ReadingCache {
+	return tm.readings
+}
+
 // AddSensor registers a new sensor with the telemetry manager
 func (tm *TelemetryManager) AddSensor(s Sensor) error {
 	if err := s.Initialize(); err != nil {
@@ -103,6 +110,7 @@ func (tm *TelemetryManager) Start(ctx context.Context) error {
 					tm.log.Error("Error reading sensor %s: %v", sensor.ID(), err)
 					continue
 				}
+				tm.readings.Update(data)
 				if err := tm.storage.Store(data); err != nil {
 					tm.log.Error("Error storing data from sensor %s: %v", sensor.ID(), err)
 					continue
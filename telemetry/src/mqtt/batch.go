@@ -0,0 +1,31 @@
+package mqtt
+
+import (
+	"path"
+
+	"telemetry/src/batch"
+)
+
+// SetBatching makes PublishTelemetry accumulate messages per telemetry type and publish
+// them as compressed batch frames, except for the types the config bypasses.
+// Subscribers unpack frames with batch.Handler. It must be called before Connect.
+func (m *MQTTTelemetryClient) SetBatching(config batch.Config) {
+	m.batcher = batch.NewBatcher(batchPublisher{m}, config)
+}
+
+// batchPublisher publishes batch frames, falling back to the outbox when the broker is
+// unreachable
+type batchPublisher struct {
+	m *MQTTTelemetryClient
+}
+
+func (p batchPublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	err := p.m.Publish(topic, qos, retained, payload)
+	if err != nil && p.m.outbox != nil {
+		p.m.log.Warn("Batch publish to %s failed, queueing: %v", topic, err)
+		queued := p.m.policy.For(path.Base(topic))
+		queued.QoS, queued.Retain = qos, retained
+		return p.m.enqueue(topic, queued, payload)
+	}
+	return err
+}
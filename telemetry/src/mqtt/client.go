@@ -1,10 +1,19 @@
 package mqtt
 
 import (
-	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"telemetry/include/logger"
-
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"telemetry/src/batch"
+	"telemetry/src/codec"
+	"telemetry/src/envelope"
+	"telemetry/src/governor"
+	"telemetry/src/outbox"
+	"telemetry/src/policy"
+	"telemetry/src/simulation"
+	"telemetry/src/transport"
+	"time"
 )
 
 const (
@@ -13,76 +22,242 @@ const (
 	QoSExactlyOnce = 2
 )
 
+// ErrNotConnected is returned when publishing or subscribing before Connect
+var ErrNotConnected = transport.ErrNotConnected
+
 type MQTTTelemetryClient struct {
-	client    mqtt.Client
-	robotID   string
-	brokerURL string
-	log       *logger.Logger
+	transportMu sync.RWMutex
+	transport   transport.Transport
+	opts        Options
+	robotID     string
+	brokerURL   string
+	log         *logger.Logger
+	statusMu    sync.Mutex
+	birth       simulation.StatusMessage
+	outbox      *outbox.Queue
+	replaying   atomic.Bool
+	sequencer   *envelope.Sequencer
+	codecs      map[string]codec.Codec
+	batcher     *batch.Batcher
+	lifecycle   connectionTracker
+	policy      policy.Table
+	governor    *governor.Governor
 }
 
 func NewMQTTTelemetryClient(robotID, brokerURL string) *MQTTTelemetryClient {
-	opts := mqtt.NewClientOptions().
-		AddBroker(brokerURL).
-		SetClientID(robotID).
-		SetAutoReconnect(true).
-		SetCleanSession(false).
-		SetOrderMatters(false)
-
-	client := mqtt.NewClient(opts)
-
-	return &MQTTTelemetryClient{
-		client:    client,
-		robotID:   robotID,
-		brokerURL: brokerURL,
+	return newClient(DefaultOptions(robotID, brokerURL))
+}
+
+// NewMQTTTelemetryClientWithOptions creates a client from validated options
+func NewMQTTTelemetryClientWithOptions(opts Options) (*MQTTTelemetryClient, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return newClient(opts), nil
+}
+
+// NewMQTTTelemetryClientWithTransport creates a client on t, e.g. a transport.Memory
+// to run without a broker. The options supply the robot ID and topic layout; their
+// connection settings are not used.
+func NewMQTTTelemetryClientWithTransport(opts Options, t transport.Transport) (*MQTTTelemetryClient, error) {
+	if err := opts.validateTopics(); err != nil {
+		return nil, err
+	}
+	m := newClient(opts)
+	m.attach(t)
+	return m, nil
+}
+
+func newClient(opts Options) *MQTTTelemetryClient {
+	m := &MQTTTelemetryClient{
+		opts:      opts,
+		robotID:   opts.RobotID,
+		brokerURL: opts.BrokerURL,
 		log:       logger.New(logger.INFO),
+		sequencer: envelope.NewSequencer(opts.RobotID, envelope.NewBootID()),
+		codecs:    make(map[string]codec.Codec),
+		policy:    policy.DefaultTable(),
 	}
+	m.lifecycle.now = time.Now
+	return m
 }
 
+// Connect registers the Last Will and connects, building the transport from the
+// options on first use
 func (m *MQTTTelemetryClient) Connect() error {
-	opts := mqtt.NewClientOptions().
-		AddBroker(m.brokerURL).
-		SetClientID(m.robotID).
-		SetAutoReconnect(true).
-		SetCleanSession(false).
-		SetOrderMatters(false)
+	t, err := m.connection()
+	if err != nil {
+		return err
+	}
+	will, err := m.willPayload()
+	if err != nil {
+		return err
+	}
+	t.SetWill(transport.Will{Topic: m.StatusTopic(), Payload: will, QoS: QoSAtLeastOnce, Retained: true})
+	return t.Connect()
+}
 
-	client := mqtt.NewClient(opts)
-	token := client.Connect()
-	if token.Wait() && token.Error() != nil {
-		return token.Error()
+// connection returns the transport, building it from the options if needed
+func (m *MQTTTelemetryClient) connection() (transport.Transport, error) {
+	m.transportMu.Lock()
+	defer m.transportMu.Unlock()
+	if m.transport != nil {
+		return m.transport, nil
 	}
+	t, err := m.opts.Transport()
+	if err != nil {
+		return nil, err
+	}
+	m.transport = t
+	t.OnEvent(m.onEvent)
+	return t, nil
+}
 
-	m.client = client
-	return nil
+// attach sets a caller-provided transport
+func (m *MQTTTelemetryClient) attach(t transport.Transport) {
+	m.transportMu.Lock()
+	defer m.transportMu.Unlock()
+	m.transport = t
+	t.OnEvent(m.onEvent)
 }
 
-func (m *MQTTTelemetryClient) SubscribeToCommands(callback mqtt.MessageHandler) error {
-	topic := "robots/" + m.robotID + "/commands"
-	token := m.client.Subscribe(topic, QoSAtLeastOnce, callback)
-	token.Wait()
-	return token.Error()
+// Transport returns the client's transport, nil before the first Connect unless one
+// was provided
+func (m *MQTTTelemetryClient) Transport() transport.Transport {
+	m.transportMu.RLock()
+	defer m.transportMu.RUnlock()
+	return m.transport
 }
 
+func (m *MQTTTelemetryClient) onEvent(ev transport.Event) {
+	m.lifecycle.record(ev)
+	switch ev.Type {
+	case transport.EventConnected:
+		m.onConnect()
+	case transport.EventConnectionLost:
+		m.log.Warn("Connection lost: %v", ev.Err)
+	case transport.EventReconnecting:
+		m.log.Debug("Reconnecting to %s (attempt %d)", m.brokerURL, ev.Attempt)
+	case transport.EventResubscribed:
+		if ev.Err != nil {
+			m.log.Error("Failed to restore subscriptions: %v", ev.Err)
+		}
+	}
+}
+
+// SubscribeToCommands calls handler for every command sent to this robot
+func (m *MQTTTelemetryClient) SubscribeToCommands(handler transport.Handler) error {
+	return m.Subscribe(m.opts.Topic(m.robotID, "commands"), QoSAtLeastOnce, handler)
+}
+
+// PublishTelemetry wraps data in a sequenced envelope and publishes it on
+// robots/<id>/telemetry/<messageType>, with the QoS and retain flag of the type's
+// delivery policy. With a bandwidth governor, messages over the budget are dropped
+// with governor.ErrShed; messages queued in the outbox are charged when replayed.
 func (m *MQTTTelemetryClient) PublishTelemetry(messageType string, data interface{}) error {
-	topic := "robots/" + m.robotID + "/telemetry/" + messageType
+	topic := m.opts.Topic(m.robotID, "telemetry", messageType)
+	p := m.policy.For(messageType)
+
+	env, err := m.sequencer.Prepare(messageType, m.codecFor(messageType), data)
+	if err != nil {
+		m.log.Error("failed to marshal telemetry data: %v", err)
+		return err
+	}
+
+	// While offline, or while older messages are still being replayed, queue so the
+	// broker sees messages in order
+	queue := m.outbox != nil && (!m.IsConnected() || m.outbox.Depth() > 0)
 
-	jsonData, err := json.Marshal(data)
+	// Shedding happens before sequencing, so deliberate drops are not reported as
+	// losses by the fleet. The size is measured before the sequence number is added.
+	// Queued messages use no airtime until they are replayed.
+	if m.governor != nil && !queue {
+		unsequenced, err := envelope.Encode(env)
+		if err != nil {
+			return err
+		}
+		if !m.governor.Allow(messageType, p.Priority, len(topic)+len(unsequenced)) {
+			return fmt.Errorf("%w: %s", governor.ErrShed, messageType)
+		}
+	}
+	payload, err := envelope.Encode(m.sequencer.Sequence(env))
 	if err != nil {
 		m.log.Error("failed to marshal telemetry data: %v", err)
 		return err
 	}
 
-	token := m.client.Publish(topic, QoSAtLeastOnce, false, jsonData)
-	token.Wait()
-	return token.Error()
+	if queue {
+		return m.enqueue(topic, p, payload)
+	}
+	// Batches are never retained, so retained types are published on their own
+	if m.batcher != nil && !p.Retain && !m.batcher.Bypasses(messageType) {
+		return m.batcher.Add(topic, p.QoS, payload)
+	}
+
+	if err := m.Publish(topic, p.QoS, p.Retain, payload); err != nil {
+		if m.outbox != nil {
+			m.log.Warn("Publish to %s failed, queueing: %v", topic, err)
+			return m.enqueue(topic, p, payload)
+		}
+		return err
+	}
+	return nil
+}
+
+// Publish sends a raw payload on any topic
+func (m *MQTTTelemetryClient) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	t := m.Transport()
+	if t == nil {
+		return ErrNotConnected
+	}
+	return t.Publish(topic, qos, retained, payload)
+}
+
+// Subscribe calls handler with the topic and payload of every message matching topic
+func (m *MQTTTelemetryClient) Subscribe(topic string, qos byte, handler func(topic string, payload []byte)) error {
+	t := m.Transport()
+	if t == nil {
+		return ErrNotConnected
+	}
+	return t.Subscribe(topic, qos, handler)
+}
+
+// Topic returns a topic below this robot, e.g. Topic("commands", "ack")
+func (m *MQTTTelemetryClient) Topic(parts ...string) string {
+	return m.opts.Topic(append([]string{m.robotID}, parts...)...)
 }
 
 func (m *MQTTTelemetryClient) BrokerURL() string {
 	return m.brokerURL
 }
 
+// SetCodec selects the payload codec for a telemetry type, e.g. codec.Compact for a
+// high-rate navigation stream. Types without one are sent as JSON. It must be called
+// before publishing.
+func (m *MQTTTelemetryClient) SetCodec(messageType string, c codec.Codec) {
+	m.codecs[messageType] = c
+}
+
+func (m *MQTTTelemetryClient) codecFor(messageType string) codec.Codec {
+	if c, ok := m.codecs[messageType]; ok {
+		return c
+	}
+	return codec.JSON
+}
+
+// BootID identifies this client's sequence numbers; it changes on every restart
+func (m *MQTTTelemetryClient) BootID() string {
+	return m.sequencer.BootID()
+}
+
+// Options returns the options the client was created with
+func (m *MQTTTelemetryClient) Options() Options {
+	return m.opts
+}
+
 func (m *MQTTTelemetryClient) IsConnected() bool {
-	return m.client != nil && m.client.IsConnected()
+	t := m.Transport()
+	return t != nil && t.IsConnected()
 }
 
 // ... move all the MQTTTelemetryClient methods here ...
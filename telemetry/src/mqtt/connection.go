@@ -0,0 +1,125 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"telemetry/src/transport"
+)
+
+// ConnectionStats counts connections and the time spent connected and disconnected
+type ConnectionStats struct {
+	Connected         bool
+	Connects          uint64 // Successful connections, the first one included
+	Reconnects        uint64 // Successful connections after a loss
+	ConnectionLosses  uint64
+	ReconnectAttempts uint64
+	Uptime            time.Duration // Total time connected
+	Downtime          time.Duration // Total time between a loss and the next connect
+	ConnectedSince    time.Time     // Zero while disconnected
+	LastLoss          time.Time
+	LastLossReason    string
+	// Broker is the broker of the current connection, when the transport reports it
+	Broker string
+	// Failovers counts connections to a different broker than the one before
+	Failovers uint64
+}
+
+// connectionTracker folds lifecycle events into ConnectionStats
+type connectionTracker struct {
+	mu        sync.Mutex
+	stats     ConnectionStats
+	lostSince time.Time
+	broker    string // Last broker connected to
+	listeners []func(transport.Event)
+	now       func() time.Time
+}
+
+func (c *connectionTracker) record(ev transport.Event) {
+	c.mu.Lock()
+	switch ev.Type {
+	case transport.EventConnected:
+		if !c.stats.Connected {
+			c.stats.Connects++
+			if !c.lostSince.IsZero() {
+				c.stats.Reconnects++
+				c.stats.Downtime += ev.Time.Sub(c.lostSince)
+				c.lostSince = time.Time{}
+			}
+			c.stats.Connected = true
+			c.stats.ConnectedSince = ev.Time
+		}
+		if ev.Broker != "" {
+			if c.broker != "" && c.broker != ev.Broker {
+				c.stats.Failovers++
+			}
+			c.broker = ev.Broker
+			c.stats.Broker = ev.Broker
+		}
+	case transport.EventConnectionLost, transport.EventDisconnected:
+		if c.stats.Connected {
+			c.stats.Uptime += ev.Time.Sub(c.stats.ConnectedSince)
+			c.stats.Connected = false
+			c.stats.ConnectedSince = time.Time{}
+			c.stats.Broker = ""
+		}
+		if ev.Type == transport.EventConnectionLost {
+			c.stats.ConnectionLosses++
+			c.stats.LastLoss = ev.Time
+			if ev.Err != nil {
+				c.stats.LastLossReason = ev.Err.Error()
+			}
+			c.lostSince = ev.Time
+		}
+	case transport.EventReconnecting:
+		c.stats.ReconnectAttempts++
+	}
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+func (c *connectionTracker) snapshot() ConnectionStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	now := c.now()
+	if stats.Connected {
+		stats.Uptime += now.Sub(stats.ConnectedSince)
+	} else if !c.lostSince.IsZero() {
+		stats.Downtime += now.Sub(c.lostSince)
+	}
+	return stats
+}
+
+// OnConnectionEvent calls fn for every connection lifecycle event: connecting,
+// connected, connection lost with its reason, reconnecting with the attempt count,
+// resubscribed and disconnected. fn runs on the transport's goroutine and must not
+// block for long.
+func (m *MQTTTelemetryClient) OnConnectionEvent(fn func(transport.Event)) {
+	m.lifecycle.mu.Lock()
+	defer m.lifecycle.mu.Unlock()
+	m.lifecycle.listeners = append(m.lifecycle.listeners, fn)
+}
+
+// ConnectionEvents returns a channel receiving every lifecycle event. Events are
+// dropped while the channel is full, so size buffer for the slowest reader.
+func (m *MQTTTelemetryClient) ConnectionEvents(buffer int) <-chan transport.Event {
+	ch := make(chan transport.Event, buffer)
+	m.OnConnectionEvent(func(ev transport.Event) {
+		select {
+		case ch <- ev:
+		default:
+			m.log.Warn("Dropping %s connection event, reader too slow", ev.Type)
+		}
+	})
+	return ch
+}
+
+// ConnectionStats returns connection counters, with the durations up to now
+func (m *MQTTTelemetryClient) ConnectionStats() ConnectionStats {
+	return m.lifecycle.snapshot()
+}
@@ -0,0 +1,60 @@
+package mqtt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"telemetry/src/transport"
+)
+
+func TestConnectionStats(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	c := NewMQTTTelemetryClient("robot1", "tcp://localhost:1883")
+	c.lifecycle.now = func() time.Time { return now }
+	events := c.ConnectionEvents(10)
+
+	at := func(offset time.Duration, ev transport.Event) {
+		ev.Time = start.Add(offset)
+		c.lifecycle.record(ev)
+	}
+	at(0, transport.Event{Type: transport.EventConnecting})
+	at(time.Second, transport.Event{Type: transport.EventConnected})
+	at(61*time.Second, transport.Event{Type: transport.EventConnectionLost, Err: errors.New("EOF")})
+	at(62*time.Second, transport.Event{Type: transport.EventReconnecting, Attempt: 1})
+	at(66*time.Second, transport.Event{Type: transport.EventReconnecting, Attempt: 2})
+	at(71*time.Second, transport.Event{Type: transport.EventConnected})
+	at(71*time.Second, transport.Event{Type: transport.EventResubscribed})
+	now = start.Add(101 * time.Second)
+
+	stats := c.ConnectionStats()
+	want := ConnectionStats{
+		Connected:         true,
+		Connects:          2,
+		Reconnects:        1,
+		ConnectionLosses:  1,
+		ReconnectAttempts: 2,
+		Uptime:            90 * time.Second,
+		Downtime:          10 * time.Second,
+		ConnectedSince:    start.Add(71 * time.Second),
+		LastLoss:          start.Add(61 * time.Second),
+		LastLossReason:    "EOF",
+	}
+	if stats != want {
+		t.Errorf("Got %+v\nwant %+v", stats, want)
+	}
+	if len(events) != 7 {
+		t.Errorf("Expected 7 buffered events, got %d", len(events))
+	}
+	if ev := <-events; ev.Type != transport.EventConnecting {
+		t.Errorf("First event %s, want connecting", ev.Type)
+	}
+
+	// Downtime keeps counting while disconnected
+	at(101*time.Second, transport.Event{Type: transport.EventConnectionLost})
+	now = start.Add(111 * time.Second)
+	if stats := c.ConnectionStats(); stats.Connected || stats.Uptime != 90*time.Second || stats.Downtime != 20*time.Second {
+		t.Errorf("Unexpected stats while disconnected: %+v", stats)
+	}
+}
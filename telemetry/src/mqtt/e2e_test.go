@@ -0,0 +1,290 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"telemetry/src/broker"
+	"telemetry/src/command"
+	"telemetry/src/failover"
+	"telemetry/src/ingest"
+	"telemetry/src/rpc"
+	"telemetry/src/simulation"
+	"telemetry/src/transport"
+)
+
+// TestEndToEndOverEmbeddedBroker runs a robot and a fleet client against a real broker:
+// birth message, telemetry ingestion, a command with acks, an RPC call, the last will
+// on a dropped connection and the death message on shutdown
+func TestEndToEndOverEmbeddedBroker(t *testing.T) {
+	b := broker.New(broker.DefaultConfig())
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Failed to start broker: %v", err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := DefaultOptions("robot1", b.URL())
+	opts.MaxReconnectInterval = 100 * time.Millisecond
+	robot, err := NewMQTTTelemetryClientWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	robot.SetBirthMessage(simulation.StatusMessage{SoftwareVersion: "1.2.3"})
+	lifecycle := robot.ConnectionEvents(20)
+	if err := robot.Connect(); err != nil {
+		t.Fatalf("Robot failed to connect: %v", err)
+	}
+
+	fleet := NewMQTTTelemetryClient("fleet", b.URL())
+	if err := fleet.Connect(); err != nil {
+		t.Fatalf("Fleet failed to connect: %v", err)
+	}
+	defer fleet.Disconnect()
+
+	// The birth is published once the connection is up; wait for it so the fleet sees
+	// it only once, as the retained status
+	waitFor(t, func() bool {
+		_, ok := b.Retained("robots/robot1/status")
+		return ok
+	})
+	statuses := make(chan simulation.StatusMessage, 10)
+	if err := fleet.Subscribe("robots/+/status", QoSAtLeastOnce, func(topic string, payload []byte) {
+		var msg simulation.StatusMessage
+		if err := json.Unmarshal(payload, &msg); err == nil && msg.RobotID == "robot1" {
+			statuses <- msg
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	nextStatus := func() simulation.StatusMessage {
+		t.Helper()
+		select {
+		case msg := <-statuses:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a status message")
+			return simulation.StatusMessage{}
+		}
+	}
+	if birth := nextStatus(); birth.Status != simulation.StatusOperational || birth.SoftwareVersion != "1.2.3" {
+		t.Errorf("Unexpected birth message: %+v", birth)
+	}
+
+	// Telemetry reaches the fleet ingestor
+	state := ingest.NewFleetState()
+	ingestor := ingest.NewIngestor(fleet, "robots")
+	ingestor.AddSink(state)
+	if err := ingestor.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := robot.PublishTelemetry("health", simulation.HealthMessage{RobotID: "robot1", CPUUsage: 42}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		s, ok := state.Robot("robot1")
+		return ok && s.Health != nil && s.Health.CPUUsage == 42
+	})
+
+	// Commands are acknowledged over the broker
+	dispatcher := command.NewDispatcher("robot1", robot.Topic("commands", "ack"), robot)
+	dispatcher.Register(command.TypePing, func(ctx context.Context, cmd command.Command) (interface{}, error) {
+		return "pong", nil
+	})
+	if err := robot.Subscribe(robot.Topic("commands"), QoSAtLeastOnce, func(_ string, payload []byte) {
+		dispatcher.Dispatch(ctx, payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	acks := make(chan command.Ack, 10)
+	if err := fleet.Subscribe("robots/robot1/commands/ack", QoSAtLeastOnce, func(_ string, payload []byte) {
+		var ack command.Ack
+		if json.Unmarshal(payload, &ack) == nil {
+			acks <- ack
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := command.New("cmd-1", command.TypePing, command.PingArgs{}, "fleet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(cmd)
+	if err := fleet.Publish("robots/robot1/commands", QoSAtLeastOnce, false, payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []command.AckState{command.AckAccepted, command.AckCompleted} {
+		select {
+		case ack := <-acks:
+			if ack.State != want || ack.CommandID != "cmd-1" {
+				t.Errorf("Got ack %+v, want %s", ack, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s ack", want)
+		}
+	}
+
+	// RPC round trip
+	server := rpc.NewServer(robot.Topic("rpc"), robot)
+	server.Handle("get_config", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return map[string]string{"mode": "idle"}, nil
+	})
+	if err := server.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.NewClient("fleet/rpc/replies", fleet)
+	callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer callCancel()
+	var config map[string]string
+	if err := client.Call(callCtx, "robots/robot1/rpc", "get_config", nil, &config); err != nil {
+		t.Fatalf("RPC call failed: %v", err)
+	}
+	if config["mode"] != "idle" {
+		t.Errorf("Unexpected RPC result: %v", config)
+	}
+
+	// Dropping the connection publishes the last will; the client reconnects and
+	// announces itself again
+	if !b.DisconnectClient(opts.ClientID()) {
+		t.Fatal("Robot is not connected to the broker")
+	}
+	if will := nextStatus(); will.Status != simulation.StatusOffline || will.Reason != simulation.StatusReasonConnectionLost {
+		t.Errorf("Unexpected last will: %+v", will)
+	}
+	if birth := nextStatus(); birth.Status != simulation.StatusOperational {
+		t.Errorf("Unexpected birth after reconnect: %+v", birth)
+	}
+
+	// The command subscription is restored, and the reconnect is counted
+	var seen []transport.EventType
+	for done := false; !done; {
+		select {
+		case ev := <-lifecycle:
+			seen = append(seen, ev.Type)
+			done = ev.Type == transport.EventResubscribed
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for resubscription, got %v", seen)
+		}
+	}
+	if stats := robot.ConnectionStats(); stats.Reconnects != 1 || stats.ConnectionLosses != 1 || !stats.Connected {
+		t.Errorf("Unexpected connection stats: %+v", stats)
+	}
+	cmd, _ = command.New("cmd-2", command.TypePing, command.PingArgs{}, "fleet")
+	payload, _ = json.Marshal(cmd)
+	if err := fleet.Publish("robots/robot1/commands", QoSAtLeastOnce, false, payload); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []command.AckState{command.AckAccepted, command.AckCompleted} {
+		select {
+		case ack := <-acks:
+			if ack.State != want || ack.CommandID != "cmd-2" {
+				t.Errorf("Got ack %+v after reconnect, want %s", ack, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s ack after reconnect", want)
+		}
+	}
+
+	if err := robot.Disconnect(); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	if death := nextStatus(); death.Status != simulation.StatusOffline || death.Reason != simulation.StatusReasonShutdown {
+		t.Errorf("Unexpected death message: %+v", death)
+	}
+	retained, ok := b.Retained("robots/robot1/status")
+	if !ok {
+		t.Fatal("Status is not retained")
+	}
+	var last simulation.StatusMessage
+	if err := json.Unmarshal(retained.Payload, &last); err != nil || last.Reason != simulation.StatusReasonShutdown {
+		t.Errorf("Unexpected retained status: %s", retained.Payload)
+	}
+	dispatcher.Wait()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestFailoverBetweenEmbeddedBrokers stops the primary broker under a connected robot:
+// the robot moves to the backup, announces itself there and still receives commands
+func TestFailoverBetweenEmbeddedBrokers(t *testing.T) {
+	primary, backup := broker.New(broker.DefaultConfig()), broker.New(broker.DefaultConfig())
+	for _, b := range []*broker.Broker{primary, backup} {
+		if err := b.Start("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		defer b.Close()
+	}
+
+	opts := DefaultOptions("robot1", primary.URL())
+	opts.Failover = failover.DefaultConfig(failover.Broker{URL: backup.URL(), Priority: 1})
+	opts.Failover.ProbeInterval = 50 * time.Millisecond
+	opts.Failover.ProbeTimeout = 100 * time.Millisecond
+	opts.Failover.FailbackDelay = 200 * time.Millisecond
+	robot, err := NewMQTTTelemetryClientWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := robot.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer robot.Disconnect()
+	commands := make(chan string, 1)
+	if err := robot.SubscribeToCommands(func(_ string, payload []byte) { commands <- string(payload) }); err != nil {
+		t.Fatal(err)
+	}
+	if stats := robot.ConnectionStats(); stats.Broker != primary.URL() {
+		t.Fatalf("Connected to %q, want the primary", stats.Broker)
+	}
+
+	primary.Close()
+	waitFor(t, func() bool { return robot.ConnectionStats().Broker == backup.URL() })
+	waitFor(t, func() bool {
+		_, ok := backup.Retained("robots/robot1/status")
+		return ok
+	})
+	if stats := robot.ConnectionStats(); stats.Failovers != 1 || stats.Reconnects != 1 {
+		t.Errorf("Unexpected connection stats: %+v", stats)
+	}
+	backup.Publish("robots/robot1/commands", 1, false, []byte("stop"))
+	select {
+	case cmd := <-commands:
+		if cmd != "stop" {
+			t.Errorf("Unexpected command %q", cmd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command subscription was not restored on the backup")
+	}
+
+	// Once the primary is back the robot fails back, leaving the backup with an offline
+	// status rather than a ghost of the connected robot
+	restarted := broker.New(broker.DefaultConfig())
+	if err := restarted.Start(strings.TrimPrefix(primary.URL(), "tcp://")); err != nil {
+		t.Fatal(err)
+	}
+	// Closed after the robot has disconnected, which the defers above do
+	t.Cleanup(func() { restarted.Close() })
+	waitFor(t, func() bool { return robot.ConnectionStats().Broker == primary.URL() })
+	waitFor(t, func() bool {
+		_, ok := restarted.Retained("robots/robot1/status")
+		return ok
+	})
+	retained, ok := backup.Retained("robots/robot1/status")
+	var last simulation.StatusMessage
+	if !ok || json.Unmarshal(retained.Payload, &last) != nil || last.Status != simulation.StatusOffline {
+		t.Errorf("Expected an offline status on the backup after fail-back, got %s", retained.Payload)
+	}
+}
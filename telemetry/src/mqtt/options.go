@@ -0,0 +1,292 @@
+package mqtt
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"telemetry/src/failover"
+	"telemetry/src/transport"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ErrInvalidOptions is wrapped by every option validation error
+var ErrInvalidOptions = errors.New("invalid mqtt options")
+
+// ClientIDScheme selects how the MQTT client ID is derived from the robot ID
+type ClientIDScheme string
+
+const (
+	// ClientIDRobot uses the robot ID as is
+	ClientIDRobot ClientIDScheme = "robot"
+	// ClientIDPrefixed uses "<ClientIDPrefix>-<robot ID>"
+	ClientIDPrefixed ClientIDScheme = "prefixed"
+	// ClientIDRandom appends a random suffix per client, for tools that may run twice
+	ClientIDRandom ClientIDScheme = "random"
+)
+
+// Options configures an MQTTTelemetryClient
+type Options struct {
+	RobotID   string
+	BrokerURL string
+
+	// TLS. CAFile verifies the broker; CertFile and KeyFile enable mutual TLS.
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+
+	Username string
+	Password string
+
+	KeepAlive            time.Duration
+	ConnectTimeout       time.Duration
+	MaxReconnectInterval time.Duration
+	CleanSession         bool
+
+	ClientIDScheme ClientIDScheme
+	ClientIDPrefix string
+
+	// TopicPrefix is the root of every topic, "robots" by default
+	TopicPrefix string
+
+	// Failover adds brokers to fall back on, ordered by priority. BrokerURL is the
+	// primary, with priority 0 and weight 1; other priority 0 brokers share the load
+	// with it. Leave Brokers and Discovery unset for a single broker.
+	Failover failover.Config
+}
+
+// DefaultOptions returns the settings the client has always used
+func DefaultOptions(robotID, brokerURL string) Options {
+	return Options{
+		RobotID:              robotID,
+		BrokerURL:            brokerURL,
+		KeepAlive:            30 * time.Second,
+		ConnectTimeout:       30 * time.Second,
+		MaxReconnectInterval: 10 * time.Minute,
+		CleanSession:         false,
+		ClientIDScheme:       ClientIDRobot,
+		TopicPrefix:          "robots",
+	}
+}
+
+var tlsSchemes = map[string]bool{"ssl": true, "tls": true, "mqtts": true, "tcps": true, "wss": true}
+var plainSchemes = map[string]bool{"tcp": true, "mqtt": true, "ws": true}
+
+// Validate reports missing settings and combinations that cannot work
+func (o Options) Validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", ErrInvalidOptions, fmt.Sprintf(format, args...))
+	}
+
+	if err := o.validateTopics(); err != nil {
+		return err
+	}
+	if o.BrokerURL == "" {
+		return invalid("broker URL is required")
+	}
+	usesTLS := o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" || o.ServerName != "" || o.InsecureSkipVerify
+	if err := validateBrokerURL(o.BrokerURL, usesTLS); err != nil {
+		return err
+	}
+	for _, b := range o.Failover.Brokers {
+		if err := validateBrokerURL(b.URL, usesTLS); err != nil {
+			return err
+		}
+		if b.Weight < 0 {
+			return invalid("broker %s has a negative weight", b.URL)
+		}
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return invalid("client certificate and key must be set together")
+	}
+	if o.InsecureSkipVerify && o.CAFile != "" {
+		return invalid("CA file is ignored when certificate verification is disabled")
+	}
+	if o.Password != "" && o.Username == "" {
+		return invalid("password set without username")
+	}
+
+	if o.KeepAlive < 0 || o.ConnectTimeout < 0 || o.MaxReconnectInterval < 0 {
+		return invalid("durations must not be negative")
+	}
+	if o.KeepAlive > 0 && o.KeepAlive < time.Second {
+		return invalid("keepalive %v is below the one second MQTT resolution", o.KeepAlive)
+	}
+
+	switch o.ClientIDScheme {
+	case "", ClientIDRobot:
+	case ClientIDPrefixed:
+		if o.ClientIDPrefix == "" {
+			return invalid("prefixed client IDs need a client ID prefix")
+		}
+	case ClientIDRandom:
+		if !o.CleanSession {
+			return invalid("random client IDs cannot resume a persistent session; enable clean session")
+		}
+	default:
+		return invalid("unknown client ID scheme %q", o.ClientIDScheme)
+	}
+	return nil
+}
+
+// validateBrokerURL checks a broker URL's scheme, and that TLS settings are only
+// combined with TLS brokers
+func validateBrokerURL(raw string, usesTLS bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%w: broker URL %q: %v", ErrInvalidOptions, raw, err)
+	}
+	if !tlsSchemes[u.Scheme] && !plainSchemes[u.Scheme] {
+		return fmt.Errorf("%w: unsupported broker URL scheme %q", ErrInvalidOptions, u.Scheme)
+	}
+	if usesTLS && !tlsSchemes[u.Scheme] {
+		return fmt.Errorf("%w: TLS settings require a TLS broker URL (ssl://, mqtts:// or wss://), got %s://", ErrInvalidOptions, u.Scheme)
+	}
+	return nil
+}
+
+// validateTopics checks the settings topics are built from, which every transport needs
+func (o Options) validateTopics() error {
+	if o.RobotID == "" {
+		return fmt.Errorf("%w: robot ID is required", ErrInvalidOptions)
+	}
+	if strings.ContainsAny(o.RobotID, "/+#") {
+		return fmt.Errorf("%w: robot ID %q must not contain topic separators or wildcards", ErrInvalidOptions, o.RobotID)
+	}
+	if strings.ContainsAny(o.TopicPrefix, "+#") || strings.HasPrefix(o.TopicPrefix, "/") || strings.HasSuffix(o.TopicPrefix, "/") {
+		return fmt.Errorf("%w: topic prefix %q must not contain wildcards or leading/trailing slashes", ErrInvalidOptions, o.TopicPrefix)
+	}
+	return nil
+}
+
+// ClientID returns the MQTT client ID for the configured scheme
+func (o Options) ClientID() string {
+	switch o.ClientIDScheme {
+	case ClientIDPrefixed:
+		return o.ClientIDPrefix + "-" + o.RobotID
+	case ClientIDRandom:
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		return o.RobotID + "-" + hex.EncodeToString(suffix)
+	}
+	return o.RobotID
+}
+
+// Topic joins parts below the topic prefix, e.g. Topic(id, "telemetry", "health")
+func (o Options) Topic(parts ...string) string {
+	if o.TopicPrefix == "" {
+		return strings.Join(parts, "/")
+	}
+	return o.TopicPrefix + "/" + strings.Join(parts, "/")
+}
+
+// TLSConfig builds the TLS configuration, or returns nil when TLS settings are unused
+func (o Options) TLSConfig() (*tls.Config, error) {
+	if o.CAFile == "" && o.CertFile == "" && o.ServerName == "" && !o.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: no certificates found in %s", ErrInvalidOptions, o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Transport builds the transport the options describe: MQTT over TCP or TLS, or MQTT
+// over WebSockets for ws:// and wss:// brokers, failing over between brokers when
+// Failover names any or discovers them
+func (o Options) Transport() (transport.Transport, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	if len(o.Failover.Brokers) == 0 && o.Failover.Discovery == nil {
+		return o.brokerTransport(o.BrokerURL, true)
+	}
+	config := o.Failover
+	config.Brokers = append([]failover.Broker{{URL: o.BrokerURL}}, o.Failover.Brokers...)
+	// The failover transport chooses the next broker itself, so paho must not reconnect
+	return failover.New(config, func(brokerURL string) (transport.Transport, error) {
+		return o.brokerTransport(brokerURL, false)
+	})
+}
+
+// brokerTransport builds the transport for a single broker
+func (o Options) brokerTransport(brokerURL string, autoReconnect bool) (transport.Transport, error) {
+	o.BrokerURL = brokerURL
+	opts, err := o.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts.SetAutoReconnect(autoReconnect)
+	if u, err := url.Parse(brokerURL); err == nil && (u.Scheme == "ws" || u.Scheme == "wss") {
+		return transport.NewWebSocket(opts, transport.WebSocketConfig{})
+	}
+	return transport.NewPaho(opts), nil
+}
+
+// clientOptions validates the options and builds the paho client options. It is the
+// only place paho options are assembled.
+func (o Options) clientOptions() (*mqtt.ClientOptions, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(o.BrokerURL).
+		SetClientID(o.ClientID()).
+		SetAutoReconnect(true).
+		SetCleanSession(o.CleanSession).
+		SetOrderMatters(false)
+
+	if o.KeepAlive > 0 {
+		opts.SetKeepAlive(o.KeepAlive)
+	}
+	if o.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.MaxReconnectInterval > 0 {
+		opts.SetMaxReconnectInterval(o.MaxReconnectInterval)
+	}
+	if o.Username != "" {
+		opts.SetUsername(o.Username)
+		opts.SetPassword(o.Password)
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	return opts, nil
+}
@@ -0,0 +1,126 @@
+package mqtt
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"telemetry/src/failover"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	base := DefaultOptions("robot1", "tcp://localhost:1883")
+	if err := base.Validate(); err != nil {
+		t.Fatalf("Default options should be valid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(o *Options)
+	}{
+		{"missing robot", func(o *Options) { o.RobotID = "" }},
+		{"wildcard robot", func(o *Options) { o.RobotID = "robot/+" }},
+		{"missing broker", func(o *Options) { o.BrokerURL = "" }},
+		{"bad scheme", func(o *Options) { o.BrokerURL = "http://localhost:1883" }},
+		{"tls over tcp", func(o *Options) { o.CAFile = "ca.pem" }},
+		{"cert without key", func(o *Options) { o.BrokerURL = "ssl://localhost:8883"; o.CertFile = "c.pem" }},
+		{"insecure with ca", func(o *Options) {
+			o.BrokerURL = "mqtts://localhost:8883"
+			o.CAFile = "ca.pem"
+			o.InsecureSkipVerify = true
+		}},
+		{"password only", func(o *Options) { o.Password = "secret" }},
+		{"negative timeout", func(o *Options) { o.ConnectTimeout = -time.Second }},
+		{"sub-second keepalive", func(o *Options) { o.KeepAlive = 500 * time.Millisecond }},
+		{"prefix scheme without prefix", func(o *Options) { o.ClientIDScheme = ClientIDPrefixed }},
+		{"random id with session", func(o *Options) { o.ClientIDScheme = ClientIDRandom }},
+		{"unknown scheme", func(o *Options) { o.ClientIDScheme = "uuid" }},
+		{"wildcard prefix", func(o *Options) { o.TopicPrefix = "fleet/#" }},
+		{"trailing slash prefix", func(o *Options) { o.TopicPrefix = "fleet/" }},
+		{"bad failover scheme", func(o *Options) { o.Failover.Brokers = []failover.Broker{{URL: "http://backup:1883"}} }},
+		{"negative failover weight", func(o *Options) {
+			o.Failover.Brokers = []failover.Broker{{URL: "tcp://backup:1883", Weight: -1}}
+		}},
+	}
+	for _, tt := range tests {
+		o := base
+		tt.modify(&o)
+		if err := o.Validate(); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%s: expected ErrInvalidOptions, got %v", tt.name, err)
+		}
+		if _, err := NewMQTTTelemetryClientWithOptions(o); err == nil {
+			t.Errorf("%s: expected client construction to fail", tt.name)
+		}
+	}
+}
+
+func TestOptionsClientIDAndTopics(t *testing.T) {
+	o := DefaultOptions("robot1", "tcp://localhost:1883")
+	if o.ClientID() != "robot1" {
+		t.Errorf("Unexpected client ID %q", o.ClientID())
+	}
+	if topic := o.Topic("robot1", "telemetry", "health"); topic != "robots/robot1/telemetry/health" {
+		t.Errorf("Unexpected topic %q", topic)
+	}
+
+	o.ClientIDScheme = ClientIDPrefixed
+	o.ClientIDPrefix = "site-a"
+	o.TopicPrefix = "site-a/robots"
+	if o.ClientID() != "site-a-robot1" {
+		t.Errorf("Unexpected prefixed client ID %q", o.ClientID())
+	}
+	if topic := o.Topic("robot1", "commands"); topic != "site-a/robots/robot1/commands" {
+		t.Errorf("Unexpected prefixed topic %q", topic)
+	}
+
+	o.ClientIDScheme = ClientIDRandom
+	o.CleanSession = true
+	a, b := o.ClientID(), o.ClientID()
+	if a == b || !strings.HasPrefix(a, "robot1-") {
+		t.Errorf("Expected distinct random client IDs, got %q and %q", a, b)
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	o := DefaultOptions("robot1", "ssl://broker:8883")
+	o.Username = "robot"
+	o.Password = "secret"
+	o.ServerName = "broker.local"
+	o.KeepAlive = 10 * time.Second
+
+	opts, err := o.clientOptions()
+	if err != nil {
+		t.Fatalf("clientOptions failed: %v", err)
+	}
+	if opts.Username != "robot" || opts.Password != "secret" || opts.ClientID != "robot1" {
+		t.Errorf("Unexpected credentials or client ID: %+v", opts)
+	}
+	if opts.KeepAlive != 10 || opts.CleanSession || !opts.AutoReconnect {
+		t.Errorf("Unexpected session settings: keepalive=%d clean=%v", opts.KeepAlive, opts.CleanSession)
+	}
+	if opts.TLSConfig == nil || opts.TLSConfig.ServerName != "broker.local" {
+		t.Errorf("Expected TLS config with server name, got %+v", opts.TLSConfig)
+	}
+
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	o.CAFile = ca
+	if _, err := o.clientOptions(); !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected invalid CA file error, got %v", err)
+	}
+}
+
+func TestPublishBeforeConnect(t *testing.T) {
+	c := NewMQTTTelemetryClient("robot1", "tcp://localhost:1883")
+	if err := c.PublishTelemetry("health", struct{}{}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+	if c.IsConnected() {
+		t.Error("Client should not report connected")
+	}
+}
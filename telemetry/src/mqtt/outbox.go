@@ -0,0 +1,102 @@
+package mqtt
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"telemetry/src/governor"
+	"telemetry/src/outbox"
+	"telemetry/src/policy"
+)
+
+// SetOutbox makes PublishTelemetry queue messages on disk while the broker is
+// unreachable and replay them after reconnecting. It must be called before Connect.
+func (m *MQTTTelemetryClient) SetOutbox(q *outbox.Queue) {
+	m.outbox = q
+}
+
+// OutboxStats returns the offline queue depth and counters, zero without an outbox
+func (m *MQTTTelemetryClient) OutboxStats() outbox.Stats {
+	if m.outbox == nil {
+		return outbox.Stats{}
+	}
+	return m.outbox.Stats()
+}
+
+// SetPolicy sets the per-type QoS, retain flag, queue priority and TTL used by
+// PublishTelemetry, replacing policy.DefaultTable. It must be called before Connect.
+func (m *MQTTTelemetryClient) SetPolicy(table policy.Table) {
+	m.policy = table
+}
+
+// SetGovernor keeps PublishTelemetry within an airtime budget, shedding messages by
+// their policy priority. Outbox replay is paced to the same budget rather than shed.
+// It must be called before Connect.
+func (m *MQTTTelemetryClient) SetGovernor(g *governor.Governor) {
+	m.governor = g
+}
+
+// GovernorStats returns what the bandwidth governor sent and shed per message type,
+// zero without a governor
+func (m *MQTTTelemetryClient) GovernorStats() governor.Stats {
+	if m.governor == nil {
+		return governor.Stats{}
+	}
+	return m.governor.Stats()
+}
+
+func (m *MQTTTelemetryClient) enqueue(topic string, p policy.Policy, payload []byte) error {
+	msg := outbox.Message{
+		Topic:     topic,
+		QoS:       p.QoS,
+		Retained:  p.Retain,
+		Priority:  p.Priority,
+		Timestamp: time.Now(),
+		Payload:   payload,
+	}
+	if p.TTL > 0 {
+		msg.Expires = msg.Timestamp.Add(p.TTL)
+	}
+	err := m.outbox.Enqueue(msg)
+	if err != nil {
+		return err
+	}
+	if m.IsConnected() {
+		m.startReplay()
+	}
+	return nil
+}
+
+// startReplay drains the outbox in the background unless a replay is already running
+func (m *MQTTTelemetryClient) startReplay() {
+	if m.outbox == nil || m.outbox.Depth() == 0 || !m.replaying.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer m.replaying.Store(false)
+		sent, err := m.outbox.Replay(context.Background(), m.publishQueued)
+		if err != nil {
+			m.log.Warn("Outbox replay stopped after %d messages: %v", sent, err)
+			return
+		}
+		if sent > 0 {
+			m.log.Info("Replayed %d queued messages", sent)
+		}
+	}()
+}
+
+func (m *MQTTTelemetryClient) publishQueued(msg outbox.Message) error {
+	if !m.IsConnected() {
+		return ErrNotConnected
+	}
+	if m.governor != nil {
+		// The message type is the last topic level
+		if err := m.governor.Wait(context.Background(), path.Base(msg.Topic), msg.Priority, len(msg.Topic)+len(msg.Payload)); err != nil {
+			return err
+		}
+	}
+	// The transport bounds each publish, so a connection that drops during replay
+	// stops it instead of blocking
+	return m.Publish(msg.Topic, msg.QoS, msg.Retained, msg.Payload)
+}
@@ -0,0 +1,199 @@
+package mqtt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"telemetry/src/envelope"
+	"telemetry/src/governor"
+	"telemetry/src/outbox"
+	"telemetry/src/policy"
+	"telemetry/src/transport"
+)
+
+func TestPublishQueuesWhileOffline(t *testing.T) {
+	q, err := outbox.Open(outbox.DefaultConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewMQTTTelemetryClient("robot1", "tcp://localhost:1883")
+	c.SetOutbox(q)
+
+	if err := c.PublishTelemetry("health", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("Expected the message to be queued, got %v", err)
+	}
+	if err := c.PublishTelemetry("lidar", map[string]int{"n": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.OutboxStats(); stats.Depth != 2 {
+		t.Errorf("Expected 2 queued messages, got %+v", stats)
+	}
+
+	msg, ok, err := q.Peek()
+	if err != nil || !ok {
+		t.Fatalf("Peek failed: %v", err)
+	}
+	if msg.Topic != "robots/robot1/telemetry/health" || msg.Priority != outbox.PriorityHealth {
+		t.Errorf("Unexpected queued message: %+v", msg)
+	}
+	decoded, err := envelope.NewDecoder().Decode(msg.Payload)
+	if err != nil {
+		t.Fatalf("Queued payload is not an envelope: %v", err)
+	}
+	if decoded.Seq != 1 || decoded.BootID != c.BootID() || string(decoded.Payload) != `{"n":1}` {
+		t.Errorf("Unexpected envelope: %+v", decoded.Envelope)
+	}
+}
+
+func TestPublishTelemetryFollowsPolicy(t *testing.T) {
+	bus := transport.NewBus()
+	c, err := NewMQTTTelemetryClientWithTransport(DefaultOptions("robot1", "tcp://unused:1883"), transport.NewMemory(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := policy.DefaultTable()
+	table.Types["lidar"] = policy.Policy{QoS: 0, Priority: outbox.PriorityRawSensor, TTL: time.Second}
+	c.SetPolicy(table)
+	q, err := outbox.Open(outbox.DefaultConfig(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetOutbox(q)
+
+	// Offline, messages are queued with their policy's QoS, retain flag and expiry
+	c.PublishTelemetry("lidar", map[string]int{"n": 1})
+	c.PublishTelemetry("navigation", map[string]int{"n": 2})
+	lidar, _, _ := q.Peek()
+	if lidar.QoS != 0 || lidar.Retained || lidar.Expires.Sub(lidar.Timestamp) != time.Second {
+		t.Errorf("Unexpected queued lidar message: %+v", lidar)
+	}
+
+	// Online, the latest position is retained and health is not
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return q.Depth() == 0 })
+	c.PublishTelemetry("health", map[string]int{"n": 3})
+	if _, ok := bus.Retained("robots/robot1/telemetry/navigation"); !ok {
+		t.Error("Navigation should be retained")
+	}
+	if _, ok := bus.Retained("robots/robot1/telemetry/health"); ok {
+		t.Error("Health should not be retained")
+	}
+}
+
+func TestPublishTelemetryShedsOverBudget(t *testing.T) {
+	bus := transport.NewBus()
+	c, err := NewMQTTTelemetryClientWithTransport(DefaultOptions("robot1", "tcp://unused:1883"), transport.NewMemory(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A budget far below what the test publishes, so only protected types get through
+	// once the burst is spent
+	g, err := governor.New(governor.DefaultConfig(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGovernor(g)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PublishTelemetry("lidar", map[string]int{"n": 1}); !errors.Is(err, governor.ErrShed) {
+		t.Errorf("Expected lidar to be shed, got %v", err)
+	}
+	if err := c.PublishTelemetry("estop", map[string]string{"reason": "bumper"}); err != nil {
+		t.Errorf("E-stops must never be shed: %v", err)
+	}
+	stats := c.GovernorStats()
+	if shed := stats.Shed(); len(shed) != 1 || shed["lidar"] != 1 {
+		t.Errorf("Unexpected shed report %v", shed)
+	}
+	if st := stats.Streams["estop"]; st.Sent != 1 || st.Priority != outbox.PriorityEStop {
+		t.Errorf("Unexpected e-stop stats %+v", st)
+	}
+}
+
+func TestQueuedMessagesAreChargedWhenReplayed(t *testing.T) {
+	bus := transport.NewBus()
+	c, err := NewMQTTTelemetryClientWithTransport(DefaultOptions("robot1", "tcp://unused:1883"), transport.NewMemory(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := governor.New(governor.DefaultConfig(100000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGovernor(g)
+	cfg := outbox.DefaultConfig(t.TempDir())
+	cfg.ReplayRate = 0
+	q, err := outbox.Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetOutbox(q)
+
+	// Offline nothing goes on the air, so nothing is charged
+	for i := 0; i < 3; i++ {
+		if err := c.PublishTelemetry("lidar", map[string]int{"n": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := c.GovernorStats().Streams["lidar"]; st.Sent != 0 || st.Shed != 0 {
+		t.Errorf("Queued messages were charged: %+v", st)
+	}
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return q.Depth() == 0 })
+	if st := c.GovernorStats().Streams["lidar"]; st.Sent != 3 || st.Shed != 0 || st.Priority != outbox.PriorityRawSensor {
+		t.Errorf("Expected the replay to be charged, got %+v", st)
+	}
+}
+
+func TestShedMessagesKeepSequenceContiguous(t *testing.T) {
+	bus := transport.NewBus()
+	c, err := NewMQTTTelemetryClientWithTransport(DefaultOptions("robot1", "tcp://unused:1883"), transport.NewMemory(bus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := governor.New(governor.Config{BytesPerSecond: 1, Burst: 400, Protected: outbox.PriorityAlert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetGovernor(g)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	observer := transport.NewMemory(bus)
+	observer.Connect()
+	decoder := envelope.NewDecoder()
+	var deliveries []envelope.Message
+	observer.Subscribe("robots/robot1/telemetry/lidar", 0, func(_ string, payload []byte) {
+		msg, err := decoder.Decode(payload)
+		if err != nil {
+			t.Errorf("Decode failed: %v", err)
+		}
+		deliveries = append(deliveries, msg)
+	})
+
+	// A scan over the budget is shed; the next ones must not look like a loss
+	if err := c.PublishTelemetry("lidar", map[string]string{"points": strings.Repeat("x", 1000)}); !errors.Is(err, governor.ErrShed) {
+		t.Fatalf("Expected the large scan to be shed, got %v", err)
+	}
+	for i := 1; i <= 2; i++ {
+		if err := c.PublishTelemetry("lidar", map[string]int{"n": i}); err != nil {
+			t.Fatalf("Scan %d: %v", i, err)
+		}
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("Expected 2 scans, got %d", len(deliveries))
+	}
+	for i, msg := range deliveries {
+		if msg.Seq != uint64(i+1) || msg.Delivery != envelope.InOrder {
+			t.Errorf("Scan %d: seq %d delivered %v", i+1, msg.Seq, msg.Delivery)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// SetBirthMessage sets the identity announced in the retained status message: software
+// version, capabilities and sensors. It must be called before Connect; RobotID is
+// always the client's robot and the status defaults to StatusOperational.
+func (m *MQTTTelemetryClient) SetBirthMessage(msg simulation.StatusMessage) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	if msg.Status == "" {
+		msg.Status = simulation.StatusOperational
+	}
+	msg.RobotID = m.robotID
+	m.birth = msg
+}
+
+// StatusTopic returns the retained liveness topic, robots/<id>/status
+func (m *MQTTTelemetryClient) StatusTopic() string {
+	return m.opts.Topic(m.robotID, "status")
+}
+
+// PublishStatus updates the status in the retained birth message and republishes it
+func (m *MQTTTelemetryClient) PublishStatus(status simulation.RobotStatus) error {
+	if m.Transport() == nil {
+		return ErrNotConnected
+	}
+	m.statusMu.Lock()
+	m.birth.Status = status
+	m.statusMu.Unlock()
+	return m.publishStatus(m.statusMessage(simulation.StatusReasonConnected))
+}
+
+// Disconnect publishes a graceful death message and closes the connection
+func (m *MQTTTelemetryClient) Disconnect() error {
+	t := m.Transport()
+	if t == nil {
+		return ErrNotConnected
+	}
+	if m.batcher != nil {
+		if err := m.batcher.Close(); err != nil {
+			m.log.Error("Failed to flush batched telemetry: %v", err)
+		}
+	}
+	death := m.statusMessage(simulation.StatusReasonShutdown)
+	death.Status = simulation.StatusOffline
+	err := m.publishStatus(death)
+	if err != nil {
+		m.log.Error("Failed to publish death message: %v", err)
+	}
+	t.Disconnect()
+	return err
+}
+
+// statusMessage returns a copy of the birth message stamped with the current time
+func (m *MQTTTelemetryClient) statusMessage(reason string) simulation.StatusMessage {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	msg := m.birth
+	msg.RobotID = m.robotID
+	if msg.Status == "" {
+		msg.Status = simulation.StatusOperational
+	}
+	msg.Timestamp = time.Now()
+	msg.Reason = reason
+	msg.BootID = m.sequencer.BootID()
+	msg.Capabilities = append([]string(nil), m.birth.Capabilities...)
+	msg.Sensors = append([]string(nil), m.birth.Sensors...)
+	return msg
+}
+
+// willPayload is registered with the broker at connect time, so its timestamp is the
+// time of the connection rather than of the drop
+func (m *MQTTTelemetryClient) willPayload() ([]byte, error) {
+	will := m.statusMessage(simulation.StatusReasonConnectionLost)
+	will.Status = simulation.StatusOffline
+	return json.Marshal(will)
+}
+
+// onConnect publishes the birth message, including after automatic reconnects since
+// the broker will have published the Last Will in between
+func (m *MQTTTelemetryClient) onConnect() {
+	if err := m.publishStatus(m.statusMessage(simulation.StatusReasonConnected)); err != nil {
+		m.log.Error("Failed to publish birth message: %v", err)
+		return
+	}
+	m.log.Debug("Published birth message on %s", m.StatusTopic())
+	m.startReplay()
+}
+
+func (m *MQTTTelemetryClient) publishStatus(msg simulation.StatusMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return m.Publish(m.StatusTopic(), QoSAtLeastOnce, true, payload)
+}
@@ -0,0 +1,65 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"telemetry/src/simulation"
+	"telemetry/src/transport"
+)
+
+func TestLastWillAndBirthMessage(t *testing.T) {
+	bus := transport.NewBus()
+	robot := transport.NewMemory(bus)
+	c, err := NewMQTTTelemetryClientWithTransport(DefaultOptions("robot1", ""), robot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetBirthMessage(simulation.StatusMessage{
+		RobotID:         "ignored",
+		SoftwareVersion: "1.2.3",
+		Capabilities:    []string{"navigation"},
+		Sensors:         []string{"lidar", "imu"},
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	retainedStatus := func() simulation.StatusMessage {
+		t.Helper()
+		payload, ok := bus.Retained("robots/robot1/status")
+		if !ok {
+			t.Fatal("Expected a retained status message")
+		}
+		var msg simulation.StatusMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Fatalf("Retained payload is not a status message: %v", err)
+		}
+		return msg
+	}
+	if birth := retainedStatus(); birth.Status != simulation.StatusOperational || birth.Reason != simulation.StatusReasonConnected {
+		t.Errorf("Unexpected retained birth message: %+v", birth)
+	}
+
+	robot.Drop()
+	will := retainedStatus()
+	if will.Status != simulation.StatusOffline || will.Reason != simulation.StatusReasonConnectionLost ||
+		will.RobotID != "robot1" || will.SoftwareVersion != "1.2.3" {
+		t.Errorf("Unexpected will payload: %+v", will)
+	}
+
+	birth := c.statusMessage(simulation.StatusReasonConnected)
+	if birth.Status != simulation.StatusOperational || birth.RobotID != "robot1" ||
+		len(birth.Sensors) != 2 || birth.Capabilities[0] != "navigation" || birth.Timestamp.IsZero() {
+		t.Errorf("Unexpected birth message: %+v", birth)
+	}
+
+	offline := NewMQTTTelemetryClient("robot1", "tcp://localhost:1883")
+	if err := offline.PublishStatus(simulation.StatusWarning); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+	if err := offline.Disconnect(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected from Disconnect, got %v", err)
+	}
+}
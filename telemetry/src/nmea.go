@@ -0,0 +1,326 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNMEAChecksum    = errors.New("nmea checksum mismatch")
+	ErrNMEAMalformed   = errors.New("malformed nmea sentence")
+	ErrNMEAUnsupported = errors.New("unsupported nmea sentence")
+)
+
+const knotsToMPS = 1852.0 / 3600.0
+
+// FixQuality is the GGA fix quality indicator
+type FixQuality int
+
+const (
+	FixInvalid   FixQuality = 0
+	FixGPS       FixQuality = 1
+	FixDGPS      FixQuality = 2
+	FixPPS       FixQuality = 3
+	FixRTK       FixQuality = 4
+	FixFloatRTK  FixQuality = 5
+	FixEstimated FixQuality = 6
+	FixManual    FixQuality = 7
+	FixSimulated FixQuality = 8
+)
+
+func (q FixQuality) String() string {
+	switch q {
+	case FixInvalid:
+		return "INVALID"
+	case FixGPS:
+		return "GPS"
+	case FixDGPS:
+		return "DGPS"
+	case FixPPS:
+		return "PPS"
+	case FixRTK:
+		return "RTK"
+	case FixFloatRTK:
+		return "FLOAT_RTK"
+	case FixEstimated:
+		return "ESTIMATED"
+	case FixManual:
+		return "MANUAL"
+	case FixSimulated:
+		return "SIMULATED"
+	}
+	return fmt.Sprintf("FixQuality(%d)", int(q))
+}
+
+// GGASentence carries fix data: position, altitude and fix quality
+type GGASentence struct {
+	Time       time.Duration // Time of day in UTC
+	Latitude   float64
+	Longitude  float64
+	Quality    FixQuality
+	Satellites int
+	HDOP       float64
+	Altitude   float64 // Meters above mean sea level
+	GeoidSep   float64 // Geoid separation in meters
+}
+
+// RMCSentence carries the recommended minimum navigation data
+type RMCSentence struct {
+	Time      time.Time
+	Valid     bool
+	Latitude  float64
+	Longitude float64
+	SpeedMPS  float64
+	CourseDeg float64
+}
+
+// VTGSentence carries course and speed over ground
+type VTGSentence struct {
+	CourseDeg float64
+	SpeedMPS  float64
+}
+
+// GSASentence carries dilution of precision and active satellites
+type GSASentence struct {
+	FixType    int // 1 no fix, 2 2D, 3 3D
+	Satellites []int
+	PDOP       float64
+	HDOP       float64
+	VDOP       float64
+}
+
+// ParseNMEA parses a single NMEA 0183 sentence, validating its checksum when present.
+// It returns a GGASentence, RMCSentence, VTGSentence or GSASentence.
+func ParseNMEA(line string) (interface{}, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return nil, ErrNMEAMalformed
+	}
+	body := line[1:]
+
+	if star := strings.LastIndexByte(body, '*'); star >= 0 {
+		want, err := strconv.ParseUint(body[star+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad checksum field", ErrNMEAMalformed)
+		}
+		body = body[:star]
+		var sum byte
+		for i := 0; i < len(body); i++ {
+			sum ^= body[i]
+		}
+		if sum != byte(want) {
+			return nil, ErrNMEAChecksum
+		}
+	}
+
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return nil, fmt.Errorf("%w: bad address %q", ErrNMEAMalformed, fields[0])
+	}
+
+	// The first two characters are the talker (GP, GN, GL, ...)
+	switch fields[0][2:] {
+	case "GGA":
+		return parseGGA(fields)
+	case "RMC":
+		return parseRMC(fields)
+	case "VTG":
+		return parseVTG(fields)
+	case "GSA":
+		return parseGSA(fields)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNMEAUnsupported, fields[0])
+}
+
+func parseGGA(f []string) (interface{}, error) {
+	if len(f) < 12 {
+		return nil, fmt.Errorf("%w: GGA has %d fields", ErrNMEAMalformed, len(f))
+	}
+	var s GGASentence
+	var err error
+	if s.Time, err = parseNMEATime(f[1]); err != nil {
+		return nil, err
+	}
+	if s.Latitude, err = parseNMEACoord(f[2], f[3], 2); err != nil {
+		return nil, err
+	}
+	if s.Longitude, err = parseNMEACoord(f[4], f[5], 3); err != nil {
+		return nil, err
+	}
+	quality, err := parseNMEAInt(f[6])
+	if err != nil {
+		return nil, err
+	}
+	s.Quality = FixQuality(quality)
+	if s.Satellites, err = parseNMEAInt(f[7]); err != nil {
+		return nil, err
+	}
+	if s.HDOP, err = parseNMEAFloat(f[8]); err != nil {
+		return nil, err
+	}
+	if s.Altitude, err = parseNMEAFloat(f[9]); err != nil {
+		return nil, err
+	}
+	if s.GeoidSep, err = parseNMEAFloat(f[11]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parseRMC(f []string) (interface{}, error) {
+	if len(f) < 10 {
+		return nil, fmt.Errorf("%w: RMC has %d fields", ErrNMEAMalformed, len(f))
+	}
+	var s RMCSentence
+	tod, err := parseNMEATime(f[1])
+	if err != nil {
+		return nil, err
+	}
+	s.Valid = f[2] == "A"
+	if s.Latitude, err = parseNMEACoord(f[3], f[4], 2); err != nil {
+		return nil, err
+	}
+	if s.Longitude, err = parseNMEACoord(f[5], f[6], 3); err != nil {
+		return nil, err
+	}
+	knots, err := parseNMEAFloat(f[7])
+	if err != nil {
+		return nil, err
+	}
+	s.SpeedMPS = knots * knotsToMPS
+	if s.CourseDeg, err = parseNMEAFloat(f[8]); err != nil {
+		return nil, err
+	}
+	if f[9] != "" {
+		date, err := time.Parse("020106", f[9])
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad date %q", ErrNMEAMalformed, f[9])
+		}
+		s.Time = date.Add(tod)
+	}
+	return s, nil
+}
+
+func parseVTG(f []string) (interface{}, error) {
+	if len(f) < 9 {
+		return nil, fmt.Errorf("%w: VTG has %d fields", ErrNMEAMalformed, len(f))
+	}
+	var s VTGSentence
+	var err error
+	if s.CourseDeg, err = parseNMEAFloat(f[1]); err != nil {
+		return nil, err
+	}
+	// Prefer the km/h field, falling back to knots
+	if f[7] != "" {
+		kmh, err := parseNMEAFloat(f[7])
+		if err != nil {
+			return nil, err
+		}
+		s.SpeedMPS = kmh / 3.6
+	} else {
+		knots, err := parseNMEAFloat(f[5])
+		if err != nil {
+			return nil, err
+		}
+		s.SpeedMPS = knots * knotsToMPS
+	}
+	return s, nil
+}
+
+func parseGSA(f []string) (interface{}, error) {
+	if len(f) < 18 {
+		return nil, fmt.Errorf("%w: GSA has %d fields", ErrNMEAMalformed, len(f))
+	}
+	var s GSASentence
+	var err error
+	if s.FixType, err = parseNMEAInt(f[2]); err != nil {
+		return nil, err
+	}
+	for _, prn := range f[3:15] {
+		if prn == "" {
+			continue
+		}
+		id, err := parseNMEAInt(prn)
+		if err != nil {
+			return nil, err
+		}
+		s.Satellites = append(s.Satellites, id)
+	}
+	if s.PDOP, err = parseNMEAFloat(f[15]); err != nil {
+		return nil, err
+	}
+	if s.HDOP, err = parseNMEAFloat(f[16]); err != nil {
+		return nil, err
+	}
+	if s.VDOP, err = parseNMEAFloat(f[17]); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseNMEATime parses hhmmss.ss into a time of day
+func parseNMEATime(field string) (time.Duration, error) {
+	if field == "" {
+		return 0, nil
+	}
+	if len(field) < 6 {
+		return 0, fmt.Errorf("%w: bad time %q", ErrNMEAMalformed, field)
+	}
+	h, err1 := strconv.Atoi(field[0:2])
+	m, err2 := strconv.Atoi(field[2:4])
+	sec, err3 := strconv.ParseFloat(field[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("%w: bad time %q", ErrNMEAMalformed, field)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(sec*float64(time.Second)), nil
+}
+
+// parseNMEACoord parses ddmm.mmmm / dddmm.mmmm with a hemisphere into signed degrees
+func parseNMEACoord(field, hemisphere string, degDigits int) (float64, error) {
+	if field == "" {
+		return 0, nil
+	}
+	if len(field) < degDigits+2 {
+		return 0, fmt.Errorf("%w: bad coordinate %q", ErrNMEAMalformed, field)
+	}
+	deg, err1 := strconv.Atoi(field[:degDigits])
+	minutes, err2 := strconv.ParseFloat(field[degDigits:], 64)
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("%w: bad coordinate %q", ErrNMEAMalformed, field)
+	}
+
+	value := float64(deg) + minutes/60.0
+	switch hemisphere {
+	case "N", "E":
+		return value, nil
+	case "S", "W":
+		return -value, nil
+	}
+	return 0, fmt.Errorf("%w: bad hemisphere %q", ErrNMEAMalformed, hemisphere)
+}
+
+func parseNMEAFloat(field string) (float64, error) {
+	if field == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad number %q", ErrNMEAMalformed, field)
+	}
+	return v, nil
+}
+
+func parseNMEAInt(field string) (int, error) {
+	if field == "" {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(field)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad integer %q", ErrNMEAMalformed, field)
+	}
+	return v, nil
+}
@@ -0,0 +1,279 @@
+package obstacles
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// ErrInvalidConfig is wrapped by every config validation error
+var ErrInvalidConfig = errors.New("invalid obstacle map config")
+
+// Validate checks the tile size, confidence parameters and intervals
+func (c Config) Validate() error {
+	switch {
+	case c.Root == "":
+		return fmt.Errorf("%w: root topic is required", ErrInvalidConfig)
+	case c.TileSize <= 0:
+		return fmt.Errorf("%w: tile size must be positive", ErrInvalidConfig)
+	case c.MatchRadius < 0:
+		return fmt.Errorf("%w: negative match radius", ErrInvalidConfig)
+	case c.Detection <= 0 || c.Detection > 1:
+		return fmt.Errorf("%w: detection %v outside (0, 1]", ErrInvalidConfig, c.Detection)
+	case c.Clearing < 0 || c.Clearing > 1:
+		return fmt.Errorf("%w: clearing %v outside [0, 1]", ErrInvalidConfig, c.Clearing)
+	case c.MinConfidence < 0 || c.MinConfidence >= 1:
+		return fmt.Errorf("%w: minimum confidence %v outside [0, 1)", ErrInvalidConfig, c.MinConfidence)
+	case c.TTL <= 0 || c.PublishInterval <= 0:
+		return fmt.Errorf("%w: TTL and publish interval must be positive", ErrInvalidConfig)
+	}
+	for name, ttl := range c.TTLs {
+		if ttl <= 0 {
+			return fmt.Errorf("%w: TTL for %s must be positive", ErrInvalidConfig, name)
+		}
+	}
+	return nil
+}
+
+// track is an obstacle with its confidence as of the last time it changed
+type track struct {
+	Obstacle
+	confidence float64
+	updated    time.Time
+}
+
+// since returns the later of now and the track's last update
+func (t *track) since(now time.Time) time.Time {
+	if t.updated.After(now) {
+		return t.updated
+	}
+	return now
+}
+
+func (t *track) confidenceAt(now time.Time, ttl time.Duration) float64 {
+	c := t.confidence - now.Sub(t.updated).Seconds()/ttl.Seconds()
+	return math.Max(0, c)
+}
+
+// Map is the merged obstacle map of a site. It is safe for concurrent use.
+type Map struct {
+	config Config
+	mu     sync.Mutex
+	tracks map[string]*track
+	nextID uint64
+	dirty  map[tileKey]bool // Tiles changed since they were last published
+}
+
+// NewMap creates an empty map
+func NewMap(config Config) (*Map, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Map{
+		config: config,
+		tracks: make(map[string]*track),
+		dirty:  make(map[tileKey]bool),
+	}, nil
+}
+
+// Apply merges a report taken at now: detections reinforce the obstacles they match
+// or add new ones, and obstacles the robot should have seen but did not lose
+// confidence. A report older than an obstacle's last update counts as of that update,
+// so it never winds the obstacle's decay back.
+func (m *Map) Apply(r Report, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	matched := make(map[string]bool)
+	for _, o := range r.Obstacles {
+		t := m.match(o, matched)
+		if t == nil {
+			m.nextID++
+			t = &track{Obstacle: Obstacle{
+				ID:        fmt.Sprintf("obstacle-%d", m.nextID),
+				Obstacle:  o,
+				FirstSeen: now,
+			}, updated: now}
+			m.tracks[t.ID] = t
+		} else {
+			m.reinforce(t, o, t.since(now))
+		}
+		at := t.since(now)
+		t.confidence = t.confidenceAt(at, m.config.ttl(t.Type))
+		t.confidence += (1 - t.confidence) * m.config.Detection
+		t.updated, t.LastSeen = at, at
+		t.addRobot(r.RobotID)
+		matched[t.ID] = true
+		m.dirty[m.config.tileOf(t.Position)] = true
+	}
+
+	if r.Range > 0 {
+		for id, t := range m.tracks {
+			if matched[id] || !visible(r, t.Position) {
+				continue
+			}
+			at := t.since(now)
+			t.confidence = t.confidenceAt(at, m.config.ttl(t.Type)) * (1 - m.config.Clearing)
+			t.updated = at
+			m.dirty[m.config.tileOf(t.Position)] = true
+		}
+	}
+	m.expire(now)
+}
+
+// match returns the nearest obstacle not yet matched in this report that o overlaps
+// or lies within the match radius of
+func (m *Map) match(o simulation.Obstacle, matched map[string]bool) *track {
+	var best *track
+	bestDistance := math.Inf(1)
+	for id, t := range m.tracks {
+		if matched[id] {
+			continue
+		}
+		d := distance(t.Position, o.Position)
+		if d > math.Max(m.config.MatchRadius, (t.Size+o.Size)/2) {
+			continue
+		}
+		if d < bestDistance || (d == bestDistance && id < best.ID) {
+			best, bestDistance = t, d
+		}
+	}
+	return best
+}
+
+// reinforce moves a known obstacle towards a new detection of it, weighting the two by
+// confidence
+func (m *Map) reinforce(t *track, o simulation.Obstacle, now time.Time) {
+	m.dirty[m.config.tileOf(t.Position)] = true
+	c := t.confidenceAt(now, m.config.ttl(t.Type))
+	w := m.config.Detection
+	blend := func(a, b float64) float64 { return (a*c + b*w) / (c + w) }
+	t.Position = simulation.Position{
+		X: blend(t.Position.X, o.Position.X),
+		Y: blend(t.Position.Y, o.Position.Y),
+		Z: blend(t.Position.Z, o.Position.Z),
+	}
+	t.Size = blend(t.Size, o.Size)
+	t.Type, t.Severity = o.Type, o.Severity
+}
+
+func (t *track) addRobot(robotID string) {
+	if robotID == "" {
+		return
+	}
+	for _, id := range t.SeenBy {
+		if id == robotID {
+			return
+		}
+	}
+	t.SeenBy = append(t.SeenBy, robotID)
+}
+
+// visible reports whether a scan should have detected an obstacle at p: it is within
+// range and field of view, and not behind anything the scan did detect
+func visible(r Report, p simulation.Position) bool {
+	d := distance(r.Pose, p)
+	if d > r.Range {
+		return false
+	}
+	if d == 0 {
+		return true
+	}
+	b := bearing(r.Pose, p)
+	if r.FieldOfView > 0 && r.FieldOfView < 360 && angleBetween(b, r.Heading) > r.FieldOfView/2 {
+		return false
+	}
+	for _, o := range r.Obstacles {
+		od := distance(r.Pose, o.Position)
+		if od == 0 || od >= d {
+			continue
+		}
+		shadow := math.Atan2(o.Size/2, od) * 180 / math.Pi
+		if angleBetween(bearing(r.Pose, o.Position), b) <= shadow {
+			return false
+		}
+	}
+	return true
+}
+
+// Expire removes obstacles whose confidence has decayed below the minimum
+func (m *Map) Expire(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+}
+
+func (m *Map) expire(now time.Time) {
+	for id, t := range m.tracks {
+		if t.confidenceAt(now, m.config.ttl(t.Type)) < m.config.MinConfidence {
+			delete(m.tracks, id)
+			m.dirty[m.config.tileOf(t.Position)] = true
+		}
+	}
+}
+
+// Obstacles returns every obstacle with its confidence at now, oldest first
+func (m *Map) Obstacles(now time.Time) []Obstacle {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.collect(now, func(*track) bool { return true })
+}
+
+// Near returns the obstacles whose edge is within radius of the path
+func (m *Map) Near(path []simulation.Position, radius float64, now time.Time) []Obstacle {
+	return NearPath(m.Obstacles(now), path, radius)
+}
+
+// Tile returns the obstacles of one tile, as published
+func (m *Map) Tile(x, y int, now time.Time) Tile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tile(tileKey{x, y}, now)
+}
+
+func (m *Map) tile(k tileKey, now time.Time) Tile {
+	return Tile{
+		X:         k.x,
+		Y:         k.y,
+		Timestamp: now,
+		Obstacles: m.collect(now, func(t *track) bool { return m.config.tileOf(t.Position) == k }),
+	}
+}
+
+func (m *Map) collect(now time.Time, keep func(*track) bool) []Obstacle {
+	obstacles := []Obstacle{}
+	for _, t := range m.tracks {
+		if !keep(t) {
+			continue
+		}
+		o := t.Obstacle
+		o.Confidence = t.confidenceAt(now, m.config.ttl(t.Type))
+		o.SeenBy = append([]string(nil), t.SeenBy...)
+		obstacles = append(obstacles, o)
+	}
+	sort.Slice(obstacles, func(i, j int) bool {
+		if !obstacles[i].FirstSeen.Equal(obstacles[j].FirstSeen) {
+			return obstacles[i].FirstSeen.Before(obstacles[j].FirstSeen)
+		}
+		return obstacles[i].ID < obstacles[j].ID
+	})
+	return obstacles
+}
+
+// changedTiles returns the tiles changed since the last call, as of now
+func (m *Map) changedTiles(now time.Time) []Tile {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(now)
+	tiles := make([]Tile, 0, len(m.dirty))
+	for k := range m.dirty {
+		tiles = append(tiles, m.tile(k, now))
+	}
+	m.dirty = make(map[tileKey]bool)
+	return tiles
+}
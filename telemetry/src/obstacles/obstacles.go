@@ -0,0 +1,196 @@
+// Package obstacles shares what robots detect across the fleet. Robots publish their
+// detections with a Publisher; a Service merges them into a global map, tracking a
+// confidence per obstacle that grows with every sighting, decays while nobody sees the
+// obstacle and drops when a robot looks at its position and finds free space. The map
+// is published as retained tiles so a robot can Watch the obstacles near its path
+// without receiving the whole site.
+//
+// Topics, below Config.Root:
+//
+//	<root>/reports/<robot id>    Report, from each robot
+//	<root>/tiles/<x>/<y>         Tile, retained, from the service
+package obstacles
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// Publisher is the messaging a robot needs to report obstacles
+type Publisher interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+}
+
+// Subscriber is the messaging a path watch needs
+type Subscriber interface {
+	Subscribe(topic string, qos byte, handler func(topic string, payload []byte)) error
+}
+
+// PubSub is the messaging the merge service needs: any transport.Transport, or an
+// MQTTTelemetryClient
+type PubSub interface {
+	Publisher
+	Subscriber
+}
+
+// obstacleQoS is used for reports and tiles
+const obstacleQoS = 1
+
+// Config is shared by robots and the merge service; Root and TileSize must match
+// across the fleet
+type Config struct {
+	Root     string
+	TileSize float64 // Metres
+
+	// MatchRadius is how close a detection must be to a known obstacle, in metres, to
+	// be the same object; obstacles larger than that match when they overlap
+	MatchRadius float64
+	// Detection is how much of the remaining doubt one sighting removes: a new obstacle
+	// starts at Detection and each further sighting moves it that share closer to 1
+	Detection float64
+	// Clearing is the share of confidence lost when a robot sees free space where an
+	// obstacle was
+	Clearing float64
+	// MinConfidence is the confidence below which an obstacle is removed
+	MinConfidence float64
+	// TTL is how long a fully confident obstacle survives without being seen;
+	// confidence decays linearly at 1/TTL per second. TTLs overrides it per obstacle
+	// type, e.g. for people and forklifts that move on.
+	TTL  time.Duration
+	TTLs map[string]time.Duration
+
+	// PublishInterval is how often the service publishes changed tiles
+	PublishInterval time.Duration
+}
+
+// DefaultConfig uses 10m tiles, merges detections within half a metre and lets static
+// obstacles linger for a minute and dynamic ones for ten seconds
+func DefaultConfig() Config {
+	return Config{
+		Root:            "fleet/obstacles",
+		TileSize:        10,
+		MatchRadius:     0.5,
+		Detection:       0.5,
+		Clearing:        0.5,
+		MinConfidence:   0.1,
+		TTL:             time.Minute,
+		TTLs:            map[string]time.Duration{"dynamic": 10 * time.Second},
+		PublishInterval: time.Second,
+	}
+}
+
+// Report is what one robot saw in one scan. Positions are in the shared site frame.
+type Report struct {
+	RobotID   string              `json:"robot_id"`
+	Timestamp time.Time           `json:"timestamp"`
+	Pose      simulation.Position `json:"pose"`
+	Heading   float64             `json:"heading"` // Degrees counterclockwise from the X axis
+	// Range is how far the scan saw, in metres: known obstacles in view that the scan
+	// did not detect are cleared. Zero clears nothing.
+	Range float64 `json:"range,omitempty"`
+	// FieldOfView is the scan's angular width in degrees, centred on Heading; zero is
+	// all round
+	FieldOfView float64               `json:"field_of_view,omitempty"`
+	Obstacles   []simulation.Obstacle `json:"obstacles"`
+}
+
+// Obstacle is an entry of the global map
+type Obstacle struct {
+	ID string `json:"id"`
+	simulation.Obstacle
+	Confidence float64   `json:"confidence"` // As of the tile's timestamp
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	SeenBy     []string  `json:"seen_by"` // Robots that detected it
+}
+
+// Tile is the retained message holding the obstacles of one map tile
+type Tile struct {
+	X         int        `json:"x"`
+	Y         int        `json:"y"`
+	Timestamp time.Time  `json:"timestamp"`
+	Obstacles []Obstacle `json:"obstacles"`
+}
+
+// tileKey identifies a map tile
+type tileKey struct{ x, y int }
+
+func (c Config) tileOf(p simulation.Position) tileKey {
+	return tileKey{int(math.Floor(p.X / c.TileSize)), int(math.Floor(p.Y / c.TileSize))}
+}
+
+// ReportTopic is where a robot publishes its reports
+func (c Config) ReportTopic(robotID string) string {
+	return c.Root + "/reports/" + robotID
+}
+
+// TileTopic is where the service publishes the tile with the given coordinates
+func (c Config) TileTopic(x, y int) string {
+	return c.Root + "/tiles/" + strconv.Itoa(x) + "/" + strconv.Itoa(y)
+}
+
+func (c Config) ttl(obstacleType string) time.Duration {
+	if ttl, ok := c.TTLs[obstacleType]; ok {
+		return ttl
+	}
+	return c.TTL
+}
+
+// distance is the distance between two positions in the ground plane
+func distance(a, b simulation.Position) float64 {
+	return math.Hypot(a.X-b.X, a.Y-b.Y)
+}
+
+// segmentDistance is the distance from p to the segment ab in the ground plane
+func segmentDistance(p, a, b simulation.Position) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length2 := dx*dx + dy*dy
+	if length2 == 0 {
+		return distance(p, a)
+	}
+	t := math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length2))
+	return math.Hypot(p.X-a.X-t*dx, p.Y-a.Y-t*dy)
+}
+
+// pathDistance is the distance from p to a path of waypoints
+func pathDistance(p simulation.Position, path []simulation.Position) float64 {
+	if len(path) == 1 {
+		return distance(p, path[0])
+	}
+	d := math.Inf(1)
+	for i := 1; i < len(path); i++ {
+		d = math.Min(d, segmentDistance(p, path[i-1], path[i]))
+	}
+	return d
+}
+
+// NearPath returns the obstacles whose edge is within radius of the path
+func NearPath(obstacles []Obstacle, path []simulation.Position, radius float64) []Obstacle {
+	var near []Obstacle
+	if len(path) == 0 {
+		return near
+	}
+	for _, o := range obstacles {
+		if pathDistance(o.Position, path)-o.Size/2 <= radius {
+			near = append(near, o)
+		}
+	}
+	return near
+}
+
+// bearing is the direction from a to b in degrees counterclockwise from the X axis
+func bearing(a, b simulation.Position) float64 {
+	return math.Atan2(b.Y-a.Y, b.X-a.X) * 180 / math.Pi
+}
+
+// angleBetween is the absolute difference between two directions in degrees
+func angleBetween(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	if d > 180 {
+		d = 360 - d
+	}
+	return d
+}
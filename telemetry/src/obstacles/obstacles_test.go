@@ -0,0 +1,251 @@
+package obstacles
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"telemetry/src/simulation"
+	"telemetry/src/transport"
+)
+
+var start = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func newTestMap(t *testing.T) *Map {
+	t.Helper()
+	m, err := NewMap(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func box(x, y float64) simulation.Obstacle {
+	return simulation.Obstacle{Position: simulation.Position{X: x, Y: y}, Size: 0.4, Type: "static", Severity: "HIGH"}
+}
+
+func TestMergeDeduplicatesAcrossRobots(t *testing.T) {
+	m := newTestMap(t)
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(5, 5)}}, start)
+	m.Apply(Report{RobotID: "r2", Obstacles: []simulation.Obstacle{box(5.2, 5), box(8, 8)}}, start.Add(time.Second))
+
+	obstacles := m.Obstacles(start.Add(time.Second))
+	if len(obstacles) != 2 {
+		t.Fatalf("Expected the shared box and a new one, got %+v", obstacles)
+	}
+	shared := obstacles[0]
+	if len(shared.SeenBy) != 2 || shared.SeenBy[0] != "r1" || shared.SeenBy[1] != "r2" {
+		t.Errorf("Shared box seen by %v", shared.SeenBy)
+	}
+	if shared.Position.X <= 5 || shared.Position.X >= 5.2 {
+		t.Errorf("Merged position %v should lie between the detections", shared.Position)
+	}
+	if shared.Confidence <= obstacles[1].Confidence {
+		t.Errorf("Two sightings should be more certain than one: %v vs %v", shared.Confidence, obstacles[1].Confidence)
+	}
+}
+
+func TestConfidenceDecays(t *testing.T) {
+	m := newTestMap(t)
+	people := simulation.Obstacle{Position: simulation.Position{X: 1, Y: 1}, Size: 0.5, Type: "dynamic"}
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(5, 5), people}}, start)
+
+	obstacles := m.Obstacles(start.Add(2 * time.Second))
+	if c := obstacles[1].Confidence; math.Abs(c-0.3) > 1e-9 {
+		t.Errorf("A dynamic obstacle should lose a tenth of full confidence a second, got %v", c)
+	}
+	// Dynamic obstacles expire first, static ones once their confidence is gone
+	m.Expire(start.Add(5 * time.Second))
+	if obstacles := m.Obstacles(start.Add(5 * time.Second)); len(obstacles) != 1 || obstacles[0].Type != "static" {
+		t.Errorf("Expected only the static obstacle, got %+v", obstacles)
+	}
+	m.Expire(start.Add(30 * time.Second))
+	if obstacles := m.Obstacles(start.Add(30 * time.Second)); len(obstacles) != 0 {
+		t.Errorf("Expected every obstacle to expire, got %+v", obstacles)
+	}
+}
+
+func TestFreeSpaceClears(t *testing.T) {
+	m := newTestMap(t)
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(5, 0), box(0, 5), box(9, 0)}}, start)
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(5, 0), box(0, 5), box(9, 0)}}, start)
+
+	// r2 looks along the X axis with a 90 degree scan: the box at (9, 0) is gone, the
+	// one at (0, 5) is outside the field of view
+	scan := Report{RobotID: "r2", Heading: 0, Range: 10, FieldOfView: 90, Obstacles: []simulation.Obstacle{}}
+	for i := 0; i < 3; i++ {
+		m.Apply(scan, start)
+	}
+	var ids []float64
+	for _, o := range m.Obstacles(start) {
+		ids = append(ids, o.Position.X)
+	}
+	if len(ids) != 1 || ids[0] != 0 {
+		t.Errorf("Expected only the box outside the field of view to remain, got x=%v", ids)
+	}
+
+	// An obstacle hidden behind a detected one is not cleared
+	m = newTestMap(t)
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(9, 0)}}, start)
+	scan.Obstacles = []simulation.Obstacle{{Position: simulation.Position{X: 3, Y: 0}, Size: 1, Type: "static"}}
+	for i := 0; i < 3; i++ {
+		m.Apply(scan, start)
+	}
+	if obstacles := m.Obstacles(start); len(obstacles) != 2 {
+		t.Errorf("Expected the occluded box to survive, got %+v", obstacles)
+	}
+}
+
+func TestNearPath(t *testing.T) {
+	m := newTestMap(t)
+	m.Apply(Report{RobotID: "r1", Obstacles: []simulation.Obstacle{box(5, 1), box(5, 3), box(12, 0.5)}}, start)
+	path := []simulation.Position{{X: 0, Y: 0}, {X: 10, Y: 0}}
+	near := m.Near(path, 1, start)
+	if len(near) != 1 || near[0].Position.X != 5 || near[0].Position.Y != 1 {
+		t.Errorf("Expected the box next to the path, got %+v", near)
+	}
+}
+
+func TestSharedMapOverMQTT(t *testing.T) {
+	bus := transport.NewBus()
+	connect := func() *transport.Memory {
+		m := transport.NewMemory(bus)
+		if err := m.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	config := DefaultConfig()
+	service, err := NewService(connect(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := start
+	service.now = func() time.Time { return now }
+	if err := service.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Robot 1 detects a box on robot 2's route and a pallet far away
+	r1 := NewReporter("r1", connect(), config)
+	source := r1.Wrap(fixedSource{box(15, 2), box(-40, -40)})
+	if _, err := source.DetectObstacles(simulation.Position{X: 12, Y: 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var seen []Obstacle
+	watch, err := NewWatch(connect(), config, 1, func(obstacles []Obstacle) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = obstacles
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watch.SetPath([]simulation.Position{{X: 0, Y: 2}, {X: 30, Y: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(seen) != 1 || len(seen[0].SeenBy) != 1 || seen[0].SeenBy[0] != "r1" {
+		t.Errorf("Expected the retained box on the path, got %+v", seen)
+	}
+	mu.Unlock()
+
+	// Robot 3 drives past and finds the box gone
+	r3 := NewReporter("r3", connect(), config)
+	r3.SetSensor(8, 0)
+	for i := 0; i < 3; i++ {
+		now = now.Add(100 * time.Millisecond)
+		r3.Report(simulation.Position{X: 10, Y: 2}, 0, nil)
+	}
+	now = now.Add(time.Second)
+	if err := service.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(seen) != 0 {
+		t.Errorf("Expected the cleared box to be gone, got %+v", seen)
+	}
+	mu.Unlock()
+
+	// A robot cannot report as another one
+	service.Handle(config.ReportTopic("r1"), []byte(`{"robot_id": "r3", "obstacles": [{"position": {"x": 20, "y": 2}, "size": 1}]}`))
+	if obstacles := service.Map().Obstacles(now); len(obstacles) != 1 {
+		t.Errorf("Expected only the far pallet, got %+v", obstacles)
+	}
+}
+
+// TestDelayedReports checks that reports count as of when they were taken, and that
+// redelivered ones are not counted twice
+func TestDelayedReports(t *testing.T) {
+	service, err := NewService(nil, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := start
+	service.now = func() time.Time { return now }
+	report := func(robotID string, taken time.Time, obstacles ...simulation.Obstacle) {
+		payload, _ := json.Marshal(Report{RobotID: robotID, Timestamp: taken, Obstacles: obstacles})
+		service.Handle(DefaultConfig().ReportTopic(robotID), payload)
+	}
+
+	report("r1", start, box(5, 5))
+	now = start.Add(30 * time.Second)
+	first := service.Map().Obstacles(now)[0].Confidence
+
+	// A sighting taken 20s ago and delivered late does not reset the decay since
+	report("r2", start.Add(10*time.Second), box(5, 5))
+	delayed := service.Map().Obstacles(now)[0]
+	if !delayed.LastSeen.Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Expected the delayed sighting's time, got %v", delayed.LastSeen)
+	}
+	now = start.Add(40 * time.Second)
+	report("r3", now, box(5, 5))
+	if fresh := service.Map().Obstacles(now)[0].Confidence; fresh <= delayed.Confidence {
+		t.Errorf("A fresh sighting should count more than a delayed one: %v vs %v", fresh, delayed.Confidence)
+	}
+	if delayed.Confidence <= first {
+		t.Errorf("A delayed sighting should still add confidence: %v vs %v", delayed.Confidence, first)
+	}
+
+	// Redelivered and out of order reports are dropped
+	before := service.Map().Obstacles(now)[0].Confidence
+	report("r3", now, box(5, 5))
+	report("r3", now.Add(-time.Second), box(5, 5))
+	if after := service.Map().Obstacles(now)[0].Confidence; after != before {
+		t.Errorf("Redelivered reports changed the confidence from %v to %v", before, after)
+	}
+	report("r1", now.Add(time.Hour), box(5, 5))
+	if seen := service.Map().Obstacles(now)[0].LastSeen; seen.After(now) {
+		t.Errorf("A report from the future should count as of now, got %v", seen)
+	}
+}
+
+type fixedSource []simulation.Obstacle
+
+func (s fixedSource) DetectObstacles(simulation.Position, float64) ([]simulation.Obstacle, error) {
+	return s, nil
+}
+
+func TestValidate(t *testing.T) {
+	for name, mutate := range map[string]func(*Config){
+		"no root":      func(c *Config) { c.Root = "" },
+		"no tiles":     func(c *Config) { c.TileSize = 0 },
+		"no detection": func(c *Config) { c.Detection = 0 },
+		"clearing":     func(c *Config) { c.Clearing = 2 },
+		"dynamic TTL":  func(c *Config) { c.TTLs["dynamic"] = 0 },
+	} {
+		config := DefaultConfig()
+		mutate(&config)
+		if _, err := NewMap(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected ErrInvalidConfig, got %v", name, err)
+		}
+	}
+}
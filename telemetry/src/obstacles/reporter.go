@@ -0,0 +1,81 @@
+package obstacles
+
+import (
+	"encoding/json"
+	"time"
+
+	"telemetry/include/logger"
+	"telemetry/src/simulation"
+)
+
+// Reporter publishes a robot's detections to the fleet
+type Reporter struct {
+	robotID     string
+	config      Config
+	publisher   Publisher
+	log         *logger.Logger
+	sensorRange float64
+	fieldOfView float64
+}
+
+// NewReporter creates a reporter for robotID. Until SetSensor is called its reports
+// clear nothing from the map.
+func NewReporter(robotID string, publisher Publisher, config Config) *Reporter {
+	return &Reporter{
+		robotID:   robotID,
+		config:    config,
+		publisher: publisher,
+		log:       logger.New(logger.INFO),
+	}
+}
+
+// SetSensor sets how far, in metres, and how wide, in degrees, the robot's scans see,
+// so the map can clear obstacles the robot looked at and did not detect. A zero field
+// of view is all round. It must be called before the first report.
+func (r *Reporter) SetSensor(sensorRange, fieldOfView float64) {
+	r.sensorRange = sensorRange
+	r.fieldOfView = fieldOfView
+}
+
+// Report publishes one scan's detections, including empty scans, which clear the
+// space in view
+func (r *Reporter) Report(pose simulation.Position, heading float64, obstacles []simulation.Obstacle) error {
+	if obstacles == nil {
+		obstacles = []simulation.Obstacle{}
+	}
+	payload, err := json.Marshal(Report{
+		RobotID:     r.robotID,
+		Timestamp:   time.Now(),
+		Pose:        pose,
+		Heading:     heading,
+		Range:       r.sensorRange,
+		FieldOfView: r.fieldOfView,
+		Obstacles:   obstacles,
+	})
+	if err != nil {
+		return err
+	}
+	return r.publisher.Publish(r.config.ReportTopic(r.robotID), obstacleQoS, false, payload)
+}
+
+// Wrap returns an obstacle source that reports every successful detection of source,
+// e.g. for MockRobot.SetObstacleSource
+func (r *Reporter) Wrap(source simulation.ObstacleSource) simulation.ObstacleSource {
+	return sharedSource{source: source, reporter: r}
+}
+
+type sharedSource struct {
+	source   simulation.ObstacleSource
+	reporter *Reporter
+}
+
+func (s sharedSource) DetectObstacles(pose simulation.Position, heading float64) ([]simulation.Obstacle, error) {
+	obstacles, err := s.source.DetectObstacles(pose, heading)
+	if err != nil {
+		return obstacles, err
+	}
+	if err := s.reporter.Report(pose, heading, obstacles); err != nil {
+		s.reporter.log.Warn("Failed to share obstacles of robot %s: %v", s.reporter.robotID, err)
+	}
+	return obstacles, nil
+}
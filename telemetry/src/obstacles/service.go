@@ -0,0 +1,113 @@
+package obstacles
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// Service merges the fleet's reports into a Map and publishes its changed tiles
+type Service struct {
+	config Config
+	pubsub PubSub
+	m      *Map
+	log    *logger.Logger
+	now    func() time.Time
+	mu     sync.Mutex
+	latest map[string]time.Time // Timestamp of each robot's last merged report
+}
+
+// NewService creates a merge service with an empty map
+func NewService(pubsub PubSub, config Config) (*Service, error) {
+	m, err := NewMap(config)
+	if err != nil {
+		return nil, err
+	}
+	return &Service{
+		config: config,
+		pubsub: pubsub,
+		m:      m,
+		log:    logger.New(logger.INFO),
+		now:    time.Now,
+		latest: make(map[string]time.Time),
+	}, nil
+}
+
+// Map returns the merged map
+func (s *Service) Map() *Map {
+	return s.m
+}
+
+// Start subscribes to the robots' reports
+func (s *Service) Start() error {
+	return s.pubsub.Subscribe(s.config.Root+"/reports/+", obstacleQoS, s.Handle)
+}
+
+// Handle merges one report; it is exported so other transports can feed the service
+// directly. The robot ID in the topic is authoritative. Reports count as of their
+// timestamp, capped at now, and one no newer than the robot's last report, e.g. a QoS 1
+// redelivery, is dropped.
+func (s *Service) Handle(topic string, payload []byte) {
+	var r Report
+	if err := json.Unmarshal(payload, &r); err != nil {
+		s.log.Warn("Dropping malformed obstacle report on %s: %v", topic, err)
+		return
+	}
+	robotID := topic[strings.LastIndex(topic, "/")+1:]
+	if r.RobotID != "" && r.RobotID != robotID {
+		s.log.Warn("Dropping obstacle report from %s published as %s", r.RobotID, robotID)
+		return
+	}
+	r.RobotID = robotID
+
+	at := s.now()
+	if !r.Timestamp.IsZero() && r.Timestamp.Before(at) {
+		at = r.Timestamp
+	}
+	s.mu.Lock()
+	if latest, ok := s.latest[robotID]; ok && !at.After(latest) {
+		s.mu.Unlock()
+		s.log.Debug("Dropping stale obstacle report from %s taken at %v", robotID, at)
+		return
+	}
+	s.latest[robotID] = at
+	s.mu.Unlock()
+	s.m.Apply(r, at)
+}
+
+// Run publishes changed tiles every publish interval until ctx is done
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PublishInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Publish(); err != nil {
+				s.log.Warn("Failed to publish obstacle tiles: %v", err)
+			}
+		}
+	}
+}
+
+// Publish expires decayed obstacles and publishes every tile that changed since the
+// last call as a retained message. A tile whose last obstacle went away is published
+// empty so late subscribers do not see it.
+func (s *Service) Publish() error {
+	var firstErr error
+	for _, tile := range s.m.changedTiles(s.now()) {
+		payload, err := json.Marshal(tile)
+		if err == nil {
+			err = s.pubsub.Publish(s.config.TileTopic(tile.X, tile.Y), obstacleQoS, true, payload)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,130 @@
+package obstacles
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+
+	"telemetry/include/logger"
+	"telemetry/src/simulation"
+)
+
+// Watch keeps a robot informed of the shared obstacles near its path. It subscribes to
+// the map tiles the path crosses; tiles stay subscribed after the path moves on, but
+// only obstacles near the current path are reported.
+type Watch struct {
+	config     Config
+	subscriber Subscriber
+	radius     float64
+	notify     func([]Obstacle)
+	log        *logger.Logger
+	mu         sync.Mutex
+	path       []simulation.Position
+	subscribed map[tileKey]bool
+	tiles      map[tileKey][]Obstacle
+}
+
+// NewWatch creates a watch calling notify with the obstacles whose edge is within
+// radius metres of the path, whenever a tile near it changes or the path is set.
+// Obstacles are filed under the tile of their centre, so one reaching into the
+// corridor from a tile the corridor does not cross is not seen.
+func NewWatch(subscriber Subscriber, config Config, radius float64, notify func([]Obstacle)) (*Watch, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Watch{
+		config:     config,
+		subscriber: subscriber,
+		radius:     radius,
+		notify:     notify,
+		log:        logger.New(logger.INFO),
+		subscribed: make(map[tileKey]bool),
+		tiles:      make(map[tileKey][]Obstacle),
+	}, nil
+}
+
+// SetPath replaces the watched path, a list of waypoints, subscribing to the tiles
+// around it
+func (w *Watch) SetPath(path []simulation.Position) error {
+	w.mu.Lock()
+	w.path = append([]simulation.Position(nil), path...)
+	var missing []tileKey
+	for _, k := range w.config.tilesAlong(path, w.radius) {
+		if !w.subscribed[k] {
+			w.subscribed[k] = true
+			missing = append(missing, k)
+		}
+	}
+	w.mu.Unlock()
+
+	// Retained tiles may be delivered during Subscribe, so subscribe without the lock
+	for i, k := range missing {
+		if err := w.subscriber.Subscribe(w.config.TileTopic(k.x, k.y), obstacleQoS, w.handle); err != nil {
+			w.mu.Lock()
+			for _, k := range missing[i:] {
+				delete(w.subscribed, k)
+			}
+			w.mu.Unlock()
+			return err
+		}
+	}
+	w.notify(w.Obstacles())
+	return nil
+}
+
+// Obstacles returns the obstacles near the current path
+func (w *Watch) Obstacles() []Obstacle {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.near()
+}
+
+func (w *Watch) near() []Obstacle {
+	var all []Obstacle
+	for _, obstacles := range w.tiles {
+		all = append(all, obstacles...)
+	}
+	return NearPath(all, w.path, w.radius)
+}
+
+func (w *Watch) handle(topic string, payload []byte) {
+	var tile Tile
+	if err := json.Unmarshal(payload, &tile); err != nil {
+		w.log.Warn("Dropping malformed obstacle tile on %s: %v", topic, err)
+		return
+	}
+	w.mu.Lock()
+	k := tileKey{tile.X, tile.Y}
+	if len(tile.Obstacles) == 0 {
+		delete(w.tiles, k)
+	} else {
+		w.tiles[k] = tile.Obstacles
+	}
+	near := w.near()
+	w.mu.Unlock()
+	w.notify(near)
+}
+
+// tilesAlong returns the tiles within margin of a path, by the bounding box of each
+// segment
+func (c Config) tilesAlong(path []simulation.Position, margin float64) []tileKey {
+	seen := make(map[tileKey]bool)
+	var tiles []tileKey
+	for i := range path {
+		a, b := path[i], path[i]
+		if i > 0 {
+			a = path[i-1]
+		}
+		lo := c.tileOf(simulation.Position{X: math.Min(a.X, b.X) - margin, Y: math.Min(a.Y, b.Y) - margin})
+		hi := c.tileOf(simulation.Position{X: math.Max(a.X, b.X) + margin, Y: math.Max(a.Y, b.Y) + margin})
+		for x := lo.x; x <= hi.x; x++ {
+			for y := lo.y; y <= hi.y; y++ {
+				if k := (tileKey{x, y}); !seen[k] {
+					seen[k] = true
+					tiles = append(tiles, k)
+				}
+			}
+		}
+	}
+	return tiles
+}
@@ -0,0 +1,381 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// Priority orders messages when the queue has to shed load; higher values are kept
+// longer
+type Priority int
+
+const (
+	PriorityRawSensor Priority = iota
+	PriorityNavigation
+	PriorityHealth
+	PriorityStatus
+	PriorityAlert
+	PriorityEStop
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityRawSensor:
+		return "raw_sensor"
+	case PriorityNavigation:
+		return "navigation"
+	case PriorityHealth:
+		return "health"
+	case PriorityStatus:
+		return "status"
+	case PriorityAlert:
+		return "alert"
+	case PriorityEStop:
+		return "estop"
+	}
+	return "priority_" + strconv.Itoa(int(p))
+}
+
+// ParsePriority returns the priority with the given name, e.g. "alert"
+func ParsePriority(name string) (Priority, error) {
+	for p := PriorityRawSensor; p <= PriorityEStop; p++ {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", name)
+}
+
+// ErrQueueFull is returned when a message is dropped because everything queued has a
+// higher priority
+var ErrQueueFull = errors.New("outbox full")
+
+// Message is a publish waiting for the broker. Payloads are stored as they were when
+// first published, so timestamps inside them are the original ones.
+type Message struct {
+	Seq       uint64    `json:"seq"`
+	Topic     string    `json:"topic"`
+	QoS       byte      `json:"qos"`
+	Retained  bool      `json:"retained,omitempty"`
+	Priority  Priority  `json:"priority"`
+	Timestamp time.Time `json:"timestamp"` // When the message was first published
+	// Expires is when the message becomes too old to be worth sending; zero never
+	Expires time.Time `json:"expires,omitempty"`
+	Payload []byte    `json:"payload"`
+}
+
+// Config bounds the queue and its replay rate
+type Config struct {
+	Dir         string
+	MaxMessages int
+	MaxBytes    int64
+	ReplayRate  float64 // Messages per second; zero replays unthrottled
+}
+
+// DefaultConfig returns limits suited to a robot SD card
+func DefaultConfig(dir string) Config {
+	return Config{
+		Dir:         dir,
+		MaxMessages: 10000,
+		MaxBytes:    64 << 20,
+		ReplayRate:  20,
+	}
+}
+
+// Stats describes the queue for metrics
+type Stats struct {
+	Depth    int
+	Bytes    int64
+	Dropped  uint64
+	Replayed uint64
+	Expired  uint64 // Discarded because they were past their expiry
+}
+
+type entry struct {
+	seq      uint64
+	priority Priority
+	size     int64
+	expires  time.Time
+}
+
+// Queue is a disk-backed FIFO of messages, one file per message. When a limit is hit
+// expired messages are discarded first, then the oldest message of the lowest priority
+// is dropped to make room.
+type Queue struct {
+	config  Config
+	log     *logger.Logger
+	mu      sync.Mutex
+	entries []entry // Ordered by seq
+	bytes   int64
+	nextSeq uint64
+	stats   Stats
+	replay  sync.Mutex
+}
+
+// Open opens the queue in cfg.Dir, creating it if needed and loading messages left from
+// a previous run
+func Open(cfg Config) (*Queue, error) {
+	if cfg.Dir == "" || cfg.MaxMessages <= 0 || cfg.MaxBytes <= 0 {
+		return nil, fmt.Errorf("outbox needs a directory and positive limits")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{config: cfg, log: logger.New(logger.INFO), nextSeq: 1}
+	files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.msg"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		msg, size, err := readMessage(path)
+		if err != nil {
+			q.log.Warn("Discarding unreadable outbox message %s: %v", path, err)
+			os.Remove(path)
+			continue
+		}
+		q.entries = append(q.entries, entry{seq: msg.Seq, priority: msg.Priority, size: size, expires: msg.Expires})
+		q.bytes += size
+		if msg.Seq >= q.nextSeq {
+			q.nextSeq = msg.Seq + 1
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+
+	// Leftover temporary files are writes interrupted by a crash
+	tmps, _ := filepath.Glob(filepath.Join(cfg.Dir, "*.tmp"))
+	for _, path := range tmps {
+		os.Remove(path)
+	}
+	if len(q.entries) > 0 {
+		q.log.Info("Outbox loaded %d queued messages", len(q.entries))
+	}
+	return q, nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.config.Dir, fmt.Sprintf("%020d.msg", seq))
+}
+
+// Enqueue appends a message, assigning its sequence number. The timestamp defaults
+// to now.
+func (q *Queue) Enqueue(msg Message) error {
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg.Seq = q.nextSeq
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > q.config.MaxBytes {
+		q.stats.Dropped++
+		return fmt.Errorf("%w: message of %d bytes exceeds the queue size", ErrQueueFull, size)
+	}
+
+	full := func() bool { return len(q.entries)+1 > q.config.MaxMessages || q.bytes+size > q.config.MaxBytes }
+	if full() {
+		q.expireLocked(time.Now())
+	}
+	for full() {
+		victim := q.victim(msg.Priority)
+		if victim < 0 {
+			q.stats.Dropped++
+			return fmt.Errorf("%w: dropped %s message for %s", ErrQueueFull, msg.Priority, msg.Topic)
+		}
+		dropped := q.entries[victim]
+		q.log.Debug("Outbox full, dropping %s message %d", dropped.priority, dropped.seq)
+		q.removeLocked(victim)
+		q.stats.Dropped++
+	}
+
+	// Write then rename so a crash never leaves a partial message behind
+	path := q.path(msg.Seq)
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.entries = append(q.entries, entry{seq: msg.Seq, priority: msg.Priority, size: size, expires: msg.Expires})
+	q.bytes += size
+	return nil
+}
+
+// expireLocked discards messages past their expiry at now, so they never cost a
+// message that is still worth sending its place
+func (q *Queue) expireLocked(now time.Time) {
+	for i := 0; i < len(q.entries); {
+		if e := q.entries[i]; !e.expires.IsZero() && now.After(e.expires) {
+			q.log.Debug("Outbox full, discarding expired %s message %d", e.priority, e.seq)
+			q.removeLocked(i)
+			q.stats.Expired++
+			continue
+		}
+		i++
+	}
+}
+
+// victim returns the index of the oldest message with the lowest priority not above p,
+// or -1 when everything queued outranks p
+func (q *Queue) victim(p Priority) int {
+	best := -1
+	for i, e := range q.entries {
+		if e.priority > p {
+			continue
+		}
+		if best < 0 || e.priority < q.entries[best].priority {
+			best = i
+		}
+	}
+	return best
+}
+
+func (q *Queue) removeLocked(i int) {
+	e := q.entries[i]
+	if err := os.Remove(q.path(e.seq)); err != nil && !os.IsNotExist(err) {
+		q.log.Warn("Failed to remove outbox message %d: %v", e.seq, err)
+	}
+	q.bytes -= e.size
+	q.entries = append(q.entries[:i], q.entries[i+1:]...)
+}
+
+// Peek returns the oldest queued message
+func (q *Queue) Peek() (Message, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) == 0 {
+		return Message{}, false, nil
+	}
+	msg, _, err := readMessage(q.path(q.entries[0].seq))
+	if err != nil {
+		return Message{}, false, err
+	}
+	return msg, true, nil
+}
+
+// Remove deletes a message once it has been delivered. It may already have been dropped.
+func (q *Queue) Remove(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range q.entries {
+		if e.seq == seq {
+			q.removeLocked(i)
+			return
+		}
+	}
+}
+
+// Depth returns the number of queued messages
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Stats returns the queue depth and counters
+func (q *Queue) Stats() Stats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = len(q.entries)
+	stats.Bytes = q.bytes
+	return stats
+}
+
+// Replay publishes queued messages oldest first at the configured rate until the queue
+// is empty, publish fails or ctx is done. A message is only removed once publish
+// succeeds, so a failed one is retried on the next replay. Expired messages are
+// discarded instead of sent late. Only one replay runs at a time; concurrent calls
+// wait for it.
+func (q *Queue) Replay(ctx context.Context, publish func(Message) error) (int, error) {
+	q.replay.Lock()
+	defer q.replay.Unlock()
+
+	var tick <-chan time.Time
+	if q.config.ReplayRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / q.config.ReplayRate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sent := 0
+	for {
+		msg, ok, err := q.Peek()
+		if err != nil {
+			// Drop the unreadable message rather than blocking the queue behind it
+			q.log.Error("Discarding unreadable outbox message: %v", err)
+			q.dropHead()
+			continue
+		}
+		if !ok {
+			return sent, nil
+		}
+		if !msg.Expires.IsZero() && time.Now().After(msg.Expires) {
+			q.Remove(msg.Seq)
+			q.mu.Lock()
+			q.stats.Expired++
+			q.mu.Unlock()
+			continue
+		}
+		if err := publish(msg); err != nil {
+			return sent, err
+		}
+		q.Remove(msg.Seq)
+		sent++
+		q.mu.Lock()
+		q.stats.Replayed++
+		q.mu.Unlock()
+
+		if tick != nil {
+			select {
+			case <-ctx.Done():
+				return sent, ctx.Err()
+			case <-tick:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+	}
+}
+
+func (q *Queue) dropHead() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.entries) > 0 {
+		q.removeLocked(0)
+		q.stats.Dropped++
+	}
+}
+
+func readMessage(path string) (Message, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Message{}, 0, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, 0, err
+	}
+	if want := strings.TrimSuffix(filepath.Base(path), ".msg"); want != fmt.Sprintf("%020d", msg.Seq) {
+		return Message{}, 0, fmt.Errorf("sequence %d does not match file name", msg.Seq)
+	}
+	return msg, int64(len(data)), nil
+}
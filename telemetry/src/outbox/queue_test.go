@@ -0,0 +1,202 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQueuePersistsAndReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig(dir)
+	cfg.ReplayRate = 200
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := q.Enqueue(Message{
+			Topic:     "robots/r1/telemetry/health",
+			QoS:       1,
+			Priority:  PriorityHealth,
+			Timestamp: first.Add(time.Duration(i) * time.Second),
+			Payload:   []byte(fmt.Sprintf(`{"n":%d}`, i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restart must find the same messages
+	q, err = Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Depth() != 5 {
+		t.Fatalf("Expected 5 messages after reopening, got %d", q.Depth())
+	}
+	if err := q.Enqueue(Message{Topic: "t", Payload: []byte(`{"n":5}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The broker goes away after two messages; the rest stay queued
+	var got []Message
+	failAfter := 2
+	publish := func(msg Message) error {
+		if len(got) == failAfter {
+			return errors.New("broker unreachable")
+		}
+		got = append(got, msg)
+		return nil
+	}
+	if sent, err := q.Replay(context.Background(), publish); err == nil || sent != 2 {
+		t.Fatalf("Expected replay to stop after 2, got %d (%v)", sent, err)
+	}
+	failAfter = -1
+	start := time.Now()
+	if sent, err := q.Replay(context.Background(), publish); err != nil || sent != 4 {
+		t.Fatalf("Expected 4 more, got %d (%v)", sent, err)
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Replay was not throttled: 4 messages in %v", elapsed)
+	}
+
+	for i, msg := range got {
+		if string(msg.Payload) != fmt.Sprintf(`{"n":%d}`, i) {
+			t.Errorf("Message %d out of order: %s", i, msg.Payload)
+		}
+	}
+	if !got[1].Timestamp.Equal(first.Add(time.Second)) {
+		t.Errorf("Original timestamp lost: %v", got[1].Timestamp)
+	}
+	if stats := q.Stats(); stats.Depth != 0 || stats.Bytes != 0 || stats.Replayed != 6 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("Expected an empty directory, found %v", files)
+	}
+}
+
+func TestQueueDropsOldestLowestPriority(t *testing.T) {
+	cfg := DefaultConfig(t.TempDir())
+	cfg.MaxMessages = 3
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enqueue := func(p Priority, name string) error {
+		return q.Enqueue(Message{Topic: name, Priority: p, Payload: []byte("{}")})
+	}
+	enqueue(PriorityHealth, "health-1")
+	enqueue(PriorityRawSensor, "raw-1")
+	enqueue(PriorityRawSensor, "raw-2")
+	// Full: the oldest raw sensor message makes room
+	if err := enqueue(PriorityAlert, "alert-1"); err != nil {
+		t.Fatal(err)
+	}
+	// Then the remaining raw sensor message, then the health message
+	enqueue(PriorityAlert, "alert-2")
+	enqueue(PriorityEStop, "estop-1")
+	// Nothing queued ranks at or below raw sensor data any more, so it is refused
+	if err := enqueue(PriorityRawSensor, "raw-3"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+
+	var topics []string
+	q.Replay(context.Background(), func(msg Message) error {
+		topics = append(topics, msg.Topic)
+		return nil
+	})
+	if fmt.Sprint(topics) != "[alert-1 alert-2 estop-1]" {
+		t.Errorf("Unexpected survivors: %v", topics)
+	}
+	if dropped := q.Stats().Dropped; dropped != 4 {
+		t.Errorf("Expected 4 drops, got %d", dropped)
+	}
+}
+
+func TestQueueSkipsCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(DefaultConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Enqueue(Message{Topic: "a", Payload: []byte("{}")})
+	os.WriteFile(filepath.Join(dir, "00000000000000000002.msg"), []byte("{trunc"), 0o644)
+	os.WriteFile(filepath.Join(dir, "00000000000000000003.msg.tmp"), []byte("{}"), 0o644)
+
+	q, err = Open(DefaultConfig(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Depth() != 1 {
+		t.Errorf("Expected only the valid message, got %d", q.Depth())
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+		t.Errorf("Expected corrupt and temporary files to be removed, found %v", files)
+	}
+}
+
+func TestQueueDiscardsExpiredMessages(t *testing.T) {
+	cfg := DefaultConfig(t.TempDir())
+	cfg.ReplayRate = 0
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	q.Enqueue(Message{Topic: "heartbeat", Expires: now.Add(-time.Second), Payload: []byte("stale")})
+	q.Enqueue(Message{Topic: "health", Expires: now.Add(time.Hour), Payload: []byte("fresh")})
+	q.Enqueue(Message{Topic: "alert", Payload: []byte("forever")})
+
+	var got []string
+	sent, err := q.Replay(context.Background(), func(msg Message) error {
+		got = append(got, string(msg.Payload))
+		return nil
+	})
+	if err != nil || sent != 2 {
+		t.Fatalf("Expected 2 sent, got %d (%v)", sent, err)
+	}
+	if len(got) != 2 || got[0] != "fresh" || got[1] != "forever" {
+		t.Errorf("Replayed %v", got)
+	}
+	if stats := q.Stats(); stats.Expired != 1 || stats.Replayed != 2 || stats.Depth != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestQueueExpiresBeforeDroppingFreshMessages(t *testing.T) {
+	cfg := DefaultConfig(t.TempDir())
+	cfg.MaxMessages = 3
+	q, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	q.Enqueue(Message{Topic: "health-1", Priority: PriorityHealth, Payload: []byte("{}")})
+	q.Enqueue(Message{Topic: "heartbeat-1", Priority: PriorityStatus, Expires: now.Add(-time.Second), Payload: []byte("{}")})
+	q.Enqueue(Message{Topic: "heartbeat-2", Priority: PriorityStatus, Expires: now.Add(-time.Second), Payload: []byte("{}")})
+	// The stale heartbeats outrank health but are worthless, so they make room
+	if err := q.Enqueue(Message{Topic: "health-2", Priority: PriorityHealth, Payload: []byte("{}")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var topics []string
+	q.Replay(context.Background(), func(msg Message) error {
+		topics = append(topics, msg.Topic)
+		return nil
+	})
+	if fmt.Sprint(topics) != "[health-1 health-2]" {
+		t.Errorf("Unexpected survivors: %v", topics)
+	}
+	if stats := q.Stats(); stats.Expired != 2 || stats.Dropped != 0 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
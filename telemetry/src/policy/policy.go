@@ -0,0 +1,166 @@
+// Package policy decides how each telemetry message type is delivered: its MQTT QoS,
+// whether the broker retains the latest one, its priority in the offline queue and
+// how long a queued message stays worth sending
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"telemetry/src/outbox"
+)
+
+// ErrInvalidPolicy is wrapped by every policy validation error
+var ErrInvalidPolicy = errors.New("invalid delivery policy")
+
+// Policy is how one message type is delivered
+type Policy struct {
+	QoS      byte
+	Retain   bool
+	Priority outbox.Priority
+	// TTL is how long a queued message may wait for the broker before it is discarded
+	// rather than sent late; zero keeps it until sent
+	TTL time.Duration
+}
+
+// Table maps message types to policies
+type Table struct {
+	Default Policy
+	Types   map[string]Policy
+}
+
+// DefaultTable sends heartbeats at QoS 0, alerts and e-stops at QoS 2, retains the
+// latest status and position, and expires heartbeats and navigation that would only
+// arrive stale
+func DefaultTable() Table {
+	return Table{
+		Default: Policy{QoS: 1, Priority: outbox.PriorityRawSensor},
+		Types: map[string]Policy{
+			"heartbeat":  {QoS: 0, Priority: outbox.PriorityStatus, TTL: 10 * time.Second},
+			"status":     {QoS: 1, Retain: true, Priority: outbox.PriorityStatus},
+			"health":     {QoS: 1, Priority: outbox.PriorityHealth, TTL: time.Hour},
+			"navigation": {QoS: 1, Retain: true, Priority: outbox.PriorityNavigation, TTL: time.Minute},
+			"alert":      {QoS: 2, Priority: outbox.PriorityAlert},
+			"estop":      {QoS: 2, Priority: outbox.PriorityEStop},
+		},
+	}
+}
+
+// For returns the policy for messageType, or the default one
+func (t Table) For(messageType string) Policy {
+	if p, ok := t.Types[messageType]; ok {
+		return p
+	}
+	return t.Default
+}
+
+// Validate checks every policy's QoS and TTL
+func (t Table) Validate() error {
+	check := func(name string, p Policy) error {
+		if p.QoS > 2 {
+			return fmt.Errorf("%w: %s: QoS %d", ErrInvalidPolicy, name, p.QoS)
+		}
+		if p.TTL < 0 {
+			return fmt.Errorf("%w: %s: negative TTL", ErrInvalidPolicy, name)
+		}
+		return nil
+	}
+	if err := check("default", t.Default); err != nil {
+		return err
+	}
+	for name, p := range t.Types {
+		if err := check(name, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileEntry is a policy as written in the config file; unset fields keep the value
+// they override
+type fileEntry struct {
+	QoS      *byte   `json:"qos"`
+	Retain   *bool   `json:"retain"`
+	Priority *string `json:"priority"`
+	TTL      *string `json:"ttl"` // A Go duration such as "30s"; "0" keeps messages until sent
+}
+
+type file struct {
+	Default *fileEntry           `json:"default"`
+	Types   map[string]fileEntry `json:"types"`
+}
+
+func (e fileEntry) apply(p Policy) (Policy, error) {
+	if e.QoS != nil {
+		p.QoS = *e.QoS
+	}
+	if e.Retain != nil {
+		p.Retain = *e.Retain
+	}
+	if e.Priority != nil {
+		priority, err := outbox.ParsePriority(*e.Priority)
+		if err != nil {
+			return p, err
+		}
+		p.Priority = priority
+	}
+	if e.TTL != nil {
+		ttl, err := time.ParseDuration(*e.TTL)
+		if err != nil {
+			return p, err
+		}
+		p.TTL = ttl
+	}
+	return p, nil
+}
+
+// Parse reads a JSON policy file over DefaultTable. Listed types override the
+// built-in policy for that type field by field, and listed types without a built-in
+// policy start from the file's default. Built-in types the file does not list keep
+// their built-in policy; any other type gets the file's default. For example:
+//
+//	{
+//	  "default": {"qos": 1, "priority": "raw_sensor"},
+//	  "types": {
+//	    "heartbeat": {"qos": 0, "ttl": "5s"},
+//	    "lidar": {"qos": 0, "ttl": "2s"}
+//	  }
+//	}
+func Parse(data []byte) (Table, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Table{}, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	table := DefaultTable()
+	if f.Default != nil {
+		p, err := f.Default.apply(table.Default)
+		if err != nil {
+			return Table{}, fmt.Errorf("%w: default: %v", ErrInvalidPolicy, err)
+		}
+		table.Default = p
+	}
+	for name, entry := range f.Types {
+		base, ok := table.Types[name]
+		if !ok {
+			base = table.Default
+		}
+		p, err := entry.apply(base)
+		if err != nil {
+			return Table{}, fmt.Errorf("%w: %s: %v", ErrInvalidPolicy, name, err)
+		}
+		table.Types[name] = p
+	}
+	return table, table.Validate()
+}
+
+// Load reads a policy file; see Parse
+func Load(path string) (Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Table{}, err
+	}
+	return Parse(data)
+}
@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"telemetry/src/outbox"
+)
+
+func TestDefaultTable(t *testing.T) {
+	table := DefaultTable()
+	if err := table.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if p := table.For("heartbeat"); p.QoS != 0 || p.Retain {
+		t.Errorf("Unexpected heartbeat policy %+v", p)
+	}
+	if p := table.For("alert"); p.QoS != 2 || p.Priority != outbox.PriorityAlert {
+		t.Errorf("Unexpected alert policy %+v", p)
+	}
+	if p := table.For("navigation"); !p.Retain {
+		t.Errorf("The latest position should be retained: %+v", p)
+	}
+	if p := table.For("lidar"); p != table.Default {
+		t.Errorf("Unknown types should get the default policy, got %+v", p)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{
+		"default": {"qos": 0, "ttl": "5s"},
+		"types": {
+			"heartbeat": {"ttl": "3s"},
+			"lidar": {"priority": "navigation"},
+			"health": {"qos": 2, "retain": true, "ttl": "0"}
+		}
+	}`), 0o644)
+	table, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Policy{
+		// Overridden field by field over the built-in policy
+		"heartbeat": {QoS: 0, Priority: outbox.PriorityStatus, TTL: 3 * time.Second},
+		"health":    {QoS: 2, Retain: true, Priority: outbox.PriorityHealth},
+		// New types start from the file's default
+		"lidar": {QoS: 0, Priority: outbox.PriorityNavigation, TTL: 5 * time.Second},
+		// Types the file leaves out keep the built-in policy
+		"alert":   {QoS: 2, Priority: outbox.PriorityAlert},
+		"unknown": {QoS: 0, Priority: outbox.PriorityRawSensor, TTL: 5 * time.Second},
+	}
+	for name, p := range want {
+		if got := table.For(name); got != p {
+			t.Errorf("%s: got %+v, want %+v", name, got, p)
+		}
+	}
+}
+
+func TestParseRejectsInvalidPolicies(t *testing.T) {
+	for _, data := range []string{
+		`{"types": {"alert": {"qos": 3}}}`,
+		`{"types": {"alert": {"priority": "urgent"}}}`,
+		`{"default": {"ttl": "soon"}}`,
+		`{"default": {"ttl": "-1s"}}`,
+		`{"types": []}`,
+	} {
+		if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalidPolicy) {
+			t.Errorf("%s: expected ErrInvalidPolicy, got %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package rate
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// decode returns a sample's JSON fields
+func decode(sample interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// lookup returns the field at a dotted path, or nil if it is absent
+func lookup(fields map[string]interface{}, path string) interface{} {
+	var v interface{} = fields
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
+
+// changed reports whether any field with a deadband differs between two samples by
+// more than its deadband
+func changed(last, fields map[string]interface{}, deadbands map[string]float64) bool {
+	for path, band := range deadbands {
+		if differs(lookup(last, path), lookup(fields, path), band) {
+			return true
+		}
+	}
+	return false
+}
+
+// differs compares numbers against the deadband, arrays and objects element by
+// element, and anything else for equality
+func differs(a, b interface{}, band float64) bool {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		return !ok || math.Abs(a-b) > band
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return true
+		}
+		for i := range a {
+			if differs(a[i], b[i], band) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return true
+		}
+		for k := range a {
+			if differs(a[k], b[k], band) {
+				return true
+			}
+		}
+		return false
+	}
+	return !reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,301 @@
+// Package rate adapts telemetry publish rates to the robot's state: idle robots report
+// less often, robots in trouble more often. Streams with deadbands are sampled at the
+// adaptive rate but only reported when a field changes by more than its deadband, with
+// a keep-alive so subscribers still hear from the robot at a minimum rate.
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// ErrInvalidConfig is wrapped by every config validation error
+var ErrInvalidConfig = errors.New("invalid rate config")
+
+// State is what the publish rates depend on
+type State struct {
+	Moving     bool
+	Status     simulation.RobotStatus
+	BatteryPct float64
+}
+
+// Rates are a stream's sampling intervals in each robot state. Zero intervals other
+// than Base fall back to the next applicable one.
+type Rates struct {
+	Base       time.Duration // Moving, operational and charged
+	Idle       time.Duration // Not moving
+	LowBattery time.Duration // Used when longer than the idle or base interval
+	Warning    time.Duration // Status WARNING, or ERROR without an Error interval
+	Error      time.Duration // Status ERROR
+}
+
+// Stream configures one telemetry stream
+type Stream struct {
+	Rates Rates
+	// Deadbands lists the fields whose changes are reported, by JSON name with dots for
+	// nested fields, e.g. "position.x". A sample is reported when any listed field
+	// changes by more than its deadband; zero reports any change and non-numeric fields
+	// are reported whenever they differ. Without deadbands every sample is reported.
+	Deadbands map[string]float64
+	// KeepAlive is the longest a stream with deadbands stays silent
+	KeepAlive time.Duration
+}
+
+// Config configures a Controller
+type Config struct {
+	Streams map[string]Stream
+	// LowBatteryPct is the charge below which the LowBattery intervals apply; zero
+	// disables them
+	LowBatteryPct float64
+}
+
+// DefaultConfig samples health every 2s and navigation every 1s while moving, slows
+// both down when idle or low on battery, speeds them up on WARNING and ERROR, and only
+// reports navigation when the robot has moved
+func DefaultConfig() Config {
+	return Config{
+		LowBatteryPct: 20,
+		Streams: map[string]Stream{
+			"health": {
+				Rates: Rates{
+					Base:       2 * time.Second,
+					Idle:       5 * time.Second,
+					LowBattery: 10 * time.Second,
+					Warning:    time.Second,
+					Error:      500 * time.Millisecond,
+				},
+				Deadbands: map[string]float64{
+					"cpu_temperature":    2,
+					"motor_temperatures": 2,
+					"voltage_level":      0.2,
+					"current_draw":       0.5,
+					"error_codes":        0,
+					"throttled":          0,
+					"outbox_depth":       100,
+				},
+				KeepAlive: 30 * time.Second,
+			},
+			"navigation": {
+				Rates: Rates{
+					Base:       time.Second,
+					Idle:       10 * time.Second,
+					LowBattery: 2 * time.Second,
+					Warning:    500 * time.Millisecond,
+					Error:      250 * time.Millisecond,
+				},
+				Deadbands: map[string]float64{
+					"position.x":  0.1,
+					"position.y":  0.1,
+					"heading":     5,
+					"velocity":    0.1,
+					"path_status": 0,
+				},
+				KeepAlive: 10 * time.Second,
+			},
+		},
+	}
+}
+
+// Validate checks that every stream has a base interval and that streams reporting on
+// change have a keep-alive
+func (c Config) Validate() error {
+	for name, s := range c.Streams {
+		r := s.Rates
+		if r.Base <= 0 {
+			return fmt.Errorf("%w: %s: base interval must be positive", ErrInvalidConfig, name)
+		}
+		if r.Idle < 0 || r.LowBattery < 0 || r.Warning < 0 || r.Error < 0 || s.KeepAlive < 0 {
+			return fmt.Errorf("%w: %s: negative interval", ErrInvalidConfig, name)
+		}
+		for field, band := range s.Deadbands {
+			if band < 0 {
+				return fmt.Errorf("%w: %s: negative deadband for %s", ErrInvalidConfig, name, field)
+			}
+		}
+		if len(s.Deadbands) > 0 && s.KeepAlive == 0 {
+			return fmt.Errorf("%w: %s: reporting on change needs a keep-alive", ErrInvalidConfig, name)
+		}
+	}
+	return nil
+}
+
+// interval returns the rates' sampling interval in state s
+func (r Rates) interval(s State, lowBatteryPct float64) time.Duration {
+	if s.Status == simulation.StatusError && r.Error > 0 {
+		return r.Error
+	}
+	if (s.Status == simulation.StatusError || s.Status == simulation.StatusWarning) && r.Warning > 0 {
+		return r.Warning
+	}
+	interval := r.Base
+	if !s.Moving && r.Idle > 0 {
+		interval = r.Idle
+	}
+	if s.BatteryPct < lowBatteryPct && r.LowBattery > interval {
+		interval = r.LowBattery
+	}
+	return interval
+}
+
+// Reason is why a sample is reported
+type Reason string
+
+const (
+	ReasonFirst     Reason = "first"
+	ReasonState     Reason = "state_change" // The robot state changed since the last report
+	ReasonOverride  Reason = "override"     // The rate was set explicitly
+	ReasonInterval  Reason = "interval"     // The stream has no deadbands
+	ReasonChange    Reason = "change"
+	ReasonKeepAlive Reason = "keep_alive"
+)
+
+// streamState is what a Controller remembers about a stream's last report
+type streamState struct {
+	override time.Duration
+	last     map[string]interface{}
+	lastSent time.Time
+	version  uint64 // State version at the last report
+}
+
+// Controller decides how often each stream is sampled and which samples are reported.
+// It is safe for concurrent use.
+type Controller struct {
+	config  Config
+	mu      sync.Mutex
+	state   State
+	version uint64
+	streams map[string]*streamState
+	updates []chan struct{}
+}
+
+// NewController creates a controller for an operational, moving and charged robot
+func NewController(config Config) (*Controller, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Controller{
+		config:  config,
+		state:   State{Moving: true, Status: simulation.StatusOperational, BatteryPct: 100},
+		streams: make(map[string]*streamState),
+	}, nil
+}
+
+// SetState updates the robot state, reporting whether it changed. The next sample of
+// every stream is reported after a change.
+func (c *Controller) SetState(s State) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A battery level change alone only matters when it crosses the threshold
+	low := func(s State) bool { return s.BatteryPct < c.config.LowBatteryPct }
+	if s.Moving == c.state.Moving && s.Status == c.state.Status && low(s) == low(c.state) {
+		c.state = s
+		return false
+	}
+	c.state = s
+	c.version++
+	c.notify()
+	return true
+}
+
+// State returns the current robot state
+func (c *Controller) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// Override pins a stream's sampling interval regardless of the robot state and reports
+// every sample; zero returns the stream to adaptive rates
+func (c *Controller) Override(stream string, interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stream(stream).override = interval
+	c.notify()
+}
+
+// Interval returns how often a stream should be sampled in the current state, or zero
+// for a stream that is neither configured nor overridden
+func (c *Controller) Interval(stream string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st, ok := c.streams[stream]; ok && st.override > 0 {
+		return st.override
+	}
+	s, ok := c.config.Streams[stream]
+	if !ok {
+		return 0
+	}
+	return s.Rates.interval(c.state, c.config.LowBatteryPct)
+}
+
+// Updates returns a channel signalled when the state or an override changes a stream's
+// interval, so a publishing loop can reschedule without waiting for its current tick.
+// Each call returns a new channel; every loop should call it once.
+func (c *Controller) Updates() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	updates := make(chan struct{}, 1)
+	c.updates = append(c.updates, updates)
+	return updates
+}
+
+// Report decides whether a sample taken at now should be published, and records it as
+// the stream's last report if so. Samples are compared through their JSON encoding.
+func (c *Controller) Report(stream string, sample interface{}, now time.Time) (Reason, bool) {
+	config := c.config.Streams[stream]
+	var fields map[string]interface{}
+	if len(config.Deadbands) > 0 {
+		var err error
+		if fields, err = decode(sample); err != nil {
+			// Report what cannot be compared rather than lose it
+			return ReasonInterval, true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.stream(stream)
+	var reason Reason
+	switch {
+	case st.lastSent.IsZero():
+		reason = ReasonFirst
+	case st.version != c.version:
+		reason = ReasonState
+	case st.override > 0:
+		reason = ReasonOverride
+	case len(config.Deadbands) == 0:
+		reason = ReasonInterval
+	case changed(st.last, fields, config.Deadbands):
+		reason = ReasonChange
+	case now.Sub(st.lastSent) >= config.KeepAlive:
+		reason = ReasonKeepAlive
+	default:
+		return "", false
+	}
+	st.last = fields
+	st.lastSent = now
+	st.version = c.version
+	return reason, true
+}
+
+func (c *Controller) stream(name string) *streamState {
+	st, ok := c.streams[name]
+	if !ok {
+		st = &streamState{version: c.version}
+		c.streams[name] = st
+	}
+	return st
+}
+
+func (c *Controller) notify() {
+	for _, updates := range c.updates {
+		select {
+		case updates <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package rate
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+func TestIntervalFollowsState(t *testing.T) {
+	c, err := NewController(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		state      State
+		health     time.Duration
+		navigation time.Duration
+	}{
+		{State{Moving: true, Status: simulation.StatusOperational, BatteryPct: 80}, 2 * time.Second, time.Second},
+		{State{Moving: false, Status: simulation.StatusOperational, BatteryPct: 80}, 5 * time.Second, 10 * time.Second},
+		// Low battery slows moving robots down, but never speeds idle ones up
+		{State{Moving: true, Status: simulation.StatusOperational, BatteryPct: 10}, 10 * time.Second, 2 * time.Second},
+		{State{Moving: false, Status: simulation.StatusOperational, BatteryPct: 10}, 10 * time.Second, 10 * time.Second},
+		// Trouble overrides everything else
+		{State{Moving: false, Status: simulation.StatusWarning, BatteryPct: 10}, time.Second, 500 * time.Millisecond},
+		{State{Moving: false, Status: simulation.StatusError, BatteryPct: 80}, 500 * time.Millisecond, 250 * time.Millisecond},
+	} {
+		c.SetState(tc.state)
+		if got := c.Interval("health"); got != tc.health {
+			t.Errorf("%+v: health every %v, want %v", tc.state, got, tc.health)
+		}
+		if got := c.Interval("navigation"); got != tc.navigation {
+			t.Errorf("%+v: navigation every %v, want %v", tc.state, got, tc.navigation)
+		}
+	}
+	if got := c.Interval("lidar"); got != 0 {
+		t.Errorf("Unconfigured stream every %v", got)
+	}
+
+	c.Override("health", 100*time.Millisecond)
+	if got := c.Interval("health"); got != 100*time.Millisecond {
+		t.Errorf("Overridden health every %v", got)
+	}
+	c.Override("health", 0)
+	if got := c.Interval("health"); got != 500*time.Millisecond {
+		t.Errorf("Health every %v after clearing the override", got)
+	}
+}
+
+func TestReportOnChange(t *testing.T) {
+	c, err := NewController(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every publishing loop hears about state changes
+	healthUpdates, navUpdates := c.Updates(), c.Updates()
+	start := time.Now()
+	nav := simulation.NavigationMessage{RobotID: "r1", Position: simulation.Position{X: 1}, PathStatus: "CLEAR"}
+	report := func(at time.Duration, msg simulation.NavigationMessage, want Reason, wantOK bool) {
+		t.Helper()
+		msg.Timestamp = start.Add(at)
+		reason, ok := c.Report("navigation", msg, start.Add(at))
+		if ok != wantOK || reason != want {
+			t.Errorf("At %v: got %q, %t, want %q, %t", at, reason, ok, want, wantOK)
+		}
+	}
+	report(0, nav, ReasonFirst, true)
+	// Jitter within the deadband is not reported, even as it accumulates slowly
+	nav.Position.X = 1.05
+	report(time.Second, nav, "", false)
+	nav.Position.X = 1.15
+	report(2*time.Second, nav, ReasonChange, true)
+	nav.PathStatus = "BLOCKED"
+	report(3*time.Second, nav, ReasonChange, true)
+	// A stationary robot is still heard from at the keep-alive rate
+	report(12*time.Second, nav, "", false)
+	report(13*time.Second, nav, ReasonKeepAlive, true)
+
+	// A state change is reported straight away
+	if !c.SetState(State{Status: simulation.StatusWarning, BatteryPct: 80}) {
+		t.Fatal("Expected a state change")
+	}
+	for _, updates := range []<-chan struct{}{healthUpdates, navUpdates} {
+		select {
+		case <-updates:
+		default:
+			t.Error("Expected an update signal")
+		}
+	}
+	report(14*time.Second, nav, ReasonState, true)
+	report(15*time.Second, nav, "", false)
+	if c.SetState(State{Status: simulation.StatusWarning, BatteryPct: 70}) {
+		t.Error("A battery change above the threshold is not a state change")
+	}
+
+	// Streams without deadbands report every sample
+	config := DefaultConfig()
+	config.Streams["navigation"] = Stream{Rates: Rates{Base: time.Second}}
+	c, _ = NewController(config)
+	report(0, nav, ReasonFirst, true)
+	report(time.Second, nav, ReasonInterval, true)
+}
+
+func TestReportComparesArrays(t *testing.T) {
+	c, _ := NewController(DefaultConfig())
+	now := time.Now()
+	health := simulation.HealthMessage{MotorTemps: []float64{50, 48}, VoltageLevel: 12}
+	c.Report("health", health, now)
+	health.MotorTemps = []float64{51, 48}
+	if _, ok := c.Report("health", health, now); ok {
+		t.Error("A motor temperature change within the deadband was reported")
+	}
+	health.MotorTemps = []float64{51, 48, 40}
+	if reason, ok := c.Report("health", health, now); !ok || reason != ReasonChange {
+		t.Errorf("A new motor was not reported: %q", reason)
+	}
+	health.ErrorCodes = []string{"E_OVERCURRENT"}
+	if reason, ok := c.Report("health", health, now); !ok || reason != ReasonChange {
+		t.Errorf("A new error code was not reported: %q", reason)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, stream := range map[string]Stream{
+		"no base":       {Rates: Rates{Idle: time.Second}},
+		"negative":      {Rates: Rates{Base: time.Second, Error: -time.Second}},
+		"no keep-alive": {Rates: Rates{Base: time.Second}, Deadbands: map[string]float64{"x": 1}},
+		"negative band": {Rates: Rates{Base: time.Second}, Deadbands: map[string]float64{"x": -1}, KeepAlive: time.Second},
+	} {
+		config := Config{Streams: map[string]Stream{"s": stream}}
+		if _, err := NewController(config); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("%s: expected ErrInvalidConfig, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"telemetry/include/logger"
+)
+
+// Client makes calls to RPC servers. Every call gets its own reply topic below the
+// client's reply root, and all of them are served by one wildcard subscription.
+type Client struct {
+	replyRoot  string
+	pubsub     PubSub
+	log        *logger.Logger
+	mu         sync.Mutex
+	subscribed bool
+	calls      map[string]*pendingCall
+}
+
+// maxPendingReplies bounds the replies buffered for a caller that is not reading
+const maxPendingReplies = 1024
+
+// pendingCall buffers the replies of one call, so a slow caller never blocks the
+// subscription delivering them
+type pendingCall struct {
+	mu      sync.Mutex
+	replies []Response
+	overrun bool
+	ready   chan struct{} // Signalled when replies are added
+}
+
+func (p *pendingCall) push(resp Response) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.overrun || len(p.replies) >= maxPendingReplies {
+		p.overrun = true
+	} else {
+		p.replies = append(p.replies, resp)
+	}
+	select {
+	case p.ready <- struct{}{}:
+	default:
+	}
+	return !p.overrun
+}
+
+func (p *pendingCall) take() ([]Response, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	replies := p.replies
+	p.replies = nil
+	return replies, p.overrun
+}
+
+// NewClient creates a client receiving replies below replyRoot, e.g.
+// fleet/controller-1/rpc. The root must be unique to this client.
+func NewClient(replyRoot string, pubsub PubSub) *Client {
+	return &Client{
+		replyRoot: replyRoot,
+		pubsub:    pubsub,
+		log:       logger.New(logger.INFO),
+		calls:     make(map[string]*pendingCall),
+	}
+}
+
+// Call invokes method on the server below root and decodes the single result into
+// result, which may be nil. The call is abandoned when ctx is done.
+func (c *Client) Call(ctx context.Context, root, method string, params, result interface{}) error {
+	received := false
+	err := c.Stream(ctx, root, method, params, func(raw json.RawMessage) error {
+		received = true
+		if result == nil || len(raw) == 0 {
+			return nil
+		}
+		return json.Unmarshal(raw, result)
+	})
+	if err == nil && !received {
+		return ErrStreamClosed
+	}
+	return err
+}
+
+// Stream invokes method and calls fn with each streamed result in order. It returns
+// after the final result, the first error from fn or the remote handler, or when ctx
+// is done.
+func (c *Client) Stream(ctx context.Context, root, method string, params interface{}, fn func(result json.RawMessage) error) error {
+	if err := c.subscribe(); err != nil {
+		return err
+	}
+
+	req := Request{ID: newCorrelationID(), Method: method}
+	req.ReplyTo = c.replyRoot + "/" + req.ID
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Deadline = deadline
+	}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	call := &pendingCall{ready: make(chan struct{}, 1)}
+	c.mu.Lock()
+	c.calls[req.ID] = call
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, req.ID)
+		c.mu.Unlock()
+	}()
+
+	if err := c.pubsub.Publish(root+"/"+method, rpcQoS, false, data); err != nil {
+		return err
+	}
+
+	// QoS 1 does not guarantee order across messages, so responses are reordered by
+	// sequence number before being handed to fn
+	next := 0
+	early := make(map[int]Response)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-call.ready:
+		}
+		replies, overrun := call.take()
+		for _, resp := range replies {
+			if resp.Seq >= next { // Otherwise a redelivered duplicate
+				early[resp.Seq] = resp
+			}
+		}
+		for {
+			resp, ok := early[next]
+			if !ok {
+				break
+			}
+			delete(early, next)
+			next++
+			if resp.Error != nil {
+				return resp.Error
+			}
+			if err := fn(resp.Result); err != nil {
+				return err
+			}
+			if !resp.More {
+				return nil
+			}
+		}
+		// Replies were dropped, so the stream cannot be completed in order
+		if overrun {
+			return ErrFellBehind
+		}
+	}
+}
+
+// subscribe sets up the reply subscription on first use, retrying on later calls if it
+// failed
+func (c *Client) subscribe() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscribed {
+		return nil
+	}
+	if err := c.pubsub.Subscribe(c.replyRoot+"/+", rpcQoS, c.onReply); err != nil {
+		return err
+	}
+	c.subscribed = true
+	return nil
+}
+
+func (c *Client) onReply(topic string, payload []byte) {
+	var resp Response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		c.log.Warn("Dropping malformed rpc response on %s: %v", topic, err)
+		return
+	}
+	if !strings.HasSuffix(topic, "/"+resp.ID) {
+		c.log.Warn("Dropping rpc response %s on mismatched topic %s", resp.ID, topic)
+		return
+	}
+
+	c.mu.Lock()
+	call, ok := c.calls[resp.ID]
+	c.mu.Unlock()
+	if !ok {
+		c.log.Debug("Dropping late rpc response %s", resp.ID)
+		return
+	}
+
+	// This runs on the transport's delivery path, so it must not wait for the caller
+	if !call.push(resp) {
+		c.log.Warn("Dropping rpc response %s: caller is not reading", resp.ID)
+	}
+}
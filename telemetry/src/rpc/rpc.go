@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// rpcQoS is at least once for both requests and replies
+const rpcQoS = 1
+
+// DefaultReplyNamespace is where servers send replies unless told otherwise. Keeping
+// replies out of the robots' topics stops a caller from making a robot publish on
+// another robot's telemetry.
+const DefaultReplyNamespace = "fleet"
+
+// Errors returned by RPC calls
+var (
+	ErrNoHandler    = errors.New("no handler for method")
+	ErrBadRequest   = errors.New("bad rpc request")
+	ErrStreamClosed = errors.New("rpc stream closed")
+	ErrFellBehind   = errors.New("rpc caller fell behind the stream")
+)
+
+// PubSub is the messaging the RPC layer needs, e.g. an MQTTTelemetryClient
+type PubSub interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Subscribe(topic string, qos byte, handler func(topic string, payload []byte)) error
+}
+
+// Request is published on <request root>/<method>
+type Request struct {
+	ID       string          `json:"id"` // Correlation ID, echoed in every response
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	ReplyTo  string          `json:"reply_to"` // Below the server's reply namespace
+	Deadline time.Time       `json:"deadline"`
+}
+
+// Response is published on the request's reply topic. Streaming handlers send several
+// responses numbered by Seq; the last one has More unset.
+type Response struct {
+	ID     string          `json:"id"`
+	Seq    int             `json:"seq"`
+	More   bool            `json:"more,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error is a failure reported by the remote handler
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes carried in Error.Code
+const (
+	CodeNoHandler  = "no_handler"
+	CodeBadRequest = "bad_request"
+	CodeTimeout    = "timeout"
+	CodeInternal   = "internal"
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error %s: %s", e.Code, e.Message)
+}
+
+// Unwrap maps remote error codes back onto the local sentinel errors
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case CodeNoHandler:
+		return ErrNoHandler
+	case CodeBadRequest:
+		return ErrBadRequest
+	}
+	return nil
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,258 @@
+package rpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"telemetry/src/broker"
+	"telemetry/src/mqtt"
+	"telemetry/src/rpc"
+	"telemetry/src/transport"
+)
+
+// connect starts an embedded broker and connects a robot and a fleet client to it, so
+// the RPC layer runs over the real MQTT client
+func connect(t *testing.T) (robot, fleet *mqtt.MQTTTelemetryClient) {
+	t.Helper()
+	b := broker.New(broker.DefaultConfig())
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Failed to start broker: %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	for _, id := range []string{"robot1", "controller"} {
+		client := mqtt.NewMQTTTelemetryClient(id, b.URL())
+		if err := client.Connect(); err != nil {
+			t.Fatalf("%s failed to connect: %v", id, err)
+		}
+		t.Cleanup(func() { client.Disconnect() })
+		if robot == nil {
+			robot = client
+		} else {
+			fleet = client
+		}
+	}
+	return robot, fleet
+}
+
+type robotConfig struct {
+	HealthHz float64 `json:"health_hz"`
+	Mode     string  `json:"mode"`
+}
+
+func startServer(t *testing.T, robot *mqtt.MQTTTelemetryClient) *rpc.Server {
+	server := rpc.NewServer("robots/robot1/rpc", robot)
+	server.Handle("get_config", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return robotConfig{HealthHz: 0.5, Mode: "idle"}, nil
+	})
+	server.HandleStream("query_health", func(ctx context.Context, params json.RawMessage, send func(interface{}) error) error {
+		var query struct {
+			Count int `json:"count"`
+		}
+		if err := json.Unmarshal(params, &query); err != nil || query.Count <= 0 {
+			return rpc.BadRequest("count must be positive")
+		}
+		for i := 0; i < query.Count; i++ {
+			if err := send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestCall(t *testing.T) {
+	robot, fleet := connect(t)
+	server := startServer(t, robot)
+	client := rpc.NewClient("fleet/controller/rpc", fleet)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var cfg robotConfig
+	if err := client.Call(ctx, "robots/robot1/rpc", "get_config", nil, &cfg); err != nil {
+		t.Fatalf("get_config failed: %v", err)
+	}
+	if cfg.HealthHz != 0.5 || cfg.Mode != "idle" {
+		t.Errorf("Unexpected config: %+v", cfg)
+	}
+
+	// Concurrent calls must each get their own reply
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var cfg robotConfig
+			if err := client.Call(ctx, "robots/robot1/rpc", "get_config", nil, &cfg); err != nil || cfg.Mode != "idle" {
+				t.Errorf("Concurrent call failed: %v %+v", err, cfg)
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := client.Call(ctx, "robots/robot1/rpc", "reboot", nil, nil)
+	var remote *rpc.Error
+	if !errors.As(err, &remote) || !errors.Is(err, rpc.ErrNoHandler) {
+		t.Errorf("Expected remote rpc.ErrNoHandler, got %v", err)
+	}
+	if err := client.Call(ctx, "robots/robot1/rpc", "query_health", map[string]int{"count": -1}, nil); !errors.Is(err, rpc.ErrBadRequest) {
+		t.Errorf("Expected rpc.ErrBadRequest, got %v", err)
+	}
+
+	started := make(chan struct{}, 1)
+	server.Handle("slow", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	if err := client.Call(short, "robots/robot1/rpc", "slow", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	<-started
+	server.Wait()
+}
+
+func TestStream(t *testing.T) {
+	robot, fleet := connect(t)
+	startServer(t, robot)
+	client := rpc.NewClient("fleet/controller/rpc", fleet)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []int
+	err := client.Stream(ctx, "robots/robot1/rpc", "query_health", map[string]int{"count": 200}, func(raw json.RawMessage) error {
+		var v int
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(got) != 200 {
+		t.Fatalf("Expected 200 results, got %d", len(got))
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("Results out of order at %d: %v", i, got[i])
+		}
+	}
+
+	stop := errors.New("enough")
+	count := 0
+	err = client.Stream(ctx, "robots/robot1/rpc", "query_health", map[string]int{"count": 50}, func(json.RawMessage) error {
+		count++
+		if count == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || count != 3 {
+		t.Errorf("Expected the stream to stop after 3 results, got %v after %d", err, count)
+	}
+}
+
+// TestReplyNamespace checks that the server only replies below its reply namespace, so
+// a caller cannot make the robot publish on another robot's topics
+func TestReplyNamespace(t *testing.T) {
+	robot, fleet := memoryClients(t)
+	server := startServer(t, robot)
+
+	var mu sync.Mutex
+	var hijacked []string
+	if err := fleet.Subscribe("robots/robot2/#", 1, func(topic string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		hijacked = append(hijacked, topic)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, replyTo := range []string{"robots/robot2/telemetry/health", "fleet/+/rpc", "fleet/", "fleetwide/replies"} {
+		req, _ := json.Marshal(rpc.Request{ID: "call-1", Method: "get_config", ReplyTo: replyTo})
+		if err := fleet.Publish("robots/robot1/rpc/get_config", 1, false, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Wait()
+
+	mu.Lock()
+	if len(hijacked) > 0 {
+		t.Errorf("Server replied on %v", hijacked)
+	}
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rpc.NewClient("fleet/controller/rpc", fleet).Call(ctx, "robots/robot1/rpc", "get_config", nil, nil); err != nil {
+		t.Errorf("Call with a reply topic in the namespace failed: %v", err)
+	}
+}
+
+// memoryClients connects a robot and a fleet client over the in-memory transport
+func memoryClients(t *testing.T) (robot, fleet *mqtt.MQTTTelemetryClient) {
+	t.Helper()
+	bus := transport.NewBus()
+	var clients []*mqtt.MQTTTelemetryClient
+	for _, id := range []string{"robot1", "controller"} {
+		client, err := mqtt.NewMQTTTelemetryClientWithTransport(mqtt.DefaultOptions(id, "tcp://unused:1883"), transport.NewMemory(bus))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Disconnect() })
+		clients = append(clients, client)
+	}
+	return clients[0], clients[1]
+}
+
+// TestSlowCaller checks that replies to a caller that is not reading are buffered
+// rather than holding up delivery. The in-memory transport delivers on the publisher's
+// goroutine, so a blocked reply handler would stall the server.
+func TestSlowCaller(t *testing.T) {
+	robot, fleet := memoryClients(t)
+	server := startServer(t, robot)
+	client := rpc.NewClient("fleet/controller/rpc", fleet)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, blocked := make(chan struct{}, 1), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Stream(ctx, "robots/robot1/rpc", "query_health", map[string]int{"count": 100}, func(json.RawMessage) error {
+			select {
+			case first <- struct{}{}:
+			default:
+			}
+			<-blocked
+			return nil
+		})
+	}()
+	<-first
+	served := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(2 * time.Second):
+		t.Error("Server is held up by a caller that is not reading")
+	}
+	close(blocked)
+	if err := <-done; err != nil {
+		t.Errorf("Stream failed: %v", err)
+	}
+}
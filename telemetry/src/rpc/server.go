@@ -0,0 +1,177 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"telemetry/include/logger"
+)
+
+// Handler answers a call with a single result
+type Handler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// StreamHandler answers a call with a sequence of results, calling send for each one.
+// Large results should be split into chunks so no single message grows unbounded.
+type StreamHandler func(ctx context.Context, params json.RawMessage, send func(result interface{}) error) error
+
+// Server answers RPC requests published below a request root, e.g. robots/<id>/rpc
+type Server struct {
+	root     string
+	replies  string // Reply namespace, see SetReplyNamespace
+	pubsub   PubSub
+	log      *logger.Logger
+	mu       sync.RWMutex
+	handlers map[string]StreamHandler
+	ctx      context.Context
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a server for requests on <root>/<method>
+func NewServer(root string, pubsub PubSub) *Server {
+	return &Server{
+		root:     root,
+		replies:  DefaultReplyNamespace,
+		pubsub:   pubsub,
+		log:      logger.New(logger.INFO),
+		handlers: make(map[string]StreamHandler),
+	}
+}
+
+// Handle registers a single-result handler for method
+func (s *Server) Handle(method string, h Handler) {
+	s.HandleStream(method, func(ctx context.Context, params json.RawMessage, send func(interface{}) error) error {
+		result, err := h(ctx, params)
+		if err != nil {
+			return err
+		}
+		return send(result)
+	})
+}
+
+// HandleStream registers a streaming handler for method
+func (s *Server) HandleStream(method string, h StreamHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[method] = h
+}
+
+// SetReplyNamespace restricts replies to topics below root, DefaultReplyNamespace by
+// default. Requests asking for replies anywhere else are dropped. It must be called
+// before Start.
+func (s *Server) SetReplyNamespace(root string) {
+	s.replies = root
+}
+
+// replyAllowed reports whether topic is a plain topic below the reply namespace
+func (s *Server) replyAllowed(topic string) bool {
+	return strings.HasPrefix(topic, s.replies+"/") && len(topic) > len(s.replies)+1 &&
+		!strings.ContainsAny(topic, "+#\x00")
+}
+
+// Start subscribes to requests. Handlers run with contexts derived from ctx and the
+// caller's deadline.
+func (s *Server) Start(ctx context.Context) error {
+	s.ctx = ctx
+	return s.pubsub.Subscribe(s.root+"/+", rpcQoS, func(topic string, payload []byte) {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(payload)
+		}()
+	})
+}
+
+// Wait blocks until all in-flight calls have been answered
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Server) serve(payload []byte) {
+	var req Request
+	if err := json.Unmarshal(payload, &req); err != nil || req.ID == "" || req.ReplyTo == "" {
+		s.log.Warn("Dropping malformed rpc request: %v", err)
+		return
+	}
+	if !s.replyAllowed(req.ReplyTo) {
+		s.log.Warn("Dropping rpc %s (%s): reply topic %q is outside %s/", req.Method, req.ID, req.ReplyTo, s.replies)
+		return
+	}
+
+	s.mu.RLock()
+	handler, ok := s.handlers[req.Method]
+	s.mu.RUnlock()
+
+	seq := 0
+	reply := func(resp Response) error {
+		resp.ID = req.ID
+		resp.Seq = seq
+		seq++
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		return s.pubsub.Publish(req.ReplyTo, rpcQoS, false, data)
+	}
+
+	if !ok {
+		reply(Response{Error: &Error{Code: CodeNoHandler, Message: req.Method}})
+		return
+	}
+
+	ctx := s.ctx
+	if !req.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, req.Deadline)
+		defer cancel()
+	}
+
+	// Each result is held back until the next one arrives so the final one can be
+	// marked as the end of the stream
+	var pending json.RawMessage
+	havePending := false
+	send := func(result interface{}) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		if havePending {
+			if err := reply(Response{More: true, Result: pending}); err != nil {
+				return err
+			}
+		}
+		pending, havePending = data, true
+		return nil
+	}
+
+	err := handler(ctx, req.Params, send)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		// The caller has already given up, so there is nobody to tell
+		s.log.Warn("rpc %s (%s) exceeded its deadline", req.Method, req.ID)
+	case err != nil:
+		code := CodeInternal
+		if errors.Is(err, ErrBadRequest) {
+			code = CodeBadRequest
+		}
+		if havePending {
+			reply(Response{More: true, Result: pending})
+		}
+		reply(Response{Error: &Error{Code: code, Message: err.Error()}})
+	default:
+		if err := reply(Response{Result: pending}); err != nil {
+			s.log.Error("Failed to reply to rpc %s (%s): %v", req.Method, req.ID, err)
+		}
+	}
+}
+
+// BadRequest wraps a parameter problem so the caller sees CodeBadRequest
+func BadRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrBadRequest, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,403 @@
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"telemetry/include/logger"
+)
+
+// Serial link protocol for microcontroller sensor boards. Each frame is
+//
+//	type    uint8
+//	seq     uint8
+//	payload []byte (little-endian fields)
+//	crc     uint16 big-endian CRC-16/CCITT-FALSE over type, seq and payload
+//
+// COBS encoded and terminated by a 0x00 byte. Every frame except an ack is
+// acknowledged with an ack frame carrying the same sequence number; unacknowledged
+// frames are retransmitted and duplicates are detected by sequence number.
+
+// SerialFrameType identifies the payload carried by a serial frame
+type SerialFrameType uint8
+
+const (
+	SerialAck        SerialFrameType = 0x01
+	SerialIMU        SerialFrameType = 0x10
+	SerialUltrasonic SerialFrameType = 0x11
+	SerialMotor      SerialFrameType = 0x12
+	SerialCommand    SerialFrameType = 0x20
+)
+
+// Serial command opcodes carried as the first byte of a SerialCommand payload
+const (
+	SerialCmdSetMotor  = 0x01
+	SerialCmdStopMotor = 0x02
+)
+
+const serialMaxFrame = 256
+
+var (
+	ErrSerialCRC       = errors.New("serial frame crc mismatch")
+	ErrSerialShort     = errors.New("serial frame too short")
+	ErrSerialNoAck     = errors.New("serial frame not acknowledged")
+	ErrSerialNoReading = errors.New("no reading received from serial link")
+	ErrSerialClosed    = errors.New("serial link closed")
+)
+
+// EncodeSerialFrame builds a COBS encoded frame including the trailing delimiter
+func EncodeSerialFrame(frameType SerialFrameType, seq uint8, payload []byte) []byte {
+	raw := make([]byte, 0, len(payload)+4)
+	raw = append(raw, byte(frameType), seq)
+	raw = append(raw, payload...)
+	raw = binary.BigEndian.AppendUint16(raw, crc16CCITT(raw))
+	return append(cobsEncode(raw), 0)
+}
+
+// DecodeSerialFrame validates a frame received without its delimiter
+func DecodeSerialFrame(encoded []byte) (SerialFrameType, uint8, []byte, error) {
+	raw, err := cobsDecode(encoded)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(raw) < 4 {
+		return 0, 0, nil, ErrSerialShort
+	}
+
+	body, sum := raw[:len(raw)-2], binary.BigEndian.Uint16(raw[len(raw)-2:])
+	if crc16CCITT(body) != sum {
+		return 0, 0, nil, ErrSerialCRC
+	}
+	return SerialFrameType(body[0]), body[1], body[2:], nil
+}
+
+// EncodeIMUPayload encodes an IMU reading for the given sensor channel
+func EncodeIMUPayload(channel uint8, data IMUData) []byte {
+	buf := []byte{channel}
+	for _, v := range []float64{data.AccelX, data.AccelY, data.AccelZ, data.GyroX, data.GyroY, data.GyroZ} {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(v)))
+	}
+	return buf
+}
+
+// EncodeUltrasonicPayload encodes an ultrasonic reading for the given sensor channel
+func EncodeUltrasonicPayload(channel uint8, data UltrasonicData) []byte {
+	buf := []byte{channel}
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(data.Distance)))
+}
+
+// EncodeMotorPayload encodes motor telemetry for the given motor channel
+func EncodeMotorPayload(channel uint8, data MotorData) []byte {
+	buf := []byte{channel}
+	buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(data.Speed)))
+	buf = append(buf, byte(int8(data.Direction)))
+	return binary.LittleEndian.AppendUint32(buf, math.Float32bits(float32(data.Current)))
+}
+
+func readFloat32(b []byte) float64 {
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+}
+
+// decodeSerialPayload converts a sensor frame payload into its channel and reading
+func decodeSerialPayload(frameType SerialFrameType, payload []byte) (uint8, interface{}, string, error) {
+	switch frameType {
+	case SerialIMU:
+		if len(payload) != 25 {
+			return 0, nil, "", ErrSerialShort
+		}
+		return payload[0], IMUData{
+			AccelX: readFloat32(payload[1:]),
+			AccelY: readFloat32(payload[5:]),
+			AccelZ: readFloat32(payload[9:]),
+			GyroX:  readFloat32(payload[13:]),
+			GyroY:  readFloat32(payload[17:]),
+			GyroZ:  readFloat32(payload[21:]),
+		}, "imu", nil
+	case SerialUltrasonic:
+		if len(payload) != 5 {
+			return 0, nil, "", ErrSerialShort
+		}
+		return payload[0], UltrasonicData{Distance: readFloat32(payload[1:])}, "ultrasonic", nil
+	case SerialMotor:
+		if len(payload) != 10 {
+			return 0, nil, "", ErrSerialShort
+		}
+		return payload[0], MotorData{
+			Speed:     readFloat32(payload[1:]),
+			Direction: int(int8(payload[5])),
+			Current:   readFloat32(payload[6:]),
+		}, "motor", nil
+	}
+	return 0, nil, "", fmt.Errorf("unknown serial frame type 0x%02x", frameType)
+}
+
+// SerialStats counts link level events
+type SerialStats struct {
+	FramesReceived uint64
+	FramesInvalid  uint64
+	Duplicates     uint64
+	Retransmits    uint64
+}
+
+type serialKey struct {
+	frameType SerialFrameType
+	channel   uint8
+}
+
+// SerialLink speaks the framed protocol to a microcontroller over any io.ReadWriter,
+// typically a UART device. Decoded readings are exposed as Sensors and commands are
+// delivered reliably with acks and retransmission.
+type SerialLink struct {
+	rw         io.ReadWriter
+	log        *logger.Logger
+	AckTimeout time.Duration
+	MaxRetries int
+	writeMu    sync.Mutex
+	mu         sync.Mutex
+	txSeq      uint8
+	rxSeq      map[SerialFrameType]uint8
+	acks       map[uint8]chan struct{}
+	latest     map[serialKey]SensorData
+	stats      SerialStats
+	done       chan struct{}
+}
+
+// NewSerialLink creates a link over rw; call Start to begin receiving frames
+func NewSerialLink(rw io.ReadWriter) *SerialLink {
+	return &SerialLink{
+		rw:         rw,
+		log:        logger.New(logger.INFO),
+		AckTimeout: 100 * time.Millisecond,
+		MaxRetries: 3,
+		rxSeq:      make(map[SerialFrameType]uint8),
+		acks:       make(map[uint8]chan struct{}),
+		latest:     make(map[serialKey]SensorData),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start receives frames until the reader fails or ctx is cancelled. If the
+// underlying ReadWriter is an io.Closer it is closed when ctx is cancelled.
+func (l *SerialLink) Start(ctx context.Context) {
+	if closer, ok := l.rw.(io.Closer); ok {
+		go func() {
+			select {
+			case <-ctx.Done():
+				closer.Close()
+			case <-l.done:
+			}
+		}()
+	}
+	go l.readLoop()
+}
+
+// Done is closed when the link stops receiving
+func (l *SerialLink) Done() <-chan struct{} {
+	return l.done
+}
+
+// Stats returns a snapshot of the link counters
+func (l *SerialLink) Stats() SerialStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
+
+func (l *SerialLink) readLoop() {
+	defer close(l.done)
+	reader := bufio.NewReader(l.rw)
+
+	for {
+		encoded, err := reader.ReadBytes(0)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+				l.log.Error("Serial link read failed: %v", err)
+			}
+			return
+		}
+		encoded = encoded[:len(encoded)-1]
+		if len(encoded) == 0 {
+			continue
+		}
+		if len(encoded) > serialMaxFrame {
+			l.countInvalid()
+			continue
+		}
+
+		frameType, seq, payload, err := DecodeSerialFrame(encoded)
+		if err != nil {
+			l.log.Debug("Dropping serial frame: %v", err)
+			l.countInvalid()
+			continue
+		}
+		l.handleFrame(frameType, seq, payload)
+	}
+}
+
+func (l *SerialLink) countInvalid() {
+	l.mu.Lock()
+	l.stats.FramesInvalid++
+	l.mu.Unlock()
+}
+
+func (l *SerialLink) handleFrame(frameType SerialFrameType, seq uint8, payload []byte) {
+	if frameType == SerialAck {
+		l.mu.Lock()
+		ch, ok := l.acks[seq]
+		delete(l.acks, seq)
+		l.mu.Unlock()
+		if ok {
+			close(ch)
+		}
+		return
+	}
+
+	// Duplicates are acknowledged again since the sender evidently missed our ack
+	defer func() {
+		if err := l.write(EncodeSerialFrame(SerialAck, seq, nil)); err != nil {
+			l.log.Warn("Failed to ack serial frame %d: %v", seq, err)
+		}
+	}()
+
+	l.mu.Lock()
+	last, seen := l.rxSeq[frameType]
+	if seen && last == seq {
+		l.stats.Duplicates++
+		l.mu.Unlock()
+		return
+	}
+	l.rxSeq[frameType] = seq
+	l.stats.FramesReceived++
+	l.mu.Unlock()
+
+	channel, value, dataType, err := decodeSerialPayload(frameType, payload)
+	if err != nil {
+		l.log.Warn("Dropping serial frame 0x%02x: %v", frameType, err)
+		l.countInvalid()
+		return
+	}
+
+	l.mu.Lock()
+	l.latest[serialKey{frameType, channel}] = SensorData{
+		Timestamp: time.Now(),
+		DataType:  dataType,
+		Value:     value,
+	}
+	l.mu.Unlock()
+}
+
+func (l *SerialLink) write(frame []byte) error {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+	_, err := l.rw.Write(frame)
+	return err
+}
+
+// SendCommand delivers a command payload, retransmitting until it is acknowledged
+func (l *SerialLink) SendCommand(ctx context.Context, payload []byte) error {
+	l.mu.Lock()
+	l.txSeq++
+	seq := l.txSeq
+	acked := make(chan struct{})
+	l.acks[seq] = acked
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.acks, seq)
+		l.mu.Unlock()
+	}()
+
+	frame := EncodeSerialFrame(SerialCommand, seq, payload)
+	for attempt := 0; attempt <= l.MaxRetries; attempt++ {
+		if attempt > 0 {
+			l.mu.Lock()
+			l.stats.Retransmits++
+			l.mu.Unlock()
+			l.log.Debug("Retransmitting serial command %d (attempt %d/%d)", seq, attempt+1, l.MaxRetries+1)
+		}
+		if err := l.write(frame); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(l.AckTimeout)
+		select {
+		case <-acked:
+			timer.Stop()
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-l.done:
+			timer.Stop()
+			return ErrSerialClosed
+		case <-timer.C:
+		}
+	}
+	return fmt.Errorf("%w: command seq %d after %d attempts", ErrSerialNoAck, seq, l.MaxRetries+1)
+}
+
+// SetMotor commands a motor channel: speed 0.0 to 1.0, direction 1 forward, -1 backward, 0 stopped
+func (l *SerialLink) SetMotor(ctx context.Context, channel uint8, speed float64, direction int) error {
+	if speed < 0.0 || speed > 1.0 {
+		return fmt.Errorf("speed must be between 0.0 and 1.0, got %v", speed)
+	}
+	if direction < -1 || direction > 1 {
+		return fmt.Errorf("invalid motor direction %d", direction)
+	}
+	payload := []byte{SerialCmdSetMotor, channel}
+	payload = binary.LittleEndian.AppendUint32(payload, math.Float32bits(float32(speed)))
+	payload = append(payload, byte(int8(direction)))
+	return l.SendCommand(ctx, payload)
+}
+
+// StopMotor stops a motor channel
+func (l *SerialLink) StopMotor(ctx context.Context, channel uint8) error {
+	return l.SendCommand(ctx, []byte{SerialCmdStopMotor, channel})
+}
+
+// Sensor returns a Sensor reporting the latest reading of frameType on a channel
+func (l *SerialLink) Sensor(id string, frameType SerialFrameType, channel uint8) Sensor {
+	return &SerialSensor{link: l, id: id, key: serialKey{frameType, channel}}
+}
+
+// SerialSensor exposes readings decoded from a serial link
+type SerialSensor struct {
+	link *SerialLink
+	id   string
+	key  serialKey
+}
+
+func (s *SerialSensor) ID() string {
+	return s.id
+}
+
+func (s *SerialSensor) Read(ctx context.Context) (SensorData, error) {
+	s.link.mu.Lock()
+	data, ok := s.link.latest[s.key]
+	s.link.mu.Unlock()
+	if !ok {
+		return SensorData{}, fmt.Errorf("%w: %s", ErrSerialNoReading, s.id)
+	}
+	data.SensorID = s.id
+	return data, nil
+}
+
+func (s *SerialSensor) Initialize() error {
+	switch s.key.frameType {
+	case SerialIMU, SerialUltrasonic, SerialMotor:
+		return nil
+	}
+	return fmt.Errorf("serial frame type 0x%02x is not a sensor reading", s.key.frameType)
+}
+
+func (s *SerialSensor) Shutdown() error {
+	return nil
+}
@@ -2,11 +2,16 @@ package simulation
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"sync"
 	"telemetry/include/logger"
+	"telemetry/src/transport"
 )
 
+// ErrDuplicateRobot is returned when adding a robot whose ID is already in the fleet
+var ErrDuplicateRobot = errors.New("robot already exists in fleet")
+
 // FleetManager manages a collection of mock robots
 type FleetManager struct {
 	robots map[string]*MockRobot
@@ -70,3 +75,53 @@ func (fm *FleetManager) GetRobotChannels() map[string]<-chan interface{} {
 	}
 	return channels
 }
+
+// PublishTo publishes every robot's messages through t as bare JSON on
+// <topicPrefix>/<id>/telemetry/<type>, the format the fleet ingestor accepts from
+// robots without envelopes, until ctx is done or the robots stop. It consumes the
+// robot channels, so it cannot be combined with GetRobotChannels.
+func (fm *FleetManager) PublishTo(ctx context.Context, t transport.Transport, topicPrefix string) {
+	var wg sync.WaitGroup
+	for id, ch := range fm.GetRobotChannels() {
+		wg.Add(1)
+		go func(id string, ch <-chan interface{}) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg, ok := <-ch:
+					if !ok {
+						return
+					}
+					fm.publish(t, topicPrefix, id, msg)
+				}
+			}
+		}(id, ch)
+	}
+	wg.Wait()
+}
+
+func (fm *FleetManager) publish(t transport.Transport, topicPrefix, robotID string, msg interface{}) {
+	var messageType string
+	switch msg.(type) {
+	case HeartbeatMessage:
+		messageType = "heartbeat"
+	case HealthMessage:
+		messageType = "health"
+	case NavigationMessage:
+		messageType = "navigation"
+	default:
+		fm.log.Warn("Robot %s produced an unknown message type %T", robotID, msg)
+		return
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		fm.log.Error("Failed to marshal %s message from robot %s: %v", messageType, robotID, err)
+		return
+	}
+	topic := topicPrefix + "/" + robotID + "/telemetry/" + messageType
+	if err := t.Publish(topic, 1, false, payload); err != nil {
+		fm.log.Warn("Failed to publish %s: %v", topic, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"telemetry/include/logger"
 	"time"
 )
@@ -12,8 +13,15 @@ import (
 type MockRobot struct {
 	ID           string
 	status       RobotStatus
+	poseMu       sync.Mutex // Guards position and heading
 	position     Position
+	heading      float64
+	moving       atomic.Bool
 	batteryLevel float64
+	obstacles    ObstacleSource
+	power        PowerSource
+	health       HealthSource
+	navInterval  func() time.Duration
 	log          *logger.Logger
 	msgChan      chan interface{}
 	stopChan     chan struct{}
@@ -22,15 +30,70 @@ type MockRobot struct {
 
 // NewMockRobot creates a new simulated robot
 func NewMockRobot(id string, initialPosition Position) *MockRobot {
-	return &MockRobot{
+	r := &MockRobot{
 		ID:           id,
 		status:       StatusOperational,
 		position:     initialPosition,
 		batteryLevel: 100.0,
+		navInterval:  func() time.Duration { return time.Second },
 		log:          logger.New(logger.DEBUG),
 		msgChan:      make(chan interface{}, 100),
 		stopChan:     make(chan struct{}),
 	}
+	r.moving.Store(true)
+	return r
+}
+
+// SetObstacleSource sets where navigation messages get detected obstacles from.
+// It must be called before Start; without a source no obstacles are reported.
+func (r *MockRobot) SetObstacleSource(source ObstacleSource) {
+	r.obstacles = source
+}
+
+// SetPowerSource sets where battery state comes from. It must be called before
+// Start; without a source the simulated battery stays full.
+func (r *MockRobot) SetPowerSource(source PowerSource) {
+	r.power = source
+}
+
+// SetHealthSource sets where host diagnostics come from. It must be called before
+// Start; without a source health readings are simulated.
+func (r *MockRobot) SetHealthSource(source HealthSource) {
+	r.health = source
+}
+
+// SetNavigationInterval sets how often navigation messages are sampled, e.g. from a
+// rate controller; interval is asked again after every sample. It must be called
+// before Start; without it the robot navigates every second.
+func (r *MockRobot) SetNavigationInterval(interval func() time.Duration) {
+	r.navInterval = interval
+}
+
+// SetMoving starts or stops the robot driving around. A new robot is moving.
+func (r *MockRobot) SetMoving(moving bool) {
+	r.moving.Store(moving)
+}
+
+// Status returns the robot's operational status
+func (r *MockRobot) Status() RobotStatus {
+	return r.status
+}
+
+// Position returns where the robot is
+func (r *MockRobot) Position() Position {
+	r.poseMu.Lock()
+	defer r.poseMu.Unlock()
+	return r.position
+}
+
+// BatteryPct returns the remaining battery charge, from the power source if one is set
+func (r *MockRobot) BatteryPct() float64 {
+	if r.power != nil {
+		if power, err := r.power.PowerStatus(); err == nil {
+			return power.BatteryPct
+		}
+	}
+	return r.batteryLevel
 }
 
 // Start begins the robot simulation
@@ -65,6 +128,11 @@ func (r *MockRobot) heartbeatRoutine(ctx context.Context) {
 				Status:     r.status,
 				BatteryPct: r.batteryLevel,
 			}
+			if r.power != nil {
+				if power, err := r.power.PowerStatus(); err == nil {
+					msg.BatteryPct = power.BatteryPct
+				}
+			}
 			r.msgChan <- msg
 		}
 	}
@@ -88,6 +156,21 @@ func (r *MockRobot) healthRoutine(ctx context.Context) {
 				VoltageLevel: 11.5 + rand.Float64()*1.0,
 				CurrentDraw:  2.0 + rand.Float64()*3.0,
 			}
+			if r.health != nil {
+				if err := r.health.FillHealth(&msg); err != nil {
+					r.log.Debug("Robot %s host health unavailable: %v", r.ID, err)
+				}
+			}
+			if r.power != nil {
+				power, err := r.power.PowerStatus()
+				if err != nil {
+					r.log.Debug("Robot %s power status unavailable: %v", r.ID, err)
+				} else {
+					msg.VoltageLevel = power.VoltageLevel
+					msg.CurrentDraw = power.CurrentDraw
+					msg.ErrorCodes = append(msg.ErrorCodes, power.ErrorCodes...)
+				}
+			}
 			r.msgChan <- msg
 		}
 	}
@@ -95,44 +178,52 @@ func (r *MockRobot) healthRoutine(ctx context.Context) {
 
 func (r *MockRobot) navigationRoutine(ctx context.Context) {
 	defer r.wg.Done()
-	ticker := time.NewTicker(1 * time.Second)
-	defer ticker.Stop()
+	timer := time.NewTimer(r.navInterval())
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			// Simulate movement
-			r.position.X += (rand.Float64() - 0.5) * 0.5
-			r.position.Y += (rand.Float64() - 0.5) * 0.5
-
-			msg := NavigationMessage{
-				RobotID:    r.ID,
-				Timestamp:  time.Now(),
-				Position:   r.position,
-				Heading:    rand.Float64() * 360.0,
-				Velocity:   rand.Float64() * 2.0,
-				PathStatus: "CLEAR",
-			}
-
-			// Randomly generate obstacles
-			if rand.Float64() < 0.1 { // 10% chance of obstacle
-				msg.Obstacles = []Obstacle{{
-					Position: Position{
-						X: r.position.X + rand.Float64()*5.0,
-						Y: r.position.Y + rand.Float64()*5.0,
-						Z: 0,
-					},
-					Size:     rand.Float64() * 2.0,
-					Type:     "STATIC",
-					Severity: "MEDIUM",
-				}}
-			}
+		case <-timer.C:
+			r.msgChan <- r.Navigate()
+			timer.Reset(r.navInterval())
+		}
+	}
+}
 
-			r.msgChan <- msg
+// Navigate advances the simulation by one step and returns the robot's navigation
+// state, with obstacles from the obstacle source. A robot that is not moving stays
+// where it is.
+func (r *MockRobot) Navigate() NavigationMessage {
+	velocity := 0.0
+	r.poseMu.Lock()
+	if r.moving.Load() {
+		// Simulate movement
+		r.position.X += (rand.Float64() - 0.5) * 0.5
+		r.position.Y += (rand.Float64() - 0.5) * 0.5
+		r.heading = rand.Float64() * 360.0
+		velocity = rand.Float64() * 2.0
+	}
+	msg := NavigationMessage{
+		RobotID:    r.ID,
+		Timestamp:  time.Now(),
+		Position:   r.position,
+		Heading:    r.heading,
+		Velocity:   velocity,
+		PathStatus: "CLEAR",
+	}
+	r.poseMu.Unlock()
+
+	if r.obstacles != nil {
+		obstacles, err := r.obstacles.DetectObstacles(msg.Position, msg.Heading)
+		if err != nil {
+			r.log.Debug("Robot %s obstacle detection failed: %v", r.ID, err)
+		} else {
+			msg.Obstacles = obstacles
 		}
 	}
+	return msg
 }
 
 // GetMessageChannel returns the channel for receiving messages from the robot
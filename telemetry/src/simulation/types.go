@@ -1,6 +1,7 @@
 package simulation
 
 import (
+	"context"
 	"time"
 )
 
@@ -26,15 +27,60 @@ type HeartbeatMessage struct {
 	BatteryPct float64     `json:"battery_percentage"`
 }
 
+// StatusMessage is the retained liveness message for a robot. It is published as a
+// birth message on connect, as a death message on clean shutdown, and registered with
+// the broker as the Last Will for when the robot drops off.
+type StatusMessage struct {
+	RobotID         string      `json:"robot_id"`
+	Timestamp       time.Time   `json:"timestamp"`
+	Status          RobotStatus `json:"status"`
+	Reason          string      `json:"reason,omitempty"`
+	SoftwareVersion string      `json:"software_version,omitempty"`
+	Capabilities    []string    `json:"capabilities,omitempty"`
+	Sensors         []string    `json:"sensors,omitempty"`
+	BootID          string      `json:"boot_id,omitempty"` // Matches the telemetry envelopes
+}
+
+// Reasons carried by status messages
+const (
+	StatusReasonConnected      = "connected"
+	StatusReasonShutdown       = "shutdown"
+	StatusReasonConnectionLost = "connection_lost"
+)
+
 // HealthMessage represents detailed hardware diagnostics
 type HealthMessage struct {
-	RobotID      string    `json:"robot_id"`
-	Timestamp    time.Time `json:"timestamp"`
-	CPUTemp      float64   `json:"cpu_temperature"`
-	MotorTemps   []float64 `json:"motor_temperatures"`
-	VoltageLevel float64   `json:"voltage_level"`
-	CurrentDraw  float64   `json:"current_draw"`
-	ErrorCodes   []string  `json:"error_codes,omitempty"`
+	RobotID         string      `json:"robot_id"`
+	Timestamp       time.Time   `json:"timestamp"`
+	CPUTemp         float64     `json:"cpu_temperature"`
+	MotorTemps      []float64   `json:"motor_temperatures"`
+	VoltageLevel    float64     `json:"voltage_level"`
+	CurrentDraw     float64     `json:"current_draw"`
+	ErrorCodes      []string    `json:"error_codes,omitempty"`
+	CPUUsage        float64     `json:"cpu_usage,omitempty"`    // Percent
+	MemoryUsage     float64     `json:"memory_usage,omitempty"` // Percent
+	DiskUsage       float64     `json:"disk_usage,omitempty"`   // Percent
+	Throttled       bool        `json:"throttled,omitempty"`
+	ThrottleFlags   uint32      `json:"throttle_flags,omitempty"`
+	UptimeSeconds   float64     `json:"uptime_seconds,omitempty"`
+	WiFiLinkQuality float64     `json:"wifi_link_quality,omitempty"`
+	WiFiSignalDBm   float64     `json:"wifi_signal_dbm,omitempty"`
+	OutboxDepth     int         `json:"outbox_depth,omitempty"` // Messages queued while offline
+	OutboxDropped   uint64      `json:"outbox_dropped,omitempty"`
+	Reconnects      uint64      `json:"reconnects,omitempty"`
+	DowntimeSeconds float64     `json:"downtime_seconds,omitempty"` // Time disconnected since start
+	Shed            []ShedCount `json:"shed,omitempty"`             // Messages dropped by the bandwidth governor
+}
+
+// ShedCount is how many messages of one type the bandwidth governor dropped
+type ShedCount struct {
+	Type  string `json:"type"`
+	Count uint64 `json:"count"`
+}
+
+// HealthSource fills host diagnostics into a health message
+type HealthSource interface {
+	FillHealth(msg *HealthMessage) error
 }
 
 // NavigationMessage represents path-related information
@@ -55,6 +101,32 @@ type Position struct {
 	Z float64 `json:"z"`
 }
 
+// ObstacleSource detects obstacles around a robot, e.g. from LiDAR scans. Heading is in
+// degrees counterclockwise from the world X axis.
+type ObstacleSource interface {
+	DetectObstacles(pose Position, heading float64) ([]Obstacle, error)
+}
+
+// PowerStatus is the battery state reported in heartbeat and health messages
+type PowerStatus struct {
+	BatteryPct   float64
+	VoltageLevel float64
+	CurrentDraw  float64
+	ErrorCodes   []string
+}
+
+// PowerSource reports the battery state of a robot, e.g. from a BMS
+type PowerSource interface {
+	PowerStatus() (PowerStatus, error)
+}
+
+// PolledPowerSource is a PowerSource that only learns the battery state when polled,
+// e.g. a sensor that reads the BMS on demand
+type PolledPowerSource interface {
+	PowerSource
+	PollPower(ctx context.Context) error
+}
+
 // Obstacle represents detected obstacles
 type Obstacle struct {
 	Position Position `json:"position"`
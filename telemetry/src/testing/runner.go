@@ -2,33 +2,332 @@ package testing
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"telemetry/include/logger"
+	"telemetry/src/command"
+	"telemetry/src/governor"
+	"telemetry/src/hostmetrics"
 	"telemetry/src/mqtt"
+	"telemetry/src/obstacles"
+	"telemetry/src/outbox"
+	"telemetry/src/policy"
+	"telemetry/src/rate"
+	"telemetry/src/rpc"
 	"telemetry/src/simulation"
+	"telemetry/src/transport"
 	"telemetry/src/workerpool"
 	"time"
-
-	paho "github.com/eclipse/paho.mqtt.golang"
 )
 
+// SoftwareVersion is announced in the birth message; override it at build time with
+// -ldflags "-X telemetry/src/testing.SoftwareVersion=..."
+var SoftwareVersion = "dev"
+
 type TelemetryTestRunner struct {
 	log         *logger.Logger
 	robotClient *mqtt.MQTTTelemetryClient
 	mockRobot   *simulation.MockRobot
 	pool        *workerpool.WorkerPool
+	hostHealth  *hostmetrics.Collector
+	commands    *command.Dispatcher
+	rpcServer   *rpc.Server
+	// power is the robot's battery, see SetPowerSource
+	power simulation.PowerSource
+	// obstacleService merges the fleet's reports, see RunObstacleService
+	obstacleService *obstacles.Service
+	// rates adapts the health and navigation publishing rates to the robot state, see
+	// set_rate
+	rates *rate.Controller
+	// moving is whether the last set_mode put the robot in a driving mode
+	moving        atomic.Bool
+	historyMu     sync.Mutex
+	healthHistory []simulation.HealthMessage
+	// connected is signalled on every successful connection
+	connected chan struct{}
 }
 
+// maxConnectBackoff caps the wait between initial connection attempts
+const maxConnectBackoff = time.Minute
+
+// healthHistoryLimit bounds the health history served over RPC, 20 minutes at the
+// rate of a moving robot
+const healthHistoryLimit = 600
+
 func NewTelemetryTestRunner(robotID string, brokerURL string) *TelemetryTestRunner {
-	return &TelemetryTestRunner{
+	return newRunner(mqtt.NewMQTTTelemetryClient(robotID, brokerURL))
+}
+
+// NewTelemetryTestRunnerWithOptions creates a runner whose client uses the given
+// options, e.g. for TLS or authenticated brokers
+func NewTelemetryTestRunnerWithOptions(opts mqtt.Options) (*TelemetryTestRunner, error) {
+	client, err := mqtt.NewMQTTTelemetryClientWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRunner(client), nil
+}
+
+// NewTelemetryTestRunnerWithTransport creates a runner publishing through t, e.g. a
+// transport.Memory so a scenario runs without a broker. The options supply the robot
+// ID and topic layout.
+func NewTelemetryTestRunnerWithTransport(opts mqtt.Options, t transport.Transport) (*TelemetryTestRunner, error) {
+	client, err := mqtt.NewMQTTTelemetryClientWithTransport(opts, t)
+	if err != nil {
+		return nil, err
+	}
+	return newRunner(client), nil
+}
+
+func newRunner(client *mqtt.MQTTTelemetryClient) *TelemetryTestRunner {
+	robotID := client.Options().RobotID
+	mockRobot := simulation.NewMockRobot(robotID, simulation.Position{X: 0, Y: 0, Z: 0})
+	// The robot stays put until set_mode starts it driving
+	mockRobot.SetMoving(false)
+	client.SetBirthMessage(simulation.StatusMessage{
+		Status:          mockRobot.Status(),
+		SoftwareVersion: SoftwareVersion,
+		Capabilities:    []string{"telemetry/health", "telemetry/navigation", "commands"},
+		Sensors:         []string{"host_health"},
+	})
+	t := &TelemetryTestRunner{
 		log:         logger.New(logger.DEBUG),
-		robotClient: mqtt.NewMQTTTelemetryClient(robotID, brokerURL),
-		mockRobot:   simulation.NewMockRobot(robotID, simulation.Position{X: 0, Y: 0, Z: 0}),
+		robotClient: client,
+		mockRobot:   mockRobot,
 		pool:        workerpool.NewWorkerPool(2), // Reduced from 5 to 2 workers
+		hostHealth:  hostmetrics.NewCollector("/"),
+		commands:    command.NewDispatcher(robotID, client.Topic("commands", "ack"), client),
+		rpcServer:   rpc.NewServer(client.Topic("rpc"), client),
+		connected:   make(chan struct{}, 1),
+	}
+	// The default rate config is valid
+	t.rates, _ = rate.NewController(rate.DefaultConfig())
+	t.updateRateState()
+	client.OnConnectionEvent(t.onConnectionEvent)
+	t.commands.OnAudit(t.auditCommand)
+	t.registerCommandHandlers()
+	t.registerRPCHandlers()
+	return t
+}
+
+// EnableOutbox queues telemetry on disk while the broker is unreachable. It must be
+// called before Run.
+func (t *TelemetryTestRunner) EnableOutbox(cfg outbox.Config) error {
+	q, err := outbox.Open(cfg)
+	if err != nil {
+		return err
+	}
+	t.robotClient.SetOutbox(q)
+	return nil
+}
+
+// LimitBandwidth keeps telemetry within an airtime budget, shedding low-priority
+// messages first. It must be called before Run.
+func (t *TelemetryTestRunner) LimitBandwidth(config governor.Config) error {
+	g, err := governor.New(config)
+	if err != nil {
+		return err
+	}
+	t.robotClient.SetGovernor(g)
+	return nil
+}
+
+// RequireSignedCommands rejects commands not signed by a key in the key file, see
+// command.LoadVerifier. It must be called before Run.
+func (t *TelemetryTestRunner) RequireSignedCommands(keyFile string) error {
+	v, err := command.LoadVerifier(keyFile)
+	if err != nil {
+		return err
+	}
+	t.commands.SetVerifier(v)
+	return nil
+}
+
+// auditCommand publishes every command decision on robots/<id>/audit
+func (t *TelemetryTestRunner) auditCommand(ev command.AuditEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		t.log.Error("Failed to marshal audit event for command %s: %v", ev.CommandID, err)
+		return
 	}
+	if err := t.robotClient.Publish(t.robotClient.Topic("audit"), mqtt.QoSAtLeastOnce, false, payload); err != nil {
+		t.log.Warn("Failed to publish audit event for command %s: %v", ev.CommandID, err)
+	}
+}
+
+// LoadPolicy reads the per-message-type delivery policy from a config file, see
+// policy.Parse. It must be called before Run.
+func (t *TelemetryTestRunner) LoadPolicy(path string) error {
+	table, err := policy.Load(path)
+	if err != nil {
+		return err
+	}
+	t.robotClient.SetPolicy(table)
+	return nil
+}
+
+// SetPowerSource reads battery state from source, e.g. a BMS-backed
+// telemetry.BatterySensor, for heartbeats, health messages and the battery-dependent
+// publishing rate. A simulation.PolledPowerSource is polled every second while Run
+// publishes. It is opt-in and must be called before Run; without a source the
+// simulated battery is used.
+func (t *TelemetryTestRunner) SetPowerSource(source simulation.PowerSource) {
+	t.power = source
+	t.mockRobot.SetPowerSource(source)
+	t.updateRateState()
+}
+
+// ShareObstacles makes the robot scan source from its current pose with every
+// navigation sample and publishes the detections to the fleet's shared obstacle map.
+// Scans see sensorRange metres across fieldOfView degrees, so the map can clear
+// obstacles that are gone; see obstacles.Reporter. It must be called before Run.
+func (t *TelemetryTestRunner) ShareObstacles(source simulation.ObstacleSource, config obstacles.Config, sensorRange, fieldOfView float64) {
+	reporter := obstacles.NewReporter(t.mockRobot.ID, t.robotClient, config)
+	reporter.SetSensor(sensorRange, fieldOfView)
+	t.mockRobot.SetObstacleSource(reporter.Wrap(source))
+}
+
+// RunObstacleService also merges the fleet's obstacle reports into the shared map and
+// publishes its tiles, for a site without a separate map service. It must be called
+// before Run.
+func (t *TelemetryTestRunner) RunObstacleService(config obstacles.Config) error {
+	service, err := obstacles.NewService(t.robotClient, config)
+	if err != nil {
+		return err
+	}
+	t.obstacleService = service
+	return nil
+}
+
+// SetRateConfig replaces the adaptive publishing rates. It must be called before Run.
+func (t *TelemetryTestRunner) SetRateConfig(config rate.Config) error {
+	rates, err := rate.NewController(config)
+	if err != nil {
+		return err
+	}
+	t.rates = rates
+	t.updateRateState()
+	return nil
+}
+
+// updateRateState feeds the robot's mode, status and battery to the rate controller
+func (t *TelemetryTestRunner) updateRateState() {
+	state := rate.State{
+		Moving:     t.moving.Load(),
+		Status:     t.mockRobot.Status(),
+		BatteryPct: t.mockRobot.BatteryPct(),
+	}
+	if t.rates.SetState(state) {
+		t.log.Info("Robot state changed (moving=%v, status=%s, battery=%.0f%%), health every %v, navigation every %v",
+			state.Moving, state.Status, state.BatteryPct, t.rates.Interval("health"), t.rates.Interval("navigation"))
+	}
+}
+
+// registerRPCHandlers wires the calls the fleet controller can make against this robot
+func (t *TelemetryTestRunner) registerRPCHandlers() {
+	t.rpcServer.Handle("get_config", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		opts := t.robotClient.Options()
+		hz := func(stream string) float64 {
+			if interval := t.rates.Interval(stream); interval > 0 {
+				return float64(time.Second) / float64(interval)
+			}
+			return 0
+		}
+		return map[string]interface{}{
+			"robot_id":         opts.RobotID,
+			"broker_url":       opts.BrokerURL,
+			"active_broker":    t.robotClient.ConnectionStats().Broker,
+			"topic_prefix":     opts.TopicPrefix,
+			"software_version": SoftwareVersion,
+			"health_hz":        hz("health"),
+			"navigation_hz":    hz("navigation"),
+		}, nil
+	})
+	// query_health streams the health history of the last "seconds" in chunks
+	t.rpcServer.HandleStream("query_health", func(ctx context.Context, params json.RawMessage, send func(interface{}) error) error {
+		query := struct {
+			Seconds   float64 `json:"seconds"`
+			ChunkSize int     `json:"chunk_size"`
+		}{Seconds: 600, ChunkSize: 50}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params, &query); err != nil {
+				return rpc.BadRequest("%v", err)
+			}
+		}
+		if query.Seconds <= 0 || query.ChunkSize <= 0 {
+			return rpc.BadRequest("seconds and chunk_size must be positive")
+		}
+
+		since := time.Now().Add(-time.Duration(query.Seconds * float64(time.Second)))
+		t.historyMu.Lock()
+		var matches []simulation.HealthMessage
+		for _, msg := range t.healthHistory {
+			if msg.Timestamp.After(since) {
+				matches = append(matches, msg)
+			}
+		}
+		t.historyMu.Unlock()
+
+		for start := 0; start < len(matches); start += query.ChunkSize {
+			end := start + query.ChunkSize
+			if end > len(matches) {
+				end = len(matches)
+			}
+			if err := send(matches[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// registerCommandHandlers wires the commands the mock runner understands; move_to and
+// calibrate have no hardware behind them and are rejected as unsupported
+func (t *TelemetryTestRunner) registerCommandHandlers() {
+	t.commands.Register(command.TypePing, func(ctx context.Context, cmd command.Command) (interface{}, error) {
+		var args command.PingArgs
+		if err := cmd.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"payload": args.Payload, "robot_time": time.Now()}, nil
+	})
+	t.commands.Register(command.TypeStop, func(ctx context.Context, cmd command.Command) (interface{}, error) {
+		var args command.StopArgs
+		if err := cmd.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		t.log.Warn("Stop requested by %s (emergency=%v): %s", cmd.Issuer, args.Emergency, args.Reason)
+		return nil, nil
+	})
+	t.commands.Register(command.TypeSetMode, func(ctx context.Context, cmd command.Command) (interface{}, error) {
+		var args command.SetModeArgs
+		if err := cmd.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		t.log.Info("Mode set to %s", args.Mode)
+		moving := args.Mode == command.ModeAutonomous || args.Mode == command.ModeManual
+		t.moving.Store(moving)
+		t.mockRobot.SetMoving(moving)
+		t.updateRateState()
+		return map[string]string{"mode": args.Mode}, nil
+	})
+	t.commands.Register(command.TypeSetRate, func(ctx context.Context, cmd command.Command) (interface{}, error) {
+		var args command.SetRateArgs
+		if err := cmd.DecodeArgs(&args); err != nil {
+			return nil, err
+		}
+		if t.rates.Interval(args.Stream) == 0 {
+			return nil, fmt.Errorf("unknown stream %q", args.Stream)
+		}
+		t.rates.Override(args.Stream, time.Duration(float64(time.Second)/args.Hz))
+		return map[string]interface{}{"stream": args.Stream, "hz": args.Hz}, nil
+	})
 }
 
 func (t *TelemetryTestRunner) Run() error {
@@ -51,84 +350,61 @@ func (t *TelemetryTestRunner) Run() error {
 	go func() {
 		defer t.pool.Shutdown()
 
-		// Submit MQTT connection job
+		// Submit MQTT connection job. With several brokers configured the client picks
+		// the best available one; until any accepts, keep trying with backoff.
 		connectionJob := workerpool.Job{
 			Name: "MQTT Connection",
 			Execute: func(ctx context.Context) error {
-				// TODO(future): Implement service discovery or configuration to handle multiple MQTT brokers
-				// Currently hibernating if primary broker is unavailable
-				maxAttempts := 3
-				attempt := 0
-
-				for attempt < maxAttempts {
-					t.log.Debug("Attempting MQTT connection to %s (attempt %d/%d)",
-						t.robotClient.BrokerURL(), attempt+1, maxAttempts)
-
+				backoff := time.Second
+				for attempt := 1; ; attempt++ {
+					t.log.Debug("Attempting MQTT connection to %s (attempt %d)", t.robotClient.BrokerURL(), attempt)
 					err := t.robotClient.Connect()
 					if err == nil {
-						t.log.Debug("Successfully connected to MQTT broker")
 						return nil
 					}
-
-					t.log.Debug("Connection attempt failed: %v", err)
-					attempt++
-
-					if attempt == maxAttempts {
-						t.log.Info("Maximum connection attempts reached, entering hibernate mode")
-						// Instead of failing, we'll just hibernate this worker
-						t.log.Info("Worker hibernating - will wake when broker at %s becomes available", t.robotClient.BrokerURL())
-						select {
-						case <-ctx.Done():
-							return ctx.Err()
-						case <-time.After(time.Hour): // Effectively hibernate
-							return fmt.Errorf("connection hibernated")
-						}
-					}
-
+					t.log.Warn("Connection attempt %d failed, retrying in %v: %v", attempt, backoff, err)
 					select {
 					case <-ctx.Done():
 						return ctx.Err()
-					case <-time.After(time.Second * time.Duration(attempt)):
-						continue
+					case <-time.After(backoff):
+					}
+					if backoff *= 2; backoff > maxConnectBackoff {
+						backoff = maxConnectBackoff
 					}
 				}
-				return fmt.Errorf("connection attempts exhausted")
 			},
 			OnError: func(err error) {
-				if err.Error() == "connection hibernated" {
-					t.log.Info("MQTT connection hibernated - will retry when broker becomes available")
-				} else {
-					t.log.Error("MQTT connection failed: %v", err)
-				}
+				t.log.Error("MQTT connection failed: %v", err)
 			},
 			Retries:  0,     // Don't retry, we handle retries in the Execute function
 			Critical: false, // Changed to false so it doesn't trigger shutdown
 		}
 		t.pool.Submit(connectionJob)
 
-		// Remove the WaitForCriticalJob and replace with a connection check loop
 		t.log.Debug("Waiting for MQTT connection to be established...")
-		for {
-			if t.robotClient.IsConnected() {
-				t.log.Info("MQTT connection established")
-				break
-			}
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(time.Second):
-				continue
-			}
+		select {
+		case <-t.connected:
+		case <-ctx.Done():
+			return
 		}
 
 		// Submit subscription job
 		subscriptionJob := workerpool.Job{
 			Name: "MQTT Subscription",
 			Execute: func(ctx context.Context) error {
-				return t.robotClient.SubscribeToCommands(func(client paho.Client, msg paho.Message) {
-					t.log.Info("Received command: %s on topic: %s",
-						string(msg.Payload()), msg.Topic())
+				err := t.robotClient.SubscribeToCommands(func(topic string, payload []byte) {
+					t.log.Debug("Received command on topic: %s", topic)
+					t.commands.Dispatch(t.pool.Context(), payload)
 				})
+				if err != nil {
+					return err
+				}
+				if t.obstacleService != nil {
+					if err := t.obstacleService.Start(); err != nil {
+						return err
+					}
+				}
+				return t.rpcServer.Start(t.pool.Context())
 			},
 			Retries:  3,
 			Critical: true,
@@ -146,8 +422,25 @@ func (t *TelemetryTestRunner) Run() error {
 		}
 		t.pool.Submit(publishJob)
 
+		if t.obstacleService != nil {
+			t.pool.Submit(workerpool.Job{
+				Name: "Obstacle Map Service",
+				Execute: func(ctx context.Context) error {
+					t.obstacleService.Run(t.pool.Context())
+					return nil
+				},
+			})
+		}
+
 		// Wait for shutdown signal
 		<-ctx.Done()
+
+		// Announce a clean shutdown so the fleet does not wait for the Last Will
+		if t.robotClient.IsConnected() {
+			if err := t.robotClient.Disconnect(); err != nil {
+				t.log.Error("Failed to disconnect cleanly: %v", err)
+			}
+		}
 	}()
 
 	// Wait for either error or interrupt
@@ -175,48 +468,176 @@ func (t *TelemetryTestRunner) Run() error {
 	}
 }
 
-func (t *TelemetryTestRunner) publishMockTelemetry() {
-	ticker := time.NewTicker(2 * time.Second)
+// onConnectionEvent logs the connection lifecycle and wakes Run once connected. The
+// transport restores the command and RPC subscriptions after a reconnect.
+func (t *TelemetryTestRunner) onConnectionEvent(ev transport.Event) {
+	switch ev.Type {
+	case transport.EventConnecting:
+		t.log.Debug("Connecting to %s", t.robotClient.BrokerURL())
+	case transport.EventConnected:
+		stats := t.robotClient.ConnectionStats()
+		if ev.Broker != "" {
+			t.log.Info("Using MQTT broker %s", ev.Broker)
+		}
+		if stats.Reconnects > 0 {
+			t.log.Info("MQTT connection re-established (reconnect %d, %v disconnected in total)",
+				stats.Reconnects, stats.Downtime.Round(time.Second))
+		} else {
+			t.log.Info("MQTT connection established")
+		}
+		select {
+		case t.connected <- struct{}{}:
+		default:
+		}
+	case transport.EventConnectionLost:
+		t.log.Warn("MQTT connection lost: %v", ev.Err)
+	case transport.EventReconnecting:
+		t.log.Debug("Reconnecting to %s (attempt %d)", t.robotClient.BrokerURL(), ev.Attempt)
+	case transport.EventResubscribed:
+		if ev.Err == nil {
+			t.log.Info("Command subscriptions restored")
+		}
+	}
+}
+
+// powerPollInterval is how often a polled power source reads the BMS
+const powerPollInterval = time.Second
+
+// pollPower polls source until ctx is done, logging when polls start and stop failing
+func (t *TelemetryTestRunner) pollPower(ctx context.Context, source simulation.PolledPowerSource) {
+	ticker := time.NewTicker(powerPollInterval)
 	defer ticker.Stop()
+	failing := false
+	for {
+		if err := source.PollPower(ctx); err != nil && ctx.Err() == nil {
+			if !failing {
+				t.log.Warn("Failed to poll the battery, using the last known state: %v", err)
+			}
+			failing = true
+		} else if err == nil && failing {
+			t.log.Info("Battery polling recovered")
+			failing = false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
+// publishMockTelemetry publishes health and navigation until the pool stops, each
+// sampled at the rate controller's interval for its stream
+func (t *TelemetryTestRunner) publishMockTelemetry() {
 	ctx := t.pool.Context()
-	done := make(chan struct{})
-	var once sync.Once // Add this to ensure we only close once
-
-	// Goroutine to handle context cancellation
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if poller, ok := t.power.(simulation.PolledPowerSource); ok {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.pollPower(ctx, poller)
+		}()
+	}
+	wg.Add(1)
 	go func() {
-		<-ctx.Done()
-		ticker.Stop()
-		once.Do(func() {
-			close(done)
+		defer wg.Done()
+		t.publishStream(ctx, "navigation", func() (interface{}, time.Time) {
+			msg := t.mockRobot.Navigate()
+			return msg, msg.Timestamp
 		})
 	}()
+	t.publishStream(ctx, "health", func() (interface{}, time.Time) {
+		msg := t.sampleHealth()
+		return msg, msg.Timestamp
+	})
+	t.log.Info("Stopping telemetry publishing")
+}
+
+// publishStream takes a sample at the stream's interval and publishes the samples the
+// rate controller reports: changes beyond the deadbands, keep-alives and state changes
+func (t *TelemetryTestRunner) publishStream(ctx context.Context, stream string, sample func() (interface{}, time.Time)) {
+	if t.rates.Interval(stream) == 0 {
+		t.log.Info("No %s rate configured, not publishing it", stream)
+		return
+	}
+	updates := t.rates.Updates()
+	timer := time.NewTimer(t.rates.Interval(stream))
+	defer timer.Stop()
 
 	for {
 		select {
-		case <-done:
-			t.log.Info("Stopping telemetry publishing")
+		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			select {
-			case <-done:
-				return
-			default:
-				healthData := simulation.HealthMessage{
-					RobotID:      t.mockRobot.ID,
-					Timestamp:    time.Now(),
-					CPUTemp:      45.5,
-					MotorTemps:   []float64{50.0, 48.5},
-					VoltageLevel: 12.1,
-					CurrentDraw:  2.5,
-				}
-
-				if err := t.robotClient.PublishTelemetry("health", healthData); err != nil {
-					t.log.Error("Failed to publish health data: %v", err)
-				} else {
-					t.log.Debug("Published health data")
+		case <-updates:
+			// Report a state change straight away rather than at the old rate
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
 			}
+			timer.Reset(0)
+			continue
+		case <-timer.C:
+		}
+
+		t.updateRateState()
+		timer.Reset(t.rates.Interval(stream))
+
+		msg, at := sample()
+		reason, ok := t.rates.Report(stream, msg, at)
+		if !ok {
+			continue
+		}
+		if err := t.robotClient.PublishTelemetry(stream, msg); errors.Is(err, governor.ErrShed) {
+			t.log.Debug("%s data shed to stay within the bandwidth budget", stream)
+		} else if err != nil {
+			t.log.Error("Failed to publish %s data: %v", stream, err)
+		} else {
+			t.log.Debug("Published %s data (%s)", stream, reason)
 		}
 	}
 }
+
+// sampleHealth collects a health sample and adds it to the history
+func (t *TelemetryTestRunner) sampleHealth() simulation.HealthMessage {
+	healthData := simulation.HealthMessage{
+		RobotID:      t.mockRobot.ID,
+		Timestamp:    time.Now(),
+		MotorTemps:   []float64{50.0, 48.5},
+		VoltageLevel: 12.1,
+		CurrentDraw:  2.5,
+	}
+	if err := t.hostHealth.FillHealth(&healthData); err != nil {
+		t.log.Warn("Failed to collect host health: %v", err)
+	}
+	if t.power != nil {
+		if power, err := t.power.PowerStatus(); err != nil {
+			t.log.Debug("Power status unavailable: %v", err)
+		} else {
+			healthData.VoltageLevel = power.VoltageLevel
+			healthData.CurrentDraw = power.CurrentDraw
+			healthData.ErrorCodes = append(healthData.ErrorCodes, power.ErrorCodes...)
+		}
+	}
+	outboxStats := t.robotClient.OutboxStats()
+	healthData.OutboxDepth = outboxStats.Depth
+	healthData.OutboxDropped = outboxStats.Dropped
+	for messageType, count := range t.robotClient.GovernorStats().Shed() {
+		healthData.Shed = append(healthData.Shed, simulation.ShedCount{Type: messageType, Count: count})
+	}
+	sort.Slice(healthData.Shed, func(i, j int) bool { return healthData.Shed[i].Type < healthData.Shed[j].Type })
+	connStats := t.robotClient.ConnectionStats()
+	healthData.Reconnects = connStats.Reconnects
+	healthData.DowntimeSeconds = connStats.Downtime.Seconds()
+
+	// The history keeps every sample, published or not
+	t.historyMu.Lock()
+	t.healthHistory = append(t.healthHistory, healthData)
+	if len(t.healthHistory) > healthHistoryLimit {
+		t.healthHistory = t.healthHistory[len(t.healthHistory)-healthHistoryLimit:]
+	}
+	t.historyMu.Unlock()
+	return healthData
+}
@@ -0,0 +1,200 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+
+	"telemetry/src/broker"
+)
+
+// ErrConnectionDropped is the reason given when Memory.Drop simulates a lost connection
+var ErrConnectionDropped = errors.New("connection dropped")
+
+// Bus is an in-process message bus with MQTT semantics for unit tests and simulations:
+// wildcard subscriptions, retained messages and last wills. Delivery is synchronous on
+// the publisher's goroutine, so a scenario is deterministic.
+type Bus struct {
+	mu       sync.RWMutex
+	clients  map[*Memory]struct{}
+	retained map[string][]byte
+}
+
+// NewBus creates an empty bus
+func NewBus() *Bus {
+	return &Bus{
+		clients:  make(map[*Memory]struct{}),
+		retained: make(map[string][]byte),
+	}
+}
+
+// Retained returns the retained payload for topic
+func (b *Bus) Retained(topic string) ([]byte, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+type memorySubscription struct {
+	filter  string
+	handler Handler
+}
+
+// Memory is a Transport on a Bus
+type Memory struct {
+	bus       *Bus
+	mu        sync.Mutex
+	connected bool
+	will      *Will
+	subs      []memorySubscription
+	events    Events
+}
+
+// NewMemory creates a transport on bus; like an MQTT client it must Connect first
+func NewMemory(bus *Bus) *Memory {
+	return &Memory{bus: bus}
+}
+
+// Connect joins the bus. Subscriptions made before a Drop or Disconnect are kept and
+// take effect again.
+func (m *Memory) Connect() error {
+	m.events.emit(EventConnecting, nil)
+	m.mu.Lock()
+	m.connected = true
+	resubscribed := len(m.subs) > 0
+	m.mu.Unlock()
+	m.bus.mu.Lock()
+	m.bus.clients[m] = struct{}{}
+	m.bus.mu.Unlock()
+	m.events.emit(EventConnected, nil)
+	if resubscribed {
+		m.events.emit(EventResubscribed, nil)
+	}
+	return nil
+}
+
+func (m *Memory) Disconnect() {
+	if m.disconnect() {
+		m.events.emit(EventDisconnected, nil)
+	}
+}
+
+// Drop simulates a lost connection: the will is published and subscribers see
+// EventConnectionLost. Subscriptions are kept, as with a persistent session, and
+// resume on the next Connect.
+func (m *Memory) Drop() {
+	if !m.disconnect() {
+		return
+	}
+	m.mu.Lock()
+	will := m.will
+	m.mu.Unlock()
+	if will != nil {
+		m.bus.publish(will.Topic, will.Retained, will.Payload)
+	}
+	m.events.emit(EventConnectionLost, ErrConnectionDropped)
+}
+
+func (m *Memory) disconnect() bool {
+	m.mu.Lock()
+	if !m.connected {
+		m.mu.Unlock()
+		return false
+	}
+	m.connected = false
+	m.mu.Unlock()
+	m.bus.mu.Lock()
+	delete(m.bus.clients, m)
+	m.bus.mu.Unlock()
+	return true
+}
+
+func (m *Memory) IsConnected() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.connected
+}
+
+func (m *Memory) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	if !m.IsConnected() {
+		return ErrNotConnected
+	}
+	m.bus.publish(topic, retained, payload)
+	return nil
+}
+
+func (m *Memory) Subscribe(topic string, qos byte, handler Handler) error {
+	if !m.IsConnected() {
+		return ErrNotConnected
+	}
+	// Subscribing to the same filter again replaces its handler, as with paho
+	m.mu.Lock()
+	replaced := false
+	for i := range m.subs {
+		if m.subs[i].filter == topic {
+			m.subs[i].handler = handler
+			replaced = true
+		}
+	}
+	if !replaced {
+		m.subs = append(m.subs, memorySubscription{filter: topic, handler: handler})
+	}
+	m.mu.Unlock()
+
+	m.bus.mu.RLock()
+	var retained []memoryDelivery
+	for t, payload := range m.bus.retained {
+		if broker.TopicMatches(topic, t) {
+			retained = append(retained, memoryDelivery{handler, t, payload})
+		}
+	}
+	m.bus.mu.RUnlock()
+	for _, d := range retained {
+		d.handler(d.topic, append([]byte(nil), d.payload...))
+	}
+	return nil
+}
+
+func (m *Memory) SetWill(will Will) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.will = &will
+}
+
+func (m *Memory) OnEvent(fn func(Event)) {
+	m.events.Add(fn)
+}
+
+type memoryDelivery struct {
+	handler Handler
+	topic   string
+	payload []byte
+}
+
+// publish delivers to every matching subscription of a connected client; handlers run
+// without locks held so they may publish in turn
+func (b *Bus) publish(topic string, retained bool, payload []byte) {
+	b.mu.Lock()
+	if retained {
+		if len(payload) == 0 {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = append([]byte(nil), payload...)
+		}
+	}
+	var deliveries []memoryDelivery
+	for client := range b.clients {
+		client.mu.Lock()
+		for _, sub := range client.subs {
+			if broker.TopicMatches(sub.filter, topic) {
+				deliveries = append(deliveries, memoryDelivery{sub.handler, topic, payload})
+			}
+		}
+		client.mu.Unlock()
+	}
+	b.mu.Unlock()
+
+	for _, d := range deliveries {
+		d.handler(d.topic, append([]byte(nil), d.payload...))
+	}
+}
@@ -0,0 +1,235 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultPublishTimeout bounds how long Publish waits for the broker to take a message
+const DefaultPublishTimeout = 10 * time.Second
+
+// disconnectQuiesce is how long Disconnect lets in-flight work finish, in milliseconds
+const disconnectQuiesce = 250
+
+// ErrTimeout is returned when the broker does not acknowledge in time
+var ErrTimeout = errors.New("timed out waiting for the broker")
+
+type pahoSubscription struct {
+	qos     byte
+	handler Handler
+}
+
+// Paho is a Transport over the paho MQTT client, for tcp://, ssl:// and ws:// brokers
+type Paho struct {
+	opts           *paho.ClientOptions
+	publishTimeout time.Duration
+	mu             sync.RWMutex
+	client         paho.Client
+	events         Events
+	attempts       atomic.Int32
+	lostMu         sync.Mutex
+	lost           chan struct{} // Closed once the current connection's loss is reported
+	subsMu         sync.Mutex
+	subs           map[string]pahoSubscription
+	restore        map[string]pahoSubscription // Subscriptions to make again on the next connect
+}
+
+// NewPaho creates a transport from paho client options. The transport installs its
+// own connect, connection-lost and reconnecting handlers.
+func NewPaho(opts *paho.ClientOptions) *Paho {
+	p := &Paho{
+		opts:           opts,
+		publishTimeout: DefaultPublishTimeout,
+		subs:           make(map[string]pahoSubscription),
+		lost:           make(chan struct{}),
+	}
+	opts.SetOnConnectHandler(p.onConnect)
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		p.pendingRestore()
+		p.events.emit(EventConnectionLost, err)
+		p.lostMu.Lock()
+		select {
+		case <-p.lost:
+		default:
+			close(p.lost)
+		}
+		p.lostMu.Unlock()
+	})
+	opts.SetReconnectingHandler(func(paho.Client, *paho.ClientOptions) {
+		// paho reports the loss and starts reconnecting on separate goroutines; keep
+		// the events in order
+		p.lostMu.Lock()
+		lost := p.lost
+		p.lostMu.Unlock()
+		select {
+		case <-lost:
+		case <-time.After(time.Second):
+		}
+		p.events.Emit(Event{Type: EventReconnecting, Attempt: int(p.attempts.Add(1))})
+	})
+	return p
+}
+
+// onConnect runs on paho's goroutine after every connect. The broker may have lost
+// the session, e.g. on a clean session or a broker restart, so every subscription made
+// before the connection went down is made again.
+func (p *Paho) onConnect(client paho.Client) {
+	p.attempts.Store(0)
+	p.lostMu.Lock()
+	p.lost = make(chan struct{})
+	p.lostMu.Unlock()
+	p.events.emit(EventConnected, nil)
+
+	p.subsMu.Lock()
+	subs := p.restore
+	p.restore = nil
+	p.subsMu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+	var firstErr error
+	for filter, sub := range subs {
+		token := client.Subscribe(filter, sub.qos, pahoHandler(sub.handler))
+		token.Wait()
+		if err := token.Error(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("resubscribe %s: %w", filter, err)
+		}
+	}
+	p.events.emit(EventResubscribed, firstErr)
+}
+
+// pendingRestore marks the current subscriptions for onConnect. Subscriptions made
+// after this, e.g. between Connect returning and onConnect running, are left alone so
+// the broker does not resend their retained messages.
+func (p *Paho) pendingRestore() {
+	p.subsMu.Lock()
+	defer p.subsMu.Unlock()
+	p.restore = make(map[string]pahoSubscription, len(p.subs))
+	for filter, sub := range p.subs {
+		p.restore[filter] = sub
+	}
+}
+
+func pahoHandler(handler Handler) paho.MessageHandler {
+	return func(_ paho.Client, msg paho.Message) {
+		handler(msg.Topic(), msg.Payload())
+	}
+}
+
+// SetPublishTimeout changes how long Publish waits for the broker. It must be called
+// before Connect.
+func (p *Paho) SetPublishTimeout(timeout time.Duration) {
+	p.publishTimeout = timeout
+}
+
+func (p *Paho) Connect() error {
+	p.events.emit(EventConnecting, nil)
+	p.pendingRestore()
+	p.mu.Lock()
+	client := paho.NewClient(p.opts)
+	p.client = client
+	p.mu.Unlock()
+
+	token := client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
+func (p *Paho) Disconnect() {
+	client := p.current()
+	if client == nil {
+		return
+	}
+	client.Disconnect(disconnectQuiesce)
+	p.events.emit(EventDisconnected, nil)
+}
+
+func (p *Paho) IsConnected() bool {
+	client := p.current()
+	return client != nil && client.IsConnected()
+}
+
+func (p *Paho) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	client := p.current()
+	if client == nil {
+		return ErrNotConnected
+	}
+	token := client.Publish(topic, qos, retained, payload)
+	if !token.WaitTimeout(p.publishTimeout) {
+		return ErrTimeout
+	}
+	return token.Error()
+}
+
+func (p *Paho) Subscribe(topic string, qos byte, handler Handler) error {
+	client := p.current()
+	if client == nil {
+		return ErrNotConnected
+	}
+	token := client.Subscribe(topic, qos, pahoHandler(handler))
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+	p.subsMu.Lock()
+	p.subs[topic] = pahoSubscription{qos: qos, handler: handler}
+	p.subsMu.Unlock()
+	return nil
+}
+
+func (p *Paho) SetWill(will Will) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts.SetBinaryWill(will.Topic, will.Payload, will.QoS, will.Retained)
+}
+
+func (p *Paho) OnEvent(fn func(Event)) {
+	p.events.Add(fn)
+}
+
+func (p *Paho) current() paho.Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.client
+}
+
+// WebSocketConfig tunes MQTT over WebSockets
+type WebSocketConfig struct {
+	// Header is sent with the handshake, e.g. credentials for an authenticating proxy
+	Header          http.Header
+	ReadBufferSize  int
+	WriteBufferSize int
+	Proxy           func(req *http.Request) (*url.URL, error)
+}
+
+// NewWebSocket creates a transport speaking MQTT over WebSockets, for sites where only
+// HTTP(S) leaves the network. Every broker in opts must be a ws:// or wss:// URL.
+func NewWebSocket(opts *paho.ClientOptions, config WebSocketConfig) (*Paho, error) {
+	if len(opts.Servers) == 0 {
+		return nil, errors.New("no WebSocket broker configured")
+	}
+	for _, server := range opts.Servers {
+		if server.Scheme != "ws" && server.Scheme != "wss" {
+			return nil, fmt.Errorf("broker %s is not a ws:// or wss:// URL", server)
+		}
+	}
+	if config.Header != nil {
+		opts.SetHTTPHeaders(config.Header)
+	}
+	ws := &paho.WebsocketOptions{
+		ReadBufferSize:  config.ReadBufferSize,
+		WriteBufferSize: config.WriteBufferSize,
+	}
+	if config.Proxy != nil {
+		ws.Proxy = config.Proxy
+	}
+	opts.SetWebsocketOptions(ws)
+	return NewPaho(opts), nil
+}
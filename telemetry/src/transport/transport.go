@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotConnected is returned when publishing or subscribing while disconnected
+var ErrNotConnected = errors.New("transport not connected")
+
+// Handler receives the topic and payload of a message. It is an alias so transports
+// satisfy the small Subscribe interfaces declared by their consumers.
+type Handler = func(topic string, payload []byte)
+
+// EventType identifies a connection lifecycle event
+type EventType string
+
+const (
+	// EventConnecting is emitted when Connect starts
+	EventConnecting EventType = "connecting"
+	// EventConnected is emitted after every successful connect, including reconnects
+	EventConnected EventType = "connected"
+	// EventConnectionLost is emitted when an established connection drops; Err holds
+	// the reason
+	EventConnectionLost EventType = "connection_lost"
+	// EventReconnecting is emitted before each automatic reconnect attempt; Attempt
+	// counts from 1 since the connection was lost
+	EventReconnecting EventType = "reconnecting"
+	// EventResubscribed is emitted once subscriptions are restored after a reconnect;
+	// Err holds the first subscription that failed
+	EventResubscribed EventType = "resubscribed"
+	// EventDisconnected is emitted after a deliberate Disconnect
+	EventDisconnected EventType = "disconnected"
+)
+
+// Event is a connection lifecycle event
+type Event struct {
+	Type    EventType
+	Err     error
+	Attempt int
+	// Broker is the broker URL, set by transports that choose between brokers
+	Broker string
+	Time   time.Time
+}
+
+// Will is published on the client's behalf when its connection is lost
+type Will struct {
+	Topic    string
+	Payload  []byte
+	QoS      byte
+	Retained bool
+}
+
+// Transport carries telemetry with MQTT semantics: topics with + and # wildcards,
+// QoS, retained messages and a last will. Implementations are safe for concurrent use.
+type Transport interface {
+	Connect() error
+	// Disconnect closes the connection without publishing the will
+	Disconnect()
+	IsConnected() bool
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	// Subscribe registers handler for topic; subscriptions are restored automatically
+	// after a reconnect
+	Subscribe(topic string, qos byte, handler Handler) error
+	// SetWill sets the message published when the connection is lost. It takes effect
+	// on the next Connect.
+	SetWill(will Will)
+	// OnEvent registers fn for connection lifecycle events. Events are delivered in
+	// order on a goroutine of the transport's choosing; fn must not block for long.
+	OnEvent(fn func(Event))
+}
+
+// Events fans lifecycle events out to registered listeners, in order. Transports
+// embed it to implement OnEvent.
+type Events struct {
+	mu        sync.Mutex
+	listeners []func(Event)
+	emitMu    sync.Mutex
+}
+
+// Add registers fn for every later event
+func (e *Events) Add(fn func(Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+// Emit stamps ev with the current time and calls every listener with it
+func (e *Events) Emit(ev Event) {
+	e.emitMu.Lock()
+	defer e.emitMu.Unlock()
+	e.mu.Lock()
+	listeners := make([]func(Event), len(e.listeners))
+	copy(listeners, e.listeners)
+	e.mu.Unlock()
+	ev.Time = time.Now()
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+func (e *Events) emit(t EventType, err error) {
+	e.Emit(Event{Type: t, Err: err})
+}
@@ -0,0 +1,238 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"telemetry/src/broker"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+type recorder struct {
+	mu       sync.Mutex
+	messages []string
+	events   []EventType
+}
+
+func (r *recorder) handle(topic string, payload []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, topic+"="+string(payload))
+}
+
+func (r *recorder) event(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev.Type)
+}
+
+func (r *recorder) snapshot() ([]string, []EventType) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.messages...), append([]EventType(nil), r.events...)
+}
+
+func (r *recorder) waitMessages(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages, _ := r.snapshot()
+		if len(messages) >= n {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d messages, want %d: %v", len(messages), n, messages)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (r *recorder) waitEvent(t *testing.T, want EventType) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, events := r.snapshot()
+		for _, ev := range events {
+			if ev == want {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No %s event, got %v", want, events)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// exercise runs the same scenario over any transport: a fleet subscriber sees
+// retained status, wildcard telemetry and the robot's last will
+func exercise(t *testing.T, robot, fleet Transport, drop func()) {
+	var fleetRec, robotRec recorder
+	robot.OnEvent(robotRec.event)
+	robot.SetWill(Will{Topic: "robots/r1/status", Payload: []byte("lost"), QoS: 1, Retained: true})
+
+	if err := robot.Publish("robots/r1/status", 1, true, []byte("x")); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Publish before Connect: got %v", err)
+	}
+	if err := robot.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fleet.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	robotRec.waitEvent(t, EventConnected)
+	if !robot.IsConnected() {
+		t.Error("Expected robot to be connected")
+	}
+
+	if err := robot.Publish("robots/r1/status", 1, true, []byte("online")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fleet.Subscribe("robots/+/status", 1, fleetRec.handle); err != nil {
+		t.Fatal(err)
+	}
+	if err := fleet.Subscribe("robots/+/telemetry/#", 1, fleetRec.handle); err != nil {
+		t.Fatal(err)
+	}
+	fleetRec.waitMessages(t, 1)
+	if err := robot.Publish("robots/r1/telemetry/health", 1, false, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if err := robot.Publish("robots/r1/commands", 1, false, []byte("ignored")); err != nil {
+		t.Fatal(err)
+	}
+	fleetRec.waitMessages(t, 2)
+
+	drop()
+	robotRec.waitEvent(t, EventConnectionLost)
+	messages := fleetRec.waitMessages(t, 3)
+	want := []string{"robots/r1/status=online", "robots/r1/telemetry/health=ok", "robots/r1/status=lost"}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("Message %d = %q, want %q", i, messages[i], want[i])
+		}
+	}
+
+	fleet.Disconnect()
+	if fleet.IsConnected() {
+		t.Error("Expected fleet to be disconnected")
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	bus := NewBus()
+	robot, fleet := NewMemory(bus), NewMemory(bus)
+	exercise(t, robot, fleet, robot.Drop)
+
+	if payload, ok := bus.Retained("robots/r1/status"); !ok || string(payload) != "lost" {
+		t.Errorf("Retained status = %q, %t", payload, ok)
+	}
+
+	// Subscriptions survive a dropped connection and resume on reconnect
+	var rec recorder
+	observer := NewMemory(bus)
+	observer.Connect()
+	observer.Subscribe("robots/r1/#", 0, rec.handle)
+	if err := robot.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	robot.Subscribe("robots/r1/commands", 1, rec.handle)
+	robot.Drop()
+	observer.Publish("robots/r1/commands", 1, false, []byte("while-down"))
+	robot.Connect()
+	observer.Publish("robots/r1/commands", 1, false, []byte("after"))
+	messages, _ := rec.snapshot()
+	want := []string{
+		"robots/r1/status=lost", // Retained, on subscribe
+		"robots/r1/status=lost", // The robot's will
+		"robots/r1/commands=while-down",
+		"robots/r1/commands=after",
+		"robots/r1/commands=after",
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("Got %v, want %v", messages, want)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("Message %d = %q, want %q", i, messages[i], want[i])
+		}
+	}
+}
+
+func startBroker(t *testing.T) *broker.Broker {
+	t.Helper()
+	b := broker.New(broker.DefaultConfig())
+	if err := b.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StartWebSocket("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func clientOptions(url, clientID string) *paho.ClientOptions {
+	return paho.NewClientOptions().AddBroker(url).SetClientID(clientID).SetAutoReconnect(false)
+}
+
+func TestPahoTransport(t *testing.T) {
+	b := startBroker(t)
+	robot := NewPaho(clientOptions(b.URL(), "r1"))
+	fleet := NewPaho(clientOptions(b.URL(), "fleet"))
+	exercise(t, robot, fleet, func() { b.DisconnectClient("r1") })
+}
+
+func TestWebSocketTransport(t *testing.T) {
+	b := startBroker(t)
+	if _, err := NewWebSocket(clientOptions(b.URL(), "r1"), WebSocketConfig{}); err == nil {
+		t.Error("Expected a tcp:// broker to be rejected")
+	}
+	robot, err := NewWebSocket(clientOptions(b.WebSocketURL(), "r1"), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fleet, err := NewWebSocket(clientOptions(b.WebSocketURL(), "fleet"), WebSocketConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exercise(t, robot, fleet, func() { b.DisconnectClient("r1") })
+}
+
+func TestPahoResubscribesAfterReconnect(t *testing.T) {
+	b := startBroker(t)
+	// A clean session loses its subscriptions on the broker with every reconnect
+	opts := clientOptions(b.URL(), "r1").SetCleanSession(true).SetAutoReconnect(true).
+		SetMaxReconnectInterval(50 * time.Millisecond)
+	robot := NewPaho(opts)
+	var rec recorder
+	robot.OnEvent(rec.event)
+	if err := robot.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer robot.Disconnect()
+	if err := robot.Subscribe("robots/r1/commands", 1, rec.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	b.DisconnectClient("r1")
+	rec.waitEvent(t, EventResubscribed)
+	b.Publish("robots/r1/commands", 1, false, []byte("stop"))
+	if messages := rec.waitMessages(t, 1); messages[0] != "robots/r1/commands=stop" {
+		t.Errorf("Unexpected message %q", messages[0])
+	}
+
+	_, events := rec.snapshot()
+	want := []EventType{EventConnecting, EventConnected, EventConnectionLost, EventReconnecting, EventConnected, EventResubscribed}
+	if len(events) != len(want) {
+		t.Fatalf("Got events %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("Event %d = %s, want %s", i, events[i], want[i])
+		}
+	}
+}
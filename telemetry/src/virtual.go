@@ -0,0 +1,192 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrInputUnavailable is returned when a virtual sensor input has no reading yet
+var ErrInputUnavailable = errors.New("virtual sensor input unavailable")
+
+// ReadingCache keeps the latest reading of every sensor so derived sensors can use them
+type ReadingCache struct {
+	latest map[string]SensorData
+	mu     sync.RWMutex
+}
+
+// NewReadingCache creates an empty reading cache
+func NewReadingCache() *ReadingCache {
+	return &ReadingCache{
+		latest: make(map[string]SensorData),
+	}
+}
+
+// Update records data as the latest reading of its sensor
+func (c *ReadingCache) Update(data SensorData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latest[data.SensorID] = data
+}
+
+// Latest returns the most recent reading of a sensor
+func (c *ReadingCache) Latest(sensorID string) (SensorData, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	data, ok := c.latest[sensorID]
+	return data, ok
+}
+
+// DeriveFunc computes a derived value from the latest readings of the input sensors
+type DeriveFunc func(inputs map[string]SensorData) (interface{}, error)
+
+// VirtualSensor is a Sensor whose value is computed from other sensors' latest readings.
+// It is registered with the TelemetryManager like any other sensor and should be added
+// after the sensors it depends on so it sees readings from the same collection cycle.
+type VirtualSensor struct {
+	id       string
+	dataType string
+	inputs   []string
+	cache    *ReadingCache
+	derive   DeriveFunc
+	// MaxAge rejects input readings older than this; zero accepts any age
+	MaxAge time.Duration
+}
+
+// NewVirtualSensor creates a virtual sensor computing its value with a Go function
+func NewVirtualSensor(id, dataType string, cache *ReadingCache, inputs []string, derive DeriveFunc) *VirtualSensor {
+	return &VirtualSensor{
+		id:       id,
+		dataType: dataType,
+		inputs:   inputs,
+		cache:    cache,
+		derive:   derive,
+	}
+}
+
+// NewExpressionSensor creates a virtual sensor computing its value from an expression such as
+// "motor_voltage * motor_current" or "deg(atan2(imu.accel_x, imu.accel_z))"
+func NewExpressionSensor(id, dataType string, cache *ReadingCache, source string) (*VirtualSensor, error) {
+	expr, err := CompileExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	derive := func(inputs map[string]SensorData) (interface{}, error) {
+		return expr.Eval(func(sensorID, field string) (float64, error) {
+			return fieldValue(inputs[sensorID], field)
+		})
+	}
+	return NewVirtualSensor(id, dataType, cache, expr.Inputs(), derive), nil
+}
+
+func (s *VirtualSensor) ID() string {
+	return s.id
+}
+
+// Inputs returns the IDs of the sensors this sensor derives its value from
+func (s *VirtualSensor) Inputs() []string {
+	return s.inputs
+}
+
+func (s *VirtualSensor) Read(ctx context.Context) (SensorData, error) {
+	inputs := make(map[string]SensorData, len(s.inputs))
+	now := time.Now()
+	for _, id := range s.inputs {
+		data, ok := s.cache.Latest(id)
+		if !ok {
+			return SensorData{}, fmt.Errorf("%w: %s", ErrInputUnavailable, id)
+		}
+		if s.MaxAge > 0 && now.Sub(data.Timestamp) > s.MaxAge {
+			return SensorData{}, fmt.Errorf("%w: %s is stale", ErrInputUnavailable, id)
+		}
+		inputs[id] = data
+	}
+
+	value, err := s.derive(inputs)
+	if err != nil {
+		return SensorData{}, fmt.Errorf("deriving %s: %w", s.id, err)
+	}
+
+	return SensorData{
+		Timestamp: now,
+		SensorID:  s.id,
+		DataType:  s.dataType,
+		Value:     value,
+	}, nil
+}
+
+func (s *VirtualSensor) Initialize() error {
+	if s.cache == nil {
+		return fmt.Errorf("virtual sensor %s has no reading cache", s.id)
+	}
+	if s.derive == nil {
+		return fmt.Errorf("virtual sensor %s has no derive function", s.id)
+	}
+	return nil
+}
+
+func (s *VirtualSensor) Shutdown() error {
+	return nil
+}
+
+// fieldValue extracts a numeric value from a reading. An empty field selects the value
+// itself, otherwise the field is looked up by its JSON name (e.g. "accel_x" of IMUData).
+func fieldValue(data SensorData, field string) (float64, error) {
+	if field == "" {
+		return toFloat(data.Value)
+	}
+
+	if m, ok := data.Value.(map[string]interface{}); ok {
+		v, ok := m[field]
+		if !ok {
+			return 0, fmt.Errorf("sensor %s has no field %q", data.SensorID, field)
+		}
+		return toFloat(v)
+	}
+
+	raw, err := json.Marshal(data.Value)
+	if err != nil {
+		return 0, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return 0, fmt.Errorf("sensor %s value has no fields: %w", data.SensorID, err)
+	}
+	v, ok := fields[field]
+	if !ok {
+		return 0, fmt.Errorf("sensor %s has no field %q", data.SensorID, field)
+	}
+	return toFloat(v)
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case float32:
+		return float64(n), nil
+	case int:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint:
+		return float64(n), nil
+	case uint32:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case bool:
+		if n {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("value of type %T is not numeric", v)
+	}
+}
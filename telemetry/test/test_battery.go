@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// MockBMS returns a configurable reading
+type MockBMS struct {
+	reading BMSReading
+}
+
+func (b *MockBMS) ReadBMS(ctx context.Context) (BMSReading, error) {
+	return b.reading, nil
+}
+
+func cells(n int, volts float64) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = volts
+	}
+	return v
+}
+
+// TestOCVStateOfCharge tests the open circuit voltage curve
+func TestOCVStateOfCharge(t *testing.T) {
+	tests := []struct{ volts, soc float64 }{
+		{2.5, 0}, {3.78, 50}, {3.755, 45}, {4.2, 100}, {4.35, 100},
+	}
+	for _, tt := range tests {
+		if got := OCVStateOfCharge(tt.volts); math.Abs(got-tt.soc) > 1e-9 {
+			t.Errorf("OCVStateOfCharge(%v) = %v, want %v", tt.volts, got, tt.soc)
+		}
+	}
+}
+
+// TestBatterySensor tests coulomb counting, rest recalibration, health and alarms
+func TestBatterySensor(t *testing.T) {
+	bms := &MockBMS{reading: BMSReading{CellVoltages: cells(3, 4.2), Temperature: 25}}
+	cfg := DefaultBatteryConfig(2.0)
+	battery := NewBatterySensor("battery", bms, cfg)
+	if err := battery.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize battery: %v", err)
+	}
+
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	battery.now = func() time.Time { return clock }
+	ctx := context.Background()
+	if _, err := battery.PowerStatus(); !errors.Is(err, ErrInputUnavailable) {
+		t.Errorf("Expected no power status before the BMS is read, got %v", err)
+	}
+
+	data, err := battery.Read(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read battery: %v", err)
+	}
+	if soc := data.Value.(BatteryData).StateOfCharge; soc != 100 {
+		t.Fatalf("Expected full pack from OCV, got %v", soc)
+	}
+
+	// Draw 1 A for 30 minutes from a 2 Ah pack: 25% consumed by coulomb counting
+	bms.reading = BMSReading{CellVoltages: cells(3, 3.9), PackCurrent: 1.0, Temperature: 30}
+	for i := 0; i < 30; i++ {
+		clock = clock.Add(time.Minute)
+		battery.Read(ctx)
+	}
+	if soc := battery.Latest().StateOfCharge; math.Abs(soc-75) > 1e-6 {
+		t.Errorf("Expected 75%% after coulomb counting, got %v", soc)
+	}
+
+	// Rest long enough for OCV recalibration; 3.84 V per cell is 60%, so 25% of a
+	// nominal capacity moved only 40% of the real one, i.e. the pack has faded
+	bms.reading = BMSReading{CellVoltages: cells(3, 3.84), Temperature: 25}
+	for i := 0; i < 3; i++ {
+		clock = clock.Add(cfg.RestDuration)
+		battery.Read(ctx)
+	}
+	latest := battery.Latest()
+	if math.Abs(latest.StateOfCharge-60) > 1e-6 {
+		t.Errorf("Expected recalibration to 60%%, got %v", latest.StateOfCharge)
+	}
+	if latest.StateOfHealth >= 100 {
+		t.Errorf("Expected state of health to drop, got %v", latest.StateOfHealth)
+	}
+	if math.Abs(latest.Cycles-0.25) > 1e-6 {
+		t.Errorf("Expected 0.25 cycles, got %v", latest.Cycles)
+	}
+
+	power, err := battery.PowerStatus()
+	if err != nil || power.BatteryPct != latest.StateOfCharge || math.Abs(power.VoltageLevel-11.52) > 1e-9 {
+		t.Errorf("Unexpected power status: %+v (%v)", power, err)
+	}
+
+	// Polling reads the BMS, and its alarms reach the power status
+	bms.reading = BMSReading{CellVoltages: []float64{3.9, 3.7, 3.9}, PackCurrent: 0.5, Temperature: 65}
+	clock = clock.Add(time.Second)
+	if err := battery.PollPower(ctx); err != nil {
+		t.Fatalf("Failed to poll battery: %v", err)
+	}
+	codes := battery.Latest().ErrorCodes
+	if len(codes) != 2 || codes[0] != ErrCodeCellImbalance || codes[1] != ErrCodeOverTemperature {
+		t.Errorf("Unexpected error codes: %v", codes)
+	}
+	if power, _ := battery.PowerStatus(); len(power.ErrorCodes) != 2 {
+		t.Errorf("Expected the alarms in the power status, got %+v", power)
+	}
+}
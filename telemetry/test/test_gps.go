@@ -0,0 +1,149 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testGGA = "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47"
+	testRMC = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+	testVTG = "$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K*48"
+	testGSA = "$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39"
+)
+
+// TestParseNMEA tests parsing of the supported sentences
+func TestParseNMEA(t *testing.T) {
+	s, err := ParseNMEA(testGGA)
+	if err != nil {
+		t.Fatalf("Failed to parse GGA: %v", err)
+	}
+	gga := s.(GGASentence)
+	if math.Abs(gga.Latitude-48.1173) > 1e-6 || math.Abs(gga.Longitude-11.516667) > 1e-6 {
+		t.Errorf("Unexpected GGA position: %v, %v", gga.Latitude, gga.Longitude)
+	}
+	if gga.Quality != FixGPS || gga.Satellites != 8 || gga.Altitude != 545.4 || gga.GeoidSep != 46.9 {
+		t.Errorf("Unexpected GGA fields: %+v", gga)
+	}
+
+	s, err = ParseNMEA(testRMC)
+	if err != nil {
+		t.Fatalf("Failed to parse RMC: %v", err)
+	}
+	rmc := s.(RMCSentence)
+	want := time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)
+	if !rmc.Valid || !rmc.Time.Equal(want) || math.Abs(rmc.SpeedMPS-22.4*knotsToMPS) > 1e-9 {
+		t.Errorf("Unexpected RMC fields: %+v", rmc)
+	}
+
+	s, err = ParseNMEA(testVTG)
+	if err != nil {
+		t.Fatalf("Failed to parse VTG: %v", err)
+	}
+	if vtg := s.(VTGSentence); vtg.CourseDeg != 54.7 || math.Abs(vtg.SpeedMPS-10.2/3.6) > 1e-9 {
+		t.Errorf("Unexpected VTG fields: %+v", vtg)
+	}
+
+	s, err = ParseNMEA(testGSA)
+	if err != nil {
+		t.Fatalf("Failed to parse GSA: %v", err)
+	}
+	if gsa := s.(GSASentence); gsa.FixType != 3 || len(gsa.Satellites) != 5 || gsa.VDOP != 2.1 {
+		t.Errorf("Unexpected GSA fields: %+v", gsa)
+	}
+
+	if _, err := ParseNMEA(strings.Replace(testGGA, "*47", "*48", 1)); !errors.Is(err, ErrNMEAChecksum) {
+		t.Errorf("Expected ErrNMEAChecksum, got %v", err)
+	}
+	if _, err := ParseNMEA("$GPGSV,1,1,00"); !errors.Is(err, ErrNMEAUnsupported) {
+		t.Errorf("Expected ErrNMEAUnsupported, got %v", err)
+	}
+}
+
+// TestGPSSensor tests assembling a fix from a sentence stream
+func TestGPSSensor(t *testing.T) {
+	stream := strings.Join([]string{"garbage", testGGA, testRMC, testVTG, testGSA}, "\r\n")
+	gps := NewGPSSensor("gps-1", strings.NewReader(stream))
+	if err := gps.Initialize(); err != nil {
+		t.Fatalf("Failed to initialize gps: %v", err)
+	}
+	<-gps.Done()
+
+	data, err := gps.Read(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read gps: %v", err)
+	}
+	fix := data.Value.(GPSData)
+	if data.DataType != "gps" || fix.FixQuality != FixGPS || fix.FixType != 3 || fix.CourseDeg != 54.7 {
+		t.Errorf("Unexpected fix: %+v", fix)
+	}
+	if gps.ParseErrors() != 1 {
+		t.Errorf("Expected 1 parse error, got %d", gps.ParseErrors())
+	}
+
+	empty := NewGPSSensor("gps-2", strings.NewReader(""))
+	empty.Initialize()
+	<-empty.Done()
+	if _, err := empty.Read(context.Background()); !errors.Is(err, ErrNoGPSFix) {
+		t.Errorf("Expected ErrNoGPSFix, got %v", err)
+	}
+
+	// Receivers that only send RMC still report a fix
+	rmcOnly := NewGPSSensor("gps-3", strings.NewReader(testRMC))
+	rmcOnly.Initialize()
+	<-rmcOnly.Done()
+	data, err = rmcOnly.Read(context.Background())
+	if err != nil {
+		t.Fatalf("Expected a fix from a valid RMC, got %v", err)
+	}
+	if fix := data.Value.(GPSData); fix.FixQuality != FixGPS || fix.Latitude == 0 {
+		t.Errorf("Unexpected RMC-only fix: %+v", fix)
+	}
+
+	// Sentences without a position do not make an old one look fresh
+	stale := data.Timestamp.Add(-time.Minute)
+	rmcOnly.mu.Lock()
+	rmcOnly.updated = stale
+	rmcOnly.mu.Unlock()
+	for _, line := range []string{testVTG, testGSA} {
+		sentence, err := ParseNMEA(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rmcOnly.apply(sentence)
+	}
+	if data, err := rmcOnly.Read(context.Background()); err != nil || !data.Timestamp.Equal(stale) {
+		t.Errorf("Expected the position timestamp to stay at %v, got %v (%v)", stale, data.Timestamp, err)
+	}
+}
+
+// TestLocalFrame tests ENU conversion around a site origin
+func TestLocalFrame(t *testing.T) {
+	origin := GeoPoint{Latitude: 52.0, Longitude: 4.0, Altitude: 40.0}
+	frame := NewLocalFrame(origin)
+
+	if e, n, u := frame.ToENU(origin); math.Abs(e)+math.Abs(n)+math.Abs(u) > 1e-6 {
+		t.Errorf("Origin should map to zero, got %v %v %v", e, n, u)
+	}
+
+	// One arc second of latitude is roughly 30.9 m at this latitude
+	e, n, _ := frame.ToENU(GeoPoint{Latitude: 52.0 + 1.0/3600, Longitude: 4.0, Altitude: 40.0})
+	if math.Abs(e) > 1e-3 || math.Abs(n-30.9) > 0.1 {
+		t.Errorf("Unexpected ENU for point north of origin: %v %v", e, n)
+	}
+
+	p := frame.FromENU(125.0, -80.0, 3.5)
+	e, n, u := frame.ToENU(p)
+	if math.Abs(e-125.0) > 1e-6 || math.Abs(n+80.0) > 1e-6 || math.Abs(u-3.5) > 1e-6 {
+		t.Errorf("ENU round trip mismatch: %v %v %v", e, n, u)
+	}
+
+	pos := frame.Position(GPSData{Latitude: 52.0, Longitude: 4.0, Altitude: 30.0, GeoidSep: 10.0})
+	if math.Abs(pos.X)+math.Abs(pos.Y)+math.Abs(pos.Z) > 1e-6 {
+		t.Errorf("Unexpected navigation position: %+v", pos)
+	}
+}
@@ -0,0 +1,207 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeHAL plays the robot_hal side of the bridge protocol over a Unix socket
+type fakeHAL struct {
+	listener net.Listener
+	conn     net.Conn
+	commands chan HALMotorCommandMessage
+	reject   atomic.Bool
+}
+
+func startFakeHAL(t *testing.T) (*fakeHAL, string) {
+	path := filepath.Join(t.TempDir(), "hal.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", path, err)
+	}
+	hal := &fakeHAL{listener: listener, commands: make(chan HALMotorCommandMessage, 10)}
+	t.Cleanup(func() { listener.Close() })
+	return hal, path
+}
+
+// accept completes the handshake and then answers motor commands
+func (h *fakeHAL) accept(t *testing.T) {
+	conn, err := h.listener.Accept()
+	if err != nil {
+		return
+	}
+	h.conn = conn
+
+	frame, err := ReadHALFrame(conn)
+	if err != nil || frame.Type != HALHello {
+		t.Errorf("Expected hello from bridge, got %v (%v)", frame.Type, err)
+		return
+	}
+	WriteHALFrame(conn, HALHello, 1, HALHelloMessage{Version: HALProtocolVersion, Role: "hal", Capabilities: []string{"motor", "sensor"}})
+
+	go func() {
+		for {
+			frame, err := ReadHALFrame(conn)
+			if err != nil {
+				return
+			}
+			if frame.Type != HALMotorCommand {
+				continue
+			}
+			var cmd HALMotorCommandMessage
+			frame.Decode(&cmd)
+			h.commands <- cmd
+
+			result := HALCommandResultMessage{Seq: frame.Seq, OK: !h.reject.Load()}
+			if h.reject.Load() {
+				result.Error = &HALErrorMessage{Kind: "motor", Component: cmd.MotorID, Message: "driver fault"}
+			}
+			WriteHALFrame(conn, HALCommandResult, frame.Seq, result)
+		}
+	}()
+}
+
+// TestHALFrameRoundTrip tests encoding and validating frames
+func TestHALFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	msg := HALSensorReadingMessage{SensorID: "range", DataType: "ultrasonic", Value: 42.5}
+	if err := WriteHALFrame(&buf, HALSensorReading, 7, msg); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+
+	raw := append([]byte(nil), buf.Bytes()...)
+	frame, err := ReadHALFrame(&buf)
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	var decoded HALSensorReadingMessage
+	if err := frame.Decode(&decoded); err != nil {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+	if frame.Type != HALSensorReading || frame.Seq != 7 || decoded.Value != 42.5 {
+		t.Errorf("Unexpected frame: %+v %+v", frame, decoded)
+	}
+
+	raw[2] = HALProtocolVersion + 1
+	if _, err := ReadHALFrame(bytes.NewReader(raw)); !errors.Is(err, ErrHALVersion) {
+		t.Errorf("Expected ErrHALVersion, got %v", err)
+	}
+	raw[0] = 'X'
+	if _, err := ReadHALFrame(bytes.NewReader(raw)); !errors.Is(err, ErrHALBadMagic) {
+		t.Errorf("Expected ErrHALBadMagic, got %v", err)
+	}
+}
+
+// TestHALBridge tests readings, errors and motor commands against a fake HAL
+func TestHALBridge(t *testing.T) {
+	hal, path := startFakeHAL(t)
+	accepted := make(chan struct{})
+	go func() {
+		hal.accept(t)
+		close(accepted)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	bridge, err := DialHAL(ctx, path)
+	if err != nil {
+		t.Fatalf("Failed to dial hal: %v", err)
+	}
+	defer bridge.Close()
+	<-accepted
+
+	if bridge.Peer().Role != "hal" {
+		t.Errorf("Unexpected peer: %+v", bridge.Peer())
+	}
+
+	motor := bridge.MotorSensor("left")
+	if _, err := motor.Read(ctx); !errors.Is(err, ErrHALNoReading) {
+		t.Errorf("Expected ErrHALNoReading, got %v", err)
+	}
+
+	WriteHALFrame(hal.conn, HALMotorState, 2, HALMotorStateMessage{MotorID: "left", Speed: 0.5, Direction: "backward", Current: 1.2})
+	WriteHALFrame(hal.conn, HALSensorReading, 3, HALSensorReadingMessage{SensorID: "range", DataType: "ultrasonic", Value: 30})
+	WriteHALFrame(hal.conn, HALErrorReport, 4, HALErrorMessage{Kind: "sensor", Component: "range", Message: "echo timeout"})
+
+	deadline := time.Now().Add(time.Second)
+	for bridge.LastError() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	data, err := motor.Read(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read motor: %v", err)
+	}
+	if m := data.Value.(MotorData); m.Speed != 0.5 || m.Direction != -1 || m.Current != 1.2 {
+		t.Errorf("Unexpected motor data: %+v", m)
+	}
+
+	rangeSensor := bridge.Sensor("range")
+	var hwErr *HardwareError
+	if _, err := rangeSensor.Read(ctx); !errors.As(err, &hwErr) || hwErr.Kind != "sensor" {
+		t.Errorf("Expected sensor HardwareError, got %v", err)
+	}
+	if data, err := rangeSensor.Read(ctx); err != nil || data.Value != 30.0 {
+		t.Errorf("Expected reading after fault was reported, got %v (%v)", data, err)
+	}
+
+	if err := bridge.SetMotorSpeed(ctx, "left", 0.8); err != nil {
+		t.Errorf("SetMotorSpeed failed: %v", err)
+	}
+	if cmd := <-hal.commands; cmd.Command != "set_speed" || cmd.Speed != 0.8 {
+		t.Errorf("Unexpected command: %+v", cmd)
+	}
+	if err := bridge.SetMotorSpeed(ctx, "left", 1.5); err == nil {
+		t.Error("Expected error for out of range speed")
+	}
+
+	hal.reject.Store(true)
+	if err := bridge.StopMotor(ctx, "left"); !errors.As(err, &hwErr) || hwErr.Kind != "motor" {
+		t.Errorf("Expected motor HardwareError, got %v", err)
+	}
+	<-hal.commands
+
+	hal.conn.Close()
+	select {
+	case <-bridge.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Bridge did not notice disconnect")
+	}
+	if err := bridge.SetMotorDirection(ctx, "left", 1); !errors.Is(err, ErrHALDisconnect) {
+		t.Errorf("Expected ErrHALDisconnect, got %v", err)
+	}
+	if _, err := motor.Read(ctx); !errors.Is(err, ErrHALDisconnect) {
+		t.Errorf("Expected cached motor state to be dropped after disconnect, got %v", err)
+	}
+	if _, err := rangeSensor.Read(ctx); !errors.Is(err, ErrHALDisconnect) {
+		t.Errorf("Expected cached reading to be dropped after disconnect, got %v", err)
+	}
+}
+
+// TestHALBridgeCloseWithoutStart tests closing a bridge whose handshake never ran
+func TestHALBridgeCloseWithoutStart(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	bridge := NewHALBridge(local)
+
+	closed := make(chan struct{})
+	go func() {
+		bridge.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked on a bridge that was never started")
+	}
+	if _, err := bridge.Sensor("range").Read(context.Background()); !errors.Is(err, ErrHALDisconnect) {
+		t.Errorf("Expected ErrHALDisconnect, got %v", err)
+	}
+}
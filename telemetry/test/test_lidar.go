@@ -0,0 +1,109 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"telemetry/src/simulation"
+)
+
+// testScan builds a 360 degree scan with two small objects in front of and behind the sensor
+func testScan() LaserScan {
+	n := 360
+	scan := LaserScan{
+		AngleMin:       0,
+		AngleIncrement: 2 * math.Pi / float64(n),
+		RangeMin:       0.15,
+		RangeMax:       12.0,
+		Ranges:         make([]float64, n),
+	}
+	scan.AngleMax = scan.Angle(n - 1)
+	for i := range scan.Ranges {
+		scan.Ranges[i] = math.Inf(1)
+	}
+	// Object straight ahead at 1 m, spanning the wrap-around at angle 0
+	for _, i := range []int{357, 358, 359, 0, 1, 2} {
+		scan.Ranges[i] = 1.0
+	}
+	// Object behind at 3 m
+	for i := 178; i <= 182; i++ {
+		scan.Ranges[i] = 3.0
+	}
+	// Single noisy return that should be filtered
+	scan.Ranges[90] = 5.0
+	return scan
+}
+
+// TestLaserScanEncoding tests the compact encoding round trip and its size
+func TestLaserScanEncoding(t *testing.T) {
+	scan := testScan()
+	encoded, err := scan.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to encode scan: %v", err)
+	}
+
+	var decoded LaserScan
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatalf("Failed to decode scan: %v", err)
+	}
+	if len(decoded.Ranges) != len(scan.Ranges) || math.Abs(decoded.AngleMax-scan.AngleMax) > 1e-5 {
+		t.Errorf("Decoded scan header mismatch: %+v", decoded)
+	}
+	for i := range scan.Ranges {
+		if scan.Valid(i) != decoded.Valid(i) || (scan.Valid(i) && math.Abs(scan.Ranges[i]-decoded.Ranges[i]) > 0.001) {
+			t.Fatalf("Range %d mismatch: %v vs %v", i, scan.Ranges[i], decoded.Ranges[i])
+		}
+	}
+
+	// JSON cannot carry +Inf, so compare sizes on a scan of a room with walls all around
+	room := testScan()
+	for i := range room.Ranges {
+		room.Ranges[i] = 4.0 + 0.0137*float64(i)
+	}
+	encoded, _ = room.MarshalBinary()
+	jsonData, _ := json.Marshal(room)
+	t.Logf("Laser scan size: compact %d bytes, JSON %d bytes", len(encoded), len(jsonData))
+	if len(encoded)*3 > len(jsonData) {
+		t.Errorf("Compact encoding is %d bytes, JSON is %d bytes", len(encoded), len(jsonData))
+	}
+
+	if err := decoded.UnmarshalBinary(encoded[:len(encoded)-1]); err == nil {
+		t.Error("Expected error decoding truncated scan")
+	}
+}
+
+// TestExtractObstacles tests clustering a scan into world frame obstacles
+func TestExtractObstacles(t *testing.T) {
+	cache := NewReadingCache()
+	cache.Update(SensorData{Timestamp: time.Now(), SensorID: "lidar", DataType: "laser_scan", Value: testScan()})
+	source := NewLaserObstacleSource(cache, "lidar")
+
+	// Robot at (10, 5) facing +Y, so "ahead" is north
+	obstacles, err := source.DetectObstacles(simulation.Position{X: 10, Y: 5}, 90)
+	if err != nil {
+		t.Fatalf("Failed to detect obstacles: %v", err)
+	}
+	if len(obstacles) != 2 {
+		t.Fatalf("Expected 2 obstacles, got %d: %+v", len(obstacles), obstacles)
+	}
+
+	for _, o := range obstacles {
+		switch o.Severity {
+		case "MEDIUM":
+			if math.Abs(o.Position.X-10) > 0.05 || math.Abs(o.Position.Y-6) > 0.05 {
+				t.Errorf("Front obstacle at unexpected position: %+v", o.Position)
+			}
+			if o.Size <= 0 || o.Size > 0.2 {
+				t.Errorf("Front obstacle has unexpected size: %v", o.Size)
+			}
+		case "LOW":
+			if math.Abs(o.Position.X-10) > 0.05 || math.Abs(o.Position.Y-2) > 0.05 {
+				t.Errorf("Rear obstacle at unexpected position: %+v", o.Position)
+			}
+		default:
+			t.Errorf("Unexpected severity: %+v", o)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// duplexPipe joins two io.Pipes into one end of a bidirectional link
+type duplexPipe struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (p *duplexPipe) Close() error {
+	for _, c := range p.closers {
+		c.Close()
+	}
+	return nil
+}
+
+func newDuplexPipe() (*duplexPipe, *duplexPipe) {
+	hostRead, mcuWrite := io.Pipe()
+	mcuRead, hostWrite := io.Pipe()
+	host := &duplexPipe{Reader: hostRead, Writer: hostWrite, closers: []io.Closer{hostRead, hostWrite}}
+	mcu := &duplexPipe{Reader: mcuRead, Writer: mcuWrite, closers: []io.Closer{mcuRead, mcuWrite}}
+	return host, mcu
+}
+
+// TestCOBS tests COBS round trips including zero runs and long blocks
+func TestCOBS(t *testing.T) {
+	long := bytes.Repeat([]byte{0x11}, 600)
+	inputs := [][]byte{{}, {0}, {0, 0}, {1, 2, 0, 3}, long, append(long, 0)}
+
+	for _, in := range inputs {
+		enc := cobsEncode(in)
+		if bytes.IndexByte(enc, 0) >= 0 {
+			t.Errorf("Encoding of %d bytes contains zero", len(in))
+		}
+		dec, err := cobsDecode(enc)
+		if err != nil || !bytes.Equal(dec, in) {
+			t.Errorf("Round trip of %d bytes failed: %v", len(in), err)
+		}
+	}
+
+	if crc := crc16CCITT([]byte("123456789")); crc != 0x29B1 {
+		t.Errorf("Unexpected CRC-16/CCITT-FALSE check value 0x%04X", crc)
+	}
+}
+
+// TestSerialFrameCorruption tests that a corrupted frame is rejected
+func TestSerialFrameCorruption(t *testing.T) {
+	frame := EncodeSerialFrame(SerialUltrasonic, 9, EncodeUltrasonicPayload(0, UltrasonicData{Distance: 12}))
+	encoded := frame[:len(frame)-1]
+
+	frameType, seq, _, err := DecodeSerialFrame(encoded)
+	if err != nil || frameType != SerialUltrasonic || seq != 9 {
+		t.Fatalf("Failed to decode frame: %v", err)
+	}
+
+	encoded[3] ^= 0x40
+	if _, _, _, err := DecodeSerialFrame(encoded); !errors.Is(err, ErrSerialCRC) && !errors.Is(err, ErrCOBSDecode) {
+		t.Errorf("Expected corrupted frame to be rejected, got %v", err)
+	}
+}
+
+// TestSerialLink tests readings, duplicate suppression and command retransmission over pipes
+func TestSerialLink(t *testing.T) {
+	host, mcu := newDuplexPipe()
+	link := NewSerialLink(host)
+	link.AckTimeout = 20 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	link.Start(ctx)
+
+	// The fake microcontroller acks commands, dropping the first one to force a retransmit
+	commands := make(chan []byte, 10)
+	acks := make(chan uint8, 10)
+	go func() {
+		reader := bufio.NewReader(mcu)
+		dropped := false
+		for {
+			encoded, err := reader.ReadBytes(0)
+			if err != nil {
+				return
+			}
+			frameType, seq, payload, err := DecodeSerialFrame(encoded[:len(encoded)-1])
+			if err != nil {
+				continue
+			}
+			switch frameType {
+			case SerialAck:
+				acks <- seq
+			case SerialCommand:
+				if !dropped {
+					dropped = true
+					continue
+				}
+				commands <- payload
+				mcu.Write(EncodeSerialFrame(SerialAck, seq, nil))
+			}
+		}
+	}()
+
+	imu := IMUData{AccelX: 0.5, AccelZ: 9.75, GyroY: -1.25}
+	frame := EncodeSerialFrame(SerialIMU, 1, EncodeIMUPayload(0, imu))
+	mcu.Write(frame)
+	mcu.Write(frame) // retransmission of the same frame
+	mcu.Write(EncodeSerialFrame(SerialMotor, 2, EncodeMotorPayload(1, MotorData{Speed: 0.25, Direction: -1, Current: 0.5})))
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-acks:
+		case <-ctx.Done():
+			t.Fatal("Timed out waiting for acks")
+		}
+	}
+
+	data, err := link.Sensor("imu-0", SerialIMU, 0).Read(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read imu: %v", err)
+	}
+	if data.SensorID != "imu-0" || data.DataType != "imu" || data.Value.(IMUData) != imu {
+		t.Errorf("Unexpected imu reading: %+v", data)
+	}
+	data, err = link.Sensor("motor-1", SerialMotor, 1).Read(ctx)
+	if err != nil || data.Value.(MotorData).Direction != -1 {
+		t.Errorf("Unexpected motor reading: %+v (%v)", data, err)
+	}
+	if _, err := link.Sensor("range", SerialUltrasonic, 0).Read(ctx); !errors.Is(err, ErrSerialNoReading) {
+		t.Errorf("Expected ErrSerialNoReading, got %v", err)
+	}
+
+	if err := link.SetMotor(ctx, 1, 0.5, 1); err != nil {
+		t.Fatalf("SetMotor failed: %v", err)
+	}
+	if cmd := <-commands; cmd[0] != SerialCmdSetMotor || cmd[1] != 1 {
+		t.Errorf("Unexpected command payload: %v", cmd)
+	}
+
+	stats := link.Stats()
+	if stats.Duplicates != 1 || stats.Retransmits != 1 || stats.FramesReceived != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	cancel()
+	select {
+	case <-link.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Link did not stop after cancel")
+	}
+}
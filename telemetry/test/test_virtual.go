@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestCompileExpression tests parsing and evaluating expressions
+func TestCompileExpression(t *testing.T) {
+	values := map[string]float64{
+		"voltage":         12.0,
+		"current":         2.5,
+		"imu.accel":       3.0,
+		"imu-front":       4.0,
+		"imu-front.accel": 8.0,
+	}
+	resolve := func(sensorID, field string) (float64, error) {
+		key := sensorID
+		if field != "" {
+			key += "." + field
+		}
+		return values[key], nil
+	}
+
+	tests := []struct {
+		source string
+		want   float64
+	}{
+		{"voltage * current", 30.0},
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"-2 ^ 2", -4.0},
+		{"2 ^ 3 ^ 2", 512.0},
+		{"max(voltage, current) - min(voltage, current)", 9.5},
+		{"deg(atan2(1, 1))", 45.0},
+		{"imu.accel / 2", 1.5},
+		{"1.5e1", 15.0},
+		{`"imu-front" - 1`, 3.0},
+		{`"imu-front".accel-"imu-front"`, 4.0},
+	}
+
+	for _, tt := range tests {
+		expr, err := CompileExpression(tt.source)
+		if err != nil {
+			t.Errorf("CompileExpression(%q) failed: %v", tt.source, err)
+			continue
+		}
+		got, err := expr.Eval(resolve)
+		if err != nil {
+			t.Errorf("Eval(%q) failed: %v", tt.source, err)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Eval(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "1 +", "(1 + 2", "foo(1)", "sqrt(1, 2)", "imu.", "1 $ 2", `"imu`, `""`, `"imu"x`, `"imu".`} {
+		if _, err := CompileExpression(bad); err == nil {
+			t.Errorf("Expected error compiling %q", bad)
+		}
+	}
+
+	expr, _ := CompileExpression("imu.accel_x + imu.accel_z * battery")
+	inputs := expr.Inputs()
+	if len(inputs) != 2 || inputs[0] != "imu" || inputs[1] != "battery" {
+		t.Errorf("Unexpected inputs: %v", inputs)
+	}
+}
+
+// TestExpressionSensor tests a virtual sensor derived from other sensors through the manager
+func TestExpressionSensor(t *testing.T) {
+	storage := &MockStorage{}
+	tm := NewTelemetryManager(storage, 50*time.Millisecond)
+
+	voltage := &MockSensor{id: "voltage", mockData: SensorData{SensorID: "voltage", Value: 12.0}}
+	motor := &MockSensor{id: "motor", mockData: SensorData{SensorID: "motor", Value: MotorData{Current: 2.0}}}
+	power, err := NewExpressionSensor("motor_power", "power", tm.Readings(), "voltage * motor.current")
+	if err != nil {
+		t.Fatalf("Failed to create expression sensor: %v", err)
+	}
+
+	for _, s := range []Sensor{voltage, motor, power} {
+		if err := tm.AddSensor(s); err != nil {
+			t.Fatalf("Failed to add sensor %s: %v", s.ID(), err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Millisecond)
+	defer cancel()
+	tm.Start(ctx)
+
+	found := false
+	for _, data := range storage.storedData {
+		if data.SensorID != "motor_power" {
+			continue
+		}
+		found = true
+		if data.DataType != "power" || data.Value != 24.0 {
+			t.Errorf("Unexpected derived reading: %+v", data)
+		}
+	}
+	if !found {
+		t.Error("No derived readings stored")
+	}
+}
+
+// TestVirtualSensorMissingInput tests reading a virtual sensor before its inputs report
+func TestVirtualSensorMissingInput(t *testing.T) {
+	cache := NewReadingCache()
+	tilt := NewVirtualSensor("tilt", "angle", cache, []string{"imu"}, func(inputs map[string]SensorData) (interface{}, error) {
+		imu := inputs["imu"].Value.(IMUData)
+		return math.Atan2(imu.AccelX, imu.AccelZ), nil
+	})
+
+	if _, err := tilt.Read(context.Background()); !errors.Is(err, ErrInputUnavailable) {
+		t.Errorf("Expected ErrInputUnavailable, got %v", err)
+	}
+
+	cache.Update(SensorData{Timestamp: time.Now(), SensorID: "imu", Value: IMUData{AccelX: 1, AccelZ: 1}})
+	data, err := tilt.Read(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read virtual sensor: %v", err)
+	}
+	if math.Abs(data.Value.(float64)-math.Pi/4) > 1e-9 {
+		t.Errorf("Unexpected tilt: %v", data.Value)
+	}
+
+	tilt.MaxAge = time.Millisecond
+	cache.Update(SensorData{Timestamp: time.Now().Add(-time.Second), SensorID: "imu", Value: IMUData{}})
+	if _, err := tilt.Read(context.Background()); !errors.Is(err, ErrInputUnavailable) {
+		t.Errorf("Expected stale input error, got %v", err)
+	}
+}